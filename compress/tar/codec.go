@@ -0,0 +1,174 @@
+package tar
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec compresses and decompresses a tar stream.
+//
+// Codecs are identified by name when writing, and by the magic bytes at
+// the start of the stream when reading.
+type Codec interface {
+	// Name returns the name used to select the codec, e.g. "gzip"
+	Name() string
+
+	// Magic returns the leading bytes of a stream produced by the codec,
+	// nil if the stream has no recognizable prefix.
+	Magic() []byte
+
+	// NewWriter returns a writer compressing into w, the caller must close it
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+
+	// NewReader returns a reader decompressing from r
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+const (
+	// CodecGzip is the default codec
+	CodecGzip = "gzip"
+
+	// CodecZstd compresses with zstandard
+	CodecZstd = "zstd"
+
+	// CodecNone writes an uncompressed tar
+	CodecNone = "none"
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(gzipCodec{})
+	RegisterCodec(zstdCodec{})
+	RegisterCodec(noneCodec{})
+}
+
+// RegisterCodec makes a codec available by its name, a codec registered
+// with an existing name replaces the previous one.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// LookupCodec returns the codec registered with name
+func LookupCodec(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// Codecs returns the names of all registered codecs
+func Codecs() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func codecByName(name string) (Codec, error) {
+	if name == "" {
+		name = CodecGzip
+	}
+	c, ok := LookupCodec(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown codec: %s", name)
+	}
+	return c, nil
+}
+
+// sniffCodec returns the codec whose magic bytes prefix the stream, or the
+// "none" codec if nothing matches.
+func sniffCodec(br *bufio.Reader) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	var (
+		found Codec
+		best  int
+	)
+	for _, c := range codecs {
+		magic := c.Magic()
+		if len(magic) <= best {
+			continue
+		}
+		head, err := br.Peek(len(magic))
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if bytes.Equal(head, magic) {
+			found, best = c, len(magic)
+		}
+	}
+	if found == nil {
+		return noneCodec{}, nil
+	}
+	return found, nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return CodecGzip }
+
+func (gzipCodec) Magic() []byte { return []byte{0x1f, 0x8b} }
+
+func (gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return CodecZstd }
+
+func (zstdCodec) Magic() []byte { return []byte{0x28, 0xb5, 0x2f, 0xfd} }
+
+func (zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string { return CodecNone }
+
+func (noneCodec) Magic() []byte { return nil }
+
+func (noneCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (noneCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
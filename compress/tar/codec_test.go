@@ -0,0 +1,93 @@
+package tar
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveFormats(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "a.txt"), "hello", 0640)
+	writeFile(t, filepath.Join(src, "sub", "b.txt"), "world", 0600)
+	require.Nil(t, os.Symlink("sub/b.txt", filepath.Join(src, "link")))
+
+	tests := []struct {
+		format string
+		codec  string
+		magic  []byte
+	}{
+		{FormatTar, CodecGzip, []byte{0x1f, 0x8b}},
+		{FormatTar, CodecZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+		{FormatTar, CodecNone, nil},
+		{FormatZip, CodecGzip, []byte("PK")},
+		{FormatZip, CodecZstd, []byte("PK")},
+		{FormatZip, CodecNone, []byte("PK")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format+"/"+tt.codec, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			require.Nil(t, Archive(src, buf, &Options{Format: tt.format, Codec: tt.codec}))
+			require.True(t, bytes.HasPrefix(buf.Bytes(), tt.magic))
+
+			// format and codec are detected when extracting
+			dst := t.TempDir()
+			require.Nil(t, Extract(dst, buf, nil))
+
+			data, err := os.ReadFile(filepath.Join(dst, "sub", "b.txt"))
+			require.Nil(t, err)
+			require.Equal(t, "world", string(data))
+
+			fi, err := os.Stat(filepath.Join(dst, "a.txt"))
+			require.Nil(t, err)
+			require.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+
+			link, err := os.Readlink(filepath.Join(dst, "link"))
+			require.Nil(t, err)
+			require.Equal(t, "sub/b.txt", link)
+		})
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	err := Archive(t.TempDir(), io.Discard, &Options{Codec: "lzma"})
+	require.NotNil(t, err)
+
+	err = Archive(t.TempDir(), io.Discard, &Options{Format: "rar"})
+	require.NotNil(t, err)
+}
+
+// zlibCodec is a codec registered by the test
+type zlibCodec struct{}
+
+func (zlibCodec) Name() string { return "zlib" }
+
+func (zlibCodec) Magic() []byte { return []byte{0x78, 0x9c} }
+
+func (zlibCodec) NewWriter(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil }
+
+func (zlibCodec) NewReader(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) }
+
+func TestRegisterCodec(t *testing.T) {
+	RegisterCodec(zlibCodec{})
+	require.Contains(t, Codecs(), "zlib")
+
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "a.txt"), "hello", 0644)
+
+	buf := &bytes.Buffer{}
+	require.Nil(t, Archive(src, buf, &Options{Codec: "zlib"}))
+
+	dst := t.TempDir()
+	require.Nil(t, Extract(dst, buf, nil))
+
+	data, err := os.ReadFile(filepath.Join(dst, "a.txt"))
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(data))
+}
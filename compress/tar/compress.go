@@ -2,141 +2,266 @@ package tar
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"syscall"
 )
 
+var (
+	// ErrUnsafePath is returned when an entry would be written outside the destination
+	ErrUnsafePath = errors.New("unsafe path in archive")
+
+	// ErrTooLarge is returned when the extracted contents exceed Options.MaxTotalSize
+	ErrTooLarge = errors.New("archive exceeds maximum total size")
+
+	// ErrTooManyFiles is returned when the archive has more entries than Options.MaxFiles
+	ErrTooManyFiles = errors.New("archive exceeds maximum number of files")
+)
+
+// modeMask keeps the permission and special bits restored on extraction
+const modeMask = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
 // Tar takes a source and variable writers and walks 'source' writing each file
 // found to the compress writer; the purpose for accepting multiple writers is to allow
 // for multiple outputs (for example a file, or md5 hash)
 func Tar(src string, writers ...io.Writer) error {
+	return Archive(src, io.MultiWriter(writers...), nil)
+}
+
+// Untar takes a destination path and a reader; a compress reader loops over the tarfile
+// creating the file structure at 'dst' along the way, and writing any files
+func Untar(dst string, r io.Reader) error {
+	return Extract(dst, r, nil)
+}
+
+// Archive walks 'src' and writes an archive to w, a gzip compressed tarball
+// unless opts selects another format or codec.
+//
+// Directories, regular files and symlinks are archived with their modes and
+// timestamps. Tarballs also keep ownership, and files sharing an inode are
+// stored as hardlinks. Devices, pipes and sockets are skipped.
+func Archive(src string, w io.Writer, opts *Options) error {
 
 	// ensure the src actually exists before trying to compress it
-	if _, err := os.Stat(src); err != nil {
+	if _, err := os.Lstat(src); err != nil {
 		return fmt.Errorf("Unable to compress files - %v", err.Error())
 	}
 
-	mw := io.MultiWriter(writers...)
+	switch format := opts.format(); format {
+	case FormatTar:
+		return archiveTar(src, w, opts)
+	case FormatZip:
+		return archiveZip(src, w, opts)
+	default:
+		return fmt.Errorf("unknown archive format: %s", format)
+	}
+}
 
-	gzw := gzip.NewWriter(mw)
-	defer gzw.Close()
+// Extract reads an archive from r and recreates it under 'dst'.
+//
+// The format and codec are detected from the leading bytes of r, so any
+// output of Archive is accepted whatever the options it was created with.
+//
+// Entries that would land outside 'dst', either by their own name or by going
+// through a symlink, are rejected with ErrUnsafePath. The number of entries and
+// the total size are limited by opts to guard against decompression bombs.
+func Extract(dst string, r io.Reader, opts *Options) error {
 
-	tw := tar.NewWriter(gzw)
-	defer tw.Close()
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	x := &extractor{dst: filepath.Clean(dst), opts: opts}
 
-	// walk path
-	return filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+	br := bufio.NewReader(r)
+	if isZip(br) {
+		return extractZip(x, br)
+	}
 
-		// return on any error
-		if err != nil {
-			return err
-		}
+	codec, err := sniffCodec(br)
+	if err != nil {
+		return err
+	}
+	rc, err := codec.NewReader(br)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
 
-		// return on non-regular files (thanks to [kumo](https://medium.com/@komuw/just-like-you-did-fbdd7df829d3) for this suggested update)
-		if !fi.Mode().IsRegular() {
-			return nil
-		}
+	return x.extract(tar.NewReader(rc))
+}
+
+func archiveTar(src string, w io.Writer, opts *Options) error {
+	codec, err := codecByName(opts.codec())
+	if err != nil {
+		return err
+	}
+
+	cw, err := newCompressor(codec, w, src, opts)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(cw)
+
+	if err := writeTree(tw, src, opts); err != nil {
+		tw.Close()
+		cw.Close()
+		return err
+	}
+
+	// closing flushes the tar footer and codec trailer, they must not be lost
+	if err := tw.Close(); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// fileID identifies an inode, used to detect hardlinks
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func hardlinkID(fi os.FileInfo) (fileID, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink <= 1 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+func writeTree(tw *tar.Writer, src string, opts *Options) error {
+	// first archived name of each inode with multiple links
+	links := map[fileID]string{}
+	m := newManifest(opts)
+
+	err := walk(src, opts, func(file, name string, fi os.FileInfo, link string) error {
 
-		// create a new dir/file header
-		header, err := tar.FileInfoHeader(fi, fi.Name())
+		// create a new dir/file header, uid/gid and mtime are filled in from fi
+		header, err := tar.FileInfoHeader(fi, link)
 		if err != nil {
 			return err
 		}
 
 		// update the name to correctly reflect the desired destination when untaring
-		header.Name = strings.TrimPrefix(strings.Replace(file, src, "", -1), string(filepath.Separator))
+		header.Name = name
+		if fi.IsDir() {
+			header.Name += "/"
+		}
 
-		// write the header
-		if err := tw.WriteHeader(header); err != nil {
-			return err
+		// hardlinks depend on the filesystem rather than the contents, so
+		// they're stored as plain files in reproducible mode
+		if fi.Mode().IsRegular() && !opts.reproducible() {
+			if id, ok := hardlinkID(fi); ok {
+				if first, seen := links[id]; seen {
+					header.Typeflag = tar.TypeLink
+					header.Linkname = first
+					header.Size = 0
+					m.link(name, first)
+				} else {
+					links[id] = name
+				}
+			}
 		}
 
-		// open files for taring
-		f, err := os.Open(file)
-		if err != nil {
-			return err
+		if opts.reproducible() {
+			header = reproducibleTarHeader(header, fi, opts)
 		}
 
-		// copy file data into compress writer
-		if _, err := io.Copy(tw, f); err != nil {
+		// write the header
+		if err := tw.WriteHeader(header); err != nil {
 			return err
 		}
 
-		// manually close here after each file operation; defering would cause each file close
-		// to wait until all operations have completed.
-		f.Close()
-
-		return nil
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		return m.hashFile(tw, file, name)
 	})
-}
-
-// Untar takes a destination path and a reader; a compress reader loops over the tarfile
-// creating the file structure at 'dst' along the way, and writing any files
-func Untar(dst string, r io.Reader) error {
-
-	gzr, err := gzip.NewReader(r)
-	if err != nil {
+	if err != nil || m == nil {
 		return err
 	}
-	defer gzr.Close()
-
-	tr := tar.NewReader(gzr)
+	return m.writeTar(tw, opts)
+}
 
-	for {
-		header, err := tr.Next()
+// walkFunc is called for each entry to archive, name is the slash separated
+// name in the archive and link the target of a symlink.
+type walkFunc func(file, name string, fi os.FileInfo, link string) error
 
-		switch {
+// walk walks 'src' calling fn for directories, regular files and symlinks
+// kept by opts. Symlinks are not followed. Entries are visited in lexical
+// order, so the output is stable across runs.
+func walk(src string, opts *Options, fn walkFunc) error {
 
-		// if no more files are found return
-		case err == io.EOF:
-			return nil
+	// walk path, filepath.Walk uses lstat
+	return filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
 
-		// return any other error
-		case err != nil:
+		// return on any error
+		if err != nil {
 			return err
-
-		// if the header is nil, just skip it (not sure how this happens)
-		case header == nil:
-			continue
 		}
 
-		// the target location where the dir/file should be created
-		target := filepath.Join(dst, header.Name)
-
-		// the following switch could also be done using fi.Mode(), not sure if there
-		// a benefit of using one vs. the other.
-		// fi := header.FileInfo()
-
-		// check the file type
-		switch header.Typeflag {
-
-		// if its a dir and it doesn't exist create it
-		case tar.TypeDir:
-			if _, err := os.Stat(target); err != nil {
-				if err := os.MkdirAll(target, 0755); err != nil {
-					return err
-				}
-			}
-
-		// if it's a file create it
-		case tar.TypeReg:
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-			if err != nil {
-				return err
+		name := entryName(src, file, fi)
+		if name == "" {
+			return nil
+		}
+		if !opts.keep(name, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
+		}
 
-			// copy over contents
-			if _, err := io.Copy(f, tr); err != nil {
+		var link string
+		switch mode := fi.Mode(); {
+		case mode.IsRegular(), mode.IsDir():
+		case mode&os.ModeSymlink != 0:
+			if link, err = os.Readlink(file); err != nil {
 				return err
 			}
+		default:
+			// devices, pipes and sockets
+			return nil
+		}
+		return fn(file, name, fi, link)
+	})
+}
 
-			// manually close here after each file operation; defering would cause each file close
-			// to wait until all operations have completed.
-			f.Close()
+// entryName returns the slash separated name of `file` relative to `src`,
+// or "" for the root directory itself.
+func entryName(src, file string, fi os.FileInfo) string {
+	if file == src {
+		if fi.IsDir() {
+			return ""
 		}
+		return fi.Name()
+	}
+	rel, err := filepath.Rel(src, file)
+	if err != nil {
+		return ""
 	}
+	return filepath.ToSlash(rel)
+}
+
+// copyFile copies the contents of file to w
+func copyFile(w io.Writer, file string) error {
+
+	// open files for taring
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	// copy file data into compress writer
+	_, err = io.Copy(w, f)
+
+	// manually close here after each file operation; defering would cause each file close
+	// to wait until all operations have completed.
+	f.Close()
+
+	return err
 }
@@ -0,0 +1,211 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, data string, mode os.FileMode) {
+	t.Helper()
+	require.Nil(t, os.MkdirAll(filepath.Dir(name), 0755))
+	require.Nil(t, os.WriteFile(name, []byte(data), mode))
+	require.Nil(t, os.Chmod(name, mode))
+}
+
+// tarball builds a gzip compressed tarball from the given headers, regular
+// files' contents are the header's Linkname field for brevity.
+func tarball(t *testing.T, headers ...*tar.Header) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+	for _, h := range headers {
+		var data string
+		if h.Typeflag == tar.TypeReg {
+			data, h.Linkname = h.Linkname, ""
+			h.Size = int64(len(data))
+		}
+		if h.Mode == 0 {
+			h.Mode = 0644
+		}
+		require.Nil(t, tw.WriteHeader(h))
+		_, err := tw.Write([]byte(data))
+		require.Nil(t, err)
+	}
+	require.Nil(t, tw.Close())
+	require.Nil(t, gzw.Close())
+	return buf
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	mtime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	writeFile(t, filepath.Join(src, "a.txt"), "hello", 0640)
+	writeFile(t, filepath.Join(src, "sub", "b.sh"), "#!/bin/sh", 0755)
+	require.Nil(t, os.Symlink("sub/b.sh", filepath.Join(src, "link")))
+	require.Nil(t, os.Link(filepath.Join(src, "a.txt"), filepath.Join(src, "sub", "hard.txt")))
+	require.Nil(t, os.Chmod(filepath.Join(src, "sub"), 0750))
+	require.Nil(t, os.Chtimes(filepath.Join(src, "a.txt"), mtime, mtime))
+	require.Nil(t, os.Chtimes(filepath.Join(src, "sub"), mtime, mtime))
+
+	buf := &bytes.Buffer{}
+	require.Nil(t, Tar(src, buf))
+
+	dst := t.TempDir()
+	require.Nil(t, Untar(dst, buf))
+
+	data, err := os.ReadFile(filepath.Join(dst, "a.txt"))
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(data))
+
+	fi, err := os.Stat(filepath.Join(dst, "a.txt"))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+	require.True(t, fi.ModTime().Equal(mtime))
+
+	fi, err = os.Stat(filepath.Join(dst, "sub"))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0750), fi.Mode().Perm())
+	require.True(t, fi.ModTime().Equal(mtime))
+
+	fi, err = os.Stat(filepath.Join(dst, "sub", "b.sh"))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+
+	link, err := os.Readlink(filepath.Join(dst, "link"))
+	require.Nil(t, err)
+	require.Equal(t, "sub/b.sh", link)
+
+	a, err := os.Stat(filepath.Join(dst, "a.txt"))
+	require.Nil(t, err)
+	hard, err := os.Stat(filepath.Join(dst, "sub", "hard.txt"))
+	require.Nil(t, err)
+	require.Equal(t, a.Sys().(*syscall.Stat_t).Ino, hard.Sys().(*syscall.Stat_t).Ino)
+}
+
+func TestArchiveSingleFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "only.txt")
+	writeFile(t, src, "data", 0644)
+
+	buf := &bytes.Buffer{}
+	require.Nil(t, Tar(src, buf))
+
+	dst := t.TempDir()
+	require.Nil(t, Untar(dst, buf))
+
+	data, err := os.ReadFile(filepath.Join(dst, "only.txt"))
+	require.Nil(t, err)
+	require.Equal(t, "data", string(data))
+}
+
+func TestExtractUnsafePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+	}{
+		{"dotdot", []*tar.Header{
+			{Name: "../evil", Typeflag: tar.TypeReg, Linkname: "x"},
+		}},
+		{"nested dotdot", []*tar.Header{
+			{Name: "a/../../evil", Typeflag: tar.TypeReg, Linkname: "x"},
+		}},
+		{"absolute", []*tar.Header{
+			{Name: "/tmp/evil", Typeflag: tar.TypeReg, Linkname: "x"},
+		}},
+		{"absolute symlink", []*tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		}},
+		{"escaping symlink", []*tar.Header{
+			{Name: "a/link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"},
+		}},
+		{"write through symlink", []*tar.Header{
+			{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "sub"},
+			{Name: "link/file", Typeflag: tar.TypeReg, Linkname: "x"},
+		}},
+		{"escaping hardlink", []*tar.Header{
+			{Name: "hard", Typeflag: tar.TypeLink, Linkname: "../outside"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dst := filepath.Join(parent, "dst")
+
+			err := Untar(dst, tarball(t, tt.headers...))
+			require.ErrorIs(t, err, ErrUnsafePath)
+
+			_, err = os.Lstat(filepath.Join(parent, "evil"))
+			require.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	headers := func() []*tar.Header {
+		return []*tar.Header{
+			{Name: "a", Typeflag: tar.TypeReg, Linkname: "0123456789"},
+			{Name: "b", Typeflag: tar.TypeReg, Linkname: "0123456789"},
+			{Name: "c", Typeflag: tar.TypeReg, Linkname: "0123456789"},
+		}
+	}
+
+	err := Extract(t.TempDir(), tarball(t, headers()...), &Options{MaxFiles: 2})
+	require.ErrorIs(t, err, ErrTooManyFiles)
+
+	err = Extract(t.TempDir(), tarball(t, headers()...), &Options{MaxTotalSize: 25})
+	require.ErrorIs(t, err, ErrTooLarge)
+
+	err = Extract(t.TempDir(), tarball(t, headers()...), &Options{MaxFiles: -1, MaxTotalSize: 30})
+	require.Nil(t, err)
+}
+
+func TestIncludeExclude(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "main.go"), "package main", 0644)
+	writeFile(t, filepath.Join(src, "README.md"), "# readme", 0644)
+	writeFile(t, filepath.Join(src, "cmd", "tool.go"), "package cmd", 0644)
+	writeFile(t, filepath.Join(src, "vendor", "dep.go"), "package dep", 0644)
+
+	buf := &bytes.Buffer{}
+	opts := &Options{Include: []string{"*.go"}, Exclude: []string{"vendor"}}
+	require.Nil(t, Archive(src, buf, opts))
+
+	dst := t.TempDir()
+	require.Nil(t, Untar(dst, buf))
+
+	for _, name := range []string{"main.go", "cmd/tool.go"} {
+		_, err := os.Stat(filepath.Join(dst, name))
+		require.Nil(t, err, name)
+	}
+	for _, name := range []string{"README.md", "vendor"} {
+		_, err := os.Stat(filepath.Join(dst, name))
+		require.True(t, os.IsNotExist(err), name)
+	}
+
+	// filters apply when extracting as well
+	buf.Reset()
+	require.Nil(t, Tar(src, buf))
+
+	dst = t.TempDir()
+	require.Nil(t, Extract(dst, buf, &Options{Exclude: []string{"cmd", "*.md"}}))
+
+	for _, name := range []string{"main.go", "vendor/dep.go"} {
+		_, err := os.Stat(filepath.Join(dst, name))
+		require.Nil(t, err, name)
+	}
+	for _, name := range []string{"README.md", "cmd/tool.go"} {
+		_, err := os.Stat(filepath.Join(dst, name))
+		require.True(t, os.IsNotExist(err), name)
+	}
+}
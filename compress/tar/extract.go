@@ -0,0 +1,297 @@
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// extractor recreates archive entries under dst. Entries of all formats are
+// described by *tar.Header, so that zip archives go through the same checks.
+type extractor struct {
+	dst  string
+	opts *Options
+
+	files int   // entries extracted so far
+	size  int64 // bytes extracted so far
+
+	// directories' metadata is restored after all entries are written,
+	// otherwise creating files inside them would change mtime, or a
+	// read-only directory would block its own contents.
+	dirs []dirEntry
+}
+
+type dirEntry struct {
+	path   string
+	header *tar.Header
+}
+
+// extract extracts all entries of a tar stream
+func (x *extractor) extract(tr *tar.Reader) error {
+	for {
+		header, err := tr.Next()
+
+		switch {
+
+		// if no more files are found, restore directories and return
+		case err == io.EOF:
+			return x.finish()
+
+		// return any other error
+		case err != nil:
+			return err
+
+		// if the header is nil, just skip it (not sure how this happens)
+		case header == nil:
+			continue
+		}
+
+		if err := x.entry(tr, header); err != nil {
+			return err
+		}
+	}
+}
+
+// entry extracts one entry, r provides the contents of regular files
+func (x *extractor) entry(r io.Reader, header *tar.Header) error {
+	name, target, err := x.target(header.Name)
+	if err != nil {
+		return err
+	}
+	if name == "" || !x.opts.keep(name, header.Typeflag == tar.TypeDir) {
+		return nil
+	}
+
+	x.files++
+	if max := x.opts.maxFiles(); max > 0 && x.files > max {
+		return fmt.Errorf("%w: more than %d entries", ErrTooManyFiles, max)
+	}
+
+	if err := x.checkParents(target); err != nil {
+		return err
+	}
+
+	// check the file type
+	switch header.Typeflag {
+
+	case tar.TypeDir:
+		return x.mkdir(target, header)
+
+	case tar.TypeReg:
+		if max := x.opts.maxTotalSize(); max > 0 && x.size+header.Size > max {
+			return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, max)
+		}
+		x.size += header.Size
+		return x.writeFile(target, header, r)
+
+	case tar.TypeSymlink:
+		return x.symlink(target, header)
+
+	case tar.TypeLink:
+		return x.hardlink(target, header)
+
+	default:
+		// devices, fifos and others are not extracted
+		return nil
+	}
+}
+
+// target validates the entry name and returns its cleaned form and the
+// location where it should be created. The name is "" for the root entry.
+func (x *extractor) target(name string) (string, string, error) {
+	clean := path.Clean(filepath.ToSlash(name))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	if clean == "." {
+		return "", "", nil
+	}
+	return clean, filepath.Join(x.dst, filepath.FromSlash(clean)), nil
+}
+
+// checkParents makes sure no existing directory between dst and target is a
+// symlink, so that a previously extracted link can't redirect writes.
+func (x *extractor) checkParents(target string) error {
+	rel, err := filepath.Rel(x.dst, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+
+	p := x.dst
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, elem)
+
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s goes through symlink %s", ErrUnsafePath, target, p)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%s: not a directory", p)
+		}
+	}
+	return nil
+}
+
+func (x *extractor) mkdir(target string, header *tar.Header) error {
+	fi, err := os.Lstat(target)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !fi.IsDir():
+		return fmt.Errorf("%s: exists and is not a directory", target)
+	}
+
+	x.dirs = append(x.dirs, dirEntry{path: target, header: header})
+	return nil
+}
+
+func (x *extractor) writeFile(target string, header *tar.Header, r io.Reader) error {
+	if err := x.prepare(target); err != nil {
+		return err
+	}
+
+	// O_EXCL makes sure we never write through a file created in between
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	// copy over contents
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	// manually close here after each file operation; defering would cause each file close
+	// to wait until all operations have completed.
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return x.restore(target, header)
+}
+
+func (x *extractor) symlink(target string, header *tar.Header) error {
+	// the link is resolved relative to its own directory and must stay in dst
+	if filepath.IsAbs(header.Linkname) {
+		return fmt.Errorf("%w: symlink %s -> %s", ErrUnsafePath, header.Name, header.Linkname)
+	}
+	resolved := filepath.Join(filepath.Dir(target), header.Linkname)
+	if !within(x.dst, resolved) {
+		return fmt.Errorf("%w: symlink %s -> %s", ErrUnsafePath, header.Name, header.Linkname)
+	}
+
+	if err := x.prepare(target); err != nil {
+		return err
+	}
+	if err := os.Symlink(header.Linkname, target); err != nil {
+		return err
+	}
+	return x.restore(target, header)
+}
+
+func (x *extractor) hardlink(target string, header *tar.Header) error {
+	// hardlink names are relative to the archive root
+	name, src, err := x.target(header.Linkname)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("%w: hardlink %s -> %s", ErrUnsafePath, header.Name, header.Linkname)
+	}
+	if err := x.checkParents(src); err != nil {
+		return err
+	}
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("hardlink %s -> %s: is a directory", header.Name, header.Linkname)
+	}
+
+	if err := x.prepare(target); err != nil {
+		return err
+	}
+	return os.Link(src, target)
+}
+
+// prepare creates the parent directories of target and removes any
+// existing non-directory entry at target.
+func (x *extractor) prepare(target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	fi, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s: exists and is a directory", target)
+	}
+	return os.Remove(target)
+}
+
+// restore applies ownership, mode and timestamps recorded in header
+func (x *extractor) restore(target string, header *tar.Header) error {
+	// chown first, it may clear setuid/setgid bits set by chmod
+	if x.opts.preserveOwner() {
+		if err := os.Lchown(target, header.Uid, header.Gid); err != nil {
+			return err
+		}
+	}
+
+	// chmod and chtimes follow symlinks, the link itself is left as is
+	if header.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+
+	if err := os.Chmod(target, header.FileInfo().Mode()&modeMask); err != nil {
+		return err
+	}
+
+	atime := header.AccessTime
+	if atime.IsZero() {
+		atime = header.ModTime
+	}
+	return os.Chtimes(target, atime, header.ModTime)
+}
+
+// finish restores directories' metadata, children before parents
+func (x *extractor) finish() error {
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		if err := x.restore(x.dirs[i].path, x.dirs[i].header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// within reports whether p is root or inside root
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
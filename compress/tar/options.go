@@ -0,0 +1,203 @@
+package tar
+
+import (
+	"path"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxTotalSize is the default limit of the total bytes extracted from an archive
+	DefaultMaxTotalSize int64 = 1 << 30
+
+	// DefaultMaxFiles is the default limit of the number of entries extracted from an archive
+	DefaultMaxFiles = 100000
+
+	// DefaultParallelThreshold is the default tree size above which gzip
+	// tarballs are compressed in parallel
+	DefaultParallelThreshold int64 = 16 << 20
+)
+
+// DefaultModTime is the mtime of all entries written in reproducible mode,
+// the earliest time representable in zip archives.
+var DefaultModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	// FormatTar writes a tarball compressed by Options.Codec, it's the default
+	FormatTar = "tar"
+
+	// FormatZip writes a zip archive
+	FormatZip = "zip"
+)
+
+// Options controls which files are archived or extracted and how.
+//
+// A nil *Options is valid and means the defaults.
+type Options struct {
+	// Format is the archive format written by Archive, FormatTar or FormatZip.
+	// Extract detects the format by itself.
+	Format string
+
+	// Codec is the name of a registered Codec used to compress tarballs,
+	// CodecGzip by default. Zip archives support CodecGzip (deflate),
+	// CodecZstd and CodecNone (store). Extract detects the codec by itself.
+	Codec string
+
+	// Include, if not empty, only keeps entries matching one of these globs.
+	//
+	// Patterns use path.Match syntax and are matched against both the slash
+	// separated relative path and the base name, e.g. "*.go" or "cmd/*".
+	Include []string
+
+	// Exclude drops entries matching one of these globs, it takes precedence
+	// over Include. An excluded directory is skipped as a whole.
+	Exclude []string
+
+	// MaxTotalSize limits the total bytes of file contents extracted, 0 means
+	// DefaultMaxTotalSize and a negative value means no limit.
+	MaxTotalSize int64
+
+	// MaxFiles limits the number of entries extracted, 0 means DefaultMaxFiles
+	// and a negative value means no limit.
+	MaxFiles int
+
+	// Reproducible makes Archive's output depend only on the names, contents
+	// and executable bits of the files. Entries are written in lexical order,
+	// with ModTime as mtime, uid/gid 0, mode 0644 or 0755, and hardlinks are
+	// stored as plain files.
+	Reproducible bool
+
+	// ModTime is the mtime of all entries in reproducible mode, zero means
+	// DefaultModTime.
+	ModTime time.Time
+
+	// Manifest adds a ManifestName entry at the end of the archive holding
+	// the SHA-256 sums of all regular files, in sha256sum(1) format.
+	Manifest bool
+
+	// Concurrency is the number of blocks compressed in parallel for gzip
+	// tarballs larger than ParallelThreshold, 0 means GOMAXPROCS. The output
+	// doesn't depend on it.
+	Concurrency int
+
+	// ParallelThreshold is the size of the tree above which gzip tarballs are
+	// compressed in parallel blocks, 0 means DefaultParallelThreshold and a
+	// negative value disables parallel compression.
+	ParallelThreshold int64
+
+	// PreserveOwner restores the uid/gid recorded in the archive when
+	// extracting, which usually requires root privilege.
+	PreserveOwner bool
+}
+
+func (o *Options) format() string {
+	if o == nil || o.Format == "" {
+		return FormatTar
+	}
+	return o.Format
+}
+
+func (o *Options) codec() string {
+	if o == nil || o.Codec == "" {
+		return CodecGzip
+	}
+	return o.Codec
+}
+
+func (o *Options) reproducible() bool {
+	return o != nil && o.Reproducible
+}
+
+func (o *Options) modTime() time.Time {
+	if o == nil || o.ModTime.IsZero() {
+		return DefaultModTime
+	}
+	return o.ModTime
+}
+
+func (o *Options) manifest() bool {
+	return o != nil && o.Manifest
+}
+
+func (o *Options) concurrency() int {
+	if o == nil || o.Concurrency <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return o.Concurrency
+}
+
+func (o *Options) parallelThreshold() int64 {
+	if o == nil || o.ParallelThreshold == 0 {
+		return DefaultParallelThreshold
+	}
+	return o.ParallelThreshold
+}
+
+func (o *Options) maxTotalSize() int64 {
+	if o == nil || o.MaxTotalSize == 0 {
+		return DefaultMaxTotalSize
+	}
+	return o.MaxTotalSize
+}
+
+func (o *Options) maxFiles() int {
+	if o == nil || o.MaxFiles == 0 {
+		return DefaultMaxFiles
+	}
+	return o.MaxFiles
+}
+
+func (o *Options) preserveOwner() bool {
+	return o != nil && o.PreserveOwner
+}
+
+// excluded reports whether the entry named `name` (slash separated) or one of
+// its parent directories matches the Exclude globs
+func (o *Options) excluded(name string) bool {
+	if o == nil || len(o.Exclude) == 0 {
+		return false
+	}
+	name = strings.TrimSuffix(name, "/")
+	for i := range name {
+		if name[i] == '/' && matchAny(o.Exclude, name[:i]) {
+			return true
+		}
+	}
+	return matchAny(o.Exclude, name)
+}
+
+// included reports whether the entry named `name` (slash separated) passes the Include globs
+func (o *Options) included(name string) bool {
+	if o == nil || len(o.Include) == 0 {
+		return true
+	}
+	return matchAny(o.Include, name)
+}
+
+// keep reports whether the entry should be archived or extracted
+func (o *Options) keep(name string, isDir bool) bool {
+	if o.excluded(name) {
+		return false
+	}
+	// directories are always kept when Include is set, so that matched files
+	// inside them are still reachable
+	if isDir {
+		return true
+	}
+	return o.included(name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	name = strings.TrimSuffix(name, "/")
+	base := path.Base(name)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package tar
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/klauspost/pgzip"
+)
+
+// ManifestName is the name of the entry written when Options.Manifest is set
+const ManifestName = "SHA256SUMS"
+
+// parallelBlockSize is the size of the blocks compressed in parallel, it's
+// fixed so that the output doesn't depend on the concurrency.
+const parallelBlockSize = 1 << 20
+
+// manifest collects the SHA-256 sums of archived files, a nil *manifest
+// ignores everything.
+type manifest struct {
+	buf  bytes.Buffer
+	sums map[string]string
+}
+
+func newManifest(opts *Options) *manifest {
+	if !opts.manifest() {
+		return nil
+	}
+	return &manifest{sums: map[string]string{}}
+}
+
+func (m *manifest) add(name, sum string) {
+	if m == nil {
+		return
+	}
+	m.sums[name] = sum
+	fmt.Fprintf(&m.buf, "%s  %s\n", sum, name)
+}
+
+// link records name with the sum of the previously archived target
+func (m *manifest) link(name, target string) {
+	if m == nil {
+		return
+	}
+	m.add(name, m.sums[target])
+}
+
+// modTime returns the mtime of the manifest entry
+func (m *manifest) modTime(opts *Options) time.Time {
+	if opts.reproducible() {
+		return opts.modTime()
+	}
+	return time.Now()
+}
+
+// hashFile copies the contents of file to w and records its digest in m
+func (m *manifest) hashFile(w io.Writer, file, name string) error {
+	if m == nil {
+		return copyFile(w, file)
+	}
+	h := sha256.New()
+	if err := copyFile(io.MultiWriter(w, h), file); err != nil {
+		return err
+	}
+	m.add(name, hex.EncodeToString(h.Sum(nil)))
+	return nil
+}
+
+func (m *manifest) writeTar(tw *tar.Writer, opts *Options) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     ManifestName,
+		Size:     int64(m.buf.Len()),
+		Mode:     0644,
+		ModTime:  m.modTime(opts),
+	}
+	if !opts.reproducible() {
+		header.Uid = os.Getuid()
+		header.Gid = os.Getgid()
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(m.buf.Bytes())
+	return err
+}
+
+func (m *manifest) writeZip(zw *zip.Writer, opts *Options, method uint16) error {
+	header := &zip.FileHeader{
+		Name:     ManifestName,
+		Method:   method,
+		Modified: m.modTime(opts),
+	}
+	header.SetMode(0644)
+
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(m.buf.Bytes())
+	return err
+}
+
+// normalizedMode returns the mode of an entry in reproducible mode
+func normalizedMode(mode os.FileMode) os.FileMode {
+	switch {
+	case mode.IsDir():
+		return os.ModeDir | 0755
+	case mode&os.ModeSymlink != 0:
+		return os.ModeSymlink | 0777
+	case mode&0111 != 0:
+		return 0755
+	default:
+		return 0644
+	}
+}
+
+// reproducibleTarHeader strips everything but the name, type, size and
+// executable bit from h.
+func reproducibleTarHeader(h *tar.Header, fi os.FileInfo, opts *Options) *tar.Header {
+	return &tar.Header{
+		Typeflag: h.Typeflag,
+		Name:     h.Name,
+		Linkname: h.Linkname,
+		Size:     h.Size,
+		Mode:     int64(normalizedMode(fi.Mode()).Perm()),
+		ModTime:  opts.modTime(),
+	}
+}
+
+// reproducibleZipHeader strips everything but the name, method and
+// executable bit from h.
+func reproducibleZipHeader(h *zip.FileHeader, fi os.FileInfo, opts *Options) *zip.FileHeader {
+	header := &zip.FileHeader{
+		Name:     h.Name,
+		Method:   h.Method,
+		Modified: opts.modTime(),
+	}
+	header.SetMode(normalizedMode(fi.Mode()))
+	return header
+}
+
+// treeSize returns the total size of the regular files archived from src
+func treeSize(src string, opts *Options) (int64, error) {
+	var size int64
+	err := walk(src, opts, func(file, name string, fi os.FileInfo, link string) error {
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// newCompressor returns the codec's writer, gzip is replaced by a parallel
+// block compressor when the tree is larger than Options.ParallelThreshold.
+func newCompressor(codec Codec, w io.Writer, src string, opts *Options) (io.WriteCloser, error) {
+	threshold := opts.parallelThreshold()
+	if _, ok := codec.(gzipCodec); !ok || threshold < 0 {
+		return codec.NewWriter(w)
+	}
+
+	size, err := treeSize(src, opts)
+	if err != nil {
+		return nil, err
+	}
+	if size <= threshold {
+		return codec.NewWriter(w)
+	}
+
+	pw := pgzip.NewWriter(w)
+	if err := pw.SetConcurrency(parallelBlockSize, opts.concurrency()); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
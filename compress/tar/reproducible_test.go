@@ -0,0 +1,116 @@
+package tar
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sampleTree creates the same tree with different mtimes and permissions
+func sampleTree(t *testing.T, mtime time.Time, perm os.FileMode) string {
+	t.Helper()
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "a.txt"), "hello", perm)
+	writeFile(t, filepath.Join(src, "bin", "run.sh"), "#!/bin/sh", perm|0100)
+	writeFile(t, filepath.Join(src, "sub", "b.txt"), strings.Repeat("world", 1000), perm)
+	require.Nil(t, os.Symlink("a.txt", filepath.Join(src, "link")))
+
+	for _, name := range []string{"a.txt", "bin/run.sh", "sub/b.txt", "sub", "bin"} {
+		require.Nil(t, os.Chtimes(filepath.Join(src, name), mtime, mtime))
+	}
+	return src
+}
+
+func TestReproducible(t *testing.T) {
+	src1 := sampleTree(t, time.Now(), 0600)
+	src2 := sampleTree(t, time.Now().Add(-time.Hour), 0644)
+
+	for _, format := range []string{FormatTar, FormatZip} {
+		t.Run(format, func(t *testing.T) {
+			opts := &Options{Format: format, Reproducible: true, Manifest: true}
+
+			buf1, buf2 := &bytes.Buffer{}, &bytes.Buffer{}
+			require.Nil(t, Archive(src1, buf1, opts))
+			require.Nil(t, Archive(src2, buf2, opts))
+			require.Equal(t, buf1.Bytes(), buf2.Bytes())
+
+			dst := t.TempDir()
+			require.Nil(t, Extract(dst, buf1, nil))
+
+			fi, err := os.Stat(filepath.Join(dst, "a.txt"))
+			require.Nil(t, err)
+			require.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+			require.True(t, fi.ModTime().Equal(DefaultModTime))
+
+			fi, err = os.Stat(filepath.Join(dst, "bin", "run.sh"))
+			require.Nil(t, err)
+			require.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+		})
+	}
+}
+
+func TestManifest(t *testing.T) {
+	src := sampleTree(t, time.Now(), 0644)
+
+	buf := &bytes.Buffer{}
+	require.Nil(t, Archive(src, buf, &Options{Manifest: true}))
+
+	dst := t.TempDir()
+	require.Nil(t, Extract(dst, buf, nil))
+
+	f, err := os.Open(filepath.Join(dst, ManifestName))
+	require.Nil(t, err)
+	defer f.Close()
+
+	var names []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), "  ", 2)
+		require.Len(t, fields, 2)
+
+		data, err := os.ReadFile(filepath.Join(dst, fields[1]))
+		require.Nil(t, err)
+		sum := sha256.Sum256(data)
+		require.Equal(t, hex.EncodeToString(sum[:]), fields[0])
+		names = append(names, fields[1])
+	}
+	require.Equal(t, []string{"a.txt", "bin/run.sh", "sub/b.txt"}, names)
+}
+
+func TestParallelGzip(t *testing.T) {
+	src := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<17)
+	writeFile(t, filepath.Join(src, "big"), string(data), 0644)
+	writeFile(t, filepath.Join(src, "small"), "small", 0644)
+
+	var outputs [][]byte
+	for _, concurrency := range []int{1, 4} {
+		buf := &bytes.Buffer{}
+		opts := &Options{Reproducible: true, Concurrency: concurrency, ParallelThreshold: 1 << 20}
+		require.Nil(t, Archive(src, buf, opts))
+		outputs = append(outputs, buf.Bytes())
+	}
+	require.Equal(t, outputs[0], outputs[1])
+
+	// the output is a regular gzip stream
+	gzr, err := gzip.NewReader(bytes.NewReader(outputs[0]))
+	require.Nil(t, err)
+	_, err = io.Copy(io.Discard, gzr)
+	require.Nil(t, err)
+
+	dst := t.TempDir()
+	require.Nil(t, Extract(dst, bytes.NewReader(outputs[0]), nil))
+	got, err := os.ReadFile(filepath.Join(dst, "big"))
+	require.Nil(t, err)
+	require.Equal(t, data, got)
+}
@@ -0,0 +1,171 @@
+package tar
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zipMethodZstd is the zip compression method id assigned to zstandard
+const zipMethodZstd uint16 = 93
+
+// maxSymlinkSize limits the target read from a zip symlink entry
+const maxSymlinkSize = 4096
+
+var (
+	zipLocalMagic = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+)
+
+// isZip reports whether the stream starts with a zip local file header, or
+// the end of central directory record of an empty archive.
+func isZip(br *bufio.Reader) bool {
+	head, _ := br.Peek(4)
+	return bytes.Equal(head, zipLocalMagic) || bytes.Equal(head, zipEmptyMagic)
+}
+
+func zipMethod(codec string) (uint16, error) {
+	switch codec {
+	case CodecGzip:
+		return zip.Deflate, nil
+	case CodecZstd:
+		return zipMethodZstd, nil
+	case CodecNone:
+		return zip.Store, nil
+	default:
+		return 0, fmt.Errorf("codec %s is not supported by zip", codec)
+	}
+}
+
+func archiveZip(src string, w io.Writer, opts *Options) error {
+	method, err := zipMethod(opts.codec())
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zipMethodZstd, func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w)
+	})
+
+	m := newManifest(opts)
+
+	err = walk(src, opts, func(file, name string, fi os.FileInfo, link string) error {
+		header, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		header.Method = method
+		if fi.IsDir() {
+			header.Name += "/"
+			header.Method = zip.Store
+		}
+		if opts.reproducible() {
+			header = reproducibleZipHeader(header, fi, opts)
+		}
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case fi.IsDir():
+			return nil
+		case link != "":
+			// zip stores the symlink target as the entry's contents
+			_, err = io.WriteString(fw, link)
+			return err
+		default:
+			return m.hashFile(fw, file, name)
+		}
+	})
+	if err == nil && m != nil {
+		err = m.writeZip(zw, opts, method)
+	}
+	if err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+func extractZip(x *extractor, r io.Reader) error {
+	// zip's central directory is at the end, spool the stream to a
+	// temporary file to get random access to it.
+	f, err := os.CreateTemp("", "extract-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	zr.RegisterDecompressor(zipMethodZstd, func(r io.Reader) io.ReadCloser {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return io.NopCloser(errReader{err})
+		}
+		return zr.IOReadCloser()
+	})
+
+	for _, zf := range zr.File {
+		if err := extractZipFile(x, zf); err != nil {
+			return err
+		}
+	}
+	return x.finish()
+}
+
+func extractZipFile(x *extractor, zf *zip.File) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fi := zf.FileInfo()
+
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		b, err := io.ReadAll(io.LimitReader(rc, maxSymlinkSize))
+		if err != nil {
+			return err
+		}
+		link = string(b)
+	}
+
+	header, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		// devices and other special files are not extracted
+		return nil
+	}
+	header.Name = zf.Name
+
+	// zip doesn't record ownership, entries belong to the current user
+	header.Uid = os.Getuid()
+	header.Gid = os.Getgid()
+
+	return x.entry(rc, header)
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
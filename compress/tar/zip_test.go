@@ -0,0 +1,60 @@
+package tar
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func zipball(t *testing.T, entries map[string]string, modes map[string]os.FileMode) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, data := range entries {
+		h := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		h.SetMode(0644)
+		if mode, ok := modes[name]; ok {
+			h.SetMode(mode)
+		}
+		w, err := zw.CreateHeader(h)
+		require.Nil(t, err)
+		_, err = w.Write([]byte(data))
+		require.Nil(t, err)
+	}
+	require.Nil(t, zw.Close())
+	return buf
+}
+
+func TestExtractZipUnsafePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries map[string]string
+		modes   map[string]os.FileMode
+	}{
+		{"dotdot", map[string]string{"../evil": "x"}, nil},
+		{"absolute", map[string]string{"/tmp/evil": "x"}, nil},
+		{"escaping symlink", map[string]string{"link": "../../etc"}, map[string]os.FileMode{"link": os.ModeSymlink | 0777}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "dst")
+			err := Extract(dst, zipball(t, tt.entries, tt.modes), nil)
+			require.ErrorIs(t, err, ErrUnsafePath)
+		})
+	}
+}
+
+func TestExtractZipLimits(t *testing.T) {
+	entries := map[string]string{"a": "0123456789", "b": "0123456789", "c": "0123456789"}
+
+	err := Extract(t.TempDir(), zipball(t, entries, nil), &Options{MaxFiles: 2})
+	require.ErrorIs(t, err, ErrTooManyFiles)
+
+	err = Extract(t.TempDir(), zipball(t, entries, nil), &Options{MaxTotalSize: 25})
+	require.ErrorIs(t, err, ErrTooLarge)
+}
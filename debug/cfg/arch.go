@@ -0,0 +1,157 @@
+package cfg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// classifiers set the kind of the instructions of a graph, by GOARCH.
+var classifiers = map[string]func(inst *Inst, g *Graph){
+	"386":   classifyX86,
+	"amd64": classifyX86,
+	"arm64": classifyARM64,
+}
+
+// classifyX86 classifies the x86 instructions in the Go syntax:
+//
+//	JMP 0x47db12, JLE 0x47db30, JMP AX, CALL runtime.panicBounds(SB), RET
+func classifyX86(inst *Inst, g *Graph) {
+	op, args := split(inst.Text)
+	switch {
+	case op == "RET":
+		inst.Kind = Return
+	case op == "UD2" || op == "INT" && args == "$0x3" || op == "HLT" || op == "?":
+		inst.Kind = Trap
+	case op == "CALL":
+		inst.Kind = Call
+		g.target(inst, args, 1)
+	case op == "JMP":
+		inst.Kind = Jump
+		if !g.target(inst, args, 1) {
+			inst.Kind = IndirectJump
+		}
+	case op[0] == 'J' || strings.HasPrefix(op, "LOOP"):
+		inst.Kind = CondJump
+		g.target(inst, args, 1)
+	}
+}
+
+// arm64CondJumps are the conditional branches of arm64, without the
+// compare and test branches
+var arm64CondJumps = map[string]bool{
+	"BEQ": true, "BNE": true, "BCS": true, "BHS": true, "BCC": true, "BLO": true,
+	"BMI": true, "BPL": true, "BVS": true, "BVC": true, "BHI": true, "BLS": true,
+	"BGE": true, "BLT": true, "BGT": true, "BLE": true,
+}
+
+// classifyARM64 classifies the arm64 instructions in the Go syntax, whose
+// relative targets are counted in instructions:
+//
+//	JMP 2(PC), BLE 10(PC), CBZ R0, 3(PC), TBNZ $3, R1, -4(PC), CALL (R2), RET
+func classifyARM64(inst *Inst, g *Graph) {
+	op, args := split(inst.Text)
+	switch {
+	case op == "RET":
+		inst.Kind = Return
+	case op == "BRK" || op == "UDF" || op == "UNDEF" || op == "?":
+		inst.Kind = Trap
+	case op == "CALL":
+		inst.Kind = Call
+		g.target(inst, args, 4)
+	case op == "JMP":
+		inst.Kind = Jump
+		if !g.target(inst, args, 4) {
+			inst.Kind = IndirectJump
+		}
+	case arm64CondJumps[op] || strings.HasPrefix(op, "CBZ") || strings.HasPrefix(op, "CBNZ") ||
+		strings.HasPrefix(op, "TBZ") || strings.HasPrefix(op, "TBNZ"):
+		inst.Kind = CondJump
+		// the target is the last argument
+		if i := strings.LastIndex(args, ", "); i >= 0 {
+			args = args[i+2:]
+		}
+		g.target(inst, args, 4)
+	}
+}
+
+// split returns the mnemonic and the arguments of the text of an
+// instruction, without the relocations.
+func split(text string) (op, args string) {
+	if i := strings.IndexByte(text, '\t'); i >= 0 {
+		text = text[:i]
+	}
+	// the GNU syntax follows in a comment, with -gnu
+	if i := strings.Index(text, " //"); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "?", ""
+	}
+	if i := strings.IndexByte(text, ' '); i >= 0 {
+		return text[:i], strings.TrimSpace(text[i+1:])
+	}
+	return text, ""
+}
+
+// target sets the target of the jump or the call inst to arg, an address,
+// a symbol with an offset, or an offset in units of the instructions
+// relative to the pc. It reports whether the target is known, the
+// indirect jumps and calls have registers or memory operands.
+func (g *Graph) target(inst *Inst, arg string, unit int64) bool {
+	switch {
+	case strings.HasPrefix(arg, "0x"):
+		addr, err := strconv.ParseUint(arg[2:], 16, 64)
+		if err != nil {
+			return false
+		}
+		inst.Target = addr
+		return true
+	case strings.HasSuffix(arg, "(PC)"):
+		n, err := strconv.ParseInt(strings.TrimSuffix(arg, "(PC)"), 0, 64)
+		if err != nil {
+			return false
+		}
+		inst.Target = uint64(int64(inst.PC) + n*unit)
+		return true
+	case strings.HasSuffix(arg, "(SB)"):
+		sym := strings.TrimSuffix(arg, "(SB)")
+		var off uint64
+		if i := strings.LastIndexByte(sym, '+'); i >= 0 {
+			if n, err := strconv.ParseUint(sym[i+1:], 0, 64); err == nil {
+				sym, off = sym[:i], n
+			}
+		}
+		if sym == g.Func {
+			inst.Target = g.Start + off
+		} else {
+			inst.Callee = sym
+		}
+		return true
+	}
+	return false
+}
+
+// noReturn reports whether the function called name doesn't return.
+func noReturn(name string) bool {
+	switch name {
+	case "runtime.gopanic", "runtime.throw", "runtime.fatal", "runtime.fatalthrow",
+		"runtime.fatalpanic", "runtime.Goexit", "runtime.goexit0":
+		return true
+	}
+	return strings.HasPrefix(name, "runtime.panic") || strings.HasPrefix(name, "runtime.goPanic")
+}
+
+// boundsCheck reports whether the function called name reports an index or
+// a slice out of range.
+func boundsCheck(name string) bool {
+	for _, prefix := range []string{
+		"runtime.panicBounds", "runtime.panicIndex", "runtime.panicSlice",
+		"runtime.panicExtend", "runtime.goPanicIndex", "runtime.goPanicSlice",
+	} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,333 @@
+// Package cfg builds the control flow graph of a function from its
+// disassembly, as decoded by objfile.Disasm.Decode in the Go assembler
+// syntax, for amd64, 386 and arm64.
+//
+// The instructions are split into basic blocks at the jumps, the calls of
+// the functions that don't return, the returns and the traps. The graph
+// has the loops of the function, found with its dominators, and the
+// bounds checks, the conditional jumps to the calls of runtime.panicBounds
+// and the like. It's written in the Graphviz DOT language or as JSON, the
+// blocks are annotated with their source lines.
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/hitzhangjie/codemaster/debug/internal/objfile"
+)
+
+// Kind is what an instruction does to the control flow.
+type Kind uint8
+
+const (
+	Plain        Kind = iota // falls through to the next instruction
+	Jump                     // unconditional jump, Target or Callee is the destination
+	CondJump                 // conditional jump, to Target or the next instruction
+	IndirectJump             // jump to a computed address, such as a jump table
+	Call                     // call, Callee is the function called
+	Return                   // return
+	Trap                     // INT3, UD2, BRK or an undefined instruction
+)
+
+var kindNames = [...]string{"plain", "jump", "condjump", "indirectjump", "call", "return", "trap"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", k)
+}
+
+// MarshalText implements encoding.TextMarshaler, the kinds are written by
+// name in JSON.
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Inst is an instruction of a function.
+type Inst struct {
+	PC   uint64 `json:"pc"`
+	Size uint64 `json:"size"`
+	Text string `json:"text"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+
+	// Kind, Target and Callee are set by Build.
+	Kind Kind `json:"kind"`
+	// Target is the destination of the direct jumps in the function and of
+	// the direct calls, 0 if unknown.
+	Target uint64 `json:"target,omitempty"`
+	// Callee is the function called, or jumped to by a tail call.
+	Callee string `json:"callee,omitempty"`
+}
+
+// Block is a basic block, the instructions executed in sequence from its
+// first one.
+type Block struct {
+	ID    int    `json:"id"`
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+	Insts []Inst `json:"insts"`
+	Succs []int  `json:"succs"`
+	Preds []int  `json:"preds"`
+
+	// Lines are the source lines of the instructions, as file:line with the
+	// base name of the file, in the order they first appear.
+	Lines []string `json:"lines"`
+
+	// LoopDepth is the number of loops the block is in.
+	LoopDepth int `json:"loop_depth,omitempty"`
+	// Panic is the function called at the end of the block that doesn't
+	// return, such as runtime.panicBounds.
+	Panic string `json:"panic,omitempty"`
+	// Unreachable reports whether the block can't be reached from the
+	// entry of the function, such as the padding after the last block.
+	Unreachable bool `json:"unreachable,omitempty"`
+}
+
+// EdgeKind is how the control flows from a block to another.
+type EdgeKind uint8
+
+const (
+	Fallthrough EdgeKind = iota // to the next block
+	Branch                      // by a conditional jump taken
+	Goto                        // by an unconditional jump
+)
+
+var edgeKindNames = [...]string{"fallthrough", "branch", "goto"}
+
+func (k EdgeKind) String() string {
+	if int(k) < len(edgeKindNames) {
+		return edgeKindNames[k]
+	}
+	return fmt.Sprintf("EdgeKind(%d)", k)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k EdgeKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Edge is an edge of the graph.
+type Edge struct {
+	From int      `json:"from"`
+	To   int      `json:"to"`
+	Kind EdgeKind `json:"kind"`
+	// Back reports whether the edge goes back to the header of a loop.
+	Back bool `json:"back,omitempty"`
+}
+
+// Loop is a natural loop.
+type Loop struct {
+	Header int   `json:"header"`
+	Blocks []int `json:"blocks"` // sorted, the header included
+	Depth  int   `json:"depth"`  // 1 for the outermost loops
+	Parent int   `json:"parent"` // index of the enclosing loop, -1 for none
+}
+
+// BoundsCheck is a jump to a call of a function reporting an index or a
+// slice out of range.
+type BoundsCheck struct {
+	PC    uint64 `json:"pc"`    // of the jump, or of the last instruction before the panic block
+	Block int    `json:"block"` // of the jump
+	Panic int    `json:"panic"` // block of the call
+	Func  string `json:"func"`  // function called, such as runtime.panicBounds
+	File  string `json:"file,omitempty"`
+	Line  int    `json:"line,omitempty"`
+}
+
+// Graph is the control flow graph of a function.
+type Graph struct {
+	Func         string        `json:"func"`
+	Arch         string        `json:"arch"`
+	Start        uint64        `json:"start"`
+	End          uint64        `json:"end"`
+	Blocks       []*Block      `json:"blocks"` // in address order, the entry first
+	Edges        []Edge        `json:"edges"`
+	Loops        []Loop        `json:"loops"`
+	BoundsChecks []BoundsCheck `json:"bounds_checks"`
+}
+
+// Load builds the graph of the function called name in the binary at path.
+func Load(path, name string) (*Graph, error) {
+	f, err := objfile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, err := f.Disasm()
+	if err != nil {
+		return nil, err
+	}
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, err
+	}
+	for _, sym := range syms {
+		if sym.Name == name && (sym.Code == 'T' || sym.Code == 't') {
+			return FromDisasm(d, name, sym.Addr, sym.Addr+uint64(sym.Size))
+		}
+	}
+	return nil, fmt.Errorf("function %s not found", name)
+}
+
+// FromDisasm decodes the function name at [start, end) with d and builds its
+// graph.
+func FromDisasm(d *objfile.Disasm, name string, start, end uint64) (*Graph, error) {
+	var insts []Inst
+	d.Decode(start, end, nil, false, func(pc, size uint64, file string, line int, text string) {
+		insts = append(insts, Inst{PC: pc, Size: size, Text: text, File: file, Line: line})
+	})
+	return Build(name, d.GOARCH(), insts)
+}
+
+// Build builds the graph of the function name from its instructions for
+// goarch, in the Go syntax.
+func Build(name, goarch string, insts []Inst) (*Graph, error) {
+	if len(insts) == 0 {
+		return nil, errors.New("no instructions")
+	}
+	classify, ok := classifiers[goarch]
+	if !ok {
+		return nil, fmt.Errorf("unsupported architecture %s", goarch)
+	}
+	last := insts[len(insts)-1]
+	g := &Graph{
+		Func:         name,
+		Arch:         goarch,
+		Start:        insts[0].PC,
+		End:          last.PC + last.Size,
+		Edges:        []Edge{},
+		Loops:        []Loop{},
+		BoundsChecks: []BoundsCheck{},
+	}
+	insts = append([]Inst(nil), insts...)
+	for i := range insts {
+		classify(&insts[i], g)
+	}
+	g.split(insts)
+	g.link()
+	g.findLoops()
+	g.findBoundsChecks()
+	return g, nil
+}
+
+// in reports whether pc is in the function.
+func (g *Graph) in(pc uint64) bool {
+	return g.Start <= pc && pc < g.End
+}
+
+// split splits insts into blocks.
+func (g *Graph) split(insts []Inst) {
+	leaders := map[uint64]bool{insts[0].PC: true}
+	for i, inst := range insts {
+		if (inst.Kind == Jump || inst.Kind == CondJump) && inst.Target != 0 && g.in(inst.Target) {
+			leaders[inst.Target] = true
+		}
+		if ends(inst) && i+1 < len(insts) {
+			leaders[insts[i+1].PC] = true
+		}
+	}
+	var b *Block
+	for _, inst := range insts {
+		if leaders[inst.PC] {
+			b = &Block{ID: len(g.Blocks), Start: inst.PC, Succs: []int{}, Preds: []int{}, Lines: []string{}}
+			g.Blocks = append(g.Blocks, b)
+		}
+		b.Insts = append(b.Insts, inst)
+		b.End = inst.PC + inst.Size
+		if inst.File != "" {
+			line := fmt.Sprintf("%s:%d", path.Base(inst.File), inst.Line)
+			if !contains(b.Lines, line) {
+				b.Lines = append(b.Lines, line)
+			}
+		}
+	}
+}
+
+// ends reports whether inst ends its block.
+func ends(inst Inst) bool {
+	switch inst.Kind {
+	case Jump, CondJump, IndirectJump, Return, Trap:
+		return true
+	case Call:
+		return noReturn(inst.Callee)
+	}
+	return false
+}
+
+// link adds the edges between the blocks.
+func (g *Graph) link() {
+	starts := map[uint64]int{}
+	for _, b := range g.Blocks {
+		starts[b.Start] = b.ID
+	}
+	add := func(from, to int, kind EdgeKind) {
+		g.Edges = append(g.Edges, Edge{From: from, To: to, Kind: kind})
+		g.Blocks[from].Succs = append(g.Blocks[from].Succs, to)
+		g.Blocks[to].Preds = append(g.Blocks[to].Preds, from)
+	}
+	for _, b := range g.Blocks {
+		inst := b.Insts[len(b.Insts)-1]
+		target, local := starts[inst.Target]
+		local = local && inst.Target != 0
+		switch inst.Kind {
+		case Jump:
+			if local {
+				add(b.ID, target, Goto)
+			}
+		case CondJump:
+			if local {
+				add(b.ID, target, Branch)
+			}
+			if b.ID+1 < len(g.Blocks) {
+				add(b.ID, b.ID+1, Fallthrough)
+			}
+		case IndirectJump, Return, Trap:
+		case Call:
+			if noReturn(inst.Callee) {
+				b.Panic = inst.Callee
+				break
+			}
+			fallthrough
+		default:
+			if b.ID+1 < len(g.Blocks) {
+				add(b.ID, b.ID+1, Fallthrough)
+			}
+		}
+	}
+}
+
+// findBoundsChecks finds the jumps to the calls of the functions reporting
+// an index out of range.
+func (g *Graph) findBoundsChecks() {
+	for _, e := range g.Edges {
+		to := g.Blocks[e.To]
+		if !boundsCheck(to.Panic) {
+			continue
+		}
+		from := g.Blocks[e.From]
+		inst := from.Insts[len(from.Insts)-1]
+		g.BoundsChecks = append(g.BoundsChecks, BoundsCheck{
+			PC:    inst.PC,
+			Block: from.ID,
+			Panic: to.ID,
+			Func:  to.Panic,
+			File:  inst.File,
+			Line:  inst.Line,
+		})
+	}
+	sort.Slice(g.BoundsChecks, func(i, j int) bool { return g.BoundsChecks[i].PC < g.BoundsChecks[j].PC })
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,259 @@
+package cfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildProg builds testdata/prog for goarch.
+func buildProg(t *testing.T, goarch string) string {
+	bin := filepath.Join(t.TempDir(), "prog")
+	cmd := exec.Command("go", "build", "-o", bin, "./testdata/prog")
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+goarch, "CGO_ENABLED=0")
+	out, err := cmd.CombinedOutput()
+	require.Nil(t, err, string(out))
+	return bin
+}
+
+// lineBlocks returns the blocks with instructions of line.
+func lineBlocks(g *Graph, line int) []*Block {
+	var blocks []*Block
+	for _, b := range g.Blocks {
+		for _, inst := range b.Insts {
+			if inst.Line == line {
+				blocks = append(blocks, b)
+				break
+			}
+		}
+	}
+	return blocks
+}
+
+func TestLoad(t *testing.T) {
+	for _, goarch := range []string{"amd64", "arm64"} {
+		t.Run(goarch, func(t *testing.T) {
+			bin := buildProg(t, goarch)
+
+			g, err := Load(bin, "main.sum")
+			require.Nil(t, err)
+			assert.Equal(t, goarch, g.Arch)
+			require.NotEmpty(t, g.Blocks)
+			assert.Equal(t, g.Start, g.Blocks[0].Start)
+
+			// the blocks cover the function
+			pc := g.Start
+			for _, b := range g.Blocks {
+				assert.Equal(t, pc, b.Start)
+				pc = b.End
+			}
+			assert.Equal(t, g.End, pc)
+
+			// one loop, the body of the for statement in it
+			require.Len(t, g.Loops, 1)
+			assert.Equal(t, 1, g.Loops[0].Depth)
+			assert.Equal(t, -1, g.Loops[0].Parent)
+			for _, line := range []int{8, 10} {
+				for _, b := range lineBlocks(g, line) {
+					assert.Equal(t, 1, b.LoopDepth, "line %d", line)
+					assert.Contains(t, g.Loops[0].Blocks, b.ID)
+				}
+			}
+			for _, b := range lineBlocks(g, 13) {
+				assert.Zero(t, b.LoopDepth)
+			}
+			var back int
+			for _, e := range g.Edges {
+				if e.Back {
+					back++
+					assert.Equal(t, g.Loops[0].Header, e.To)
+				}
+			}
+			assert.Equal(t, 1, back)
+
+			// the check of xs[i]
+			require.Len(t, g.BoundsChecks, 1)
+			bc := g.BoundsChecks[0]
+			assert.Equal(t, 7, bc.Line)
+			assert.True(t, boundsCheck(bc.Func), bc.Func)
+			assert.Equal(t, bc.Func, g.Blocks[bc.Panic].Panic)
+			assert.Contains(t, g.Blocks[bc.Block].Succs, bc.Panic)
+			assert.Empty(t, g.Blocks[bc.Panic].Succs)
+
+			// nested loops
+			g, err = Load(bin, "main.matrix")
+			require.Nil(t, err)
+			require.Len(t, g.Loops, 2)
+			outer, inner := g.Loops[0], g.Loops[1]
+			if inner.Depth == 1 {
+				outer, inner = inner, outer
+			}
+			assert.Equal(t, 1, outer.Depth)
+			assert.Equal(t, 2, inner.Depth)
+			assert.Subset(t, outer.Blocks, inner.Blocks)
+			for _, b := range lineBlocks(g, 21) {
+				if g.Blocks[b.ID].Panic == "" {
+					assert.Equal(t, 2, b.LoopDepth)
+				}
+			}
+			// the range loops need none
+			assert.Empty(t, g.BoundsChecks)
+		})
+	}
+
+	_, err := Load(buildProg(t, "amd64"), "main.missing")
+	assert.NotNil(t, err)
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		goarch string
+		text   []string
+		kinds  []Kind
+		succs  [][]int
+	}{
+		{
+			goarch: "amd64",
+			text: []string{
+				"TESTQ AX, AX",
+				"JE 0x100c",
+				"CALL main.g(SB)",
+				"JMP main.f(SB)",
+				"CMPQ CX, $0x3",
+				"JA 0x1016",
+				"JMP AX",
+				"CALL runtime.panicBounds(SB)",
+				"INT $0x3",
+				"RET",
+				"JMP runtime.tail(SB)",
+			},
+			kinds: []Kind{Plain, CondJump, Call, Jump, Plain, CondJump, IndirectJump, Call, Trap, Return, Jump},
+			// b0 [0, 1], b1 [2, 3], b2 [4, 5], b3 [6], b4 [7], b5 [8], b6 [9], b7 [10],
+			// JA jumps out of the function
+			succs: [][]int{{3, 1}, {0}, {3}, {}, {}, {}, {}, {}},
+		},
+		{
+			goarch: "arm64",
+			text: []string{
+				"CMP R16, RSP",
+				"BLS 5(PC)",
+				"CBZ R0, 2(PC)",
+				"CALL main.g(SB)",
+				"TBNZ $3, R1, -2(PC)",
+				"RET",
+				"CALL runtime.morestack_noctxt.abi0(SB)",
+				"JMP main.f(SB)",
+				"?",
+			},
+			kinds: []Kind{Plain, CondJump, CondJump, Call, CondJump, Return, Call, Jump, Trap},
+			// b0 [0, 1], b1 [2], b2 [3], b3 [4], b4 [5], b5 [6, 7], b6 [8]
+			succs: [][]int{{5, 1}, {3, 2}, {3}, {1, 4}, {}, {0}, {}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.goarch, func(t *testing.T) {
+			size := uint64(4)
+			if tt.goarch == "amd64" {
+				size = 2
+			}
+			var insts []Inst
+			for i, text := range tt.text {
+				insts = append(insts, Inst{PC: 0x1000 + uint64(i)*size, Size: size, Text: text, File: "/src/f.go", Line: 10 + i})
+			}
+			g, err := Build("main.f", tt.goarch, insts)
+			require.Nil(t, err)
+			var kinds []Kind
+			for _, b := range g.Blocks {
+				for _, inst := range b.Insts {
+					kinds = append(kinds, inst.Kind)
+				}
+			}
+			assert.Equal(t, tt.kinds, kinds)
+			var succs [][]int
+			for _, b := range g.Blocks {
+				succs = append(succs, b.Succs)
+			}
+			assert.Equal(t, tt.succs, succs)
+			assert.Equal(t, []string{"f.go:10", "f.go:11"}, g.Blocks[0].Lines)
+		})
+	}
+
+	// a tail call
+	g, err := Build("main.f", "amd64", []Inst{
+		{PC: 0x1000, Size: 2, Text: "TESTQ AX, AX", File: "/src/f.go", Line: 10},
+		{PC: 0x1002, Size: 2, Text: "JMP main.g(SB)", File: "/src/f.go", Line: 10},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "main.g", g.Blocks[0].Insts[1].Callee)
+	assert.Empty(t, g.Loops)
+
+	_, err = Build("main.f", "mips", []Inst{{PC: 0x1000, Size: 4, Text: "NOP"}})
+	assert.NotNil(t, err)
+	_, err = Build("main.f", "amd64", nil)
+	assert.NotNil(t, err)
+}
+
+func TestBuildLoops(t *testing.T) {
+	g, err := Build("main.f", "arm64", []Inst{
+		{PC: 0x1000, Size: 4, Text: "MOVD ZR, R2"},
+		{PC: 0x1004, Size: 4, Text: "ADD $1, R2, R2"}, // outer header
+		{PC: 0x1008, Size: 4, Text: "ADD $1, R3, R3"}, // inner header
+		{PC: 0x100c, Size: 4, Text: "BLT -1(PC)"},
+		{PC: 0x1010, Size: 4, Text: "CBNZ R2, -3(PC)"},
+		{PC: 0x1014, Size: 4, Text: "RET"},
+		{PC: 0x1018, Size: 4, Text: "JMP -4(PC)"}, // unreachable
+	})
+	require.Nil(t, err)
+	// b0 [0], b1 [1], b2 [2, 3], b3 [4], b4 [5], b5 [6]
+	require.Len(t, g.Blocks, 6)
+	require.Len(t, g.Loops, 2)
+	assert.Equal(t, Loop{Header: 1, Blocks: []int{1, 2, 3}, Depth: 1, Parent: -1}, g.Loops[0])
+	assert.Equal(t, Loop{Header: 2, Blocks: []int{2}, Depth: 2, Parent: 0}, g.Loops[1])
+	assert.Equal(t, []int{0, 1, 2, 1, 0, 0}, depths(g))
+	assert.True(t, g.Blocks[5].Unreachable)
+	assert.False(t, g.Blocks[4].Unreachable)
+}
+
+func depths(g *Graph) []int {
+	var d []int
+	for _, b := range g.Blocks {
+		d = append(d, b.LoopDepth)
+	}
+	return d
+}
+
+func TestExport(t *testing.T) {
+	g, err := Load(buildProg(t, "amd64"), "main.sum")
+	require.Nil(t, err)
+
+	var dot bytes.Buffer
+	require.Nil(t, g.WriteDOT(&dot, true))
+	assert.Contains(t, dot.String(), `digraph "main.sum" {`)
+	assert.Contains(t, dot.String(), `main.go:7`)
+	assert.Contains(t, dot.String(), `style="dashed"`)
+	assert.Contains(t, dot.String(), `color="red"`)
+	assert.Contains(t, dot.String(), `RET\l`)
+	if _, err := exec.LookPath("dot"); err == nil {
+		cmd := exec.Command("dot", "-Tsvg")
+		cmd.Stdin = &dot
+		out, err := cmd.CombinedOutput()
+		assert.Nil(t, err, string(out))
+	}
+
+	var js bytes.Buffer
+	require.Nil(t, g.WriteJSON(&js))
+	var decoded map[string]interface{}
+	require.Nil(t, json.Unmarshal(js.Bytes(), &decoded))
+	assert.Equal(t, "main.sum", decoded["func"])
+	blocks := decoded["blocks"].([]interface{})
+	assert.Len(t, blocks, len(g.Blocks))
+	first := blocks[0].(map[string]interface{})["insts"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "plain", first["kind"])
+	assert.Len(t, decoded["bounds_checks"], 1)
+}
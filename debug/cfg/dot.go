@@ -0,0 +1,86 @@
+package cfg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteDOT writes the graph in the Graphviz DOT language. The blocks are
+// labeled with their address and source lines, and their instructions if
+// insts is true. The loop headers are bold, the back edges dashed and the
+// panic blocks red.
+func (g *Graph) WriteDOT(w io.Writer, insts bool) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph %q {\n", g.Func)
+	fmt.Fprintf(bw, "\tnode [shape=box fontname=\"monospace\"];\n")
+	headers := map[int]bool{}
+	for _, l := range g.Loops {
+		headers[l.Header] = true
+	}
+	for _, b := range g.Blocks {
+		var label strings.Builder
+		fmt.Fprintf(&label, "b%d %#x", b.ID, b.Start)
+		if b.LoopDepth > 0 {
+			fmt.Fprintf(&label, " loop depth %d", b.LoopDepth)
+		}
+		label.WriteString(`\l`)
+		if len(b.Lines) > 0 {
+			label.WriteString(escape(strings.Join(b.Lines, " ")))
+			label.WriteString(`\l`)
+		}
+		if insts {
+			for _, inst := range b.Insts {
+				fmt.Fprintf(&label, "%#x  %s\\l", inst.PC, escape(inst.Text))
+			}
+		}
+		var attrs []string
+		switch {
+		case b.Panic != "":
+			attrs = append(attrs, `color="red"`, `fontcolor="red"`)
+			fmt.Fprintf(&label, "%s\\l", escape(b.Panic))
+		case b.Unreachable:
+			attrs = append(attrs, `color="gray"`, `fontcolor="gray"`)
+		}
+		if headers[b.ID] {
+			attrs = append(attrs, `style="bold"`)
+		}
+		attrs = append(attrs, fmt.Sprintf(`label="%s"`, label.String()))
+		fmt.Fprintf(bw, "\tb%d [%s];\n", b.ID, strings.Join(attrs, " "))
+	}
+	for _, e := range g.Edges {
+		var attrs []string
+		switch e.Kind {
+		case Branch:
+			attrs = append(attrs, `color="darkgreen"`)
+		case Goto:
+			attrs = append(attrs, `color="blue"`)
+		}
+		if e.Back {
+			attrs = append(attrs, `style="dashed"`)
+		}
+		fmt.Fprintf(bw, "\tb%d -> b%d", e.From, e.To)
+		if len(attrs) > 0 {
+			fmt.Fprintf(bw, " [%s]", strings.Join(attrs, " "))
+		}
+		fmt.Fprintf(bw, ";\n")
+	}
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
+
+// escape escapes s for a DOT label.
+func escape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\t", " ")
+}
+
+// WriteJSON writes the graph as JSON.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
@@ -0,0 +1,168 @@
+package cfg
+
+import (
+	"sort"
+	"strings"
+)
+
+// dominators returns the immediate dominator of each block, -1 for the
+// entry and the unreachable blocks, with the algorithm of Cooper, Harvey
+// and Kennedy, "A Simple, Fast Dominance Algorithm". The unreachable
+// blocks are marked.
+func (g *Graph) dominators() []int {
+	// reverse postorder of the reachable blocks
+	order := make([]int, len(g.Blocks)) // rank in the postorder, -1 unvisited
+	for i := range order {
+		order[i] = -1
+	}
+	var post []int
+	visited := make([]bool, len(g.Blocks))
+	type frame struct{ b, next int }
+	stack := []frame{{0, 0}}
+	visited[0] = true
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		succs := g.Blocks[top.b].Succs
+		if top.next < len(succs) {
+			s := succs[top.next]
+			top.next++
+			if !visited[s] {
+				visited[s] = true
+				stack = append(stack, frame{s, 0})
+			}
+			continue
+		}
+		order[top.b] = len(post)
+		post = append(post, top.b)
+		stack = stack[:len(stack)-1]
+	}
+
+	idom := make([]int, len(g.Blocks))
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[0] = 0
+	intersect := func(a, b int) int {
+		for a != b {
+			for order[a] < order[b] {
+				a = idom[a]
+			}
+			for order[b] < order[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(post) - 1; i >= 0; i-- {
+			b := post[i]
+			if b == 0 {
+				continue
+			}
+			dom := -1
+			for _, p := range g.Blocks[b].Preds {
+				if idom[p] < 0 {
+					continue
+				}
+				if dom < 0 {
+					dom = p
+				} else {
+					dom = intersect(p, dom)
+				}
+			}
+			if dom != idom[b] {
+				idom[b] = dom
+				changed = true
+			}
+		}
+	}
+	idom[0] = -1
+
+	for _, b := range g.Blocks {
+		b.Unreachable = !visited[b.ID]
+	}
+	return idom
+}
+
+// dominates reports whether a dominates b.
+func dominates(idom []int, a, b int) bool {
+	for ; b >= 0; b = idom[b] {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+// findLoops finds the natural loops, those of the back edges to a block
+// dominating their source, and the loop depth of the blocks. The jump back
+// to the entry after growing the stack isn't a loop.
+func (g *Graph) findLoops() {
+	idom := g.dominators()
+	bodies := map[int]map[int]bool{} // by header
+	for i, e := range g.Edges {
+		if g.Blocks[e.From].Unreachable || !dominates(idom, e.To, e.From) ||
+			e.To == 0 && growsStack(g.Blocks[e.From]) {
+			continue
+		}
+		g.Edges[i].Back = true
+		body := bodies[e.To]
+		if body == nil {
+			body = map[int]bool{e.To: true}
+			bodies[e.To] = body
+		}
+		// the blocks reaching the source of the edge without the header
+		work := []int{e.From}
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			if body[b] || g.Blocks[b].Unreachable {
+				continue
+			}
+			body[b] = true
+			work = append(work, g.Blocks[b].Preds...)
+		}
+	}
+
+	var headers []int
+	for h := range bodies {
+		headers = append(headers, h)
+	}
+	sort.Ints(headers)
+	for _, h := range headers {
+		l := Loop{Header: h, Parent: -1}
+		for b := range bodies[h] {
+			l.Blocks = append(l.Blocks, b)
+			g.Blocks[b].LoopDepth++
+		}
+		sort.Ints(l.Blocks)
+		g.Loops = append(g.Loops, l)
+	}
+	// the enclosing loop is the smallest other loop with the header
+	for i := range g.Loops {
+		l := &g.Loops[i]
+		for j, outer := range g.Loops {
+			if j != i && bodies[outer.Header][l.Header] &&
+				(l.Parent < 0 || len(outer.Blocks) < len(g.Loops[l.Parent].Blocks)) {
+				l.Parent = j
+			}
+		}
+	}
+	for i := range g.Loops {
+		for p := i; p >= 0; p = g.Loops[p].Parent {
+			g.Loops[i].Depth++
+		}
+	}
+}
+
+// growsStack reports whether b calls runtime.morestack, in the prologue of
+// the functions.
+func growsStack(b *Block) bool {
+	for _, inst := range b.Insts {
+		if inst.Kind == Call && strings.HasPrefix(inst.Callee, "runtime.morestack") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package main
+
+//go:noinline
+func sum(xs []int, n int) int {
+	s := 0
+	for i := 0; i < n; i++ {
+		if xs[i] > 10 {
+			s += xs[i]
+		} else {
+			s--
+		}
+	}
+	return s
+}
+
+//go:noinline
+func matrix(m [][]int) int {
+	t := 0
+	for i := range m {
+		for j := range m[i] {
+			t += m[i][j] * j
+		}
+	}
+	return t
+}
+
+func main() {
+	println(sum([]int{1, 20, 3}, 3), matrix([][]int{{1, 2}, {3}}))
+}
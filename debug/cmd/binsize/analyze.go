@@ -0,0 +1,477 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hitzhangjie/codemaster/debug/internal/objfile"
+)
+
+// Kinds of items.
+const (
+	KindFunc    = "func"
+	KindGeneric = "generic" // instantiation of a generic function
+	KindType    = "type"    // type descriptor
+	KindItab    = "itab"
+	KindData    = "data"
+	KindPclntab = "pclntab"
+	KindDWARF   = "dwarf"
+	KindOther   = "other"
+)
+
+// Sections of the binary items are attributed to.
+const (
+	SectText    = "text"
+	SectRodata  = "rodata"
+	SectData    = "data"
+	SectBss     = "bss"
+	SectPclntab = "pclntab"
+	SectDWARF   = "dwarf"
+	SectOther   = "other"
+)
+
+// unattributed is the package of the bytes of a section no symbol covers
+const unattributed = "<unattributed>"
+
+// Item is a number of bytes of the binary attributed to a symbol, the
+// runtime metadata of a function or the debug info of a compile unit.
+type Item struct {
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	Kind    string `json:"kind"`
+	Section string `json:"section"`
+	Size    int64  `json:"size"`
+}
+
+// Analysis is the attribution of the bytes of a binary.
+type Analysis struct {
+	Path     string           `json:"path"`
+	FileSize int64            `json:"file_size"`
+	Sections map[string]int64 `json:"sections"` // bytes in the file by section, bss is in memory only
+	Items    []Item           `json:"items"`
+}
+
+// sectionClass returns the class of the ELF section s.
+func sectionClass(s *elf.Section) string {
+	switch {
+	case s.Type == elf.SHT_NOBITS:
+		return SectBss
+	case s.Name == ".gopclntab" || s.Name == ".gosymtab":
+		return SectPclntab
+	case strings.HasPrefix(s.Name, ".debug_") || strings.HasPrefix(s.Name, ".zdebug_"):
+		return SectDWARF
+	case s.Flags&elf.SHF_ALLOC == 0 || s.Type == elf.SHT_NOTE:
+		return SectOther
+	case s.Flags&elf.SHF_EXECINSTR != 0:
+		return SectText
+	case s.Flags&elf.SHF_WRITE != 0:
+		return SectData
+	}
+	return SectRodata
+}
+
+// analyze attributes the bytes of the ELF binary at path.
+func analyze(path string) (*Analysis, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	of, err := objfile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer of.Close()
+	syms, err := of.Symbols()
+	if err != nil {
+		return nil, err
+	}
+	ef, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("only ELF binaries are supported: %v", err)
+	}
+	defer ef.Close()
+
+	a := &Analysis{Path: path, FileSize: st.Size(), Sections: map[string]int64{}}
+	for _, s := range ef.Sections {
+		if s.Type == elf.SHT_NULL {
+			continue
+		}
+		class := sectionClass(s)
+		if class == SectBss {
+			a.Sections[class] += int64(s.Size)
+		} else {
+			a.Sections[class] += int64(s.FileSize)
+		}
+	}
+
+	types, _ := typeDescriptors(ef, syms)
+	a.addSymbols(ef, syms, types)
+	if err := a.addPclntab(ef, syms); err != nil {
+		return nil, err
+	}
+	a.addDWARF(ef)
+	return a, nil
+}
+
+// span is a symbol or a type descriptor in an allocated section
+type span struct {
+	name string
+	addr uint64
+	size uint64
+}
+
+// addSymbols attributes the allocated sections, but the pclntab, to the
+// symbols and the type descriptors in them.
+func (a *Analysis) addSymbols(ef *elf.File, syms []objfile.Sym, types []span) {
+	for _, s := range ef.Sections {
+		class := sectionClass(s)
+		if s.Flags&elf.SHF_ALLOC == 0 || class == SectPclntab || class == SectOther {
+			continue
+		}
+		var spans []span
+		inSection := func(addr uint64) bool { return addr >= s.Addr && addr < s.Addr+s.Size }
+		for _, sym := range syms {
+			if sym.Size > 0 && inSection(sym.Addr) {
+				spans = append(spans, span{sym.Name, sym.Addr, uint64(sym.Size)})
+			}
+		}
+		for _, t := range types {
+			if inSection(t.addr) {
+				spans = append(spans, t)
+			}
+		}
+		// symbols win over type descriptors at the same address
+		sort.SliceStable(spans, func(i, j int) bool { return spans[i].addr < spans[j].addr })
+		var covered uint64
+		end := s.Addr
+		for i, sp := range spans {
+			if i > 0 && sp.addr == spans[i-1].addr {
+				continue
+			}
+			next := s.Addr + s.Size
+			for _, n := range spans[i+1:] {
+				if n.addr > sp.addr {
+					next = n.addr
+					break
+				}
+			}
+			size := sp.size
+			if size == 0 || sp.addr+size > next {
+				size = next - sp.addr
+			}
+			if sp.addr < end {
+				// nested in the previous span
+				continue
+			}
+			end = sp.addr + size
+			covered += size
+			pkg, kind := classify(sp.name)
+			if kind == "" {
+				kind = KindData
+				if class == SectText {
+					kind = KindFunc
+				}
+			}
+			a.Items = append(a.Items, Item{Name: sp.name, Package: pkg, Kind: kind, Section: class, Size: int64(size)})
+		}
+		if covered < s.Size {
+			a.Items = append(a.Items, Item{Name: s.Name, Package: unattributed, Kind: KindOther, Section: class, Size: int64(s.Size - covered)})
+		}
+	}
+	for _, s := range ef.Sections {
+		if s.Type != elf.SHT_NULL && sectionClass(s) == SectOther {
+			a.Items = append(a.Items, Item{Name: s.Name, Package: unattributed, Kind: KindOther, Section: SectOther, Size: int64(s.FileSize)})
+		}
+	}
+}
+
+// addPclntab attributes the pclntab to the functions, the funcdata in
+// go:func.* are attributed to the functions referencing them.
+func (a *Analysis) addPclntab(ef *elf.File, syms []objfile.Sym) error {
+	var total int64
+	for _, s := range ef.Sections {
+		if sectionClass(s) == SectPclntab {
+			total += int64(s.Size)
+		}
+	}
+	s := ef.Section(".gopclntab")
+	if s == nil {
+		if total > 0 {
+			a.Items = append(a.Items, Item{Name: "pclntab", Package: unattributed, Kind: KindPclntab, Section: SectPclntab, Size: total})
+		}
+		return nil
+	}
+	data, err := s.Data()
+	if err != nil {
+		return err
+	}
+	funcs, err := parsePclntab(data, ef.ByteOrder)
+	if err != nil {
+		a.Items = append(a.Items, Item{Name: "pclntab", Package: unattributed, Kind: KindPclntab, Section: SectPclntab, Size: total})
+		return nil
+	}
+	var funcdata map[string]int64
+	for _, sym := range syms {
+		if sym.Name == "go:func.*" && sym.Addr >= s.Addr && sym.Addr < s.Addr+s.Size {
+			funcdata = funcdataSizes(funcs, sym.Size)
+		}
+	}
+	var owned int64
+	for _, fn := range funcs {
+		size := fn.size + funcdata[fn.name]
+		pkg, _ := classify(fn.name)
+		a.Items = append(a.Items, Item{Name: fn.name, Package: pkg, Kind: KindPclntab, Section: SectPclntab, Size: size})
+		owned += size
+	}
+	if owned < total {
+		a.Items = append(a.Items, Item{Name: "pclntab", Package: unattributed, Kind: KindPclntab, Section: SectPclntab, Size: total - owned})
+	}
+	return nil
+}
+
+// addDWARF attributes .debug_info and .debug_line to compile units, the
+// other debug sections are attributed as a whole. Sizes are those in the
+// file, compressed sections are scaled.
+func (a *Analysis) addDWARF(ef *elf.File) {
+	units, _ := dwarfUnits(ef)
+	for _, s := range ef.Sections {
+		if sectionClass(s) != SectDWARF {
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(s.Name, ".debug_"), ".zdebug_")
+		sizes := units[name]
+		var owned int64
+		for _, u := range sizes {
+			size := u.size * int64(s.FileSize) / max64(int64(s.Size), 1)
+			a.Items = append(a.Items, Item{Name: u.unit + " " + s.Name, Package: u.pkg, Kind: KindDWARF, Section: SectDWARF, Size: size})
+			owned += size
+		}
+		if owned < int64(s.FileSize) {
+			a.Items = append(a.Items, Item{Name: s.Name, Package: unattributed, Kind: KindDWARF, Section: SectDWARF, Size: int64(s.FileSize) - owned})
+		}
+	}
+}
+
+// unitSize is the size of the contribution of a compile unit to a debug
+// section
+type unitSize struct {
+	unit, pkg string
+	size      int64
+}
+
+// dwarfUnits returns the sizes of the compile units in .debug_info and
+// .debug_line, uncompressed.
+func dwarfUnits(ef *elf.File) (map[string][]unitSize, error) {
+	dw, err := ef.DWARF()
+	if err != nil {
+		return nil, err
+	}
+	type unit struct {
+		name, pkg string
+		info      int64 // offset of the first entry
+		line      int64
+	}
+	var units []unit
+	r := dw.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		r.SkipChildren()
+		if e.Tag != dwarf.TagCompileUnit {
+			continue
+		}
+		u := unit{info: int64(e.Offset), line: -1}
+		u.name, _ = e.Val(dwarf.AttrName).(string)
+		u.pkg = u.name
+		if lang, _ := e.Val(dwarf.AttrLanguage).(int64); lang != 0x16 { // DW_LANG_Go
+			u.pkg = "<C>"
+		}
+		if off, ok := e.Val(dwarf.AttrStmtList).(int64); ok {
+			u.line = off
+		}
+		units = append(units, u)
+	}
+
+	sizes := map[string][]unitSize{}
+	split := func(name string, offsetOf func(u unit) int64) {
+		s := ef.Section(".debug_" + name)
+		if s == nil {
+			s = ef.Section(".zdebug_" + name)
+		}
+		if s == nil {
+			return
+		}
+		var us []unit
+		for _, u := range units {
+			if offsetOf(u) >= 0 {
+				us = append(us, u)
+			}
+		}
+		sort.Slice(us, func(i, j int) bool { return offsetOf(us[i]) < offsetOf(us[j]) })
+		for i, u := range us {
+			end := int64(s.Size)
+			if i+1 < len(us) {
+				end = offsetOf(us[i+1])
+			}
+			sizes[name] = append(sizes[name], unitSize{unit: u.name, pkg: u.pkg, size: end - offsetOf(u)})
+		}
+	}
+	// the unit headers are attributed to the previous unit, the first
+	// header is left unattributed
+	split("info", func(u unit) int64 { return u.info })
+	split("line", func(u unit) int64 { return u.line })
+	return sizes, nil
+}
+
+// AttrGoRuntimeType is the address of the runtime type descriptor of a
+// type in the DWARF of Go binaries.
+const attrGoRuntimeType dwarf.Attr = 0x2904
+
+// typeDescriptors returns the type descriptors found in the DWARF of ef.
+// Recent linkers write their offsets from runtime.types instead of their
+// addresses.
+func typeDescriptors(ef *elf.File, syms []objfile.Sym) ([]span, error) {
+	dw, err := ef.DWARF()
+	if err != nil {
+		return nil, err
+	}
+	var base uint64
+	for _, sym := range syms {
+		if sym.Name == "runtime.types" {
+			base = sym.Addr
+		}
+	}
+	lowest := ^uint64(0)
+	for _, s := range ef.Sections {
+		if s.Flags&elf.SHF_ALLOC != 0 && s.Addr != 0 && s.Addr < lowest {
+			lowest = s.Addr
+		}
+	}
+	var types []span
+	data := map[*elf.Section][]byte{}
+	r := dw.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		addr, ok := e.Val(attrGoRuntimeType).(uint64)
+		if !ok || addr == 0 {
+			continue
+		}
+		if addr < lowest {
+			addr += base
+		}
+		var size uint64
+		for _, s := range ef.Sections {
+			if s.Type != elf.SHT_NOBITS && addr >= s.Addr && addr < s.Addr+s.Size {
+				if _, ok := data[s]; !ok {
+					data[s], _ = s.Data()
+				}
+				size = descriptorSize(ef, data[s], addr-s.Addr)
+				break
+			}
+		}
+		name, _ := e.Val(dwarf.AttrName).(string)
+		types = append(types, span{name: "type:" + name, addr: addr, size: size})
+	}
+	return types, nil
+}
+
+// classify returns the package and the kind of the symbol name, the kind
+// is empty if it depends on the section of the symbol.
+func classify(name string) (pkg, kind string) {
+	switch {
+	case strings.HasPrefix(name, "type:") || strings.HasPrefix(name, "type."):
+		return typePackage(name[len("type:"):]), KindType
+	case strings.HasPrefix(name, "go:itab.") || strings.HasPrefix(name, "go.itab."):
+		typ := name[len("go:itab."):]
+		if i := strings.Index(typ, ","); i >= 0 {
+			typ = typ[:i]
+		}
+		return typePackage(typ), KindItab
+	case strings.HasPrefix(name, "go:") || strings.HasPrefix(name, "go.") || strings.HasPrefix(name, "$"):
+		return "<go>", ""
+	}
+	if strings.Contains(name, "[") {
+		return packageOf(name), KindGeneric
+	}
+	return packageOf(name), ""
+}
+
+// packageOf returns the import path of the package of the symbol name, the
+// dots of the last element of the path are escaped by the linker.
+func packageOf(name string) string {
+	if i := strings.IndexAny(name, "[("); i >= 0 {
+		name = name[:i]
+	}
+	slash := strings.LastIndex(name, "/") + 1
+	if dot := strings.Index(name[slash:], "."); dot >= 0 {
+		return name[:slash+dot]
+	}
+	return name
+}
+
+// typePackage returns the package of the first named type in the type
+// expression typ.
+func typePackage(typ string) string {
+	for len(typ) > 0 {
+		switch {
+		case typ[0] == '*' || typ[0] == '[' || typ[0] == ']' || (typ[0] >= '0' && typ[0] <= '9'):
+			typ = typ[1:]
+		case strings.HasPrefix(typ, "map["):
+			typ = typ[len("map["):]
+		case strings.HasPrefix(typ, "chan "):
+			typ = typ[len("chan "):]
+		case strings.HasPrefix(typ, "<-chan "):
+			typ = typ[len("<-chan "):]
+		default:
+			end := strings.IndexAny(typ, "[]*(), ;{}")
+			if end >= 0 {
+				typ = typ[:end]
+			}
+			if !strings.Contains(typ, ".") {
+				return "<builtin>"
+			}
+			return packageOf(typ)
+		}
+	}
+	return "<builtin>"
+}
+
+// genericBase returns the name of the generic function of the
+// instantiation name, without type arguments.
+func genericBase(name string) string {
+	var sb strings.Builder
+	depth := 0
+	for _, c := range name {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0:
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const program = `package main
+
+type T struct{ a, b int }
+
+//go:noinline
+func Map[X, Y any](xs []X, f func(X) Y) []Y {
+	ys := make([]Y, 0, len(xs))
+	for _, x := range xs {
+		ys = append(ys, f(x))
+	}
+	return ys
+}
+
+var sink interface{}
+
+func main() {
+	sink = Map([]int{1, 2}, func(i int) string { return string(rune('a' + i)) })
+	sink = &T{1, 2}
+	%s
+}
+`
+
+// build builds the program with extra statements in main.
+func build(t *testing.T, name, extra string) string {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	require.Nil(t, os.WriteFile(src, []byte(strings.Replace(program, "%s", extra, 1)), 0644))
+	bin := filepath.Join(dir, name)
+	cmd := exec.Command("go", "build", "-o", bin, src)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "CGO_ENABLED=0")
+	out, err := cmd.CombinedOutput()
+	require.Nil(t, err, string(out))
+	return bin
+}
+
+func find(items []Item, name, section string) *Item {
+	for i := range items {
+		if items[i].Name == name && items[i].Section == section {
+			return &items[i]
+		}
+	}
+	return nil
+}
+
+func TestAnalyze(t *testing.T) {
+	bin := build(t, "old", "")
+	a, err := analyze(bin)
+	require.Nil(t, err)
+
+	var total int64
+	for s, size := range a.Sections {
+		if s != SectBss {
+			total += size
+		}
+	}
+	assert.LessOrEqual(t, total, a.FileSize)
+
+	// each section is fully attributed
+	bySection := map[string]int64{}
+	for _, it := range a.Items {
+		bySection[it.Section] += it.Size
+	}
+	for _, s := range []string{SectText, SectRodata, SectData, SectPclntab, SectDWARF} {
+		assert.Equal(t, a.Sections[s], bySection[s], s)
+	}
+
+	main := find(a.Items, "main.main", SectText)
+	require.NotNil(t, main)
+	assert.Equal(t, "main", main.Package)
+	assert.Equal(t, KindFunc, main.Kind)
+	assert.NotNil(t, find(a.Items, "main.main", SectPclntab))
+
+	inst := find(a.Items, "main.Map[go.shape.int,go.shape.string]", SectText)
+	require.NotNil(t, inst)
+	assert.Equal(t, KindGeneric, inst.Kind)
+	assert.Equal(t, "main", inst.Package)
+	var generic *Generic
+	for _, g := range a.Generics() {
+		if g.Name == "main.Map" {
+			generic = &g
+		}
+	}
+	require.NotNil(t, generic)
+	assert.Equal(t, 1, generic.Instantiations)
+	assert.Greater(t, generic.Size, inst.Size)
+
+	typ := find(a.Items, "type:main.T", SectRodata)
+	require.NotNil(t, typ)
+	assert.Equal(t, KindType, typ.Kind)
+	assert.Equal(t, "main", typ.Package)
+
+	var dwarf int64
+	for _, it := range a.Items {
+		if it.Package == "main" && it.Section == SectDWARF {
+			dwarf += it.Size
+		}
+	}
+	assert.Greater(t, dwarf, int64(0))
+
+	tree := a.Treemap()
+	var sum int64
+	for _, c := range tree.Children {
+		sum += c.Value
+	}
+	assert.Equal(t, tree.Value, sum)
+	data, err := json.Marshal(tree)
+	require.Nil(t, err)
+	assert.Contains(t, string(data), `"name":"main.main"`)
+
+	var sb bytes.Buffer
+	a.Report(&sb, 10)
+	assert.Contains(t, sb.String(), "main.Map")
+}
+
+func TestDiff(t *testing.T) {
+	old := build(t, "old", "")
+	new := build(t, "new", "sink = Map([]string{\"a\"}, func(s string) int { return len(s) })")
+	a, err := analyze(old)
+	require.Nil(t, err)
+	b, err := analyze(new)
+	require.Nil(t, err)
+
+	d := diff(a, b)
+	assert.Equal(t, b.FileSize-a.FileSize, d.NewSize-d.OldSize)
+	var added *Delta
+	for i, s := range d.Symbols {
+		if s.Name == "main.Map[go.shape.string,go.shape.int]" {
+			added = &d.Symbols[i]
+		}
+	}
+	require.NotNil(t, added)
+	assert.Equal(t, int64(0), added.Old)
+	assert.Equal(t, added.New, added.Delta)
+
+	var main *Node
+	for _, c := range d.Growth.Children {
+		if c.Name == "main" {
+			main = c
+		}
+	}
+	require.NotNil(t, main)
+	assert.GreaterOrEqual(t, main.Value, added.Delta)
+
+	var sb bytes.Buffer
+	d.Report(&sb, -1)
+	assert.Contains(t, sb.String(), "main.Map[go.shape.string,go.shape.int]")
+}
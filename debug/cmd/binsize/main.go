@@ -0,0 +1,90 @@
+// Command binsize attributes the bytes of a Go binary to packages,
+// functions, generic instantiations and type descriptors: text, rodata,
+// data, the pclntab and the DWARF of each compile unit.
+//
+// Usage:
+//
+//	binsize [-json file] [-top n] binary
+//	binsize -diff [-json file] [-top n] old new
+//
+// The text report lists the largest sections, packages, symbols and generic
+// functions. With -json the treemap of the binary, or the diff of the two
+// binaries with the treemap of the bytes added, is written to file, "-"
+// for stdout.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	var (
+		diffMode = flag.Bool("diff", false, "compare the binaries old and new")
+		jsonOut  = flag.String("json", "", "write the treemap or the diff as JSON to `file`, - for stdout")
+		top      = flag.Int("top", 20, "report the `n` largest entries of each table, 0 for all")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: binsize [flags] binary\n       binsize -diff [flags] old new\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if (*diffMode && flag.NArg() != 2) || (!*diffMode && flag.NArg() != 1) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *top <= 0 {
+		*top = -1
+	}
+
+	var (
+		report func(w io.Writer, top int)
+		tree   interface{}
+	)
+	a, err := analyze(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	if *diffMode {
+		b, err := analyze(flag.Arg(1))
+		if err != nil {
+			fatal(err)
+		}
+		d := diff(a, b)
+		report, tree = d.Report, d
+	} else {
+		report, tree = a.Report, a.Treemap()
+	}
+
+	if *jsonOut != "-" {
+		report(os.Stdout, *top)
+	}
+	if *jsonOut != "" {
+		if err := writeJSON(*jsonOut, tree); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+func writeJSON(path string, v interface{}) error {
+	w := os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "binsize:", err)
+	os.Exit(1)
+}
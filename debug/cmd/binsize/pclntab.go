@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// pclntab magic numbers of the layouts of _func supported
+const (
+	go118PcLnTabMagic = 0xfffffff0
+	go120PcLnTabMagic = 0xfffffff1
+)
+
+// funcTable is the size of the runtime metadata of a function in the
+// pclntab: its functab entry, _func, name and pc value tables.
+type funcTable struct {
+	name     string
+	size     int64
+	funcdata []uint32 // offsets of the funcdata of the function in go:func.*
+}
+
+// parsePclntab returns the pclntab bytes of each function, the bytes not
+// owned by a function (header, file and cu tables) are the difference with
+// the size of the section.
+func parsePclntab(data []byte, order binary.ByteOrder) ([]funcTable, error) {
+	if len(data) < 8 {
+		return nil, errors.New("pclntab too short")
+	}
+	magic := order.Uint32(data)
+	if magic != go118PcLnTabMagic && magic != go120PcLnTabMagic {
+		return nil, errors.New("unsupported pclntab version, only go1.18 and later are supported")
+	}
+	ptrSize := int(data[7])
+	if ptrSize != 4 && ptrSize != 8 {
+		return nil, errors.New("bad pointer size in pclntab header")
+	}
+	word := func(i int) uint64 {
+		off := 8 + i*ptrSize
+		if off+ptrSize > len(data) {
+			return 0
+		}
+		if ptrSize == 4 {
+			return uint64(order.Uint32(data[off:]))
+		}
+		return order.Uint64(data[off:])
+	}
+	var (
+		nfunc       = int(word(0))
+		funcnametab = word(3)
+		pctab       = word(6)
+		pcln        = word(7)
+	)
+	funcSize := 40 // _func without startLine
+	if magic == go120PcLnTabMagic {
+		funcSize = 44
+	}
+	if pcln+uint64(nfunc+1)*8 > uint64(len(data)) {
+		return nil, errors.New("functab out of pclntab")
+	}
+
+	u32 := func(off uint64) uint32 {
+		if off+4 > uint64(len(data)) {
+			return 0
+		}
+		return order.Uint32(data[off:])
+	}
+	// pc value tables can be shared by functions, a table is
+	// attributed to the first function using it
+	seen := map[uint32]bool{}
+	tableSize := func(off uint32) int64 {
+		if off == 0 || seen[off] {
+			return 0
+		}
+		seen[off] = true
+		return pcvalueTableSize(data, pctab+uint64(off))
+	}
+
+	funcs := make([]funcTable, 0, nfunc)
+	for i := 0; i < nfunc; i++ {
+		fn := pcln + uint64(u32(pcln+uint64(i)*8+4))
+		if fn+uint64(funcSize) > uint64(len(data)) {
+			return nil, errors.New("_func out of pclntab")
+		}
+		nameOff := u32(fn + 4)
+		npcdata := u32(fn + 28)
+		nfuncdata := uint32(data[fn+uint64(funcSize)-1])
+
+		ft := funcTable{name: cstring(data, funcnametab+uint64(nameOff))}
+		ft.size = 8 + int64(funcSize) + 4*int64(npcdata+nfuncdata) + int64(len(ft.name)) + 1
+		for _, off := range []uint64{16, 20, 24} { // pcsp, pcfile, pcln
+			ft.size += tableSize(u32(fn + off))
+		}
+		tables := fn + uint64(funcSize)
+		for j := uint32(0); j < npcdata; j++ {
+			ft.size += tableSize(u32(tables + uint64(j)*4))
+		}
+		for j := uint32(0); j < nfuncdata; j++ {
+			if off := u32(tables + uint64(npcdata+j)*4); off != ^uint32(0) {
+				ft.funcdata = append(ft.funcdata, off)
+			}
+		}
+		funcs = append(funcs, ft)
+	}
+	return funcs, nil
+}
+
+// pcvalueTableSize returns the size of the pc value table at off, pairs of
+// value and pc deltas ending with a zero value delta.
+func pcvalueTableSize(data []byte, off uint64) int64 {
+	start := off
+	for first := true; off < uint64(len(data)); first = false {
+		uvdelta, n := binary.Uvarint(data[off:])
+		if n <= 0 {
+			break
+		}
+		off += uint64(n)
+		if uvdelta == 0 && !first {
+			break
+		}
+		_, n = binary.Uvarint(data[off:])
+		if n <= 0 {
+			break
+		}
+		off += uint64(n)
+	}
+	return int64(off - start)
+}
+
+// funcdataSizes attributes the funcdata blobs in go:func.*, of size total,
+// to the functions referencing them. The blobs have no size, a blob ends
+// where the next one starts.
+func funcdataSizes(funcs []funcTable, total int64) map[string]int64 {
+	owner := map[uint32]string{}
+	var offs []uint32
+	for _, fn := range funcs {
+		for _, off := range fn.funcdata {
+			if _, ok := owner[off]; !ok {
+				owner[off] = fn.name
+				offs = append(offs, off)
+			}
+		}
+	}
+	sort.Slice(offs, func(i, j int) bool { return offs[i] < offs[j] })
+	sizes := map[string]int64{}
+	for i, off := range offs {
+		end := total
+		if i+1 < len(offs) {
+			end = int64(offs[i+1])
+		}
+		if int64(off) < end {
+			sizes[owner[off]] += end - int64(off)
+		}
+	}
+	return sizes
+}
+
+func cstring(data []byte, off uint64) string {
+	if off >= uint64(len(data)) {
+		return ""
+	}
+	end := off
+	for end < uint64(len(data)) && data[end] != 0 {
+		end++
+	}
+	return string(data[off:end])
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Node is a node of a treemap, the value of an inner node is the sum of the
+// values of its children. The JSON is that read by d3-hierarchy and
+// most treemap viewers.
+type Node struct {
+	Name     string  `json:"name"`
+	Value    int64   `json:"value"`
+	Children []*Node `json:"children,omitempty"`
+}
+
+// child returns the child name of n, it is added if missing.
+func (n *Node) child(name string) *Node {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &Node{Name: name}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// add adds value to the node at path under n and its ancestors.
+func (n *Node) add(value int64, path ...string) {
+	n.Value += value
+	for _, name := range path {
+		n = n.child(name)
+		n.Value += value
+	}
+}
+
+// sort sorts the children of n by decreasing value, recursively.
+func (n *Node) sort() {
+	sort.SliceStable(n.Children, func(i, j int) bool { return n.Children[i].Value > n.Children[j].Value })
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+// Treemap returns the treemap of the file bytes of the binary: package,
+// section then symbol. The bss takes no space in the file and is left out.
+func (a *Analysis) Treemap() *Node {
+	root := &Node{Name: a.Path}
+	for _, it := range a.Items {
+		if it.Section != SectBss {
+			root.add(it.Size, it.Package, it.Section, it.Name)
+		}
+	}
+	root.sort()
+	return root
+}
+
+// PackageSize is the size of a package in each section.
+type PackageSize struct {
+	Package  string           `json:"package"`
+	Sections map[string]int64 `json:"sections"`
+	Total    int64            `json:"total"` // in the file
+}
+
+// Packages returns the sizes of the packages, largest first.
+func (a *Analysis) Packages() []PackageSize {
+	byPkg := map[string]*PackageSize{}
+	for _, it := range a.Items {
+		p := byPkg[it.Package]
+		if p == nil {
+			p = &PackageSize{Package: it.Package, Sections: map[string]int64{}}
+			byPkg[it.Package] = p
+		}
+		p.Sections[it.Section] += it.Size
+		if it.Section != SectBss {
+			p.Total += it.Size
+		}
+	}
+	pkgs := make([]PackageSize, 0, len(byPkg))
+	for _, p := range byPkg {
+		pkgs = append(pkgs, *p)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Total != pkgs[j].Total {
+			return pkgs[i].Total > pkgs[j].Total
+		}
+		return pkgs[i].Package < pkgs[j].Package
+	})
+	return pkgs
+}
+
+// Symbols returns the file size of each symbol summed over the sections,
+// its code, pclntab and type descriptor, largest first.
+func (a *Analysis) Symbols() []Item {
+	bySym := map[string]*Item{}
+	var syms []*Item
+	for _, it := range a.Items {
+		if it.Section == SectBss || it.Package == unattributed || it.Kind == KindDWARF {
+			continue
+		}
+		s := bySym[it.Name]
+		if s == nil {
+			s = &Item{Name: it.Name, Package: it.Package, Kind: it.Kind}
+			bySym[it.Name] = s
+			syms = append(syms, s)
+		}
+		if s.Kind == KindPclntab {
+			s.Kind = it.Kind
+		}
+		s.Size += it.Size
+	}
+	items := make([]Item, len(syms))
+	for i, s := range syms {
+		items[i] = *s
+		items[i].Section = ""
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Size > items[j].Size })
+	return items
+}
+
+// Generic is the size of the instantiations of a generic function.
+type Generic struct {
+	Name           string `json:"name"`
+	Instantiations int    `json:"instantiations"`
+	Size           int64  `json:"size"`
+}
+
+// Generics returns the size of the instantiations of the generic functions,
+// largest first.
+func (a *Analysis) Generics() []Generic {
+	byBase := map[string]*Generic{}
+	insts := map[string]bool{}
+	for _, it := range a.Items {
+		if it.Kind != KindGeneric && !(it.Kind == KindPclntab && strings.Contains(it.Name, "[")) {
+			continue
+		}
+		base := genericBase(it.Name)
+		g := byBase[base]
+		if g == nil {
+			g = &Generic{Name: base}
+			byBase[base] = g
+		}
+		g.Size += it.Size
+		if !insts[it.Name] {
+			insts[it.Name] = true
+			g.Instantiations++
+		}
+	}
+	generics := make([]Generic, 0, len(byBase))
+	for _, g := range byBase {
+		generics = append(generics, *g)
+	}
+	sort.Slice(generics, func(i, j int) bool {
+		if generics[i].Size != generics[j].Size {
+			return generics[i].Size > generics[j].Size
+		}
+		return generics[i].Name < generics[j].Name
+	})
+	return generics
+}
+
+// sectionOrder is the order of the columns of the reports
+var sectionOrder = []string{SectText, SectRodata, SectData, SectPclntab, SectDWARF, SectOther, SectBss}
+
+// Report writes the top packages, symbols and generic functions of a.
+func (a *Analysis) Report(w io.Writer, top int) {
+	fmt.Fprintf(w, "%s: %d bytes\n\n", a.Path, a.FileSize)
+	for _, s := range sectionOrder {
+		if size, ok := a.Sections[s]; ok {
+			fmt.Fprintf(w, "%-10s %10d\n", s, size)
+		}
+	}
+
+	fmt.Fprintf(w, "\n%10s %10s %10s %10s %10s %10s  %s\n", "total", "text", "rodata", "data", "pclntab", "dwarf", "package")
+	for i, p := range a.Packages() {
+		if i == top {
+			break
+		}
+		s := p.Sections
+		fmt.Fprintf(w, "%10d %10d %10d %10d %10d %10d  %s\n", p.Total, s[SectText], s[SectRodata], s[SectData], s[SectPclntab], s[SectDWARF], p.Package)
+	}
+
+	fmt.Fprintf(w, "\n%10s  %-8s %s\n", "size", "kind", "symbol")
+	for i, s := range a.Symbols() {
+		if i == top {
+			break
+		}
+		fmt.Fprintf(w, "%10d  %-8s %s\n", s.Size, s.Kind, s.Name)
+	}
+
+	if generics := a.Generics(); len(generics) > 0 {
+		fmt.Fprintf(w, "\n%10s %6s  %s\n", "size", "insts", "generic")
+		for i, g := range generics {
+			if i == top {
+				break
+			}
+			fmt.Fprintf(w, "%10d %6d  %s\n", g.Size, g.Instantiations, g.Name)
+		}
+	}
+}
+
+// Delta is the change of size of a package or a symbol.
+type Delta struct {
+	Name  string `json:"name"`
+	Old   int64  `json:"old"`
+	New   int64  `json:"new"`
+	Delta int64  `json:"delta"`
+}
+
+// Diff is the change of size between two binaries.
+type Diff struct {
+	Old      string  `json:"old"`
+	New      string  `json:"new"`
+	OldSize  int64   `json:"old_size"`
+	NewSize  int64   `json:"new_size"`
+	Sections []Delta `json:"sections"`
+	Packages []Delta `json:"packages"`
+	Symbols  []Delta `json:"symbols"`
+	// Growth is the treemap of the bytes added, package then symbol.
+	Growth *Node `json:"growth"`
+}
+
+// diff returns the change of size from old to new.
+func diff(old, new *Analysis) *Diff {
+	d := &Diff{Old: old.Path, New: new.Path, OldSize: old.FileSize, NewSize: new.FileSize}
+	oldSections, newSections := map[string]int64{}, map[string]int64{}
+	for _, s := range sectionOrder {
+		oldSections[s], newSections[s] = old.Sections[s], new.Sections[s]
+	}
+	d.Sections = deltas(oldSections, newSections)
+
+	pkgSizes := func(a *Analysis) map[string]int64 {
+		m := map[string]int64{}
+		for _, p := range a.Packages() {
+			m[p.Package] = p.Total
+		}
+		return m
+	}
+	d.Packages = deltas(pkgSizes(old), pkgSizes(new))
+
+	symSizes := func(a *Analysis) (map[string]int64, map[string]string) {
+		m, pkgs := map[string]int64{}, map[string]string{}
+		for _, s := range a.Symbols() {
+			m[s.Name] = s.Size
+			pkgs[s.Name] = s.Package
+		}
+		return m, pkgs
+	}
+	oldSyms, _ := symSizes(old)
+	newSyms, pkgs := symSizes(new)
+	d.Symbols = deltas(oldSyms, newSyms)
+
+	d.Growth = &Node{Name: new.Path}
+	for _, s := range d.Symbols {
+		if s.Delta > 0 {
+			d.Growth.add(s.Delta, pkgs[s.Name], s.Name)
+		}
+	}
+	d.Growth.sort()
+	return d
+}
+
+// deltas returns the changes from old to new, the largest first, the
+// unchanged entries are left out.
+func deltas(old, new map[string]int64) []Delta {
+	var ds []Delta
+	for name, n := range new {
+		if o := old[name]; o != n {
+			ds = append(ds, Delta{Name: name, Old: o, New: n, Delta: n - o})
+		}
+	}
+	for name, o := range old {
+		if _, ok := new[name]; !ok && o != 0 {
+			ds = append(ds, Delta{Name: name, Old: o, Delta: -o})
+		}
+	}
+	sort.Slice(ds, func(i, j int) bool {
+		ai, aj := abs(ds[i].Delta), abs(ds[j].Delta)
+		if ai != aj {
+			return ai > aj
+		}
+		return ds[i].Name < ds[j].Name
+	})
+	return ds
+}
+
+// Report writes the changes of sizes of d.
+func (d *Diff) Report(w io.Writer, top int) {
+	fmt.Fprintf(w, "%s: %d bytes\n%s: %d bytes (%+d)\n", d.Old, d.OldSize, d.New, d.NewSize, d.NewSize-d.OldSize)
+	writeDeltas := func(title string, ds []Delta) {
+		fmt.Fprintf(w, "\n%10s %10s %10s  %s\n", "old", "new", "delta", title)
+		for i, delta := range ds {
+			if i == top {
+				break
+			}
+			fmt.Fprintf(w, "%10d %10d %+10d  %s\n", delta.Old, delta.New, delta.Delta, delta.Name)
+		}
+	}
+	writeDeltas("section", d.Sections)
+	writeDeltas("package", d.Packages)
+	writeDeltas("symbol", d.Symbols)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
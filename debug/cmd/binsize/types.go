@@ -0,0 +1,98 @@
+package main
+
+import (
+	"debug/elf"
+)
+
+// kinds of the runtime types, internal/abi.Kind
+const (
+	kindArray     = 17
+	kindChan      = 18
+	kindFunc      = 19
+	kindInterface = 20
+	kindMap       = 21
+	kindPointer   = 22
+	kindSlice     = 23
+	kindStruct    = 25
+	kindMask      = 1<<5 - 1
+
+	tflagUncommon = 1 << 0
+)
+
+// descriptorSize returns the size of the type descriptor at off in data, the
+// internal/abi.Type with the kind specific fields, the uncommon type and
+// the arrays following it. It returns 0 if the descriptor can't be read.
+//
+// The names and the gc data of the type are shared and not included. The
+// size of map types is that of the swiss maps of go1.24 and later.
+func descriptorSize(ef *elf.File, data []byte, off uint64) uint64 {
+	ptr := uint64(8)
+	if ef.Class == elf.ELFCLASS32 {
+		ptr = 4
+	}
+	u8 := func(o uint64) uint64 {
+		if off+o >= uint64(len(data)) {
+			return 0
+		}
+		return uint64(data[off+o])
+	}
+	u16 := func(o uint64) uint64 {
+		if off+o+2 > uint64(len(data)) {
+			return 0
+		}
+		return uint64(ef.ByteOrder.Uint16(data[off+o:]))
+	}
+	u32 := func(o uint64) uint64 {
+		if off+o+4 > uint64(len(data)) {
+			return 0
+		}
+		return uint64(ef.ByteOrder.Uint32(data[off+o:]))
+	}
+	word := func(o uint64) uint64 {
+		if ptr == 4 {
+			return u32(o)
+		}
+		if off+o+8 > uint64(len(data)) {
+			return 0
+		}
+		return ef.ByteOrder.Uint64(data[off+o:])
+	}
+	header := 4*ptr + 16 // Size_, PtrBytes, Hash, flags and kind, Equal, GCData, Str, PtrToThis
+	if off+header > uint64(len(data)) {
+		return 0
+	}
+	tflag, kind := u8(2*ptr+4), u8(2*ptr+7)&kindMask
+
+	size := header
+	var trailing uint64 // arrays after the uncommon type
+	switch kind {
+	case kindArray:
+		size += 3 * ptr
+	case kindChan:
+		size += 2 * ptr
+	case kindFunc:
+		size += ptr // in and out counts, padded
+		in, out := u16(header), u16(header+2)&(1<<15-1)
+		trailing = (in + out) * ptr
+	case kindInterface:
+		size += 4 * ptr
+		trailing = word(header+2*ptr) * 8 // imethods
+	case kindMap:
+		size += 8 * ptr
+	case kindPointer, kindSlice:
+		size += ptr
+	case kindStruct:
+		size += 4 * ptr
+		trailing = word(header+2*ptr) * 3 * ptr // fields
+	}
+	if tflag&tflagUncommon != 0 {
+		uncommon := size
+		size += 16
+		if mcount := u16(uncommon + 4); mcount > 0 {
+			if end := u32(uncommon+8) + mcount*16; uncommon+end > size+trailing {
+				return uncommon + end
+			}
+		}
+	}
+	return size + trailing
+}
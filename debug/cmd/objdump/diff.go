@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// FuncDiff is the diff of the disassembly of a function in two builds.
+type FuncDiff struct {
+	Name    string     `json:"name"`
+	OldSize uint64     `json:"old_size"` // 0 if the function is new
+	NewSize uint64     `json:"new_size"` // 0 if the function was removed
+	Lines   []DiffLine `json:"lines"`
+}
+
+// DiffLine is an instruction of the diff, the offsets are those of the
+// instruction in the old and the new function.
+type DiffLine struct {
+	Op        string `json:"op"` // " " for instructions in both, "-" or "+"
+	Text      string `json:"text"`
+	OldOffset uint64 `json:"old_offset,omitempty"`
+	NewOffset uint64 `json:"new_offset,omitempty"`
+}
+
+// Changed reports whether the function changed.
+func (d FuncDiff) Changed() bool {
+	for _, l := range d.Lines {
+		if l.Op != " " {
+			return true
+		}
+	}
+	return false
+}
+
+// diffFuncs diffs the functions of the same name of old and new.
+func diffFuncs(old, new []Func) []FuncDiff {
+	byName := map[string]*Func{}
+	for i := range new {
+		byName[new[i].Name] = &new[i]
+	}
+	var diffs []FuncDiff
+	seen := map[string]bool{}
+	for i := range old {
+		fn := &old[i]
+		seen[fn.Name] = true
+		diffs = append(diffs, diffFunc(fn.Name, fn, byName[fn.Name]))
+	}
+	for i := range new {
+		if !seen[new[i].Name] {
+			diffs = append(diffs, diffFunc(new[i].Name, nil, &new[i]))
+		}
+	}
+	return diffs
+}
+
+// diffFunc diffs the instructions of old and new, either can be nil.
+// The instructions are compared without the addresses in the functions,
+// which change with the code before them.
+func diffFunc(name string, old, new *Func) FuncDiff {
+	d := FuncDiff{Name: name}
+	var a, b []string
+	if old != nil {
+		d.OldSize = old.End - old.Start
+		a = normalize(old)
+	}
+	if new != nil {
+		d.NewSize = new.End - new.Start
+		b = normalize(new)
+	}
+	for _, e := range diffLines(a, b) {
+		l := DiffLine{Op: string(e.op)}
+		if e.a >= 0 {
+			l.Text = a[e.a]
+			l.OldOffset = old.Insts[e.a].PC - old.Start
+		}
+		if e.b >= 0 {
+			l.Text = b[e.b]
+			l.NewOffset = new.Insts[e.b].PC - new.Start
+		}
+		d.Lines = append(d.Lines, l)
+	}
+	return d
+}
+
+// hexNumber is a number of the text of an instruction
+var hexNumber = regexp.MustCompile(`\b0x[0-9a-f]+\b`)
+
+// normalize returns the text of the instructions of fn, the addresses in
+// fn replaced by their offset.
+func normalize(fn *Func) []string {
+	lines := make([]string, len(fn.Insts))
+	for i, inst := range fn.Insts {
+		lines[i] = hexNumber.ReplaceAllStringFunc(inst.Text, func(s string) string {
+			v, err := strconv.ParseUint(s[2:], 16, 64)
+			if err != nil || v < fn.Start || v >= fn.End {
+				return s
+			}
+			return fmt.Sprintf(".+%#x", v-fn.Start)
+		})
+	}
+	return lines
+}
+
+// edit is an element of an edit script, a and b are the indices of the
+// lines in the old and the new text, -1 for the lines added or removed.
+type edit struct {
+	op   byte
+	a, b int
+}
+
+// diffLines returns the shortest edit script from a to b, Myers' algorithm.
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] is the window [-d, d] of v before the step d
+	var trace [][]int
+	var d int
+search:
+	for d = 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// backtrack from the end, the edits are found in reverse
+	var edits []edit
+	x, y := n, m
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || k != d && v[d+k-1] < v[d+k+1] {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[d+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			edits = append(edits, edit{' ', x, y})
+		}
+		if x == prevX {
+			y--
+			edits = append(edits, edit{'+', -1, y})
+		} else {
+			x--
+			edits = append(edits, edit{'-', x, -1})
+		}
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		edits = append(edits, edit{' ', x, y})
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// printDiffs prints the changed functions of diffs as unified diffs with
+// context lines of context around the changes.
+func printDiffs(w io.Writer, diffs []FuncDiff, oldName, newName string, context int) {
+	for _, d := range diffs {
+		if !d.Changed() {
+			fmt.Fprintf(w, "%s: unchanged (%d bytes)\n", d.Name, d.OldSize)
+			continue
+		}
+		fmt.Fprintf(w, "--- %s %s (%d bytes)\n+++ %s %s (%d bytes)\n", oldName, d.Name, d.OldSize, newName, d.Name, d.NewSize)
+		for _, h := range hunks(d.Lines, context) {
+			var na, nb int
+			for _, l := range h.lines {
+				if l.Op != "+" {
+					na++
+				}
+				if l.Op != "-" {
+					nb++
+				}
+			}
+			fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", h.a+1, na, h.b+1, nb)
+			for _, l := range h.lines {
+				off := l.OldOffset
+				if l.Op == "+" {
+					off = l.NewOffset
+				}
+				fmt.Fprintf(w, "%s %#6x\t%s\n", l.Op, off, l.Text)
+			}
+		}
+	}
+}
+
+// hunk is a run of lines of a diff, starting at the instructions a and b of
+// the old and the new function.
+type hunk struct {
+	a, b  int
+	lines []DiffLine
+}
+
+// hunks returns the changes of lines with context lines around them.
+func hunks(lines []DiffLine, context int) []hunk {
+	near := make([]bool, len(lines))
+	for i, l := range lines {
+		if l.Op == " " {
+			continue
+		}
+		for j := i - context; j <= i+context; j++ {
+			if j >= 0 && j < len(lines) {
+				near[j] = true
+			}
+		}
+	}
+	var (
+		hs   []hunk
+		a, b int // indices of the instructions of lines[i]
+	)
+	for i, l := range lines {
+		if near[i] {
+			if i == 0 || !near[i-1] {
+				hs = append(hs, hunk{a: a, b: b})
+			}
+			h := &hs[len(hs)-1]
+			h.lines = append(h.lines, l)
+		}
+		if l.Op != "+" {
+			a++
+		}
+		if l.Op != "-" {
+			b++
+		}
+	}
+	return hs
+}
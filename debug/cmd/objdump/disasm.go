@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hitzhangjie/codemaster/debug/internal/objfile"
+	"github.com/hitzhangjie/codemaster/debug/symbolize"
+)
+
+// Inst is a disassembled instruction.
+type Inst struct {
+	PC    uint64 `json:"pc"`
+	Bytes string `json:"bytes"` // hexadecimal
+	Text  string `json:"text"`
+	File  string `json:"file,omitempty"`
+	Line  int    `json:"line,omitempty"`
+	// Source is the source line of the instruction, with -S.
+	Source string `json:"source,omitempty"`
+	// Inlined are the calls inlined at the instruction, innermost first,
+	// with -inline.
+	Inlined []InlinedCall `json:"inlined,omitempty"`
+}
+
+// InlinedCall is a call inlined at an instruction.
+type InlinedCall struct {
+	Func string `json:"func"`
+	// File and Line are the position of the call, in the caller.
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// Func is the disassembly of a function.
+type Func struct {
+	Name  string `json:"name"`
+	File  string `json:"file,omitempty"`
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+	Insts []Inst `json:"insts"`
+}
+
+// options select the functions disassembled and what is reported of their
+// instructions.
+type options struct {
+	filter     *regexp.Regexp // of the names of the functions, nil for all
+	start, end uint64         // the functions overlapping [start, end)
+	gnu        bool           // GNU syntax instead of Go's
+	source     bool           // source lines
+	inline     bool           // inlined calls, from the DWARF
+}
+
+// disassemble disassembles the functions of the binary at path selected
+// by opts.
+func disassemble(path string, opts options) ([]Func, error) {
+	f, err := objfile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, err := f.Disasm()
+	if err != nil {
+		return nil, err
+	}
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, err
+	}
+	textStart, text, err := f.Text()
+	if err != nil {
+		return nil, err
+	}
+	textEnd := textStart + uint64(len(text))
+	if opts.end == 0 || opts.end > textEnd {
+		opts.end = textEnd
+	}
+
+	var fc *objfile.FileCache
+	if opts.source {
+		fc = objfile.NewFileCache(8)
+	}
+	funcs := []Func{}
+	for _, sym := range syms {
+		symStart, symEnd := sym.Addr, sym.Addr+uint64(sym.Size)
+		if sym.Code != 'T' && sym.Code != 't' ||
+			symStart < textStart ||
+			symEnd <= opts.start || opts.end <= symStart ||
+			opts.filter != nil && !opts.filter.MatchString(sym.Name) {
+			continue
+		}
+		if symEnd > opts.end {
+			symEnd = opts.end
+		}
+		fn := Func{Name: sym.Name, Start: symStart, End: symEnd}
+		d.Decode(symStart, symEnd, sym.Relocs, opts.gnu, func(pc, size uint64, file string, line int, asm string) {
+			inst := Inst{
+				PC:    pc,
+				Bytes: hex.EncodeToString(text[pc-textStart : pc-textStart+size]),
+				Text:  asm,
+				File:  file,
+				Line:  line,
+			}
+			if fc != nil {
+				if src, err := fc.Line(file, line); err == nil {
+					inst.Source = string(src)
+				}
+			}
+			fn.Insts = append(fn.Insts, inst)
+		})
+		if len(fn.Insts) > 0 {
+			fn.File = fn.Insts[0].File
+		}
+		funcs = append(funcs, fn)
+	}
+
+	if opts.inline {
+		if err := addInlined(path, funcs); err != nil {
+			return nil, err
+		}
+	}
+	return funcs, nil
+}
+
+// addInlined sets the inlined calls of the instructions of funcs, from the
+// DWARF of the binary at path.
+func addInlined(path string, funcs []Func) error {
+	s, err := symbolize.Open(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	var pcs []uint64
+	for _, fn := range funcs {
+		for _, inst := range fn.Insts {
+			pcs = append(pcs, inst.PC)
+		}
+	}
+	locs, err := s.SymbolizeDWARF(pcs)
+	if err != nil {
+		return fmt.Errorf("inlined calls: %v", err)
+	}
+	for i := range funcs {
+		for j := range funcs[i].Insts {
+			frames := locs[0].Frames
+			locs = locs[1:]
+			for k := 0; k+1 < len(frames); k++ {
+				call := InlinedCall{Func: frames[k].Func, File: frames[k+1].File, Line: frames[k+1].Line}
+				funcs[i].Insts[j].Inlined = append(funcs[i].Insts[j].Inlined, call)
+			}
+		}
+	}
+	return nil
+}
+
+// printFuncs prints funcs like go tool objdump, the inlined calls are
+// printed before the instructions they start at.
+func printFuncs(w io.Writer, funcs []Func, opts options) {
+	tw := tabwriter.NewWriter(w, 18, 8, 1, '\t', tabwriter.StripEscape)
+	for i, fn := range funcs {
+		if i > 0 {
+			fmt.Fprintf(tw, "\n")
+		}
+		fmt.Fprintf(tw, "TEXT %s(SB) %s\n", fn.Name, fn.File)
+
+		var (
+			lastFile    string
+			lastLine    int
+			lastInlined string
+		)
+		for _, inst := range fn.Insts {
+			if opts.inline {
+				if chain := inlineChain(inst.Inlined); chain != lastInlined {
+					if chain != "" {
+						fmt.Fprintf(tw, "%s  ; inlined %s%s\n", []byte{tabwriter.Escape}, chain, []byte{tabwriter.Escape})
+					}
+					lastInlined = chain
+				}
+			}
+			if opts.source {
+				if inst.File != lastFile || inst.Line != lastLine {
+					if inst.Source != "" {
+						fmt.Fprintf(tw, "%s%s%s\n", []byte{tabwriter.Escape}, inst.Source, []byte{tabwriter.Escape})
+					}
+					lastFile, lastLine = inst.File, inst.Line
+				}
+				fmt.Fprintf(tw, "  %#x\t", inst.PC)
+			} else {
+				fmt.Fprintf(tw, "  %s:%d\t%#x\t", base(inst.File), inst.Line, inst.PC)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t\n", inst.Bytes, inst.Text)
+		}
+		tw.Flush()
+	}
+}
+
+// inlineChain returns the inlined calls, innermost first, with the lines
+// they are called at.
+func inlineChain(inlined []InlinedCall) string {
+	var calls []string
+	for _, c := range inlined {
+		calls = append(calls, fmt.Sprintf("%s (%s:%d)", c.Func, base(c.File), c.Line))
+	}
+	return strings.Join(calls, " <- ")
+}
+
+// base returns the final element in the path.
+func base(path string) string {
+	path = path[strings.LastIndex(path, "/")+1:]
+	path = path[strings.LastIndex(path, `\`)+1:]
+	return path
+}
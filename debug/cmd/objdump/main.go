@@ -0,0 +1,151 @@
+// Command objdump disassembles the functions of a binary in the Go or the
+// GNU assembler syntax, like go tool objdump, with the source lines and
+// the inlined calls of the instructions.
+//
+// Usage:
+//
+//	objdump [-s regexp] [-S] [-gnu] [-inline] [-json] binary [start end]
+//	objdump -diff -s regexp [-gnu] [-json] old new
+//	objdump -cfg dot|json -s regexp binary
+//
+// The functions disassembled are those whose name matches -s and that
+// overlap the address range [start, end). With -S the source lines are
+// interleaved with the instructions, with -inline the calls inlined at
+// the instructions are read from the DWARF. With -json the functions are
+// written as JSON.
+//
+// With -diff the disassembly of the functions matching -s in the builds old
+// and new is compared, the addresses in the functions are replaced by
+// their offsets so that only the changes of the code are reported.
+//
+// With -cfg the control flow graphs of the functions matching -s are
+// written in the Graphviz DOT language, one digraph per function, or as
+// JSON, instead of their disassembly.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/hitzhangjie/codemaster/debug/cfg"
+)
+
+func main() {
+	var (
+		symRegexp = flag.String("s", "", "only disassemble the functions matching `regexp`")
+		source    = flag.Bool("S", false, "print the source lines of the instructions")
+		gnu       = flag.Bool("gnu", false, "print the instructions in the GNU syntax")
+		inline    = flag.Bool("inline", false, "annotate the instructions with the calls inlined, from the DWARF")
+		asJSON    = flag.Bool("json", false, "write the disassembly or the diff as JSON")
+		diffMode  = flag.Bool("diff", false, "compare the functions matching -s in the builds old and new")
+		context   = flag.Int("context", 3, "`lines` of context around the changes of a diff")
+		graph     = flag.String("cfg", "", "write the control flow graphs of the functions matching -s, in `format` dot or json")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: objdump [flags] binary [start end]\n       objdump -diff -s regexp [flags] old new\n       objdump -cfg dot|json -s regexp binary\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *diffMode && (flag.NArg() != 2 || *symRegexp == "") ||
+		!*diffMode && flag.NArg() != 1 && flag.NArg() != 3 ||
+		*graph != "" && (*diffMode || *symRegexp == "" || *graph != "dot" && *graph != "json") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := options{gnu: *gnu, source: *source, inline: *inline}
+	if *symRegexp != "" {
+		re, err := regexp.Compile(*symRegexp)
+		if err != nil {
+			fatal(fmt.Errorf("invalid -s regexp: %v", err))
+		}
+		opts.filter = re
+	}
+	if !*diffMode && flag.NArg() == 3 {
+		var err error
+		if opts.start, err = parseAddr(flag.Arg(1)); err != nil {
+			fatal(err)
+		}
+		if opts.end, err = parseAddr(flag.Arg(2)); err != nil {
+			fatal(err)
+		}
+	}
+
+	funcs, err := disassemble(flag.Arg(0), opts)
+	if err != nil {
+		fatal(err)
+	}
+	if *diffMode {
+		newFuncs, err := disassemble(flag.Arg(1), opts)
+		if err != nil {
+			fatal(err)
+		}
+		if len(funcs) == 0 && len(newFuncs) == 0 {
+			fatal(fmt.Errorf("no function matches %s", *symRegexp))
+		}
+		diffs := diffFuncs(funcs, newFuncs)
+		if *asJSON {
+			writeJSON(diffs)
+			return
+		}
+		printDiffs(os.Stdout, diffs, flag.Arg(0), flag.Arg(1), *context)
+		return
+	}
+	if *graph != "" {
+		if err := writeGraphs(flag.Arg(0), funcs, *graph); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *asJSON {
+		writeJSON(funcs)
+		return
+	}
+	printFuncs(os.Stdout, funcs, opts)
+}
+
+// writeGraphs writes the control flow graphs of funcs in format.
+func writeGraphs(path string, funcs []Func, format string) error {
+	graphs := []*cfg.Graph{}
+	for _, f := range funcs {
+		g, err := cfg.Load(path, f.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		if format == "dot" {
+			if err := g.WriteDOT(os.Stdout, true); err != nil {
+				return err
+			}
+		}
+		graphs = append(graphs, g)
+	}
+	if format == "json" {
+		writeJSON(graphs)
+	}
+	return nil
+}
+
+func parseAddr(s string) (uint64, error) {
+	addr, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q: %v", s, err)
+	}
+	return addr, nil
+}
+
+func writeJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "objdump:", err)
+	os.Exit(1)
+}
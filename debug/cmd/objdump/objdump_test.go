@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const program = `package main
+
+var counter int
+
+func inc() {
+	counter++
+}
+
+//go:noinline
+func add(x int) int {
+	inc()
+	return %s
+}
+
+//go:noinline
+func loop(n int) int {
+	s := 0
+	for i := 0; i < n; i++ {
+		s += i
+	}
+	return s
+}
+
+func main() {
+	println(add(1), loop(3))
+}
+`
+
+// build builds the program with the return expression of add.
+func build(t *testing.T, name, ret string) string {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	require.Nil(t, os.WriteFile(src, []byte(strings.Replace(program, "%s", ret, 1)), 0644))
+	bin := filepath.Join(dir, name)
+	cmd := exec.Command("go", "build", "-o", bin, src)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	out, err := cmd.CombinedOutput()
+	require.Nil(t, err, string(out))
+	return bin
+}
+
+func TestDisassemble(t *testing.T) {
+	bin := build(t, "old", "x + 1")
+
+	funcs, err := disassemble(bin, options{filter: regexp.MustCompile(`^main\.add$`), source: true, inline: true})
+	require.Nil(t, err)
+	require.Len(t, funcs, 1)
+	fn := funcs[0]
+	assert.Equal(t, "main.add", fn.Name)
+	assert.Equal(t, "main.go", filepath.Base(fn.File))
+	require.NotEmpty(t, fn.Insts)
+	assert.Equal(t, fn.Start, fn.Insts[0].PC)
+
+	var size uint64
+	var ret, inlined bool
+	for _, inst := range fn.Insts {
+		b, err := hex.DecodeString(inst.Bytes)
+		require.Nil(t, err)
+		size += uint64(len(b))
+		ret = ret || inst.Text == "RET"
+		if inst.Line == 6 {
+			assert.Equal(t, "\tcounter++", inst.Source)
+			require.Len(t, inst.Inlined, 1)
+			assert.Equal(t, InlinedCall{Func: "main.inc", File: fn.File, Line: 11}, inst.Inlined[0])
+			inlined = true
+		}
+	}
+	assert.Equal(t, fn.End-fn.Start, size)
+	assert.True(t, ret)
+	assert.True(t, inlined)
+
+	// the GNU syntax follows that of Go
+	gnu, err := disassemble(bin, options{filter: regexp.MustCompile(`^main\.add$`), gnu: true})
+	require.Nil(t, err)
+	require.Len(t, gnu[0].Insts, len(fn.Insts))
+	ret = false
+	for _, inst := range gnu[0].Insts {
+		ret = ret || strings.Contains(inst.Text, "// ret")
+		assert.Nil(t, inst.Inlined)
+	}
+	assert.True(t, ret)
+
+	// the functions overlapping an address range
+	byRange, err := disassemble(bin, options{start: fn.Start + 1, end: fn.Start + 2})
+	require.Nil(t, err)
+	require.Len(t, byRange, 1)
+	assert.Equal(t, "main.add", byRange[0].Name)
+
+	var sb strings.Builder
+	printFuncs(&sb, funcs, options{source: true, inline: true})
+	assert.Contains(t, sb.String(), "TEXT main.add(SB) ")
+	assert.Contains(t, sb.String(), "  ; inlined main.inc (main.go:11)\n\tcounter++\n")
+}
+
+func TestDiff(t *testing.T) {
+	old, new := build(t, "old", "x + 1"), build(t, "new", "x*x + 3")
+	opts := options{filter: regexp.MustCompile(`^main\.(add|loop)$`)}
+	a, err := disassemble(old, opts)
+	require.Nil(t, err)
+	b, err := disassemble(new, opts)
+	require.Nil(t, err)
+
+	diffs := diffFuncs(a, b)
+	require.Len(t, diffs, 2)
+	byName := map[string]FuncDiff{}
+	for _, d := range diffs {
+		byName[d.Name] = d
+	}
+	assert.True(t, byName["main.add"].Changed())
+	var added []string
+	for _, l := range byName["main.add"].Lines {
+		if l.Op == "+" {
+			added = append(added, l.Text)
+		}
+	}
+	assert.Contains(t, strings.Join(added, "\n"), "IMULQ")
+
+	// loop only moved, its jumps are compared as offsets
+	assert.False(t, byName["main.loop"].Changed())
+
+	var sb strings.Builder
+	printDiffs(&sb, diffs, "old", "new", 1)
+	assert.Contains(t, sb.String(), "--- old main.add")
+	assert.Contains(t, sb.String(), "main.loop: unchanged")
+}
+
+func TestDiffLines(t *testing.T) {
+	a := strings.Split("a b c d e f g", " ")
+	b := strings.Split("a c d x e f g h", " ")
+	var ops strings.Builder
+	for _, e := range diffLines(a, b) {
+		ops.WriteByte(e.op)
+		switch e.op {
+		case ' ':
+			assert.Equal(t, a[e.a], b[e.b])
+		case '-':
+			assert.Equal(t, -1, e.b)
+		case '+':
+			assert.Equal(t, -1, e.a)
+		}
+	}
+	assert.Equal(t, " -  +   +", ops.String())
+	assert.Empty(t, diffLines(nil, nil))
+
+	var lines []DiffLine
+	for _, op := range " -  +   +" {
+		lines = append(lines, DiffLine{Op: string(op)})
+	}
+	hs := hunks(lines, 1)
+	require.Len(t, hs, 2)
+	assert.Len(t, hs[0].lines, 6)
+	assert.Equal(t, 0, hs[0].a)
+	assert.Len(t, hs[1].lines, 2)
+	assert.Equal(t, 6, hs[1].a)
+	assert.Equal(t, 6, hs[1].b)
+}
@@ -0,0 +1,242 @@
+// Command objinspect inspects the Go object files and archives of a build,
+// such as those of the build cache, to debug its linknames and inlining.
+//
+// Usage:
+//
+//	objinspect [-s regexp] [-relocs] [-funcs] [-inl] [-refs] [-linkname] [-json] file.a...
+//	objinspect -pkg [-gcflags flags] [-tags tags] [flags] package...
+//
+// For each Go object of the archives, objinspect prints its header and its
+// symbols, with their kind, size and flags, and with -relocs, -funcs and
+// -inl their relocations, funcinfo and inlining trees. -refs prints the
+// symbols referenced by name or from the other packages. -linkname keeps
+// the symbols pushed by a linkname and the symbols referenced by name,
+// such as those pulled by one. With -pkg the archives of the packages are
+// read from the build cache, built with -gcflags and -tags if not yet, and
+// the symbols of the other packages, such as the functions inlined, are
+// named.
+//
+// With -json the archives, with the symbols kept, are printed as JSON.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hitzhangjie/codemaster/debug/objinspect"
+)
+
+var (
+	pkg      = flag.Bool("pkg", false, "the arguments are packages, whose archives are read from the build cache")
+	gcflags  = flag.String("gcflags", "", "build the packages of -pkg with these compiler `flags`")
+	tags     = flag.String("tags", "", "build the packages of -pkg with these build `tags`")
+	symRE    = flag.String("s", "", "keep the symbols and refs matching `regexp`")
+	relocs   = flag.Bool("relocs", false, "print the relocations of the symbols")
+	funcs    = flag.Bool("funcs", false, "print the funcinfo of the functions")
+	inl      = flag.Bool("inl", false, "print the inlining trees of the functions")
+	refs     = flag.Bool("refs", false, "print the symbols referenced")
+	linkname = flag.Bool("linkname", false, "keep the symbols of linknames and the refs by name")
+	asJSON   = flag.Bool("json", false, "print as JSON")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: objinspect [flags] file.a...\n       objinspect -pkg [flags] package...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var re *regexp.Regexp
+	if *symRE != "" {
+		var err error
+		if re, err = regexp.Compile(*symRE); err != nil {
+			fatal(err)
+		}
+	}
+	var buildFlags []string
+	if *gcflags != "" {
+		buildFlags = append(buildFlags, "-gcflags="+*gcflags)
+	}
+	if *tags != "" {
+		buildFlags = append(buildFlags, "-tags="+*tags)
+	}
+
+	var files []*objinspect.File
+	for _, arg := range flag.Args() {
+		var f *objinspect.File
+		var err error
+		if *pkg {
+			f, err = objinspect.OpenPackage(arg, buildFlags...)
+		} else {
+			f, err = objinspect.Open(arg)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		for _, o := range f.Objects {
+			filter(o, re)
+		}
+		files = append(files, f)
+	}
+
+	if *asJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "\t")
+		if err := e.Encode(files); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	for _, f := range files {
+		for _, o := range f.Objects {
+			printObject(w, f, o)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		fatal(err)
+	}
+}
+
+// filter keeps the symbols and refs of o matching re and -linkname.
+func filter(o *objinspect.Object, re *regexp.Regexp) {
+	syms := o.Symbols[:0]
+	for _, s := range o.Symbols {
+		if (re == nil || re.MatchString(s.Name)) && (!*linkname || linknamed(s)) {
+			syms = append(syms, s)
+		}
+	}
+	o.Symbols = syms
+	files := map[string]bool{}
+	for _, f := range o.Files {
+		files[f] = true
+	}
+	rs := o.Refs[:0]
+	for _, r := range o.Refs {
+		if (re == nil || re.MatchString(r.Name)) && (!*linkname || byName(r) && !files[r.Name]) {
+			rs = append(rs, r)
+		}
+	}
+	o.Refs = rs
+}
+
+// linknamed reports whether s is pushed by a linkname.
+func linknamed(s *objinspect.Symbol) bool {
+	for _, f := range s.Flags {
+		if f == "linkname" || f == "linknamestd" {
+			return true
+		}
+	}
+	return false
+}
+
+// byName reports whether r is a symbol of Go code referenced by name, not
+// one of the compiler such as a type.
+func byName(r *objinspect.Ref) bool {
+	if r.Pkg != "" {
+		return false
+	}
+	for _, prefix := range []string{"go:", "type:", "gofile..", "go.", "type."} {
+		if strings.HasPrefix(r.Name, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+func printObject(w io.Writer, f *objinspect.File, o *objinspect.Object) {
+	fmt.Fprintf(w, "%s(%s) size=%d\n", f.Path, o.Name, o.Size)
+	if o.Native {
+		fmt.Fprintf(w, "\tnative object\n\n")
+		return
+	}
+	fmt.Fprintf(w, "\t%s\n", o.Header)
+	if o.BuildID != "" {
+		fmt.Fprintf(w, "\t%s\n", o.BuildID)
+	}
+	fmt.Fprintf(w, "\tformat=%s fingerprint=%s flags=%s\n", o.Format, o.Fingerprint, strings.Join(o.Flags, ","))
+	for _, imp := range o.Imports {
+		fmt.Fprintf(w, "\timport %s %s\n", imp.Pkg, imp.Fingerprint)
+	}
+
+	if len(o.Symbols) > 0 {
+		fmt.Fprintf(w, "\n\tKIND\tSIZE\tFLAGS\tNAME\n")
+	}
+	for _, s := range o.Symbols {
+		fmt.Fprintf(w, "\t%s\t%d\t%s\t%s<%s>\n", s.Kind, s.Size, strings.Join(s.Flags, ","), s.Name, s.ABI)
+		if s.Type != "" {
+			fmt.Fprintf(w, "\t\t\t\ttype %s\n", s.Type)
+		}
+		if *relocs {
+			for _, r := range s.Relocs {
+				fmt.Fprintf(w, "\t\t\t\treloc %#x+%d %s %s%+d\n", r.Off, r.Size, r.Type, r.Sym, r.Add)
+			}
+		}
+		if s.Func == nil {
+			continue
+		}
+		if *funcs {
+			printFunc(w, s.Func)
+		}
+		if *inl {
+			for i, n := range s.Func.InlTree {
+				fmt.Fprintf(w, "\t\t\t\tinl %d parent=%d %s %s:%d pc=%#x\n", i, n.Parent, n.Func, n.File, n.Line, n.ParentPC)
+			}
+		}
+	}
+
+	if *refs && len(o.Refs) > 0 {
+		fmt.Fprintf(w, "\n\tREF\tABI\tFLAGS\tPKG\n")
+		for _, r := range o.Refs {
+			fmt.Fprintf(w, "\t%s\t%s\t%s\t%s\n", r.Name, r.ABI, strings.Join(r.Flags, ","), r.Pkg)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+func printFunc(w io.Writer, fn *objinspect.Func) {
+	fmt.Fprintf(w, "\t\t\t\targs=%d locals=%d funcid=%s funcflags=%s startline=%d\n",
+		fn.Args, fn.Locals, fn.FuncID, strings.Join(fn.FuncFlags, ","), fn.StartLine)
+	for _, t := range []struct {
+		name string
+		tab  *objinspect.PCTable
+	}{{"pcsp", fn.Pcsp}, {"pcfile", fn.Pcfile}, {"pcline", fn.Pcline}, {"pcinline", fn.Pcinline}} {
+		if t.tab != nil {
+			fmt.Fprintf(w, "\t\t\t\t%s %s\n", t.name, values(t.tab))
+		}
+	}
+	for i, t := range fn.Pcdata {
+		fmt.Fprintf(w, "\t\t\t\tpcdata %d %s\n", i, values(t))
+	}
+	for i, s := range fn.Funcdata {
+		if s != "" {
+			fmt.Fprintf(w, "\t\t\t\tfuncdata %d %s\n", i, s)
+		}
+	}
+	for i, file := range fn.Files {
+		fmt.Fprintf(w, "\t\t\t\tfile %d %s\n", i, file)
+	}
+}
+
+// values formats the values of t as value@pc.
+func values(t *objinspect.PCTable) string {
+	vs := make([]string, len(t.Values))
+	for i, v := range t.Values {
+		vs[i] = fmt.Sprintf("%d@%#x", v.Value, v.PC)
+	}
+	return strings.Join(vs, " ")
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "objinspect:", err)
+	os.Exit(1)
+}
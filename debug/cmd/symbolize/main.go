@@ -0,0 +1,133 @@
+// Command symbolize resolves the pcs of a Go binary to functions, files,
+// lines and inlined calls, with its pclntab when it is stripped and its
+// DWARF for the code out of the Go functions.
+//
+// Usage:
+//
+//	symbolize [-format auto|pcs|pprof|panic] [-load addr] [-json] binary [input]
+//
+// The input, stdin by default, is a log whose hexadecimal numbers are the
+// pcs, a pprof profile or the traceback of a panic. The pcs of a position
+// independent executable are resolved with the address its executable
+// segment was loaded at, given with -load or read from the profile.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hitzhangjie/codemaster/debug/symbolize"
+)
+
+func main() {
+	var (
+		format = flag.String("format", "auto", "format of the input: auto, pcs, pprof or panic")
+		load   = flag.String("load", "", "load `address` of the executable segment of a position independent binary")
+		asJSON = flag.Bool("json", false, "write the locations as JSON")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: symbolize [flags] binary [input]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 && flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	s, err := symbolize.Open(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	defer s.Close()
+	if *load != "" {
+		addr, err := strconv.ParseUint(*load, 0, 64)
+		if err != nil {
+			fatal(fmt.Errorf("bad load address: %v", err))
+		}
+		s.SetLoadAddress(addr)
+	}
+
+	in := io.Reader(os.Stdin)
+	if flag.NArg() == 2 {
+		f, err := os.Open(flag.Arg(1))
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		fatal(err)
+	}
+	if *format == "auto" {
+		*format = detect(data)
+	}
+
+	var pcs []uint64
+	switch *format {
+	case "pcs":
+		pcs, err = symbolize.ParsePCs(bytes.NewReader(data))
+	case "pprof":
+		pcs, err = s.ProfilePCs(bytes.NewReader(data))
+	case "panic":
+		pcs, err = s.PanicPCs(bytes.NewReader(data))
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if s.PIE() && *load == "" && *format != "pprof" {
+		fmt.Fprintln(os.Stderr, "symbolize: warning: position independent binary without -load, the pcs are assumed to be link time addresses")
+	}
+
+	locs := s.Symbolize(pcs)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(locs); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	var sb strings.Builder
+	for _, loc := range locs {
+		fmt.Fprintf(&sb, "%#x\n", loc.PC)
+		if len(loc.Frames) == 0 {
+			sb.WriteString("\t??\n")
+		}
+		for _, f := range loc.Frames {
+			inlined := ""
+			if f.Inlined {
+				inlined = " (inlined)"
+			}
+			fmt.Fprintf(&sb, "\t%s%s\n\t\t%s:%d\n", f.Func, inlined, f.File, f.Line)
+		}
+	}
+	io.WriteString(os.Stdout, sb.String())
+}
+
+// detect returns the format of the input data.
+func detect(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return "pprof"
+	case bytes.Contains(data, []byte("\ngoroutine ")) || bytes.HasPrefix(data, []byte("goroutine ")):
+		return "panic"
+	}
+	return "pcs"
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "symbolize:", err)
+	os.Exit(1)
+}
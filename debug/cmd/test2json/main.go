@@ -0,0 +1,159 @@
+// Command test2json converts the output of go test -v to the JSON stream of
+// go test -json, like go tool test2json, and reports the tests as JUnit
+// XML, TAP or a summary.
+//
+// Usage:
+//
+//	test2json [-p pkg] [-t] [-json file] [-junit file] [-tap file] [-summary file] [./pkg.test -test.v]
+//	test2json -replay [-junit file] [-tap file] [-summary file] [file.json...]
+//
+// The output of go test -v is read from stdin, or from the test binary run
+// with its arguments. With -replay the JSON stream of go test -json is read
+// from the files, or from stdin, instead.
+//
+// Each report is written to its file, "-" for stdout. Without any, the
+// JSON stream is written to stdout, or the summary with -replay. The
+// summary has the results and the time of the packages, the -slowest
+// tests, the flaky tests passing after failed runs, the failed tests and
+// the stacks of the panics.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/hitzhangjie/codemaster/debug/internal/test2json"
+)
+
+func main() {
+	var (
+		pkg      = flag.String("p", "", "report `pkg` as the package being tested")
+		stamp    = flag.Bool("t", false, "include timestamps in the JSON events")
+		replay   = flag.Bool("replay", false, "read the JSON stream of go test -json instead of the output of go test -v")
+		jsonOut  = flag.String("json", "", "write the JSON stream to `file`, - for stdout")
+		junitOut = flag.String("junit", "", "write the JUnit XML report to `file`, - for stdout")
+		tapOut   = flag.String("tap", "", "write the TAP report to `file`, - for stdout")
+		summary  = flag.String("summary", "", "write the summary to `file`, - for stdout")
+		slowest  = flag.Int("slowest", 10, "report the `n` slowest tests in the summary")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: test2json [flags] [./pkg.test -test.v]\n       test2json -replay [flags] [file.json...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *replay && (*jsonOut != "" || *pkg != "" || *stamp) {
+		fmt.Fprintln(os.Stderr, "test2json: -json, -p and -t are not supported with -replay")
+		os.Exit(2)
+	}
+	if *jsonOut == "" && *junitOut == "" && *tapOut == "" && *summary == "" {
+		if *replay {
+			*summary = "-"
+		} else {
+			*jsonOut = "-"
+		}
+	}
+
+	var sinks []test2json.Sink
+	for _, out := range []struct {
+		path string
+		sink func(w io.Writer) test2json.Sink
+	}{
+		{*junitOut, test2json.JUnitSink},
+		{*tapOut, test2json.TAPSink},
+		{*summary, func(w io.Writer) test2json.Sink { return test2json.SummarySink(w, *slowest) }},
+	} {
+		if out.path == "" {
+			continue
+		}
+		w, err := create(out.path)
+		if err != nil {
+			fatal(err)
+		}
+		defer w.Close()
+		sinks = append(sinks, out.sink(w))
+	}
+
+	if *replay {
+		var r io.Reader = os.Stdin
+		if flag.NArg() > 0 {
+			var readers []io.Reader
+			for _, path := range flag.Args() {
+				f, err := os.Open(path)
+				if err != nil {
+					fatal(err)
+				}
+				defer f.Close()
+				readers = append(readers, f)
+			}
+			r = io.MultiReader(readers...)
+		}
+		if err := test2json.Replay(r, sinks...); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	var w io.Writer
+	if *jsonOut != "" {
+		f, err := create(*jsonOut)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	var mode test2json.Mode
+	if *stamp {
+		mode |= test2json.Timestamp
+	}
+	c := test2json.NewConverter(w, *pkg, mode)
+	for _, s := range sinks {
+		c.AddSink(s)
+	}
+
+	status := 0
+	if flag.NArg() == 0 {
+		if _, err := io.Copy(c, os.Stdin); err != nil {
+			fatal(err)
+		}
+	} else {
+		cmd := exec.Command(flag.Arg(0), flag.Args()[1:]...)
+		cmd.Stdout = c
+		cmd.Stderr = c
+		err := cmd.Run()
+		if err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				fatal(err)
+			}
+			status = 1
+		}
+		c.Exited(err)
+	}
+	if err := c.Close(); err != nil {
+		fatal(err)
+	}
+	if status != 0 {
+		// the reports are written, the files are unbuffered
+		os.Exit(status)
+	}
+}
+
+// create creates the file at path, stdout for "-".
+func create(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "test2json:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,148 @@
+// Command traceconv converts an execution trace of runtime/trace to the JSON
+// of the Chrome trace viewer, or to a Perfetto trace.
+//
+// Usage:
+//
+//	traceconv [-o file] [-format json|perfetto] [-g ids] [-task ids] [-start d] [-end d] trace.out
+//	traceconv -split d [-o prefix] [-format json|perfetto] [-g ids] [-task ids] trace.out
+//
+// The goroutines of -g and the tasks of -task, by ID or type, are
+// comma-separated. -start and -end are durations since the first event of
+// the trace. With -split the trace is cut in windows of d, written to
+// prefix.0.json, prefix.1.json..., to keep each file small enough for the
+// viewer.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hitzhangjie/codemaster/debug/internal/traceviewer"
+	"github.com/hitzhangjie/codemaster/debug/traceconv"
+)
+
+func main() {
+	var (
+		out        = flag.String("o", "", "write to `file`, stdout by default, the prefix of the files with -split")
+		format     = flag.String("format", "json", "output `format`, json or perfetto")
+		goroutines = flag.String("g", "", "keep the goroutines with these comma-separated `ids`")
+		tasks      = flag.String("task", "", "keep the tasks with these comma-separated `ids` or types, their subtasks and goroutines")
+		start      = flag.Duration("start", 0, "start of the window kept, since the first event")
+		end        = flag.Duration("end", 0, "end of the window kept, since the first event, 0 for the end of the trace")
+		split      = flag.Duration("split", 0, "split the trace in windows of `d`")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: traceconv [flags] trace.out\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format != "json" && *format != "perfetto" {
+		fatal(fmt.Errorf("unknown format %q", *format))
+	}
+
+	data, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	opts := traceconv.Options{Start: *start, End: *end}
+	for _, s := range fields(*goroutines) {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			fatal(fmt.Errorf("invalid goroutine %q", s))
+		}
+		opts.Goroutines = append(opts.Goroutines, id)
+	}
+	opts.Tasks = fields(*tasks)
+
+	if *split <= 0 {
+		if err := convert(*out, *format, data, opts); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	span, err := traceconv.Span(data)
+	if err != nil {
+		fatal(err)
+	}
+	prefix := *out
+	if prefix == "" {
+		prefix = strings.TrimSuffix(filepath.Base(flag.Arg(0)), filepath.Ext(flag.Arg(0)))
+	}
+	ext := ".json"
+	if *format == "perfetto" {
+		ext = ".pftrace"
+	}
+	for i, t := 0, time.Duration(0); t < span; i, t = i+1, t+*split {
+		opts.Start, opts.End = t, t+*split
+		path := fmt.Sprintf("%s.%d%s", prefix, i, ext)
+		if err := convert(path, *format, data, opts); err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v-%v\n", path, opts.Start, opts.End)
+	}
+}
+
+// convert writes the trace data converted with opts to path, stdout if
+// empty.
+func convert(path, format string, data []byte, opts traceconv.Options) error {
+	d, err := traceconv.Convert(data, opts)
+	if err != nil {
+		return err
+	}
+	w := io.WriteCloser(nopCloser{os.Stdout})
+	if path != "" {
+		if w, err = os.Create(path); err != nil {
+			return err
+		}
+	}
+	bw := bufio.NewWriter(w)
+	if err := write(bw, format, d); err != nil {
+		w.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func write(w io.Writer, format string, d *traceviewer.Data) error {
+	if format == "perfetto" {
+		return traceconv.WritePerfetto(w, d)
+	}
+	return json.NewEncoder(w).Encode(d)
+}
+
+// fields returns the comma-separated fields of s.
+func fields(s string) []string {
+	var fs []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "traceconv:", err)
+	os.Exit(1)
+}
@@ -417,7 +417,7 @@ func (r *objReader) parseObject(o *GoObj, size int64) error {
 	if err != nil {
 		return err
 	}
-	if !bytes.Equal(p, []byte(goobj.Magic)) {
+	if !bytes.Equal(p, []byte(goobj.Magic)) && !bytes.Equal(p, []byte(goobj.MagicGo120)) {
 		return r.error(errCorruptObject)
 	}
 	r.skip(o.Size)
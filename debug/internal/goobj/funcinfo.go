@@ -32,6 +32,10 @@ type FuncInfo struct {
 	File        []CUFileIndex
 
 	InlTree []InlTreeNode
+
+	// Go 1.20 and later, read by ReadGo120 but not written.
+	FuncFlag  uint8
+	StartLine int32
 }
 
 func (a *FuncInfo) Write(w *bytes.Buffer) {
@@ -233,7 +233,7 @@ func (h *Header) Write(w *Writer) {
 func (h *Header) Read(r *Reader) error {
 	b := r.BytesAt(0, len(Magic))
 	h.Magic = string(b)
-	if h.Magic != Magic {
+	if h.Magic != Magic && h.Magic != MagicGo120 {
 		return errors.New("wrong magic, not a Go object file")
 	}
 	off := uint32(len(h.Magic))
@@ -242,9 +242,15 @@ func (h *Header) Read(r *Reader) error {
 	h.Flags = r.uint32At(off)
 	off += 4
 	for i := range h.Offsets {
+		if i == BlkPcdata && h.Magic == MagicGo120 {
+			continue // filled with the empty block at BlkRefName
+		}
 		h.Offsets[i] = r.uint32At(off)
 		off += 4
 	}
+	if h.Magic == MagicGo120 {
+		h.Offsets[BlkPcdata] = h.Offsets[BlkRefName]
+	}
 	return nil
 }
 
@@ -597,6 +603,8 @@ type Reader struct {
 	rd    io.ReaderAt
 	start uint32
 	h     Header // keep block offsets
+
+	go120 bool // object file of Go 1.20 or later
 }
 
 func NewReaderFromBytes(b []byte, readonly bool) *Reader {
@@ -605,6 +613,7 @@ func NewReaderFromBytes(b []byte, readonly bool) *Reader {
 	if err != nil {
 		return nil
 	}
+	r.go120 = r.h.Magic == MagicGo120
 	return r
 }
 
@@ -770,6 +779,11 @@ func (r *Reader) Hash64(i uint32) uint64 {
 // Note: here i is the index of hashed symbols, not all symbols
 // (unlike other accessors).
 func (r *Reader) Hash(i uint32) *HashType {
+	if r.go120 {
+		var h HashType
+		copy(h[:], r.BytesAt(r.h.Offsets[BlkHash]+i*hashSizeGo120, hashSizeGo120))
+		return &h
+	}
 	off := r.h.Offsets[BlkHash] + uint32(i*HashSize)
 	return (*HashType)(unsafe.Pointer(&r.b[off]))
 }
@@ -784,12 +798,18 @@ func (r *Reader) NReloc(i uint32) int {
 func (r *Reader) RelocOff(i uint32, j int) uint32 {
 	relocIdxOff := r.h.Offsets[BlkRelocIdx] + uint32(i*4)
 	relocIdx := r.uint32At(relocIdxOff)
+	if r.go120 {
+		return r.h.Offsets[BlkReloc] + (relocIdx+uint32(j))*uint32(relocSizeGo120)
+	}
 	return r.h.Offsets[BlkReloc] + (relocIdx+uint32(j))*uint32(RelocSize)
 }
 
 // Reloc returns a pointer to the j-th relocation of the i-th symbol.
 func (r *Reader) Reloc(i uint32, j int) *Reloc {
 	off := r.RelocOff(i, j)
+	if r.go120 {
+		return r.relocGo120(off)
+	}
 	return (*Reloc)(unsafe.Pointer(&r.b[off]))
 }
 
@@ -797,6 +817,13 @@ func (r *Reader) Reloc(i uint32, j int) *Reloc {
 func (r *Reader) Relocs(i uint32) []Reloc {
 	off := r.RelocOff(i, 0)
 	n := r.NReloc(i)
+	if r.go120 {
+		relocs := make([]Reloc, n)
+		for j := range relocs {
+			relocs[j] = *r.relocGo120(off + uint32(j*relocSizeGo120))
+		}
+		return relocs
+	}
 	return (*[huge]Reloc)(unsafe.Pointer(&r.b[off]))[:n:n]
 }
 
@@ -872,3 +899,8 @@ func (r *Reader) Flags() uint32 {
 func (r *Reader) Shared() bool            { return r.Flags()&ObjFlagShared != 0 }
 func (r *Reader) NeedNameExpansion() bool { return r.Flags()&ObjFlagNeedNameExpansion != 0 }
 func (r *Reader) FromAssembly() bool      { return r.Flags()&ObjFlagFromAssembly != 0 }
+
+// Go120 reports whether the object file is of Go 1.20 or later, with the
+// symbol kinds, relocation types and builtins of objabi and BuiltinName
+// suffixed by Go120.
+func (r *Reader) Go120() bool { return r.go120 }
@@ -0,0 +1,88 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package objabi
+
+import "strconv"
+
+// The names of the symbol kinds, relocation types and funcIDs of the
+// object files of Go 1.20 and later, as of Go 1.27, numbered unlike those
+// of Go 1.16 of this package.
+
+// SymKindNameGo120 returns the name of the symbol kind k of Go 1.20.
+func SymKindNameGo120(k uint8) string {
+	if int(k) < len(symKindsGo120) {
+		return symKindsGo120[k]
+	}
+	return "SymKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// RelocTypeNameGo120 returns the name of the relocation type t of Go 1.20.
+func RelocTypeNameGo120(t uint16) string {
+	if t > 0 && int(t) <= len(relocTypesGo120) {
+		return relocTypesGo120[t-1]
+	}
+	return "RelocType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// FuncIDNameGo120 returns the name of the funcID id of Go 1.20.
+func FuncIDNameGo120(id uint8) string {
+	if int(id) < len(funcIDsGo120) {
+		return funcIDsGo120[id]
+	}
+	return "FuncID(" + strconv.Itoa(int(id)) + ")"
+}
+
+var symKindsGo120 = [...]string{
+	"Sxxx", "STEXT", "STEXTFIPS", "SRODATA", "SRODATAFIPS", "SNOPTRDATA",
+	"SNOPTRDATAFIPS", "SDATA", "SDATAFIPS", "SBSS", "SNOPTRBSS", "STLSBSS",
+	"SDWARFCUINFO", "SDWARFCONST", "SDWARFFCN", "SDWARFABSFCN", "SDWARFTYPE",
+	"SDWARFVAR", "SDWARFRANGE", "SDWARFLOC", "SDWARFLINES", "SDWARFADDR",
+	"SLIBFUZZER_8BIT_COUNTER", "SCOVERAGE_COUNTER", "SCOVERAGE_AUXVAR",
+	"SSEHUNWINDINFO",
+}
+
+// relocTypesGo120 start at 1, R_ADDR.
+var relocTypesGo120 = [...]string{
+	"R_ADDR", "R_ADDRPOWER", "R_ADDRARM64", "R_ADDRMIPS", "R_ADDROFF",
+	"R_SIZE", "R_CALL", "R_CALLARM", "R_CALLARM64", "R_CALLIND",
+	"R_CALLPOWER", "R_CALLMIPS", "R_CONST", "R_PCREL", "R_TLS_LE", "R_TLS_IE",
+	"R_GOTOFF", "R_PLT0", "R_PLT1", "R_PLT2", "R_USEFIELD", "R_USETYPE",
+	"R_USEIFACE", "R_USEIFACEMETHOD", "R_USENAMEDMETHOD", "R_METHODOFF",
+	"R_KEEP", "R_POWER_TOC", "R_GOTPCREL", "R_JMPMIPS", "R_DWARFSECREF",
+	"R_ARM64_TLS_LE", "R_ARM64_TLS_IE", "R_ARM64_GOTPCREL", "R_ARM64_GOT",
+	"R_ARM64_PCREL", "R_ARM64_PCREL_LDST8", "R_ARM64_PCREL_LDST16",
+	"R_ARM64_PCREL_LDST32", "R_ARM64_PCREL_LDST64", "R_ARM64_LDST8",
+	"R_ARM64_LDST16", "R_ARM64_LDST32", "R_ARM64_LDST64", "R_ARM64_LDST128",
+	"R_POWER_TLS_LE", "R_POWER_TLS_IE", "R_POWER_TLS",
+	"R_POWER_TLS_IE_PCREL34", "R_POWER_TLS_LE_TPREL34", "R_ADDRPOWER_DS",
+	"R_ADDRPOWER_GOT", "R_ADDRPOWER_GOT_PCREL34", "R_ADDRPOWER_PCREL",
+	"R_ADDRPOWER_TOCREL", "R_ADDRPOWER_TOCREL_DS", "R_ADDRPOWER_D34",
+	"R_ADDRPOWER_PCREL34", "R_RISCV_JAL", "R_RISCV_JAL_TRAMP", "R_RISCV_CALL",
+	"R_RISCV_PCREL_ITYPE", "R_RISCV_PCREL_STYPE", "R_RISCV_TLS_IE",
+	"R_RISCV_TLS_LE", "R_RISCV_GOT_HI20", "R_RISCV_GOT_PCREL_ITYPE",
+	"R_RISCV_PCREL_HI20", "R_RISCV_PCREL_LO12_I", "R_RISCV_PCREL_LO12_S",
+	"R_RISCV_BRANCH", "R_RISCV_ADD32", "R_RISCV_SUB32", "R_RISCV_RVC_BRANCH",
+	"R_RISCV_RVC_JUMP", "R_PCRELDBL", "R_LOONG64_ADDR_HI",
+	"R_LOONG64_ADDR_LO", "R_LOONG64_ADDR64_HI", "R_LOONG64_ADDR64_LO",
+	"R_LOONG64_ADDR_PCREL20_S2", "R_LOONG64_TLS_LE_HI", "R_LOONG64_TLS_LE_LO",
+	"R_CALLLOONG64", "R_LOONG64_CALL36", "R_LOONG64_TLS_IE_HI",
+	"R_LOONG64_TLS_IE_LO", "R_LOONG64_GOT_HI", "R_LOONG64_GOT_LO",
+	"R_LOONG64_GOT64_HI", "R_LOONG64_GOT64_LO", "R_LOONG64_ADD64",
+	"R_LOONG64_SUB64", "R_JMP16LOONG64", "R_JMP21LOONG64", "R_ADDRMIPSU",
+	"R_ADDRMIPSTLS", "R_ADDRCUOFF", "R_WASMIMPORT", "R_XCOFFREF",
+	"R_PEIMAGEOFF", "R_INITORDER", "R_DWTXTADDR_U1", "R_DWTXTADDR_U2",
+	"R_DWTXTADDR_U3", "R_DWTXTADDR_U4",
+}
+
+var funcIDsGo120 = [...]string{
+	"FuncIDNormal", "FuncID_abort", "FuncID_asmcgocall",
+	"FuncID_asyncPreempt", "FuncID_cgocallback", "FuncID_corostart",
+	"FuncID_debugCallV2", "FuncID_gcBgMarkWorker", "FuncID_goexit",
+	"FuncID_gogo", "FuncID_gopanic", "FuncID_handleAsyncEvent",
+	"FuncID_mcall", "FuncID_morestack", "FuncID_mstart", "FuncID_panicwrap",
+	"FuncID_rt0_go", "FuncID_runtime_main", "FuncID_runFinalizers",
+	"FuncID_runCleanups", "FuncID_sigpanic", "FuncID_systemstack",
+	"FuncID_systemstack_switch", "FuncIDWrapper",
+}
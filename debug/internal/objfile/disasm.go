@@ -89,6 +89,11 @@ func (e *Entry) Disasm() (*Disasm, error) {
 	return d, nil
 }
 
+// GOARCH returns the architecture the instructions are decoded for.
+func (d *Disasm) GOARCH() string {
+	return d.goarch
+}
+
 // lookup finds the symbol name containing addr.
 func (d *Disasm) lookup(addr uint64) (name string, base uint64) {
 	i := sort.Search(len(d.syms), func(i int) bool { return addr < d.syms[i].Addr })
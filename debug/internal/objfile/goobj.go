@@ -85,11 +85,16 @@ func goobjName(name string, ver int) string {
 type goobjReloc struct {
 	Off  int32
 	Size uint8
-	Type objabi.RelocType
+	Type fmt.Stringer
 	Add  int64
 	Sym  string
 }
 
+// relocTypeGo120 is a relocation type of Go 1.20 and later.
+type relocTypeGo120 uint16
+
+func (t relocTypeGo120) String() string { return objabi.RelocTypeNameGo120(uint16(t)) }
+
 func (r goobjReloc) String(insnOffset uint64) string {
 	delta := int64(r.Off) - int64(insnOffset)
 	s := fmt.Sprintf("[%d:%d]%s", delta, delta+int64(r.Size), r.Type)
@@ -141,6 +146,10 @@ func (f *goobjFile) symbols() ([]Sym, error) {
 		case goobj.PkgIdxNone:
 			i = s.SymIdx + uint32(r.NSym()+r.NHashed64def()+r.NHasheddef())
 		case goobj.PkgIdxBuiltin:
+			if r.Go120() {
+				name, abi := goobj.BuiltinNameGo120(int(s.SymIdx))
+				return goobjName(name, abi)
+			}
 			name, abi := goobj.BuiltinName(int(s.SymIdx))
 			return goobjName(name, abi)
 		case goobj.PkgIdxSelf:
@@ -162,16 +171,19 @@ func (f *goobjFile) symbols() ([]Sym, error) {
 		name := osym.Name(r)
 		ver := osym.ABI()
 		name = goobjName(name, abiToVer(ver))
-		typ := objabi.SymKind(osym.Type())
+		typ := objabi.SymKind(osym.Type()).String()
+		if r.Go120() {
+			typ = objabi.SymKindNameGo120(osym.Type())
+		}
 		var code rune = '?'
 		switch typ {
-		case objabi.STEXT:
+		case "STEXT", "STEXTFIPS":
 			code = 'T'
-		case objabi.SRODATA:
+		case "SRODATA", "SRODATAFIPS":
 			code = 'R'
-		case objabi.SDATA:
+		case "SDATA", "SDATAFIPS":
 			code = 'D'
-		case objabi.SBSS, objabi.SNOPTRBSS, objabi.STLSBSS:
+		case "SBSS", "SNOPTRBSS", "STLSBSS":
 			code = 'B'
 		}
 		if ver >= goobj.SymABIstatic {
@@ -189,13 +201,17 @@ func (f *goobjFile) symbols() ([]Sym, error) {
 		sym.Relocs = make([]Reloc, len(relocs))
 		for j := range relocs {
 			rel := &relocs[j]
+			var typ fmt.Stringer = objabi.RelocType(rel.Type())
+			if r.Go120() {
+				typ = relocTypeGo120(rel.Type())
+			}
 			sym.Relocs[j] = Reloc{
 				Addr: uint64(r.DataOff(i)) + uint64(rel.Off()),
 				Size: uint64(rel.Siz()),
 				Stringer: goobjReloc{
 					Off:  rel.Off(),
 					Size: rel.Siz(),
-					Type: objabi.RelocType(rel.Type()),
+					Type: typ,
 					Add:  rel.Add(),
 					Sym:  resolveSymRef(rel.Sym()),
 				},
@@ -251,6 +267,24 @@ func (f *goobjFile) PCToLine(pc uint64) (string, int, *gosym.Func) {
 		if pc < addr || pc >= addr+uint64(osym.Siz()) {
 			continue
 		}
+		if r.Go120() {
+			// the pc tables are aux symbols
+			var pcfileSym, pclineSym goobj.SymRef
+			for _, a := range r.Auxs(i) {
+				switch a.Type() {
+				case goobj.AuxPcfile:
+					pcfileSym = a.Sym()
+				case goobj.AuxPcline:
+					pclineSym = a.Sym()
+				}
+			}
+			if pcfileSym == (goobj.SymRef{}) || pclineSym == (goobj.SymRef{}) {
+				continue
+			}
+			line := int(pcValue(getSymData(pclineSym), pc-addr, f.arch))
+			fileID := pcValue(getSymData(pcfileSym), pc-addr, f.arch)
+			return r.File(int(fileID)), line, &gosym.Func{Sym: &gosym.Sym{Name: osym.Name(r)}}
+		}
 		isym := ^uint32(0)
 		auxs := r.Auxs(i)
 		for j := range auxs {
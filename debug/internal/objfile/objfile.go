@@ -99,6 +99,10 @@ func (f *File) PCLineTable() (Liner, error) {
 	return f.entries[0].PCLineTable()
 }
 
+func (f *File) PCLN() (textStart uint64, symtab, pclntab []byte, err error) {
+	return f.entries[0].PCLN()
+}
+
 func (f *File) Text() (uint64, []byte, error) {
 	return f.entries[0].Text()
 }
@@ -152,6 +156,13 @@ func (e *Entry) PCLineTable() (Liner, error) {
 	return gosym.NewTable(symtab, gosym.NewLineTable(pclntab, textStart))
 }
 
+// PCLN returns the raw Go symbol and pc-line tables of the file, for the
+// callers that need more than the Liner built from them, such as the
+// inlining tree of the functions.
+func (e *Entry) PCLN() (textStart uint64, symtab, pclntab []byte, err error) {
+	return e.raw.pcln()
+}
+
 func (e *Entry) Text() (uint64, []byte, error) {
 	return e.raw.text()
 }
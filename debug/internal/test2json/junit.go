@@ -0,0 +1,157 @@
+package test2json
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The JUnit XML schema of Ant and Maven Surefire, with the flakyFailure
+// and rerunFailure of the tests rerun.
+type (
+	junitSuites struct {
+		XMLName  xml.Name     `xml:"testsuites"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Errors   int          `xml:"errors,attr"`
+		Skipped  int          `xml:"skipped,attr"`
+		Time     string       `xml:"time,attr"`
+		Suites   []junitSuite `xml:"testsuite"`
+	}
+	junitSuite struct {
+		Name      string      `xml:"name,attr"`
+		Tests     int         `xml:"tests,attr"`
+		Failures  int         `xml:"failures,attr"`
+		Errors    int         `xml:"errors,attr"`
+		Skipped   int         `xml:"skipped,attr"`
+		Time      string      `xml:"time,attr"`
+		Cases     []junitCase `xml:"testcase"`
+		SystemOut *junitText  `xml:"system-out"`
+	}
+	junitCase struct {
+		Classname     string         `xml:"classname,attr"`
+		Name          string         `xml:"name,attr"`
+		Time          string         `xml:"time,attr"`
+		Failure       *junitResult   `xml:"failure"`
+		Error         *junitResult   `xml:"error"`
+		Skipped       *junitResult   `xml:"skipped"`
+		FlakyFailures []*junitResult `xml:"flakyFailure"`
+		RerunFailures []*junitResult `xml:"rerunFailure"`
+		SystemOut     *junitText     `xml:"system-out"`
+	}
+	junitResult struct {
+		Message string `xml:"message,attr,omitempty"`
+		Type    string `xml:"type,attr,omitempty"`
+		Text    string `xml:",cdata"`
+	}
+	junitText struct {
+		Text string `xml:",cdata"`
+	}
+)
+
+// WriteJUnit writes r as JUnit XML, a test suite by package. The tests
+// that panicked have an error instead of a failure, the failed runs of the
+// tests rerun are flaky failures if the test passed in the end and rerun
+// failures otherwise. A package that failed without a failed test, such
+// as by a build error, has a failed test case TestMain with its output.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	suites := junitSuites{}
+	var elapsed float64
+	for _, p := range r.Packages {
+		s := junitSuite{Name: p.Name, Time: seconds(p.Elapsed)}
+		for _, t := range p.Tests {
+			c := junitCase{Classname: p.Name, Name: t.Name, Time: seconds(t.Elapsed())}
+			last := t.Runs[len(t.Runs)-1]
+			switch {
+			case last.Action == "skip":
+				c.Skipped = &junitResult{Message: skipMessage(last.Output)}
+				s.Skipped++
+			case last.Panic != nil:
+				c.Error = &junitResult{Message: "panic: " + last.Panic.Value, Type: "panic", Text: join(last.Output)}
+				s.Errors++
+			case last.Action == "fail" || last.Action == "" && p.Action == "fail":
+				c.Failure = &junitResult{Message: "Failed", Text: join(last.Output)}
+				s.Failures++
+			default:
+				c.SystemOut = output(last.Output)
+			}
+			for _, run := range t.Runs[:len(t.Runs)-1] {
+				if run.Action != "fail" {
+					continue
+				}
+				res := &junitResult{Message: "Failed", Text: join(run.Output)}
+				if run.Panic != nil {
+					res.Message, res.Type = "panic: "+run.Panic.Value, "panic"
+				}
+				if t.Result() == "pass" {
+					c.FlakyFailures = append(c.FlakyFailures, res)
+				} else {
+					c.RerunFailures = append(c.RerunFailures, res)
+				}
+			}
+			s.Cases = append(s.Cases, c)
+		}
+		if p.Action == "fail" && s.Failures == 0 && s.Errors == 0 {
+			c := junitCase{Classname: p.Name, Name: "TestMain", Time: seconds(0)}
+			res := &junitResult{Message: "Failed", Text: join(p.Output)}
+			if p.Panic != nil {
+				res.Message, res.Type = "panic: "+p.Panic.Value, "panic"
+				c.Error = res
+				s.Errors++
+			} else {
+				c.Failure = res
+				s.Failures++
+			}
+			s.Cases = append(s.Cases, c)
+		} else {
+			s.SystemOut = output(p.Output)
+		}
+		s.Tests = len(s.Cases)
+		suites.Tests += s.Tests
+		suites.Failures += s.Failures
+		suites.Errors += s.Errors
+		suites.Skipped += s.Skipped
+		elapsed += p.Elapsed
+		suites.Suites = append(suites.Suites, s)
+	}
+	suites.Time = seconds(elapsed)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// skipMessage returns the reason of a skip, the log of t.Skip.
+func skipMessage(output []string) string {
+	for _, line := range output {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "=== ") && !strings.HasPrefix(line, "--- ") {
+			return line
+		}
+	}
+	return ""
+}
+
+// output returns the system-out of output, nil if empty.
+func output(output []string) *junitText {
+	if len(output) == 0 {
+		return nil
+	}
+	return &junitText{join(output)}
+}
+
+func seconds(d float64) string {
+	return fmt.Sprintf("%.3f", d)
+}
+
+func join(output []string) string {
+	return strings.Join(output, "")
+}
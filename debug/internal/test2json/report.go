@@ -0,0 +1,390 @@
+package test2json
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Replay decodes the JSON stream of go test -json or of a Converter from r
+// and sends its events to the sinks, then closes them. The lines that
+// aren't JSON, such as the build errors of go test, are sent as output
+// events.
+func Replay(r io.Reader, sinks ...Sink) error {
+	send := func(e *Event) {
+		for _, s := range sinks {
+			s.Event(e)
+		}
+	}
+	br := bufio.NewReader(r)
+	var err error
+	for err == nil {
+		var line []byte
+		line, err = br.ReadBytes('\n')
+		if len(line) == 0 {
+			continue
+		}
+		e := new(Event)
+		if trimmed := bytes.TrimSpace(line); len(trimmed) == 0 || trimmed[0] != '{' || json.Unmarshal(trimmed, e) != nil {
+			e = &Event{Action: "output", Output: string(line)}
+		}
+		send(e)
+	}
+	if err != io.EOF {
+		return err
+	}
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Report aggregates the events of the tests of one or more packages, to
+// write them as JUnit XML, TAP or a summary. It's a Sink.
+//
+// The packages of the output of go test -v without the -p of the
+// Converter are named by the result lines ending their output, such as
+// "ok  \tpkg\t0.012s". The tests run several times, by -count or by
+// reruns of the failed tests, have several runs.
+type Report struct {
+	Packages []*Package // in the order of their first event
+
+	pkgs    map[string]*Package
+	pending []*Event // of the unnamed package
+	closed  bool
+}
+
+// Package is the result of the tests of a package.
+type Package struct {
+	Name    string
+	Action  string  // pass, fail or skip, of the last run
+	Elapsed float64 // seconds, of all the runs
+	Tests   []*Test // in the order of their first event
+	Output  []string
+	// Panic is the first panic in the output outside the tests, such as
+	// in TestMain or init.
+	Panic *Panic
+
+	tests map[string]*Test
+}
+
+// Test is the result of a test, or of a subtest.
+type Test struct {
+	Package string
+	Name    string
+	Runs    []*Run
+}
+
+// Run is a run of a test.
+type Run struct {
+	Action  string  // pass, fail, skip or bench, empty if unfinished
+	Elapsed float64 // seconds
+	Output  []string
+	Panic   *Panic
+}
+
+// Panic is a panic in the output of a test.
+type Panic struct {
+	Value     string // without "panic: "
+	Goroutine string // as in "goroutine 7 [running]"
+	Stack     []Frame
+}
+
+// Frame is a frame of the stack of a panic.
+type Frame struct {
+	Func string // without the arguments, such as "created by testing.(*T).Run"
+	File string
+	Line int
+}
+
+// NewReport returns an empty report.
+func NewReport() *Report {
+	return &Report{pkgs: map[string]*Package{}}
+}
+
+// Result returns the action of the last run of t.
+func (t *Test) Result() string {
+	if len(t.Runs) == 0 {
+		return ""
+	}
+	return t.Runs[len(t.Runs)-1].Action
+}
+
+// Elapsed returns the time of all the runs of t, in seconds.
+func (t *Test) Elapsed() float64 {
+	var d float64
+	for _, r := range t.Runs {
+		d += r.Elapsed
+	}
+	return d
+}
+
+// Failures returns the number of the runs of t that failed.
+func (t *Test) Failures() int {
+	n := 0
+	for _, r := range t.Runs {
+		if r.Action == "fail" {
+			n++
+		}
+	}
+	return n
+}
+
+// Flaky reports whether t failed and then passed.
+func (t *Test) Flaky() bool {
+	return t.Failures() > 0 && t.Result() == "pass"
+}
+
+// Panic returns the panic of the last run of t that panicked, or nil.
+func (t *Test) Panic() *Panic {
+	for i := len(t.Runs) - 1; i >= 0; i-- {
+		if p := t.Runs[i].Panic; p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// pkgResult matches the lines of go test with the result of a package:
+//
+//	ok  	pkg	0.012s
+//	ok  	pkg	(cached)
+//	FAIL	pkg	0.042s
+//	FAIL	pkg [build failed]
+//	?   	pkg	[no test files]
+var pkgResult = regexp.MustCompile(`^(ok  |FAIL|\?   )\t([^\t\s]+)(?:\t\(?([0-9.]+)s\)?|\t\(cached\)| \[[^\]]*\]|\t\[no test files\])?`)
+
+// Event implements Sink.
+func (r *Report) Event(e *Event) {
+	if r.pkgs == nil {
+		r.pkgs = map[string]*Package{}
+	}
+	if e.Package != "" {
+		r.add(e)
+		return
+	}
+	m := pkgResult.FindStringSubmatch(e.Output)
+	if e.Action != "output" || e.Test != "" || m == nil {
+		r.pending = append(r.pending, e)
+		return
+	}
+	// name the tests since the result of the previous package
+	name := m[2]
+	for _, pe := range r.pending {
+		if pe.Test == "" && pe.Action != "output" {
+			continue // the result of the Converter, replaced by the line
+		}
+		pe := *pe
+		pe.Package = name
+		r.add(&pe)
+	}
+	r.pending = nil
+	ne := *e
+	ne.Package = name
+	r.add(&ne)
+	result := &Event{Package: name}
+	switch m[1] {
+	case "ok  ":
+		result.Action = "pass"
+	case "FAIL":
+		result.Action = "fail"
+	default:
+		result.Action = "skip"
+	}
+	result.Elapsed, _ = strconv.ParseFloat(m[3], 64)
+	r.add(result)
+}
+
+// Close implements Sink. The events of an unnamed package are added to the
+// package "", unless they are only the result of the Converter after the
+// last package named.
+func (r *Report) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	keep := len(r.Packages) == 0
+	for _, e := range r.pending {
+		if e.Test != "" {
+			keep = true
+		}
+	}
+	if keep {
+		for _, e := range r.pending {
+			r.add(e)
+		}
+	}
+	r.pending = nil
+	return nil
+}
+
+// add adds e of a named package.
+func (r *Report) add(e *Event) {
+	p := r.pkgs[e.Package]
+	if p == nil {
+		p = &Package{Name: e.Package, tests: map[string]*Test{}}
+		r.pkgs[e.Package] = p
+		r.Packages = append(r.Packages, p)
+	}
+	if e.Test == "" {
+		switch e.Action {
+		case "output":
+			p.Output = append(p.Output, e.Output)
+		case "pass", "fail", "skip":
+			// a rerun of some tests passing leaves the others failed
+			if _, failed, _ := p.counts(); e.Action != "pass" || failed == 0 {
+				p.Action = e.Action
+			}
+			p.Elapsed += e.Elapsed
+			if p.Panic == nil {
+				p.Panic = parsePanic(p.Output)
+			}
+			// the tests unfinished by a panic or a timeout
+			for _, t := range p.Tests {
+				if run := t.Runs[len(t.Runs)-1]; run.Action == "" && run.Panic == nil {
+					run.Panic = parsePanic(run.Output)
+				}
+			}
+		}
+		return
+	}
+
+	t := p.tests[e.Test]
+	if t == nil {
+		t = &Test{Package: p.Name, Name: e.Test}
+		p.tests[e.Test] = t
+		p.Tests = append(p.Tests, t)
+	}
+	var run *Run
+	if n := len(t.Runs); n > 0 && t.Runs[n-1].Action == "" {
+		run = t.Runs[n-1]
+	}
+	switch e.Action {
+	case "run":
+		if run == nil || len(run.Output) > 0 {
+			t.Runs = append(t.Runs, &Run{})
+		}
+	case "output":
+		if run == nil {
+			run = &Run{}
+			t.Runs = append(t.Runs, run)
+		}
+		run.Output = append(run.Output, e.Output)
+	case "pass", "fail", "skip", "bench":
+		if run == nil {
+			run = &Run{}
+			t.Runs = append(t.Runs, run)
+		}
+		run.Action = e.Action
+		run.Elapsed = e.Elapsed
+		if e.Action == "fail" {
+			run.Panic = parsePanic(run.Output)
+		}
+	}
+}
+
+// parsePanic returns the first panic in output, or nil:
+//
+//	panic: oops [recovered]
+//		panic: oops
+//
+//	goroutine 7 [running]:
+//	testing.tRunner.func1(0xc000092100)
+//		/go/src/testing/testing.go:874 +0x3a3
+//	created by testing.(*T).Run
+//		/go/src/testing/testing.go:960 +0x350
+func parsePanic(output []string) *Panic {
+	lines := strings.Split(strings.Join(output, ""), "\n")
+	start := -1
+	for i, line := range lines {
+		if strings.HasPrefix(line, "panic: ") {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+	p := &Panic{Value: strings.TrimPrefix(lines[start], "panic: ")}
+	// panic: oops [recovered, repanicked]
+	if i := strings.LastIndex(p.Value, " [recovered"); i >= 0 && strings.HasSuffix(p.Value, "]") {
+		p.Value = p.Value[:i]
+	}
+	i := start + 1
+	for ; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "goroutine ") {
+			p.Goroutine = strings.TrimSuffix(lines[i], ":")
+			i++
+			break
+		}
+	}
+	for ; i+1 < len(lines); i += 2 {
+		fn, loc := lines[i], lines[i+1]
+		if fn == "" || !strings.HasPrefix(loc, "\t") {
+			break
+		}
+		f := Frame{Func: fn}
+		if j := strings.LastIndexByte(fn, '('); j > 0 && strings.HasSuffix(fn, ")") && !strings.HasPrefix(fn, "created by ") {
+			f.Func = fn[:j]
+		}
+		// created by testing.(*T).Run in goroutine 6
+		if j := strings.Index(f.Func, " in goroutine "); j >= 0 {
+			f.Func = f.Func[:j]
+		}
+		loc = strings.TrimSpace(loc)
+		if j := strings.LastIndex(loc, " +0x"); j >= 0 {
+			loc = loc[:j]
+		}
+		f.File = loc
+		if j := strings.LastIndexByte(loc, ':'); j >= 0 {
+			if n, err := strconv.Atoi(loc[j+1:]); err == nil {
+				f.File, f.Line = loc[:j], n
+			}
+		}
+		p.Stack = append(p.Stack, f)
+	}
+	return p
+}
+
+// Slowest returns the n slowest tests, without those of 0s.
+func (r *Report) Slowest(n int) []*Test {
+	var tests []*Test
+	for _, p := range r.Packages {
+		for _, t := range p.Tests {
+			if t.Elapsed() > 0 {
+				tests = append(tests, t)
+			}
+		}
+	}
+	sort.SliceStable(tests, func(i, j int) bool { return tests[i].Elapsed() > tests[j].Elapsed() })
+	if len(tests) > n {
+		tests = tests[:n]
+	}
+	return tests
+}
+
+// counts returns the number of the tests of p by result.
+func (p *Package) counts() (tests, failed, skipped int) {
+	for _, t := range p.Tests {
+		tests++
+		switch t.Result() {
+		case "fail":
+			failed++
+		case "":
+			// unfinished, by a panic or a timeout
+			if p.Action == "fail" {
+				failed++
+			}
+		case "skip":
+			skipped++
+		}
+	}
+	return tests, failed, skipped
+}
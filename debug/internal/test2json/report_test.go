@@ -0,0 +1,185 @@
+package test2json
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// reportSinks returns the sinks writing the reports of testdata/report, by
+// extension of their golden file.
+func reportSinks() (map[string]*bytes.Buffer, []Sink) {
+	bufs := map[string]*bytes.Buffer{".junit": {}, ".tap": {}, ".summary": {}}
+	return bufs, []Sink{JUnitSink(bufs[".junit"]), TAPSink(bufs[".tap"]), SummarySink(bufs[".summary"], 3)}
+}
+
+// checkReports compares the reports to the golden files of name.
+func checkReports(t *testing.T, name string, bufs map[string]*bytes.Buffer) {
+	t.Helper()
+	for ext, buf := range bufs {
+		file := "testdata/report/" + name + ext
+		if *update {
+			t.Logf("rewriting %s", file)
+			if err := ioutil.WriteFile(file, buf.Bytes(), 0666); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != string(want) {
+			t.Errorf("%s:\nhave:\n%s\nwant:\n%s", file, buf, want)
+		}
+	}
+}
+
+func TestReportGolden(t *testing.T) {
+	// The output of go test -v of two packages, and of a rerun of the
+	// flaky test of the first.
+	t.Run("text", func(t *testing.T) {
+		in, err := ioutil.ReadFile("testdata/report/gotest.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bufs, sinks := reportSinks()
+		c := NewConverter(nil, "", 0)
+		for _, s := range sinks {
+			c.AddSink(s)
+		}
+		for _, line := range bytes.SplitAfter(in, []byte("\n")) {
+			writeAndKill(c, line)
+		}
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		checkReports(t, "gotest.test", bufs)
+	})
+
+	// The same tests run with go test -json.
+	t.Run("json", func(t *testing.T) {
+		f, err := os.Open("testdata/report/gotest.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		bufs, sinks := reportSinks()
+		if err := Replay(f, sinks...); err != nil {
+			t.Fatal(err)
+		}
+		checkReports(t, "gotest.json", bufs)
+	})
+}
+
+func TestReport(t *testing.T) {
+	r := NewReport()
+	f, err := os.Open("testdata/report/gotest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := Replay(f, r); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		pkg, action string
+		tests       int
+	}
+	var have []result
+	for _, p := range r.Packages {
+		have = append(have, result{p.Name, p.Action, len(p.Tests)})
+	}
+	want := []result{{"example.com/t2j/a", "fail", 6}, {"example.com/t2j/b", "fail", 2}}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("packages = %v, want %v", have, want)
+	}
+
+	flaky := r.Packages[0].tests["TestFlaky"]
+	if !flaky.Flaky() || len(flaky.Runs) != 2 || flaky.Failures() != 1 {
+		t.Errorf("TestFlaky: flaky %v, %d runs, %d failures, want flaky, 2 runs, 1 failure", flaky.Flaky(), len(flaky.Runs), flaky.Failures())
+	}
+	if slowest := r.Slowest(1); len(slowest) != 1 || slowest[0].Name != "TestPass" {
+		t.Errorf("Slowest(1) = %v, want TestPass", slowest)
+	}
+
+	p := r.Packages[1].tests["TestPanic"].Panic()
+	if p == nil {
+		t.Fatal("no panic in TestPanic")
+	}
+	if p.Value != "assignment to entry in nil map" || p.Goroutine != "goroutine 7 [running]" {
+		t.Errorf("panic %q in %q", p.Value, p.Goroutine)
+	}
+	frame := Frame{Func: "example.com/t2j/b.TestPanic", File: "/tmp/t2j/b/b_test.go", Line: 9}
+	if len(p.Stack) != 6 || p.Stack[3] != frame || p.Stack[5].Func != "created by testing.(*T).Run" {
+		t.Errorf("stack = %+v", p.Stack)
+	}
+}
+
+func TestParsePanic(t *testing.T) {
+	in, err := ioutil.ReadFile("testdata/panic.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parsePanic([]string{string(in)})
+	want := &Panic{
+		Value:     "oops",
+		Goroutine: "goroutine 7 [running]",
+		Stack: []Frame{
+			{"testing.tRunner.func1", "/go/src/testing/testing.go", 874},
+			{"panic", "/go/src/runtime/panic.go", 679},
+			{"command-line-arguments.TestPanic", "a_test.go", 6},
+			{"testing.tRunner", "go/src/testing/testing.go", 909},
+			{"created by testing.(*T).Run", "go/src/testing/testing.go", 960},
+		},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("parsePanic = %+v\nwant %+v", p, want)
+	}
+	if p := parsePanic([]string{"--- FAIL: TestX (0.00s)\n", "    x_test.go:3: no panic\n"}); p != nil {
+		t.Errorf("parsePanic = %+v, want nil", p)
+	}
+}
+
+func TestConverterSinks(t *testing.T) {
+	// The events of the sinks are those of the JSON stream.
+	in, err := ioutil.ReadFile("testdata/issue29755.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var js bytes.Buffer
+	c := NewConverter(&js, "pkg", 0)
+	var events bytes.Buffer
+	c.AddSink(&recorder{&events})
+	c.Write(in)
+	c.Close()
+
+	var want strings.Builder
+	for _, line := range strings.SplitAfter(js.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		want.WriteString(e.Action + " " + e.Package + " " + e.Test + " " + e.Output + "\n")
+	}
+	if events.String() != want.String() {
+		t.Errorf("events:\n%s\nwant:\n%s", events.String(), want.String())
+	}
+}
+
+// recorder records the events without their time.
+type recorder struct{ w *bytes.Buffer }
+
+func (r *recorder) Event(e *Event) {
+	r.w.WriteString(e.Action + " " + e.Package + " " + e.Test + " " + e.Output + "\n")
+}
+
+func (r *recorder) Close() error { return nil }
@@ -0,0 +1,151 @@
+package test2json
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// WriteSummary writes a summary of r: the results and the time of the
+// packages, the n slowest tests, the flaky tests, the failed tests and the
+// stacks of the panics.
+func (r *Report) WriteSummary(w io.Writer, n int) error {
+	bw := bufio.NewWriter(w)
+	tw := tabwriter.NewWriter(bw, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tRESULT\tTIME\tTESTS\tFAILED\tSKIPPED")
+	var total struct {
+		elapsed                float64
+		tests, failed, skipped int
+	}
+	result := "ok"
+	for _, p := range r.Packages {
+		tests, failed, skipped := p.counts()
+		fmt.Fprintf(tw, "%s\t%s\t%ss\t%d\t%d\t%d\n", name(p.Name), pkgResultName(p.Action), seconds(p.Elapsed), tests, failed, skipped)
+		total.elapsed += p.Elapsed
+		total.tests += tests
+		total.failed += failed
+		total.skipped += skipped
+		if p.Action == "fail" {
+			result = "FAIL"
+		}
+	}
+	fmt.Fprintf(tw, "TOTAL\t%s\t%ss\t%d\t%d\t%d\n", result, seconds(total.elapsed), total.tests, total.failed, total.skipped)
+	tw.Flush()
+
+	if slowest := r.Slowest(n); len(slowest) > 0 {
+		fmt.Fprintf(bw, "\nSlowest tests:\n")
+		for _, t := range slowest {
+			fmt.Fprintf(bw, "  %8ss  %s %s\n", seconds(t.Elapsed()), name(t.Package), t.Name)
+		}
+	}
+
+	var flaky, failed []*Test
+	for _, p := range r.Packages {
+		for _, t := range p.Tests {
+			switch {
+			case t.Flaky():
+				flaky = append(flaky, t)
+			case t.Result() == "fail" || t.Result() == "" && p.Action == "fail":
+				failed = append(failed, t)
+			}
+		}
+	}
+	if len(flaky) > 0 {
+		fmt.Fprintf(bw, "\nFlaky tests:\n")
+		for _, t := range flaky {
+			fmt.Fprintf(bw, "  %s %s: failed %d of %d runs\n", name(t.Package), t.Name, t.Failures(), len(t.Runs))
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(bw, "\nFailed tests:\n")
+		for _, t := range failed {
+			fmt.Fprintf(bw, "  %s %s", name(t.Package), t.Name)
+			if t.Result() == "" {
+				fmt.Fprintf(bw, " (unfinished)")
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+
+	first := true
+	printPanic := func(where string, p *Panic) {
+		if first {
+			fmt.Fprintf(bw, "\nPanics:\n")
+			first = false
+		}
+		fmt.Fprintf(bw, "  %s: panic: %s\n", where, p.Value)
+		if p.Goroutine != "" {
+			fmt.Fprintf(bw, "    %s\n", p.Goroutine)
+		}
+		for _, f := range p.Stack {
+			fmt.Fprintf(bw, "    %s\n", f.Func)
+			if f.Line > 0 {
+				fmt.Fprintf(bw, "        %s:%d\n", f.File, f.Line)
+			} else if f.File != "" {
+				fmt.Fprintf(bw, "        %s\n", f.File)
+			}
+		}
+	}
+	for _, p := range r.Packages {
+		if p.Panic != nil {
+			printPanic(name(p.Name), p.Panic)
+		}
+		for _, t := range p.Tests {
+			if pc := t.Panic(); pc != nil {
+				printPanic(strings.TrimSpace(name(p.Name)+" "+t.Name), pc)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// name returns the name of the package pkg, "-" for the package unnamed.
+func name(pkg string) string {
+	if pkg == "" {
+		return "-"
+	}
+	return pkg
+}
+
+func pkgResultName(action string) string {
+	switch action {
+	case "pass":
+		return "ok"
+	case "fail":
+		return "FAIL"
+	case "skip":
+		return "?"
+	}
+	return "-"
+}
+
+// JUnitSink returns a sink writing the report of the events as JUnit XML
+// to w when closed.
+func JUnitSink(w io.Writer) Sink {
+	return &reportSink{Report: NewReport(), write: func(r *Report) error { return r.WriteJUnit(w) }}
+}
+
+// TAPSink returns a sink writing the report of the events in the Test
+// Anything Protocol to w when closed.
+func TAPSink(w io.Writer) Sink {
+	return &reportSink{Report: NewReport(), write: func(r *Report) error { return r.WriteTAP(w) }}
+}
+
+// SummarySink returns a sink writing the summary of the events to w when
+// closed, with the n slowest tests.
+func SummarySink(w io.Writer, n int) Sink {
+	return &reportSink{Report: NewReport(), write: func(r *Report) error { return r.WriteSummary(w, n) }}
+}
+
+// reportSink is a Report written when closed.
+type reportSink struct {
+	*Report
+	write func(r *Report) error
+}
+
+func (s *reportSink) Close() error {
+	s.Report.Close()
+	return s.write(s.Report)
+}
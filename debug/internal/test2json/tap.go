@@ -0,0 +1,89 @@
+package test2json
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteTAP writes r in the Test Anything Protocol version 13, a test point
+// by test named "pkg Test", with the time, the runs and the output of the
+// failed tests in YAML diagnostics:
+//
+//	TAP version 13
+//	ok 1 - example.com/a TestA
+//	not ok 2 - example.com/a TestB
+//	  ---
+//	  duration_ms: 12
+//	  output: |
+//	    --- FAIL: TestB (0.01s)
+//	  ...
+//	ok 3 - example.com/a TestC # SKIP not on linux
+//	1..3
+//
+// A package that failed without a failed test has a failed test point
+// named by the package.
+func (r *Report) WriteTAP(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "TAP version 13")
+	n := 0
+	for _, p := range r.Packages {
+		fmt.Fprintf(bw, "# %s\n", p.Name)
+		failed := false
+		for _, t := range p.Tests {
+			n++
+			last := t.Runs[len(t.Runs)-1]
+			ok := last.Action != "fail" && (last.Action != "" || p.Action != "fail")
+			failed = failed || !ok
+			switch {
+			case last.Action == "skip":
+				fmt.Fprintf(bw, "ok %d - %s %s # SKIP %s\n", n, p.Name, t.Name, skipMessage(last.Output))
+				continue
+			case ok:
+				fmt.Fprintf(bw, "ok %d - %s %s\n", n, p.Name, t.Name)
+			default:
+				fmt.Fprintf(bw, "not ok %d - %s %s\n", n, p.Name, t.Name)
+			}
+			fmt.Fprintln(bw, "  ---")
+			fmt.Fprintf(bw, "  duration_ms: %d\n", int64(t.Elapsed()*1000+0.5))
+			if len(t.Runs) > 1 {
+				fmt.Fprintf(bw, "  runs: %d\n  failures: %d\n", len(t.Runs), t.Failures())
+			}
+			if t.Flaky() {
+				fmt.Fprintln(bw, "  flaky: true")
+			}
+			if pc := t.Panic(); pc != nil {
+				fmt.Fprintf(bw, "  panic: %q\n", pc.Value)
+			}
+			if !ok {
+				yamlBlock(bw, "output", last.Output)
+			}
+			fmt.Fprintln(bw, "  ...")
+		}
+		if p.Action == "fail" && !failed {
+			n++
+			fmt.Fprintf(bw, "not ok %d - %s\n", n, p.Name)
+			fmt.Fprintln(bw, "  ---")
+			if p.Panic != nil {
+				fmt.Fprintf(bw, "  panic: %q\n", p.Panic.Value)
+			}
+			yamlBlock(bw, "output", p.Output)
+			fmt.Fprintln(bw, "  ...")
+		}
+	}
+	fmt.Fprintf(bw, "1..%d\n", n)
+	return bw.Flush()
+}
+
+// yamlBlock writes output as the literal block of key.
+func yamlBlock(w io.Writer, key string, output []string) {
+	text := strings.TrimRight(join(output), "\n")
+	if text == "" {
+		return
+	}
+	fmt.Fprintf(w, "  %s: |\n", key)
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+}
@@ -35,6 +35,29 @@ type event struct {
 	Test    string     `json:",omitempty"`
 	Elapsed *float64   `json:",omitempty"`
 	Output  *textBytes `json:",omitempty"`
+
+	elapsed float64 // for the sinks, without Timestamp
+}
+
+// Event is an event of the JSON stream, as received by the sinks.
+// The sinks of a Converter receive the Time and Elapsed of the events
+// even without Timestamp.
+type Event struct {
+	Time    time.Time `json:",omitempty"`
+	Action  string
+	Package string  `json:",omitempty"`
+	Test    string  `json:",omitempty"`
+	Elapsed float64 `json:",omitempty"` // seconds
+	Output  string  `json:",omitempty"`
+}
+
+// A Sink receives the events of a conversion or of a replayed JSON stream,
+// to report them in another format.
+type Sink interface {
+	// Event handles an event.
+	Event(e *Event)
+	// Close is called after the last event.
+	Close() error
 }
 
 // textBytes is a hack to get JSON to emit a []byte as a string
@@ -58,6 +81,7 @@ type Converter struct {
 	result   string     // overall test result if seen
 	input    lineBuffer // input buffer
 	output   lineBuffer // output buffer
+	sinks    []Sink     // event sinks
 }
 
 // inBuffer and outBuffer are the input and output buffer sizes.
@@ -100,6 +124,8 @@ var (
 //
 // The pkg string, if present, specifies the import path to
 // report in the JSON stream.
+//
+// If w is nil, the events are only sent to the sinks added with AddSink.
 func NewConverter(w io.Writer, pkg string, mode Mode) *Converter {
 	c := new(Converter)
 	*c = Converter{
@@ -121,6 +147,12 @@ func NewConverter(w io.Writer, pkg string, mode Mode) *Converter {
 	return c
 }
 
+// AddSink adds a sink receiving the events written as JSON to w.
+// It must be called before the first Write.
+func (c *Converter) AddSink(s Sink) {
+	c.sinks = append(c.sinks, s)
+}
+
 // Write writes the test input to the converter.
 func (c *Converter) Write(b []byte) (int, error) {
 	c.input.write(b)
@@ -254,6 +286,7 @@ func (c *Converter) handleInputLine(line []byte) {
 			if strings.HasSuffix(name, "s)") {
 				t, err := strconv.ParseFloat(name[i+2:len(name)-2], 64)
 				if err == nil {
+					e.elapsed = t
 					if c.mode&Timestamp != 0 {
 						e.Elapsed = &t
 					}
@@ -307,18 +340,25 @@ func (c *Converter) flushReport(depth int) {
 // Close marks the end of the go test output.
 // It flushes any pending input and then output (only partial lines at this point)
 // and then emits the final overall package-level pass/fail event.
+// It closes the sinks and returns the first error of their Close.
 func (c *Converter) Close() error {
 	c.input.flush()
 	c.output.flush()
 	if c.result != "" {
-		e := &event{Action: c.result}
+		dt := time.Since(c.start).Round(1 * time.Millisecond).Seconds()
+		e := &event{Action: c.result, elapsed: dt}
 		if c.mode&Timestamp != 0 {
-			dt := time.Since(c.start).Round(1 * time.Millisecond).Seconds()
 			e.Elapsed = &dt
 		}
 		c.writeEvent(e)
 	}
-	return nil
+	var err error
+	for _, s := range c.sinks {
+		if cerr := s.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
 }
 
 // writeOutputEvent writes a single output event with the given bytes.
@@ -333,13 +373,25 @@ func (c *Converter) writeOutputEvent(out []byte) {
 // It adds the package, time (if requested), and test name (if needed).
 func (c *Converter) writeEvent(e *event) {
 	e.Package = c.pkg
+	t := time.Now()
 	if c.mode&Timestamp != 0 {
-		t := time.Now()
 		e.Time = &t
 	}
 	if e.Test == "" {
 		e.Test = c.testName
 	}
+	if len(c.sinks) > 0 {
+		se := &Event{Time: t, Action: e.Action, Package: e.Package, Test: e.Test, Elapsed: e.elapsed}
+		if e.Output != nil {
+			se.Output = string(*e.Output)
+		}
+		for _, s := range c.sinks {
+			s.Event(se)
+		}
+	}
+	if c.w == nil {
+		return
+	}
 	js, err := json.Marshal(e)
 	if err != nil {
 		// Should not happen - event is valid for json.Marshal.
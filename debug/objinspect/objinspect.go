@@ -0,0 +1,723 @@
+// Package objinspect inspects the Go object files and archives written by
+// the compiler, such as those of the build cache, to debug the linknames
+// and the inlining of a build.
+//
+// An archive has the Go objects of the package, compiled from its Go and
+// its assembly files, and the native objects of cgo. For each Go object
+// Open reads the packages it imports and references, its symbols with
+// their kind, size, flags and relocations, the funcinfo of its functions,
+// with their frame, pc tables, funcdata and inlining trees, and the
+// symbols it references by name, such as those pulled by a linkname.
+//
+// The objects of Go 1.16 and of Go 1.20 and later are read. OpenPackage
+// reads the archive of a package in the build cache, and names the symbols
+// of the other packages it references by index, such as the functions
+// inlined, with the archives of its dependencies.
+package objinspect
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/hitzhangjie/codemaster/debug/internal/archive"
+	"github.com/hitzhangjie/codemaster/debug/internal/goobj"
+	"github.com/hitzhangjie/codemaster/debug/internal/objabi"
+	"github.com/hitzhangjie/codemaster/debug/internal/sys"
+)
+
+// File is an object file or an archive.
+type File struct {
+	Path    string    `json:"path"`
+	Objects []*Object `json:"objects"`
+}
+
+// Object is a member of an archive, or the object file itself.
+type Object struct {
+	Name string `json:"name"`
+	// Native reports whether the object is a native object of cgo, not
+	// inspected.
+	Native bool  `json:"native,omitempty"`
+	Size   int64 `json:"size"`
+
+	// Header is the text header of the object: go object, the GOOS, the
+	// GOARCH, the Go version and the experiments.
+	Header  string `json:"header,omitempty"`
+	BuildID string `json:"buildid,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Format is the magic of the object, go116ld or go120ld.
+	Format      string   `json:"format,omitempty"`
+	Fingerprint string   `json:"fingerprint,omitempty"`
+	Flags       []string `json:"flags,omitempty"`
+
+	// Imports are the packages imported, with their fingerprints.
+	Imports []Import `json:"imports,omitempty"`
+	// Packages are the packages whose symbols are referenced by index.
+	Packages []string `json:"packages,omitempty"`
+	// Files are the source files of the funcinfos.
+	Files []string `json:"files,omitempty"`
+
+	Symbols []*Symbol `json:"symbols,omitempty"`
+	// Refs are the symbols referenced, by name or from the other
+	// packages.
+	Refs []*Ref `json:"refs,omitempty"`
+}
+
+// Import is an imported package.
+type Import struct {
+	Pkg         string `json:"pkg"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Symbol is a symbol defined by an object.
+type Symbol struct {
+	Index uint32 `json:"index"`
+	Name  string `json:"name"`
+	ABI   string `json:"abi"`
+	// Class is pkg for the symbols indexed in the package, hashed64 and
+	// hashed for the content-addressable ones, and nonpkg for the others.
+	Class string   `json:"class"`
+	Kind  string   `json:"kind"`
+	Size  uint32   `json:"size"`
+	Align uint32   `json:"align,omitempty"`
+	Flags []string `json:"flags,omitempty"`
+	// Type is the symbol of the Go type of a variable.
+	Type   string  `json:"type,omitempty"`
+	Relocs []Reloc `json:"relocs,omitempty"`
+	Func   *Func   `json:"func,omitempty"`
+}
+
+// Reloc is a relocation of a symbol.
+type Reloc struct {
+	Off  int32  `json:"off"`
+	Size uint8  `json:"size"`
+	Type string `json:"type"`
+	Add  int64  `json:"add,omitempty"`
+	Sym  string `json:"sym,omitempty"`
+}
+
+// Func is the funcinfo of a function.
+type Func struct {
+	Args      uint32   `json:"args"`
+	Locals    uint32   `json:"locals"`
+	FuncID    string   `json:"funcid,omitempty"`
+	FuncFlags []string `json:"funcflags,omitempty"`
+	StartLine int32    `json:"startline,omitempty"`
+
+	Pcsp     *PCTable   `json:"pcsp,omitempty"`
+	Pcfile   *PCTable   `json:"pcfile,omitempty"`
+	Pcline   *PCTable   `json:"pcline,omitempty"`
+	Pcinline *PCTable   `json:"pcinline,omitempty"`
+	Pcdata   []*PCTable `json:"pcdata,omitempty"`
+	// Funcdata are the symbols of the funcdata, such as the pointer maps
+	// of the arguments and the locals, empty if there is none at an
+	// index.
+	Funcdata []string `json:"funcdata,omitempty"`
+	// Files are the files of Pcfile and of the inlining tree.
+	Files   []string  `json:"files,omitempty"`
+	InlTree []InlNode `json:"inltree,omitempty"`
+}
+
+// PCTable is a table of values by pc, such as the frame size or the line.
+type PCTable struct {
+	Sym    string    `json:"sym,omitempty"`
+	Size   int       `json:"size"`
+	Values []PCValue `json:"values,omitempty"`
+}
+
+// PCValue is the value of a table from PC, up to the PC of the next one.
+type PCValue struct {
+	PC    uint64 `json:"pc"`
+	Value int32  `json:"value"`
+}
+
+// InlNode is a call inlined in a function, the index of the node is the
+// value of Pcinline at the instructions of the inlined body.
+type InlNode struct {
+	// Parent is the index of the node of the inlined call the call is in,
+	// -1 for the function.
+	Parent   int32  `json:"parent"`
+	Func     string `json:"func"`
+	File     string `json:"file"`
+	Line     int32  `json:"line"`
+	ParentPC int32  `json:"parentpc"`
+}
+
+// Ref is a symbol referenced by an object.
+type Ref struct {
+	Name string `json:"name"`
+	// ABI is empty for the symbols referenced by index.
+	ABI string `json:"abi,omitempty"`
+	// Pkg is the package of the symbols referenced by index, empty for
+	// those referenced by name.
+	Pkg   string   `json:"pkg,omitempty"`
+	Flags []string `json:"flags,omitempty"`
+}
+
+// Open reads the object file or archive at path.
+func Open(path string) (*File, error) {
+	return open(path, nil)
+}
+
+// OpenPackage reads the archive of the package pkg in the build cache,
+// built with the build flags, such as -gcflags=-l, if not yet.
+func OpenPackage(pkg string, buildFlags ...string) (*File, error) {
+	args := append([]string{"list", "-export", "-deps", "-f", "{{if .Export}}{{.ImportPath}}={{.Export}}{{end}}"}, buildFlags...)
+	cmd := exec.Command("go", append(args, "--", pkg)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list %s: %v: %s", pkg, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	d := &deps{paths: map[string]string{}, readers: map[string]*goobj.Reader{}}
+	var path string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if i := strings.Index(line, "="); i > 0 {
+			// the package is listed after its dependencies
+			d.paths[line[:i]], path = line[i+1:], line[i+1:]
+		}
+	}
+	if path == "" {
+		return nil, fmt.Errorf("go list %s: no archive", pkg)
+	}
+	return open(path, d)
+}
+
+func open(path string, d *deps) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	a, err := archive.Parse(f, false)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %v", path, err)
+	}
+	file := &File{Path: path}
+	for _, e := range a.Entries {
+		switch e.Type {
+		case archive.EntryPkgDef:
+			continue
+		case archive.EntryNativeObj:
+			file.Objects = append(file.Objects, &Object{Name: e.Name, Native: true, Size: e.Size})
+			continue
+		}
+		b := make([]byte, e.Obj.Size)
+		if _, err := f.ReadAt(b, e.Obj.Offset); err != nil {
+			return nil, err
+		}
+		o, err := readObject(e.Name, e.Obj, b, d)
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %s: %v", path, e.Name, err)
+		}
+		file.Objects = append(file.Objects, o)
+	}
+	return file, nil
+}
+
+// Symbol returns the symbol named name defined by o, nil if none.
+func (o *Object) Symbol(name string) *Symbol {
+	for _, s := range o.Symbols {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// objReader reads an object.
+type objReader struct {
+	r        *goobj.Reader
+	quantum  uint64 // of the pcs of the pc tables
+	ndef     uint32
+	pkgs     []string
+	refNames map[goobj.SymRef]string
+	deps     *deps // nil if the dependencies are unknown
+}
+
+func readObject(name string, obj *archive.GoObj, b []byte, d *deps) (*Object, error) {
+	r := goobj.NewReaderFromBytes(b, false)
+	if r == nil {
+		return nil, errors.New("unsupported object file version")
+	}
+	o := &Object{
+		Name:        name,
+		Size:        obj.Size,
+		Arch:        obj.Arch,
+		Format:      strings.TrimPrefix(string(b[:len(goobj.Magic)]), "\x00"),
+		Fingerprint: fingerprint(r.Fingerprint()),
+		Files:       make([]string, r.NFile()),
+	}
+	for _, line := range strings.Split(string(obj.TextHeader), "\n") {
+		switch {
+		case strings.HasPrefix(line, "go object "):
+			o.Header = line
+			if fs := strings.Fields(line); len(fs) >= 5 {
+				o.Version = fs[4]
+			}
+		case strings.HasPrefix(line, "build id "):
+			o.BuildID = strings.Trim(strings.TrimPrefix(line, "build id "), `"`)
+		}
+	}
+	o.Flags = flagNames(uint8(r.Flags()), objFlags(r))
+	for _, p := range r.Autolib() {
+		o.Imports = append(o.Imports, Import{p.Pkg, fingerprint(p.Fingerprint)})
+	}
+	// the first package is the empty one of the invalid index
+	if pkgs := r.Pkglist(); len(pkgs) > 1 {
+		o.Packages = pkgs[1:]
+	}
+	for i := range o.Files {
+		o.Files[i] = r.File(i)
+	}
+
+	or := &objReader{
+		r:        r,
+		quantum:  1,
+		ndef:     uint32(r.NSym() + r.NHashed64def() + r.NHasheddef() + r.NNonpkgdef()),
+		pkgs:     r.Pkglist(),
+		refNames: make(map[goobj.SymRef]string, r.NRefName()),
+		deps:     d,
+	}
+	for _, a := range sys.Archs {
+		if a.Name == obj.Arch {
+			or.quantum = uint64(a.MinLC)
+		}
+	}
+	for i := 0; i < r.NRefName(); i++ {
+		rn := r.RefName(i)
+		or.refNames[rn.Sym()] = rn.Name(r)
+	}
+
+	for i := uint32(0); i < or.ndef; i++ {
+		if s := or.symbol(i); s != nil {
+			o.Symbols = append(o.Symbols, s)
+		}
+	}
+	o.Refs = or.refs()
+	return o, nil
+}
+
+// symbol reads the i-th symbol defined, nil if it is not a real symbol.
+func (or *objReader) symbol(i uint32) *Symbol {
+	r := or.r
+	osym := r.Sym(i)
+	name := osym.Name(r)
+	if name == "" {
+		return nil
+	}
+	s := &Symbol{
+		Index: i,
+		Name:  name,
+		ABI:   abiName(osym.ABI()),
+		Class: or.class(i),
+		Kind:  or.kind(osym.Type()),
+		Size:  osym.Siz(),
+		Align: osym.Align(),
+		Flags: append(flagNames(osym.Flag(), symFlags(r)), flagNames(osym.Flag2(), symFlags2(r))...),
+	}
+	for _, rel := range r.Relocs(i) {
+		typ := objabi.RelocType(rel.Type()).String()
+		if r.Go120() {
+			typ = objabi.RelocTypeNameGo120(uint16(rel.Type()))
+		}
+		s.Relocs = append(s.Relocs, Reloc{
+			Off:  rel.Off(),
+			Size: rel.Siz(),
+			Type: typ,
+			Add:  rel.Add(),
+			Sym:  or.name(rel.Sym()),
+		})
+	}
+
+	var info []byte
+	var fn Func
+	for _, a := range r.Auxs(i) {
+		switch a.Type() {
+		case goobj.AuxGotype:
+			s.Type = or.name(a.Sym())
+		case goobj.AuxFuncInfo:
+			info = or.data(a.Sym())
+		case goobj.AuxFuncdata:
+			fn.Funcdata = append(fn.Funcdata, or.name(a.Sym()))
+		case goobj.AuxPcsp:
+			fn.Pcsp = or.pcTable(a.Sym())
+		case goobj.AuxPcfile:
+			fn.Pcfile = or.pcTable(a.Sym())
+		case goobj.AuxPcline:
+			fn.Pcline = or.pcTable(a.Sym())
+		case goobj.AuxPcinline:
+			fn.Pcinline = or.pcTable(a.Sym())
+		case goobj.AuxPcdata:
+			fn.Pcdata = append(fn.Pcdata, or.pcTable(a.Sym()))
+		}
+	}
+	if info != nil {
+		or.funcInfo(&fn, info)
+		s.Func = &fn
+	}
+	return s
+}
+
+// funcInfo reads the funcinfo of a function to fn.
+func (or *objReader) funcInfo(fn *Func, b []byte) {
+	r := or.r
+	var info goobj.FuncInfo
+	if r.Go120() {
+		info.ReadGo120(b)
+		fn.FuncID = objabi.FuncIDNameGo120(uint8(info.FuncID))
+		fn.FuncFlags = flagNames(info.FuncFlag, funcFlags)
+		fn.StartLine = info.StartLine
+	} else {
+		info.Read(b)
+		fn.FuncID = fmt.Sprintf("FuncID(%d)", info.FuncID)
+		// the pc tables are in the funcinfo too
+		for _, t := range []struct {
+			table **PCTable
+			ref   goobj.SymRef
+		}{
+			{&fn.Pcsp, info.Pcsp},
+			{&fn.Pcfile, info.Pcfile},
+			{&fn.Pcline, info.Pcline},
+			{&fn.Pcinline, info.Pcinline},
+		} {
+			if *t.table == nil {
+				*t.table = or.pcTable(t.ref)
+			}
+		}
+		if len(fn.Pcdata) == 0 {
+			for _, ref := range info.Pcdata {
+				fn.Pcdata = append(fn.Pcdata, or.pcTable(ref))
+			}
+		}
+	}
+	if info.FuncID == 0 {
+		fn.FuncID = ""
+	}
+	fn.Args, fn.Locals = info.Args, info.Locals
+	for _, f := range info.File {
+		fn.Files = append(fn.Files, or.file(int(f)))
+	}
+	for _, n := range info.InlTree {
+		fn.InlTree = append(fn.InlTree, InlNode{
+			Parent:   n.Parent,
+			Func:     or.name(n.Func),
+			File:     or.file(int(n.File)),
+			Line:     n.Line,
+			ParentPC: n.ParentPC,
+		})
+	}
+}
+
+// refs returns the symbols referenced by name and by index in the other
+// packages, with their flags.
+func (or *objReader) refs() []*Ref {
+	r := or.r
+	flags := make(map[goobj.SymRef]*goobj.RefFlags, r.NRefFlags())
+	for i := 0; i < r.NRefFlags(); i++ {
+		rf := r.RefFlags(i)
+		flags[rf.Sym()] = rf
+	}
+	refFlags := func(ref goobj.SymRef) []string {
+		rf, ok := flags[ref]
+		if !ok {
+			return nil
+		}
+		return append(flagNames(rf.Flag(), symFlags(r)), flagNames(rf.Flag2(), symFlags2(r))...)
+	}
+
+	var refs []*Ref
+	for i := 0; i < r.NNonpkgref(); i++ {
+		osym := r.Sym(or.ndef + uint32(i))
+		if osym.Name(r) == "" {
+			continue // not a real symbol
+		}
+		ref := goobj.SymRef{PkgIdx: goobj.PkgIdxNone, SymIdx: uint32(r.NNonpkgdef() + i)}
+		refs = append(refs, &Ref{
+			Name:  osym.Name(r),
+			ABI:   abiName(osym.ABI()),
+			Flags: refFlags(ref),
+		})
+	}
+	for i := 0; i < r.NRefName(); i++ {
+		rn := r.RefName(i)
+		ref := rn.Sym()
+		pkg := ""
+		if int(ref.PkgIdx) < len(or.pkgs) {
+			pkg = or.pkgs[ref.PkgIdx]
+		}
+		refs = append(refs, &Ref{Name: rn.Name(r), Pkg: pkg, Flags: refFlags(ref)})
+	}
+	return refs
+}
+
+// index returns the index of the symbol ref in the object, false if it is
+// not defined or referenced by name by it.
+func (or *objReader) index(ref goobj.SymRef) (uint32, bool) {
+	r := or.r
+	switch ref.PkgIdx {
+	case goobj.PkgIdxSelf:
+		return ref.SymIdx, true
+	case goobj.PkgIdxHashed64:
+		return ref.SymIdx + uint32(r.NSym()), true
+	case goobj.PkgIdxHashed:
+		return ref.SymIdx + uint32(r.NSym()+r.NHashed64def()), true
+	case goobj.PkgIdxNone:
+		return ref.SymIdx + uint32(r.NSym()+r.NHashed64def()+r.NHasheddef()), true
+	}
+	return 0, false
+}
+
+// name returns the name of the symbol ref.
+func (or *objReader) name(ref goobj.SymRef) string {
+	r := or.r
+	switch ref.PkgIdx {
+	case goobj.PkgIdxInvalid:
+		return ""
+	case goobj.PkgIdxBuiltin:
+		builtin := goobj.BuiltinName
+		if r.Go120() {
+			builtin = goobj.BuiltinNameGo120
+		}
+		n, abi := builtin(int(ref.SymIdx))
+		if abi != 0 {
+			return n + "<" + abiName(uint16(abi)) + ">"
+		}
+		return n
+	}
+	if i, ok := or.index(ref); ok {
+		osym := r.Sym(i)
+		if n := osym.Name(r); n != "" {
+			return n
+		}
+		return fmt.Sprintf("<%s %d>", or.class(i), i)
+	}
+	if n, ok := or.refNames[ref]; ok {
+		return n
+	}
+	if int(ref.PkgIdx) < len(or.pkgs) {
+		pkg := or.pkgs[ref.PkgIdx]
+		if n := or.deps.name(pkg, ref.SymIdx); n != "" {
+			return n
+		}
+		return fmt.Sprintf("<%s sym %d>", pkg, ref.SymIdx)
+	}
+	return fmt.Sprintf("<pkg %d sym %d>", ref.PkgIdx, ref.SymIdx)
+}
+
+// data returns the data of the symbol ref defined by the object.
+func (or *objReader) data(ref goobj.SymRef) []byte {
+	i, ok := or.index(ref)
+	if !ok || i >= or.ndef {
+		return nil
+	}
+	return or.r.Data(i)
+}
+
+// pcTable reads the pc table of the symbol ref.
+func (or *objReader) pcTable(ref goobj.SymRef) *PCTable {
+	if ref == (goobj.SymRef{}) {
+		return nil
+	}
+	b := or.data(ref)
+	t := &PCTable{Size: len(b)}
+	if ref.PkgIdx != goobj.PkgIdxHashed && ref.PkgIdx != goobj.PkgIdxHashed64 {
+		// the tables are hashed, and named only when not
+		t.Sym = or.name(ref)
+	}
+	t.Values = pcValues(b, or.quantum)
+	return t
+}
+
+// file returns the i-th file of the object.
+func (or *objReader) file(i int) string {
+	if i < 0 || i >= or.r.NFile() {
+		return fmt.Sprintf("<file %d>", i)
+	}
+	return or.r.File(i)
+}
+
+// class returns the class of the i-th symbol.
+func (or *objReader) class(i uint32) string {
+	r := or.r
+	switch {
+	case i < uint32(r.NSym()):
+		return "pkg"
+	case i < uint32(r.NSym()+r.NHashed64def()):
+		return "hashed64"
+	case i < uint32(r.NSym()+r.NHashed64def()+r.NHasheddef()):
+		return "hashed"
+	}
+	return "nonpkg"
+}
+
+// deps are the archives of the dependencies of a package, read to name
+// the symbols it references by index.
+type deps struct {
+	paths   map[string]string // by import path
+	readers map[string]*goobj.Reader
+}
+
+// name returns the name of the i-th symbol of the package pkg, empty if
+// unknown.
+func (d *deps) name(pkg string, i uint32) string {
+	if d == nil {
+		return ""
+	}
+	r, ok := d.readers[pkg]
+	if !ok {
+		r = d.read(pkg)
+		d.readers[pkg] = r
+	}
+	if r == nil || i >= uint32(r.NSym()) {
+		return ""
+	}
+	return r.Sym(i).Name(r)
+}
+
+// read reads the Go object compiled from the Go files of the package pkg,
+// which indexes its symbols, nil if it can't.
+func (d *deps) read(pkg string) *goobj.Reader {
+	path, ok := d.paths[pkg]
+	if !ok {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	a, err := archive.Parse(f, false)
+	if err != nil {
+		return nil
+	}
+	for _, e := range a.Entries {
+		if e.Type != archive.EntryGoObj {
+			continue
+		}
+		b := make([]byte, e.Obj.Size)
+		if _, err := f.ReadAt(b, e.Obj.Offset); err != nil {
+			return nil
+		}
+		if r := goobj.NewReaderFromBytes(b, false); r != nil && !r.FromAssembly() {
+			return r
+		}
+	}
+	return nil
+}
+
+func (or *objReader) kind(k uint8) string {
+	if or.r.Go120() {
+		return objabi.SymKindNameGo120(k)
+	}
+	return objabi.SymKind(k).String()
+}
+
+// pcValues decodes the pc-value table tab, whose pcs are in units of
+// quantum: pairs of a zig-zag varint delta of the value, from -1, and of a
+// varint delta of the pc, ended by a zero value delta.
+func pcValues(tab []byte, quantum uint64) []PCValue {
+	var values []PCValue
+	val := int32(-1)
+	var pc uint64
+	for first := true; len(tab) > 0; first = false {
+		uvdelta, n := uvarint(tab)
+		if n <= 0 || uvdelta == 0 && !first {
+			break
+		}
+		tab = tab[n:]
+		if uvdelta&1 != 0 {
+			uvdelta = ^(uvdelta >> 1)
+		} else {
+			uvdelta >>= 1
+		}
+		pcdelta, n := uvarint(tab)
+		if n <= 0 {
+			break
+		}
+		tab = tab[n:]
+		val += int32(uvdelta)
+		values = append(values, PCValue{PC: pc, Value: val})
+		pc += uint64(pcdelta) * quantum
+	}
+	return values
+}
+
+// uvarint decodes a uint32 varint from b, and returns it and its length,
+// 0 if b is too short.
+func uvarint(b []byte) (uint32, int) {
+	var v uint32
+	for i, shift := 0, uint(0); i < len(b) && shift < 35; i, shift = i+1, shift+7 {
+		v |= uint32(b[i]&0x7f) << shift
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+func fingerprint(fp goobj.FingerprintType) string {
+	if fp.IsZero() {
+		return ""
+	}
+	return hex.EncodeToString(fp[:])
+}
+
+func abiName(abi uint16) string {
+	switch abi {
+	case 0:
+		return "ABI0"
+	case 1:
+		return "ABIInternal"
+	case goobj.SymABIstatic:
+		return "static"
+	}
+	return fmt.Sprintf("ABI%d", abi)
+}
+
+// flagNames returns the names of the bits set in flags, named by bit.
+func flagNames(flags uint8, names []string) []string {
+	var set []string
+	for i := 0; flags != 0; i, flags = i+1, flags>>1 {
+		if flags&1 == 0 {
+			continue
+		}
+		if i < len(names) && names[i] != "" {
+			set = append(set, names[i])
+		} else {
+			set = append(set, fmt.Sprintf("bit%d", i))
+		}
+	}
+	return set
+}
+
+func objFlags(r *goobj.Reader) []string {
+	if r.Go120() {
+		return []string{"shared", "", "asm", "unlinkable", "std"}
+	}
+	return []string{"shared", "needexpansion", "asm"}
+}
+
+func symFlags(r *goobj.Reader) []string {
+	names := []string{"dupok", "local", "typelink", "leaf", "nosplit", "reflectmethod", "gotype"}
+	if !r.Go120() {
+		names = append(names, "topframe")
+	}
+	return names
+}
+
+func symFlags2(r *goobj.Reader) []string {
+	names := []string{"usedininterface", "itab"}
+	if r.Go120() {
+		names = append(names, "dict", "pkginit", "linkname", "linknamestd", "abiwrapper", "wasmexport")
+	}
+	return names
+}
+
+var funcFlags = []string{"topframe", "spwrite", "asm"}
@@ -0,0 +1,148 @@
+package objinspect
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const pkgPath = "github.com/hitzhangjie/codemaster/debug/objinspect/testdata/p"
+
+// function returns the function named name of o, the one of ABIInternal
+// if it has an ABI wrapper.
+func function(t *testing.T, o *Object, name string) *Symbol {
+	for _, s := range o.Symbols {
+		if s.Name == pkgPath+"."+name && s.Kind == "STEXT" && s.ABI == "ABIInternal" {
+			require.NotNil(t, s.Func, name)
+			return s
+		}
+	}
+	t.Fatalf("no function %s", name)
+	return nil
+}
+
+func TestOpenPackage(t *testing.T) {
+	f, err := OpenPackage("./testdata/p")
+	require.Nil(t, err)
+	require.Len(t, f.Objects, 2)
+
+	// the objects of the Go and the assembly files
+	o, asm := f.Objects[0], f.Objects[1]
+	assert.Equal(t, "_go_.o", o.Name)
+	assert.Equal(t, "go120ld", o.Format)
+	assert.Regexp(t, `^go object \w+ \w+ go1\.\d+`, o.Header)
+	assert.Contains(t, o.Header, o.Version)
+	assert.NotEmpty(t, o.BuildID)
+	assert.NotEmpty(t, o.Fingerprint)
+	assert.Contains(t, o.Files, "<autogenerated>")
+	assert.Contains(t, asm.Flags, "asm")
+	assert.Empty(t, asm.Symbols)
+
+	// add is inlined in Sum
+	sum := function(t, o, "Sum")
+	assert.Contains(t, sum.Flags, "nosplit")
+	assert.Equal(t, uint32(24), sum.Func.Args)
+	assert.Equal(t, int32(9), sum.Func.StartLine)
+	require.Len(t, sum.Func.InlTree, 1)
+	inl := sum.Func.InlTree[0]
+	assert.Equal(t, InlNode{Parent: -1, Func: pkgPath + ".add", File: inl.File, Line: 12, ParentPC: inl.ParentPC}, inl)
+	assert.Contains(t, inl.File, "testdata/p/p.go")
+	var inlined bool
+	for _, v := range sum.Func.Pcinline.Values {
+		inlined = inlined || v.Value == 0
+	}
+	assert.True(t, inlined)
+	assert.Equal(t, int32(9), sum.Func.Pcline.Values[0].Value)
+	assert.Equal(t, []string{inl.File}, sum.Func.Files)
+	assert.Contains(t, sum.Func.Funcdata, pkgPath+".Sum.arginfo1")
+
+	// Triple is pushed by a linkname and has an ABI0 wrapper for the
+	// assembly
+	triple := function(t, o, "Triple")
+	assert.Contains(t, triple.Flags, "linkname")
+	assert.Empty(t, triple.Func.InlTree)
+	var wrapper *Symbol
+	for _, s := range o.Symbols {
+		if s.Name == triple.Name && s.ABI == "ABI0" {
+			wrapper = s
+		}
+	}
+	require.NotNil(t, wrapper)
+	assert.Contains(t, wrapper.Flags, "abiwrapper")
+	assert.Equal(t, "FuncIDWrapper", wrapper.Func.FuncID)
+	calls := callRelocs(wrapper)
+	require.Len(t, calls, 1)
+	assert.Equal(t, triple.Name, calls[0].Sym)
+	assert.Equal(t, uint8(4), calls[0].Size)
+
+	// runtime.nanotime is pulled by a linkname
+	now := function(t, o, "Now")
+	assert.Equal(t, "runtime.nanotime", callRelocs(now)[0].Sym)
+	var pulled *Ref
+	for _, r := range o.Refs {
+		if r.Name == "runtime.nanotime" {
+			pulled = r
+		}
+	}
+	require.NotNil(t, pulled)
+	assert.Equal(t, "ABIInternal", pulled.ABI)
+	assert.Empty(t, pulled.Pkg)
+
+	// the map of Names is made by the init of the package
+	init := function(t, o, "init")
+	assert.Contains(t, init.Flags, "pkginit")
+	assert.Equal(t, "runtime.makemap_small<ABIInternal>", callRelocs(init)[0].Sym)
+	names := o.Symbol(pkgPath + ".Names")
+	require.NotNil(t, names)
+	assert.Equal(t, "SBSS", names.Kind)
+	assert.Equal(t, uint32(8), names.Size)
+	assert.Equal(t, "type:map[string]int", names.Type)
+
+	_, err = json.Marshal(f)
+	assert.Nil(t, err)
+}
+
+// callRelocs returns the calls of s.
+func callRelocs(s *Symbol) []Reloc {
+	var calls []Reloc
+	for _, r := range s.Relocs {
+		if r.Type == "R_CALL" && r.Sym != "runtime.morestack_noctxt" {
+			calls = append(calls, r)
+		}
+	}
+	return calls
+}
+
+func TestOpenPackageNoInline(t *testing.T) {
+	f, err := OpenPackage("./testdata/p", "-gcflags=-l")
+	require.Nil(t, err)
+	sum := function(t, f.Objects[0], "Sum")
+	assert.Empty(t, sum.Func.InlTree)
+	assert.Equal(t, pkgPath+".add", callRelocs(sum)[0].Sym)
+
+	// Open of the same archive names the symbols of the package only
+	f, err = Open(f.Path)
+	require.Nil(t, err)
+	sum = function(t, f.Objects[0], "Sum")
+	assert.Equal(t, pkgPath+".add", callRelocs(sum)[0].Sym)
+}
+
+func TestOpenErrors(t *testing.T) {
+	_, err := Open("objinspect.go")
+	assert.NotNil(t, err)
+	_, err = OpenPackage("./testdata/none")
+	assert.NotNil(t, err)
+}
+
+func TestPCValues(t *testing.T) {
+	// 9 at 0, 11 at 5 and 12 at 11 to 13, in units of 1 and 4
+	tab := []byte{20, 5, 4, 6, 2, 2, 0}
+	assert.Equal(t, []PCValue{{0, 9}, {5, 11}, {11, 12}}, pcValues(tab, 1))
+	assert.Equal(t, []PCValue{{0, 9}, {20, 11}, {44, 12}}, pcValues(tab, 4))
+	// a negative delta, and truncated tables
+	assert.Equal(t, []PCValue{{0, -1}, {1, 0}, {3, -2}}, pcValues([]byte{0, 1, 2, 2, 3, 1}, 1))
+	assert.Equal(t, []PCValue{{0, 9}}, pcValues([]byte{20, 5, 4}, 1))
+	assert.Empty(t, pcValues([]byte{0x80}, 1))
+}
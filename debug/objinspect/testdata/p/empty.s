@@ -0,0 +1 @@
+// The assembly file allows the function without a body of p.go.
@@ -0,0 +1,29 @@
+// Package p is inspected by the tests of objinspect.
+package p
+
+import _ "unsafe" // for go:linkname
+
+func add(a, b int) int { return a + b }
+
+// Sum inlines add.
+func Sum(xs []int) int {
+	s := 0
+	for _, x := range xs {
+		s = add(s, x)
+	}
+	return s
+}
+
+// Triple can be pulled by a linkname of the other packages.
+//
+//go:linkname Triple
+//go:noinline
+func Triple(x int) int { return x * 3 }
+
+//go:linkname nanotime runtime.nanotime
+func nanotime() int64
+
+// Now calls runtime.nanotime through a linkname.
+func Now() int64 { return nanotime() }
+
+var Names = map[string]int{"a": 1}
@@ -0,0 +1,192 @@
+package symbolize
+
+import (
+	"debug/dwarf"
+	"path"
+)
+
+// dwarfTable resolves pcs with the DWARF, for the code the pclntab
+// doesn't cover.
+type dwarfTable struct {
+	dw    *dwarf.Data
+	units []dwarfUnit
+}
+
+// dwarfUnit is a compile unit and the pc ranges it covers
+type dwarfUnit struct {
+	entry  *dwarf.Entry
+	ranges [][2]uint64
+}
+
+// scope is a function or an inlined call in a compile unit
+type scope struct {
+	name     string
+	ranges   [][2]uint64
+	inlined  bool
+	callFile int64 // of the inlined call, index in the files of the line table
+	callLine int64
+	parent   int // index of the enclosing scope, -1 for functions
+	depth    int
+}
+
+func newDWARFTable(dw *dwarf.Data) (*dwarfTable, error) {
+	t := &dwarfTable{dw: dw}
+	r := dw.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag == dwarf.TagCompileUnit {
+			ranges, err := dw.Ranges(e)
+			if err == nil && len(ranges) > 0 {
+				t.units = append(t.units, dwarfUnit{entry: e, ranges: ranges})
+			}
+		}
+		r.SkipChildren()
+	}
+	return t, nil
+}
+
+// resolve returns the frames of the pcs, nil for the pcs not
+// covered. The compile units of the pcs are read once.
+func (t *dwarfTable) resolve(pcs []uint64) [][]Frame {
+	frames := make([][]Frame, len(pcs))
+	for _, u := range t.units {
+		var idx []int
+		for i, pc := range pcs {
+			if frames[i] == nil && inRanges(u.ranges, pc) {
+				idx = append(idx, i)
+			}
+		}
+		if len(idx) == 0 {
+			continue
+		}
+		scopes := t.scopes(u.entry)
+		lr, err := t.dw.LineReader(u.entry)
+		if err != nil || lr == nil {
+			continue
+		}
+		// the names are relative to the compilation directory of the
+		// unit in the line tables of DWARF 5
+		compDir, _ := u.entry.Val(dwarf.AttrCompDir).(string)
+		abs := func(name string) string {
+			if compDir != "" && !path.IsAbs(name) {
+				return path.Join(compDir, name)
+			}
+			return name
+		}
+		files := lr.Files()
+		fileName := func(i int64) string {
+			if i < 0 || i >= int64(len(files)) || files[i] == nil {
+				return "?"
+			}
+			return abs(files[i].Name)
+		}
+		for _, i := range idx {
+			inner := -1
+			for j, s := range scopes {
+				if inRanges(s.ranges, pcs[i]) && (inner < 0 || s.depth > scopes[inner].depth) {
+					inner = j
+				}
+			}
+			if inner < 0 {
+				continue
+			}
+			file, line := "?", 0
+			var entry dwarf.LineEntry
+			if err := lr.SeekPC(pcs[i], &entry); err == nil {
+				file, line = abs(entry.File.Name), entry.Line
+			}
+			var fs []Frame
+			for j := inner; j >= 0; j = scopes[j].parent {
+				s := scopes[j]
+				fs = append(fs, Frame{Func: s.name, File: file, Line: line, Inlined: s.inlined})
+				file, line = fileName(s.callFile), int(s.callLine)
+			}
+			frames[i] = fs
+		}
+	}
+	return frames
+}
+
+// scopes returns the functions and the inlined calls of the compile unit
+// cu, the nested scopes after their parents.
+func (t *dwarfTable) scopes(cu *dwarf.Entry) []scope {
+	var scopes []scope
+	r := t.dw.Reader()
+	r.Seek(cu.Offset)
+	if _, err := r.Next(); err != nil {
+		return nil
+	}
+	// enclosing is the innermost scope at each depth of the tree, -1 out of
+	// functions
+	enclosing := []int{-1}
+	for depth := 1; depth > 0; {
+		e, err := r.Next()
+		if err != nil {
+			break
+		}
+		if e == nil || e.Tag == 0 {
+			depth--
+			enclosing = enclosing[:len(enclosing)-1]
+			continue
+		}
+		parent := enclosing[len(enclosing)-1]
+		if e.Tag == dwarf.TagSubprogram || e.Tag == dwarf.TagInlinedSubroutine {
+			ranges, _ := t.dw.Ranges(e)
+			if len(ranges) > 0 {
+				s := scope{name: t.name(e), ranges: ranges, parent: parent, depth: depth}
+				if e.Tag == dwarf.TagInlinedSubroutine {
+					s.inlined = true
+					s.callFile, _ = e.Val(dwarf.AttrCallFile).(int64)
+					s.callLine, _ = e.Val(dwarf.AttrCallLine).(int64)
+				} else {
+					s.parent = -1
+				}
+				scopes = append(scopes, s)
+				parent = len(scopes) - 1
+			}
+		}
+		if e.Children {
+			depth++
+			enclosing = append(enclosing, parent)
+		}
+	}
+	return scopes
+}
+
+// name returns the name of the function of e, from its abstract origin or
+// its declaration.
+func (t *dwarfTable) name(e *dwarf.Entry) string {
+	for i := 0; e != nil && i < 4; i++ {
+		if name, ok := e.Val(dwarf.AttrLinkageName).(string); ok {
+			return name
+		}
+		if name, ok := e.Val(dwarf.AttrName).(string); ok {
+			return name
+		}
+		off, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+		if !ok {
+			if off, ok = e.Val(dwarf.AttrSpecification).(dwarf.Offset); !ok {
+				break
+			}
+		}
+		r := t.dw.Reader()
+		r.Seek(off)
+		e, _ = r.Next()
+	}
+	return "?"
+}
+
+func inRanges(ranges [][2]uint64, pc uint64) bool {
+	for _, r := range ranges {
+		if r[0] <= pc && pc < r[1] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package symbolize
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// hexPC is a pc in a log, a hexadecimal number with the 0x prefix
+var hexPC = regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`)
+
+// ParsePCs returns the hexadecimal numbers of the text read from r, such
+// as a log, in the order they appear.
+func ParsePCs(r io.Reader) ([]uint64, error) {
+	var pcs []uint64
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		for _, m := range hexPC.FindAllString(sc.Text(), -1) {
+			pc, err := strconv.ParseUint(m[2:], 16, 64)
+			if err == nil && pc != 0 {
+				pcs = append(pcs, pc)
+			}
+		}
+	}
+	return pcs, sc.Err()
+}
+
+// fields of the messages of profile.proto read
+const (
+	profileMapping  = 3
+	profileLocation = 4
+	mappingID       = 1
+	mappingStart    = 2
+	mappingOffset   = 4
+	locationAddress = 3
+)
+
+// ProfilePCs returns the addresses of the locations of the pprof profile
+// read from r, gzipped or not. If the binary is position independent and
+// its load address wasn't set, it is set from the first mapping of the
+// profile, that of the main binary.
+func (s *Symbolizer) ProfilePCs(r io.Reader) ([]uint64, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = ioutil.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		pcs            []uint64
+		mapped         bool
+		start, fileOff uint64
+	)
+	err = walkMessage(data, func(num protowire.Number, v uint64, msg []byte) error {
+		switch num {
+		case profileMapping:
+			var id, st, off uint64
+			err := walkMessage(msg, func(num protowire.Number, v uint64, _ []byte) error {
+				switch num {
+				case mappingID:
+					id = v
+				case mappingStart:
+					st = v
+				case mappingOffset:
+					off = v
+				}
+				return nil
+			})
+			if err == nil && id == 1 {
+				mapped, start, fileOff = true, st, off
+			}
+			return err
+		case profileLocation:
+			return walkMessage(msg, func(num protowire.Number, v uint64, _ []byte) error {
+				if num == locationAddress && v != 0 {
+					pcs = append(pcs, v)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if mapped && s.PIE() && s.bias == 0 {
+		if err := s.setMapping(start, fileOff); err != nil {
+			return nil, err
+		}
+	}
+	return pcs, nil
+}
+
+// walkMessage calls fn with the fields of the protobuf message data, the
+// value of the varint fields or the bytes of the length delimited ones.
+func walkMessage(data []byte, fn func(num protowire.Number, v uint64, msg []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var (
+			v   uint64
+			msg []byte
+		)
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			msg, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			if err := fn(num, v, msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tracebackFunc is a function line of a traceback, with its arguments
+var tracebackFunc = regexp.MustCompile(`^(?:created by )?(\S+?)(?:\(.*\))?(?: in goroutine \d+)?$`)
+
+// tracebackPos is a position line of a traceback, the offset is that of
+// the pc in the function and is missing for inlined calls
+var tracebackPos = regexp.MustCompile(`^\t\S+:\d+(?: \+0x([0-9a-f]+))?`)
+
+// signalPC is the pc of a fault, in the signal line of a traceback
+var signalPC = regexp.MustCompile(`^\[signal .* pc=0x([0-9a-f]+)\]`)
+
+// PanicPCs returns the pcs of the frames of the tracebacks of the
+// goroutines read from r, as printed by a panic or a fatal error. The pcs
+// are computed from the function names and the offsets of the frames, the
+// inlined calls have none and are resolved from the pc of their caller.
+//
+// The pcs are those of the calls, like the positions printed, but that of
+// the frame interrupted by a signal, and the faulting pc of the signal
+// line.
+func (s *Symbolizer) PanicPCs(r io.Reader) ([]uint64, error) {
+	var (
+		pcs    []uint64
+		fn     string // of the last function line
+		callee string // of the previous frame of the goroutine
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "goroutine "):
+			fn, callee = "", ""
+		case signalPC.MatchString(line):
+			pc, _ := strconv.ParseUint(signalPC.FindStringSubmatch(line)[1], 16, 64)
+			pcs = append(pcs, pc)
+		case tracebackPos.MatchString(line):
+			m := tracebackPos.FindStringSubmatch(line)
+			if fn == "" || m[1] == "" {
+				// inlined call
+				continue
+			}
+			off, err := strconv.ParseUint(m[1], 16, 64)
+			if err != nil {
+				continue
+			}
+			entry, err := s.FuncEntry(runtimeName(fn))
+			if err != nil {
+				continue
+			}
+			pc := entry + off
+			if off > 0 && callee != "runtime.sigpanic" {
+				pc--
+			}
+			pcs = append(pcs, pc)
+			callee, fn = runtimeName(fn), ""
+		case tracebackFunc.MatchString(line):
+			fn = tracebackFunc.FindStringSubmatch(line)[1]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(pcs) == 0 {
+		return nil, errors.New("no traceback found")
+	}
+	return pcs, nil
+}
+
+// runtimeName returns the name of the function printed as name in
+// tracebacks.
+func runtimeName(name string) string {
+	if name == "panic" {
+		return "runtime.gopanic"
+	}
+	return name
+}
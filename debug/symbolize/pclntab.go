@@ -0,0 +1,310 @@
+package symbolize
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// pclntab magic numbers of the layouts read, older tables are read with
+// debug/gosym, without the inlining tree
+const (
+	go118PcLnTabMagic = 0xfffffff0
+	go120PcLnTabMagic = 0xfffffff1
+)
+
+// indexes of the pcdata and funcdata used, internal/abi
+const (
+	pcdataInlTreeIndex  = 2
+	funcdataInlTree     = 3
+	noFuncdata          = ^uint32(0)
+	parentPcOffset118   = 16 // in the inlinedCall of go1.18 and go1.19
+	nameOffOffset118    = 12
+	inlinedCallSize118  = 20
+	parentPcOffset120   = 8
+	nameOffOffset120    = 4
+	inlinedCallSize120  = 16
+	funcStructSize118   = 40
+	funcStructSize120   = 44 // with startLine
+	funcNpcdataOffset   = 28
+	funcCuOffsetOffset  = 32
+	funcPcfileOffset    = 20
+	funcPclnOffset      = 24
+	funcNameOffOffset   = 4
+	functabEntrySize    = 8
+	pcHeaderWordsOffset = 8
+)
+
+// pclntab reads the pc-line table of go1.18 and later.
+type pclntab struct {
+	data     []byte
+	order    binary.ByteOrder
+	magic    uint32
+	quantum  uint64
+	ptrSize  int
+	nfunc    int
+	text     uint64 // address of the text the entries are relative to
+	funcname []byte
+	cutab    []byte
+	filetab  []byte
+	pctab    []byte
+	ftab     []byte // the functab followed by the _func structs
+
+	// gofunc is the content of go:func.*, where the funcdata are, nil if
+	// it couldn't be found
+	gofunc []byte
+}
+
+// newPclntab returns the reader of the table data, nil if the table is
+// not in a supported layout. The start of the text is that of the header,
+// textStart if it is relocated at run time.
+func newPclntab(data []byte, order binary.ByteOrder, textStart uint64) (*pclntab, error) {
+	if len(data) < pcHeaderWordsOffset {
+		return nil, errors.New("pclntab too short")
+	}
+	t := &pclntab{data: data, order: order, magic: order.Uint32(data)}
+	if t.magic != go118PcLnTabMagic && t.magic != go120PcLnTabMagic {
+		return nil, nil
+	}
+	t.quantum = uint64(data[6])
+	t.ptrSize = int(data[7])
+	if t.ptrSize != 4 && t.ptrSize != 8 {
+		return nil, errors.New("bad pointer size in pclntab header")
+	}
+	word := func(i int) uint64 {
+		off := pcHeaderWordsOffset + i*t.ptrSize
+		if off+t.ptrSize > len(data) {
+			return 0
+		}
+		if t.ptrSize == 4 {
+			return uint64(order.Uint32(data[off:]))
+		}
+		return order.Uint64(data[off:])
+	}
+	slice := func(off uint64) []byte {
+		if off > uint64(len(data)) {
+			return nil
+		}
+		return data[off:]
+	}
+	t.nfunc = int(word(0))
+	if t.text = word(2); t.text == 0 {
+		t.text = textStart
+	}
+	t.funcname = slice(word(3))
+	t.cutab = slice(word(4))
+	t.filetab = slice(word(5))
+	t.pctab = slice(word(6))
+	t.ftab = slice(word(7))
+	if uint64(len(t.ftab)) < uint64(t.nfunc+1)*functabEntrySize {
+		return nil, errors.New("functab out of pclntab")
+	}
+	return t, nil
+}
+
+// funcInfo is a function of the table
+type funcInfo struct {
+	t     *pclntab
+	off   uint64 // of the _func in ftab
+	entry uint64
+	end   uint64
+}
+
+func (t *pclntab) u32(b []byte, off uint64) uint32 {
+	if off+4 > uint64(len(b)) {
+		return 0
+	}
+	return t.order.Uint32(b[off:])
+}
+
+// entry returns the entry of the i-th function of the functab, the end of
+// the text for i == nfunc.
+func (t *pclntab) entry(i int) uint64 {
+	return t.text + uint64(t.u32(t.ftab, uint64(i)*functabEntrySize))
+}
+
+// findFunc returns the function containing pc.
+func (t *pclntab) findFunc(pc uint64) (funcInfo, bool) {
+	if t.nfunc == 0 || pc < t.entry(0) || pc >= t.entry(t.nfunc) {
+		return funcInfo{}, false
+	}
+	i := sort.Search(t.nfunc, func(i int) bool { return t.entry(i+1) > pc })
+	return t.funcAt(i), true
+}
+
+func (t *pclntab) funcAt(i int) funcInfo {
+	return funcInfo{
+		t:     t,
+		off:   uint64(t.u32(t.ftab, uint64(i)*functabEntrySize+4)),
+		entry: t.entry(i),
+		end:   t.entry(i + 1),
+	}
+}
+
+// lookupFunc returns the function called name, the type arguments of
+// generic functions can be elided as in tracebacks.
+func (t *pclntab) lookupFunc(name string) (funcInfo, bool) {
+	elided := strings.Contains(name, "[...]")
+	for i := 0; i < t.nfunc; i++ {
+		f := t.funcAt(i)
+		if fn := f.name(); fn == name || (elided && elideTypeArgs(fn) == name) {
+			return f, true
+		}
+	}
+	return funcInfo{}, false
+}
+
+// elideTypeArgs returns name with the type arguments replaced by "...".
+func elideTypeArgs(name string) string {
+	var sb strings.Builder
+	depth := 0
+	for _, c := range name {
+		switch {
+		case c == '[':
+			if depth == 0 {
+				sb.WriteString("[...]")
+			}
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0:
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+func (f funcInfo) field(off uint64) uint32 {
+	return f.t.u32(f.t.ftab, f.off+off)
+}
+
+func (f funcInfo) name() string {
+	return cstring(f.t.funcname, uint64(f.field(funcNameOffOffset)))
+}
+
+func (f funcInfo) size() uint64 {
+	if f.t.magic == go118PcLnTabMagic {
+		return funcStructSize118
+	}
+	return funcStructSize120
+}
+
+// pcdata returns the offset of the i-th pcdata table, 0 if the function
+// has none.
+func (f funcInfo) pcdata(i uint32) uint32 {
+	if i >= f.field(funcNpcdataOffset) {
+		return 0
+	}
+	return f.field(f.size() + uint64(i)*4)
+}
+
+// funcdata returns the offset in go:func.* of the i-th funcdata.
+func (f funcInfo) funcdata(i uint32) uint32 {
+	nfuncdata := uint32(0)
+	if last := f.off + f.size() - 1; last < uint64(len(f.t.ftab)) {
+		nfuncdata = uint32(f.t.ftab[last])
+	}
+	if i >= nfuncdata {
+		return noFuncdata
+	}
+	return f.field(f.size() + uint64(f.field(funcNpcdataOffset)+i)*4)
+}
+
+// pcvalue returns the value of the pc-value table at off for pc, -1 if pc
+// is not covered.
+func (f funcInfo) pcvalue(off uint32, pc uint64) int32 {
+	if off == 0 || uint64(off) >= uint64(len(f.t.pctab)) {
+		return -1
+	}
+	p := f.t.pctab[off:]
+	val, cur := int32(-1), f.entry
+	for first := true; ; first = false {
+		uvdelta, n := binary.Uvarint(p)
+		if n <= 0 || (uvdelta == 0 && !first) {
+			return -1
+		}
+		p = p[n:]
+		if uvdelta&1 != 0 {
+			uvdelta = ^(uvdelta >> 1)
+		} else {
+			uvdelta >>= 1
+		}
+		pcdelta, n := binary.Uvarint(p)
+		if n <= 0 {
+			return -1
+		}
+		p = p[n:]
+		val += int32(uvdelta)
+		cur += pcdelta * f.t.quantum
+		if pc < cur {
+			return val
+		}
+	}
+}
+
+// fileLine returns the position of pc in the function, that of the
+// innermost inlined call.
+func (f funcInfo) fileLine(pc uint64) (string, int) {
+	line := f.pcvalue(f.field(funcPclnOffset), pc)
+	fileno := f.pcvalue(f.field(funcPcfileOffset), pc)
+	if fileno < 0 {
+		return "?", int(line)
+	}
+	cu := uint64(f.field(funcCuOffsetOffset))
+	fileoff := f.t.u32(f.t.cutab, (cu+uint64(fileno))*4)
+	if fileoff == ^uint32(0) {
+		return "?", int(line)
+	}
+	return cstring(f.t.filetab, uint64(fileoff)), int(line)
+}
+
+// frames returns the frames at pc, the inlined calls first and the
+// function last.
+func (f funcInfo) frames(pc uint64) []Frame {
+	var frames []Frame
+	inltree := f.inlineTree()
+	inlIndex := f.pcdata(pcdataInlTreeIndex)
+	for depth := 0; inltree != nil && inlIndex != 0 && depth < 1024; depth++ {
+		ix := f.pcvalue(inlIndex, pc)
+		callSize, nameOff, parentPc := uint64(inlinedCallSize120), uint64(nameOffOffset120), uint64(parentPcOffset120)
+		if f.t.magic == go118PcLnTabMagic {
+			callSize, nameOff, parentPc = inlinedCallSize118, nameOffOffset118, parentPcOffset118
+		}
+		if ix < 0 || uint64(ix+1)*callSize > uint64(len(inltree)) {
+			break
+		}
+		call := uint64(ix) * callSize
+		file, line := f.fileLine(pc)
+		frames = append(frames, Frame{
+			Func:    cstring(f.t.funcname, uint64(f.t.u32(inltree, call+nameOff))),
+			File:    file,
+			Line:    line,
+			Inlined: true,
+		})
+		pc = f.entry + uint64(f.t.u32(inltree, call+parentPc))
+	}
+	file, line := f.fileLine(pc)
+	return append(frames, Frame{Func: f.name(), File: file, Line: line})
+}
+
+// inlineTree returns the inlining tree of the function, nil if it has none
+// or go:func.* wasn't found.
+func (f funcInfo) inlineTree() []byte {
+	off := f.funcdata(funcdataInlTree)
+	if f.t.gofunc == nil || off == noFuncdata || uint64(off) >= uint64(len(f.t.gofunc)) {
+		return nil
+	}
+	return f.t.gofunc[off:]
+}
+
+func cstring(data []byte, off uint64) string {
+	if off >= uint64(len(data)) {
+		return ""
+	}
+	end := off
+	for end < uint64(len(data)) && data[end] != 0 {
+		end++
+	}
+	return string(data[off:end])
+}
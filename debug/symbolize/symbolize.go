@@ -0,0 +1,327 @@
+// Package symbolize resolves the program counters of a Go binary to
+// functions, files, lines and inlined calls, offline.
+//
+// The pcs are resolved with the pclntab the runtime uses for its
+// tracebacks, it is kept in stripped binaries. The inlined calls are read
+// from the inlining trees of go1.18 and later binaries. The pcs out of the
+// Go functions, such as those of cgo code, are resolved with the DWARF when
+// the binary has it.
+//
+// The pcs can be read from a log, a pprof profile or the traceback of a
+// panic, see ParsePCs, ProfilePCs and PanicPCs. For a position independent
+// executable the address it was loaded at must be set, from the memory
+// mappings of the process or from the profile.
+package symbolize
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hitzhangjie/codemaster/debug/internal/objfile"
+)
+
+// Frame is a function of the call stack at a pc.
+type Frame struct {
+	Func string `json:"func"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+	// Inlined reports whether the call was inlined in the function of the
+	// next frame.
+	Inlined bool `json:"inlined,omitempty"`
+}
+
+// Location is a resolved pc.
+type Location struct {
+	PC uint64 `json:"pc"`
+	// Frames are the innermost inlined call first and the function the
+	// pc is in last, empty if the pc couldn't be resolved.
+	Frames []Frame `json:"frames,omitempty"`
+}
+
+// Symbolizer resolves the pcs of a binary.
+type Symbolizer struct {
+	f     *objfile.File
+	elf   *elf.File // nil for the other formats
+	syms  []objfile.Sym
+	pcln  *pclntab     // go1.18 and later
+	table *gosym.Table // older binaries
+
+	dwarf      *dwarfTable
+	dwarfErr   error
+	dwarfReady bool
+
+	linkAddr uint64 // address of the executable segment at link time
+	bias     uint64 // from link time addresses to run time addresses
+}
+
+// Open opens the binary at path.
+func Open(path string) (*Symbolizer, error) {
+	f, err := objfile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Symbolizer{f: f}
+	if ef, err := elf.Open(path); err == nil {
+		s.elf = ef
+	}
+	// stripped binaries have no symbols
+	s.syms, _ = f.Symbols()
+	s.linkAddr, _ = f.LoadAddress()
+
+	textStart, _, pclntab, err := f.PCLN()
+	if err == nil && len(pclntab) > 0 {
+		if s.pcln, err = newPclntab(pclntab, s.byteOrder(), textStart); err != nil {
+			s.Close()
+			return nil, err
+		}
+		if s.pcln != nil {
+			s.pcln.gofunc = s.gofunc()
+		} else if liner, err := f.PCLineTable(); err == nil {
+			s.table, _ = liner.(*gosym.Table)
+		}
+	}
+	if s.pcln == nil && s.table == nil {
+		if _, err := s.loadDWARF(); err != nil {
+			s.Close()
+			return nil, errors.New("no pclntab nor DWARF to symbolize with")
+		}
+	}
+	return s, nil
+}
+
+// Close closes the binary.
+func (s *Symbolizer) Close() error {
+	if s.elf != nil {
+		s.elf.Close()
+	}
+	return s.f.Close()
+}
+
+// PIE reports whether the binary is a position independent executable,
+// its load address must be set to resolve run time pcs.
+func (s *Symbolizer) PIE() bool {
+	return s.elf != nil && s.elf.Type == elf.ET_DYN
+}
+
+// SetLoadAddress sets the address the executable segment of the binary
+// was loaded at, the start of its r-xp mapping in /proc/<pid>/maps. The
+// pcs given to Symbolize are then run time pcs.
+func (s *Symbolizer) SetLoadAddress(addr uint64) {
+	s.bias = addr - s.linkAddr
+}
+
+// setMapping sets the load address from a mapping of the file at offset
+// to start, as in pprof profiles and /proc/<pid>/maps.
+func (s *Symbolizer) setMapping(start, offset uint64) error {
+	if s.elf == nil {
+		return errors.New("mappings are only supported for ELF binaries")
+	}
+	for _, p := range s.elf.Progs {
+		if p.Type == elf.PT_LOAD && p.Flags&elf.PF_X != 0 {
+			s.SetLoadAddress(start + p.Off - offset)
+			return nil
+		}
+	}
+	return errors.New("no executable segment")
+}
+
+// Symbolize resolves pcs, in one pass over the tables, the locations are
+// in the order of pcs.
+func (s *Symbolizer) Symbolize(pcs []uint64) []Location {
+	sorted := append([]uint64(nil), pcs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	frames := map[uint64][]Frame{}
+	var unresolved []uint64
+	var fn funcInfo
+	for i, pc := range sorted {
+		if i > 0 && pc == sorted[i-1] {
+			continue
+		}
+		linkPC := pc - s.bias
+		switch {
+		case s.pcln != nil:
+			// the pcs are sorted, the function of the previous pc is
+			// checked before looking up the table
+			if fn.t == nil || linkPC < fn.entry || linkPC >= fn.end {
+				var ok bool
+				if fn, ok = s.pcln.findFunc(linkPC); !ok {
+					fn = funcInfo{}
+				}
+			}
+			if fn.t != nil {
+				frames[pc] = fn.frames(linkPC)
+			}
+		case s.table != nil:
+			if file, line, f := s.table.PCToLine(linkPC); f != nil {
+				frames[pc] = []Frame{{Func: f.Name, File: file, Line: line}}
+			}
+		}
+		if frames[pc] == nil {
+			unresolved = append(unresolved, pc)
+		}
+	}
+
+	if len(unresolved) > 0 {
+		if dt, err := s.loadDWARF(); err == nil {
+			linkPCs := make([]uint64, len(unresolved))
+			for i, pc := range unresolved {
+				linkPCs[i] = pc - s.bias
+			}
+			for i, fs := range dt.resolve(linkPCs) {
+				if fs != nil {
+					frames[unresolved[i]] = fs
+				}
+			}
+		}
+	}
+
+	locs := make([]Location, len(pcs))
+	for i, pc := range pcs {
+		locs[i] = Location{PC: pc, Frames: frames[pc]}
+	}
+	return locs
+}
+
+// SymbolizeDWARF resolves pcs with the DWARF only, the inlined calls are
+// those of its inlined subroutines. It fails if the binary has no DWARF.
+func (s *Symbolizer) SymbolizeDWARF(pcs []uint64) ([]Location, error) {
+	dt, err := s.loadDWARF()
+	if err != nil {
+		return nil, err
+	}
+	linkPCs := make([]uint64, len(pcs))
+	for i, pc := range pcs {
+		linkPCs[i] = pc - s.bias
+	}
+	frames := dt.resolve(linkPCs)
+	locs := make([]Location, len(pcs))
+	for i, pc := range pcs {
+		locs[i] = Location{PC: pc, Frames: frames[i]}
+	}
+	return locs, nil
+}
+
+// FuncEntry returns the run time address of the entry of the function
+// called name.
+func (s *Symbolizer) FuncEntry(name string) (uint64, error) {
+	switch {
+	case s.pcln != nil:
+		if fn, ok := s.pcln.lookupFunc(name); ok {
+			return fn.entry + s.bias, nil
+		}
+	case s.table != nil:
+		if fn := s.table.LookupFunc(name); fn != nil {
+			return fn.Entry + s.bias, nil
+		}
+	}
+	for _, sym := range s.syms {
+		if sym.Name == name {
+			return sym.Addr + s.bias, nil
+		}
+	}
+	return 0, fmt.Errorf("function %s not found", name)
+}
+
+func (s *Symbolizer) loadDWARF() (*dwarfTable, error) {
+	if !s.dwarfReady {
+		s.dwarfReady = true
+		dw, err := s.f.DWARF()
+		if err == nil {
+			s.dwarf, err = newDWARFTable(dw)
+		}
+		s.dwarfErr = err
+	}
+	return s.dwarf, s.dwarfErr
+}
+
+// gofunc returns the content of go:func.*, the funcdata the inlining
+// trees are in. The symbol is looked up in the symbol table, and in
+// runtime.firstmoduledata for stripped binaries, which is only supported
+// for ELF binaries.
+func (s *Symbolizer) gofunc() []byte {
+	if s.elf == nil {
+		return nil
+	}
+	var addr uint64
+	for _, sym := range s.syms {
+		if sym.Name == "go:func.*" || sym.Name == "go.func.*" {
+			addr = sym.Addr
+		}
+	}
+	if addr == 0 {
+		addr = s.moduledataGofunc()
+	}
+	for _, sect := range s.elf.Sections {
+		if sect.Type != elf.SHT_NOBITS && addr >= sect.Addr && addr < sect.Addr+sect.Size {
+			data, err := sect.Data()
+			if err != nil {
+				return nil
+			}
+			return data[addr-sect.Addr:]
+		}
+	}
+	return nil
+}
+
+// moduledataGofunc returns the gofunc field of runtime.firstmoduledata,
+// found as the data starting with the address of the pclntab. The field
+// follows the rodata field, whose value is the address of .rodata.
+//
+// The moduledata of position independent executables is relocated at run
+// time and the field is not found.
+func (s *Symbolizer) moduledataGofunc() uint64 {
+	pclntab, rodata := s.elf.Section(".gopclntab"), s.elf.Section(".rodata")
+	if pclntab == nil || rodata == nil {
+		return 0
+	}
+	ptrSize := 8
+	if s.elf.Class == elf.ELFCLASS32 {
+		ptrSize = 4
+	}
+	const maxFields = 64 // before gofunc
+	for _, name := range []string{".go.module", ".noptrdata", ".data"} {
+		sect := s.elf.Section(name)
+		if sect == nil {
+			continue
+		}
+		data, err := sect.Data()
+		if err != nil {
+			continue
+		}
+		word := func(off int) uint64 {
+			if ptrSize == 4 {
+				return uint64(s.elf.ByteOrder.Uint32(data[off:]))
+			}
+			return s.elf.ByteOrder.Uint64(data[off:])
+		}
+		for off := 0; off+ptrSize <= len(data); off += ptrSize {
+			if word(off) != pclntab.Addr {
+				continue
+			}
+			// types and rodata can have the same value, rodata is the
+			// last one
+			gofunc := uint64(0)
+			for i := off; i < off+maxFields*ptrSize && i+2*ptrSize <= len(data); i += ptrSize {
+				if word(i) == rodata.Addr {
+					gofunc = word(i + ptrSize)
+				}
+			}
+			if gofunc != 0 {
+				return gofunc
+			}
+		}
+	}
+	return 0
+}
+
+func (s *Symbolizer) byteOrder() binary.ByteOrder {
+	if s.elf != nil {
+		return s.elf.ByteOrder
+	}
+	return binary.LittleEndian
+}
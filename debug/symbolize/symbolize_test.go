@@ -0,0 +1,249 @@
+package symbolize
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// buildInline builds testdata/inline with the flags of go build.
+func buildInline(t *testing.T, flags ...string) string {
+	bin := filepath.Join(t.TempDir(), "inline")
+	args := append([]string{"build", "-o", bin}, flags...)
+	cmd := exec.Command("go", append(args, "./testdata/inline")...)
+	out, err := cmd.CombinedOutput()
+	require.Nil(t, err, string(out))
+	return bin
+}
+
+// output is the output of testdata/inline
+type output struct {
+	Load   uint64 `json:"load"`
+	Offset uint64 `json:"offset"`
+	Middle uint64 `json:"middle"`
+}
+
+func run(t *testing.T, bin string) output {
+	data, err := exec.Command(bin).Output()
+	require.Nil(t, err)
+	var out output
+	require.Nil(t, json.Unmarshal(data, &out))
+	return out
+}
+
+// funcPCs returns the pcs of the function name, at link time.
+func funcPCs(t *testing.T, s *Symbolizer, name string) []uint64 {
+	fn, ok := s.pcln.lookupFunc(name)
+	require.True(t, ok, name)
+	var pcs []uint64
+	for pc := fn.entry; pc < fn.end; pc++ {
+		pcs = append(pcs, pc)
+	}
+	return pcs
+}
+
+func TestSymbolize(t *testing.T) {
+	s, err := Open(buildInline(t))
+	require.Nil(t, err)
+	defer s.Close()
+	require.NotNil(t, s.pcln)
+	require.NotNil(t, s.pcln.gofunc)
+
+	pcs := funcPCs(t, s, "main.middle")
+	locs := s.Symbolize(pcs)
+	require.Len(t, locs, len(pcs))
+
+	// the frames from the pclntab are those from the DWARF
+	dt, err := s.loadDWARF()
+	require.Nil(t, err)
+	fromDWARF := dt.resolve(pcs)
+	var inlined bool
+	for i, loc := range locs {
+		require.NotEmpty(t, loc.Frames, "%#x", loc.PC)
+		assert.Equal(t, "main.middle", loc.Frames[len(loc.Frames)-1].Func)
+		if fromDWARF[i] != nil && fromDWARF[i][0].Line != 0 {
+			assert.Equal(t, fromDWARF[i], loc.Frames, "%#x", loc.PC)
+		}
+		if len(loc.Frames) == 3 {
+			inlined = true
+			assert.Equal(t, []string{"main.inc", "main.bump", "main.middle"}, funcNames(loc.Frames))
+			assert.True(t, loc.Frames[0].Inlined)
+			assert.True(t, loc.Frames[1].Inlined)
+			assert.False(t, loc.Frames[2].Inlined)
+			assert.Equal(t, 36, loc.Frames[0].Line)
+			assert.Equal(t, 41, loc.Frames[1].Line)
+			assert.Equal(t, 46, loc.Frames[2].Line)
+		}
+	}
+	assert.True(t, inlined)
+
+	// the same frames are found in a stripped binary
+	stripped, err := Open(buildInline(t, "-ldflags=-s -w"))
+	require.Nil(t, err)
+	defer stripped.Close()
+	_, err = stripped.loadDWARF()
+	assert.NotNil(t, err)
+	assert.Equal(t, locs, stripped.Symbolize(funcPCs(t, stripped, "main.middle")))
+
+	unknown := s.Symbolize([]uint64{1})
+	assert.Equal(t, []Location{{PC: 1}}, unknown)
+}
+
+func funcNames(frames []Frame) []string {
+	var names []string
+	for _, f := range frames {
+		names = append(names, f.Func)
+	}
+	return names
+}
+
+func TestPIE(t *testing.T) {
+	bin := buildInline(t, "-buildmode=pie")
+	out := run(t, bin)
+	require.NotZero(t, out.Load)
+
+	s, err := Open(bin)
+	require.Nil(t, err)
+	defer s.Close()
+	assert.True(t, s.PIE())
+	require.Nil(t, s.setMapping(out.Load, out.Offset))
+	locs := s.Symbolize([]uint64{out.Middle})
+	require.NotEmpty(t, locs[0].Frames)
+	assert.Equal(t, "main.middle", locs[0].Frames[0].Func)
+	assert.Equal(t, 45, locs[0].Frames[0].Line)
+
+	// the load address is read from the mapping of a profile
+	var mapping, location, profile []byte
+	mapping = protowire.AppendTag(mapping, mappingID, protowire.VarintType)
+	mapping = protowire.AppendVarint(mapping, 1)
+	mapping = protowire.AppendTag(mapping, mappingStart, protowire.VarintType)
+	mapping = protowire.AppendVarint(mapping, out.Load)
+	mapping = protowire.AppendTag(mapping, mappingOffset, protowire.VarintType)
+	mapping = protowire.AppendVarint(mapping, out.Offset)
+	location = protowire.AppendTag(location, locationAddress, protowire.VarintType)
+	location = protowire.AppendVarint(location, out.Middle)
+	profile = protowire.AppendTag(profile, profileMapping, protowire.BytesType)
+	profile = protowire.AppendBytes(profile, mapping)
+	profile = protowire.AppendTag(profile, profileLocation, protowire.BytesType)
+	profile = protowire.AppendBytes(profile, location)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(profile)
+	require.Nil(t, zw.Close())
+
+	s, err = Open(bin)
+	require.Nil(t, err)
+	defer s.Close()
+	pcs, err := s.ProfilePCs(&gz)
+	require.Nil(t, err)
+	assert.Equal(t, []uint64{out.Middle}, pcs)
+	assert.Equal(t, locs, s.Symbolize(pcs))
+}
+
+func TestPanic(t *testing.T) {
+	bin := buildInline(t, "-ldflags=-s -w")
+	var stderr bytes.Buffer
+	cmd := exec.Command(bin, "panic")
+	cmd.Stderr = &stderr
+	require.NotNil(t, cmd.Run())
+
+	// the frames printed, as function and line
+	var want []string
+	lines := strings.Split(stderr.String(), "\n")
+	for i := 1; i < len(lines); i++ {
+		if m := tracebackPos.FindString(lines[i]); m != "" {
+			fn := tracebackFunc.FindStringSubmatch(lines[i-1])[1]
+			pos := strings.Fields(m)[0]
+			want = append(want, fmt.Sprintf("%s:%s", fn, pos[strings.LastIndex(pos, ":")+1:]))
+		}
+	}
+	require.Len(t, want, 4, stderr.String())
+
+	s, err := Open(bin)
+	require.Nil(t, err)
+	defer s.Close()
+	pcs, err := s.PanicPCs(&stderr)
+	require.Nil(t, err)
+	var got []string
+	for _, loc := range s.Symbolize(pcs) {
+		for _, f := range loc.Frames {
+			got = append(got, fmt.Sprintf("%s:%d", elideTypeArgs(f.Func), f.Line))
+		}
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestParsePCs(t *testing.T) {
+	pcs, err := ParsePCs(strings.NewReader("pc=0x4a1f20 sp=0xc000010000\nno pc 0x0 here\n  0X12 0x1f"))
+	require.Nil(t, err)
+	assert.Equal(t, []uint64{0x4a1f20, 0xc000010000, 0x1f}, pcs)
+}
+
+func TestDWARFFallback(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	bin := filepath.Join(t.TempDir(), "cinline")
+	out, err := exec.Command("gcc", "-g", "-O2", "-o", bin, "testdata/cinline.c").CombinedOutput()
+	require.Nil(t, err, string(out))
+
+	s, err := Open(bin)
+	require.Nil(t, err)
+	defer s.Close()
+	assert.Nil(t, s.pcln)
+	var pcs []uint64
+	for _, sym := range s.syms {
+		if sym.Name == "main" {
+			for pc := sym.Addr; pc < sym.Addr+uint64(sym.Size); pc++ {
+				pcs = append(pcs, pc)
+			}
+		}
+	}
+	require.NotEmpty(t, pcs)
+	locs := s.Symbolize(pcs)
+	var inlined bool
+	for _, loc := range locs {
+		require.NotEmpty(t, loc.Frames, "%#x", loc.PC)
+		assert.Equal(t, "main", loc.Frames[len(loc.Frames)-1].Func)
+		if len(loc.Frames) == 2 {
+			inlined = true
+			assert.Equal(t, Frame{Func: "square", File: loc.Frames[0].File, Line: 6, Inlined: true}, loc.Frames[0])
+			assert.Equal(t, 11, loc.Frames[1].Line)
+		}
+	}
+	assert.True(t, inlined)
+
+	if _, err := exec.LookPath("addr2line"); err != nil {
+		return
+	}
+	args := []string{"-f", "-i", "-e", bin}
+	for _, pc := range pcs {
+		args = append(args, "0x"+strconv.FormatUint(pc, 16))
+	}
+	data, err := exec.Command("addr2line", args...).Output()
+	require.Nil(t, err)
+	var want []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if i := strings.Index(line, " ("); i >= 0 {
+			line = line[:i] // discriminator
+		}
+		want = append(want, line)
+	}
+	var got []string
+	for _, loc := range locs {
+		for _, f := range loc.Frames {
+			got = append(got, f.Func, fmt.Sprintf("%s:%d", f.File, f.Line))
+		}
+	}
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,14 @@
+/* cinline has a static function inlined in main, for the DWARF fallback. */
+#include <stdio.h>
+
+static inline int square(int x)
+{
+	return x * x;
+}
+
+int main(int argc, char **argv)
+{
+	int n = square(argc + 1);
+	printf("%d\n", n);
+	return 0;
+}
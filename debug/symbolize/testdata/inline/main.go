@@ -0,0 +1,99 @@
+// Program inline has functions with inlined calls, it prints where it is
+// loaded. With the panic argument it panics in an inlined call of a
+// function called by a generic function instead.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+type output struct {
+	// Load and Offset are the start and the file offset of the executable
+	// mapping of the binary.
+	Load   uint64 `json:"load"`
+	Offset uint64 `json:"offset"`
+	// Middle is the entry of main.middle.
+	Middle uint64 `json:"middle"`
+}
+
+var (
+	sink    []uintptr
+	counter int
+)
+
+// callers can't be inlined as it calls runtime.Callers
+func callers() []uintptr {
+	pcs := make([]uintptr, 16)
+	return pcs[:runtime.Callers(1, pcs)]
+}
+
+func inc() {
+	counter++
+}
+
+func bump() {
+	inc()
+	inc()
+}
+
+//go:noinline
+func middle() {
+	bump()
+	sink = callers()
+}
+
+//go:noinline
+func apply[T any](x T, f func(T)) {
+	f(x)
+}
+
+func validate(n int) {
+	if n > 0 {
+		panic("boom")
+	}
+}
+
+//go:noinline
+func check(n int) {
+	validate(n)
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "panic" {
+		apply(1, check)
+		return
+	}
+	middle()
+
+	var out output
+	out.Middle = uint64(runtime.FuncForPC(sink[1]).Entry())
+	out.Load, out.Offset = executableMapping()
+	json.NewEncoder(os.Stdout).Encode(out)
+}
+
+// executableMapping returns the start and the offset of the executable
+// mapping of the binary in /proc/self/maps.
+func executableMapping() (uint64, uint64) {
+	exe, _ := os.Executable()
+	f, err := os.Open("/proc/self/maps")
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 6 || fields[1] != "r-xp" || fields[5] != exe {
+			continue
+		}
+		start, _ := strconv.ParseUint(strings.Split(fields[0], "-")[0], 16, 64)
+		offset, _ := strconv.ParseUint(fields[2], 16, 64)
+		return start, offset
+	}
+	return 0, 0
+}
@@ -0,0 +1,447 @@
+package traceconv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/hitzhangjie/codemaster/debug/internal/traceviewer"
+)
+
+// The fields of the Perfetto trace protos used, from
+// protos/perfetto/trace in the Perfetto repository.
+const (
+	tracePacket = 1 // Trace.packet
+
+	packetTimestamp       = 8  // TracePacket.timestamp
+	packetSequenceID      = 10 // TracePacket.trusted_packet_sequence_id
+	packetTrackEvent      = 11 // TracePacket.track_event
+	packetSequenceFlags   = 13 // TracePacket.sequence_flags
+	packetTrackDescriptor = 60 // TracePacket.track_descriptor
+
+	trackUUID    = 1 // TrackDescriptor.uuid
+	trackName    = 2 // TrackDescriptor.name
+	trackProcess = 3 // TrackDescriptor.process
+	trackParent  = 5 // TrackDescriptor.parent_uuid
+	trackCounter = 8 // TrackDescriptor.counter
+
+	processPID  = 1 // ProcessDescriptor.pid
+	processName = 6 // ProcessDescriptor.process_name
+
+	eventAnnotations   = 4  // TrackEvent.debug_annotations
+	eventType          = 9  // TrackEvent.type
+	eventTrackUUID     = 11 // TrackEvent.track_uuid
+	eventCategories    = 22 // TrackEvent.categories
+	eventName          = 23 // TrackEvent.name
+	eventDoubleCounter = 44 // TrackEvent.double_counter_value
+
+	annotationUint   = 3  // DebugAnnotation.uint_value
+	annotationInt    = 4  // DebugAnnotation.int_value
+	annotationDouble = 5  // DebugAnnotation.double_value
+	annotationString = 6  // DebugAnnotation.string_value
+	annotationName   = 10 // DebugAnnotation.name
+
+	typeSliceBegin = 1 // TrackEvent.TYPE_SLICE_BEGIN
+	typeSliceEnd   = 2
+	typeInstant    = 3
+	typeCounter    = 4
+
+	seqIncrementalStateCleared = 1 // TracePacket.SEQ_INCREMENTAL_STATE_CLEARED
+)
+
+// WritePerfetto writes d as a Perfetto trace in the protobuf format, with
+// track events:
+//
+//   - a process track by process, with a child track by thread, for the
+//     complete events and the instant events;
+//   - a counter track by counter and series;
+//   - a track by async span.
+//
+// Perfetto needs the slices of a track to nest, the slices overlapping the
+// slices before them on a thread go to other tracks of the thread, such as
+// "P0 (2)". The stacks are debug annotations.
+func WritePerfetto(w io.Writer, d *traceviewer.Data) error {
+	p := &perfetto{
+		d:        d,
+		procs:    map[uint64]uint64{},
+		threads:  map[[2]uint64]*thread{},
+		counters: map[string]uint64{},
+		asyncs:   map[string]uint64{},
+	}
+	names := map[[2]uint64]string{} // of the threads, the processes with tid ^0
+	for _, e := range d.Events {
+		if e.Phase != "M" {
+			continue
+		}
+		args, _ := e.Arg.(map[string]interface{})
+		name, _ := args["name"].(string)
+		switch e.Name {
+		case "process_name":
+			names[[2]uint64{e.PID, math.MaxUint64}] = name
+		case "thread_name":
+			names[[2]uint64{e.PID, e.TID}] = name
+		}
+	}
+	p.names = names
+
+	// descriptors of the process tracks, in order of the pids
+	var pids []uint64
+	seen := map[uint64]bool{}
+	for _, e := range d.Events {
+		if !seen[e.PID] {
+			seen[e.PID] = true
+			pids = append(pids, e.PID)
+		}
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	for _, pid := range pids {
+		p.process(pid)
+	}
+
+	var events []timed
+	var slices []*traceviewer.Event
+	for _, e := range d.Events {
+		switch e.Phase {
+		case "X":
+			slices = append(slices, e)
+		case "i", "I":
+			events = append(events, timed{ns(e.Time), p.trackEvent(typeInstant, p.thread(e.PID, e.TID).lanes[0].uuid, e)})
+		case "C":
+			args, _ := e.Arg.(map[string]interface{})
+			keys := make([]string, 0, len(args))
+			for k := range args {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				v, ok := number(args[k])
+				if !ok {
+					continue
+				}
+				name := e.Name
+				if len(keys) > 1 {
+					name += " " + k
+				}
+				var b []byte
+				b = protowire.AppendTag(b, eventType, protowire.VarintType)
+				b = protowire.AppendVarint(b, typeCounter)
+				b = protowire.AppendTag(b, eventTrackUUID, protowire.VarintType)
+				b = protowire.AppendVarint(b, p.counter(e.PID, name))
+				b = protowire.AppendTag(b, eventDoubleCounter, protowire.Fixed64Type)
+				b = protowire.AppendFixed64(b, math.Float64bits(v))
+				events = append(events, timed{ns(e.Time), b})
+			}
+		case "b", "e":
+			uuid := p.async(e)
+			typ := uint64(typeSliceBegin)
+			ev := e
+			if e.Phase == "e" {
+				typ = typeSliceEnd
+				ev = &traceviewer.Event{}
+			}
+			events = append(events, timed{ns(e.Time), p.trackEvent(typ, uuid, ev)})
+		}
+	}
+	events = append(events, p.nest(slices)...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].ts < events[j].ts })
+
+	bw := bufio.NewWriter(w)
+	first := true
+	write := func(ts uint64, field protowire.Number, msg []byte) {
+		var b []byte
+		if field == packetTrackEvent {
+			b = protowire.AppendTag(b, packetTimestamp, protowire.VarintType)
+			b = protowire.AppendVarint(b, ts)
+		}
+		b = protowire.AppendTag(b, packetSequenceID, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+		if first {
+			b = protowire.AppendTag(b, packetSequenceFlags, protowire.VarintType)
+			b = protowire.AppendVarint(b, seqIncrementalStateCleared)
+			first = false
+		}
+		b = protowire.AppendTag(b, field, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+
+		var packet []byte
+		packet = protowire.AppendTag(packet, tracePacket, protowire.BytesType)
+		packet = protowire.AppendBytes(packet, b)
+		bw.Write(packet)
+	}
+	for _, desc := range p.descriptors {
+		write(0, packetTrackDescriptor, desc)
+	}
+	for _, e := range events {
+		write(e.ts, packetTrackEvent, e.msg)
+	}
+	return bw.Flush()
+}
+
+// perfetto is the state of a conversion to Perfetto.
+type perfetto struct {
+	d           *traceviewer.Data
+	names       map[[2]uint64]string
+	uuid        uint64
+	descriptors [][]byte
+
+	procs    map[uint64]uint64 // track uuids by pid
+	threads  map[[2]uint64]*thread
+	counters map[string]uint64 // by pid and name
+	asyncs   map[string]uint64 // by pid, category and id
+}
+
+// thread is the tracks of a thread, the lanes of the slices nesting.
+type thread struct {
+	pid, tid uint64
+	name     string
+	lanes    []*lane
+}
+
+type lane struct {
+	uuid   uint64
+	open   []uint64 // end of the slices open, the innermost last
+	events []timed
+}
+
+// timed is a track event at a time, in nanoseconds.
+type timed struct {
+	ts  uint64
+	msg []byte
+}
+
+// track adds the descriptor of a track and returns its uuid.
+func (p *perfetto) track(parent uint64, name string, process []byte, counter bool) uint64 {
+	p.uuid++
+	var b []byte
+	b = protowire.AppendTag(b, trackUUID, protowire.VarintType)
+	b = protowire.AppendVarint(b, p.uuid)
+	if parent != 0 {
+		b = protowire.AppendTag(b, trackParent, protowire.VarintType)
+		b = protowire.AppendVarint(b, parent)
+	}
+	if name != "" {
+		b = protowire.AppendTag(b, trackName, protowire.BytesType)
+		b = protowire.AppendString(b, name)
+	}
+	if process != nil {
+		b = protowire.AppendTag(b, trackProcess, protowire.BytesType)
+		b = protowire.AppendBytes(b, process)
+	}
+	if counter {
+		b = protowire.AppendTag(b, trackCounter, protowire.BytesType)
+		b = protowire.AppendBytes(b, nil)
+	}
+	p.descriptors = append(p.descriptors, b)
+	return p.uuid
+}
+
+// process returns the uuid of the track of the process pid.
+func (p *perfetto) process(pid uint64) uint64 {
+	if uuid, ok := p.procs[pid]; ok {
+		return uuid
+	}
+	var desc []byte
+	desc = protowire.AppendTag(desc, processPID, protowire.VarintType)
+	desc = protowire.AppendVarint(desc, pid)
+	if name := p.names[[2]uint64{pid, math.MaxUint64}]; name != "" {
+		desc = protowire.AppendTag(desc, processName, protowire.BytesType)
+		desc = protowire.AppendString(desc, name)
+	}
+	uuid := p.track(0, "", desc, false)
+	p.procs[pid] = uuid
+	return uuid
+}
+
+// thread returns the tracks of the thread tid of pid.
+func (p *perfetto) thread(pid, tid uint64) *thread {
+	k := [2]uint64{pid, tid}
+	if t, ok := p.threads[k]; ok {
+		return t
+	}
+	name, ok := p.names[k]
+	if !ok {
+		name = fmt.Sprintf("%d", tid)
+	}
+	t := &thread{pid: pid, tid: tid, name: name}
+	t.lanes = []*lane{{uuid: p.track(p.process(pid), name, nil, false)}}
+	p.threads[k] = t
+	return t
+}
+
+// counter returns the uuid of the counter track name of pid.
+func (p *perfetto) counter(pid uint64, name string) uint64 {
+	k := fmt.Sprintf("%d/%s", pid, name)
+	if uuid, ok := p.counters[k]; ok {
+		return uuid
+	}
+	uuid := p.track(p.process(pid), name, nil, true)
+	p.counters[k] = uuid
+	return uuid
+}
+
+// async returns the uuid of the track of the async span of e.
+func (p *perfetto) async(e *traceviewer.Event) uint64 {
+	k := fmt.Sprintf("%d/%s/%d", e.PID, e.Category, e.ID)
+	if uuid, ok := p.asyncs[k]; ok {
+		return uuid
+	}
+	uuid := p.track(p.process(e.PID), e.Name, nil, false)
+	p.asyncs[k] = uuid
+	return uuid
+}
+
+// nest returns the begin and end events of the slices, on the first lane of
+// their thread where they nest.
+func (p *perfetto) nest(slices []*traceviewer.Event) []timed {
+	sort.SliceStable(slices, func(i, j int) bool {
+		a, b := slices[i], slices[j]
+		if a.Time != b.Time {
+			return a.Time < b.Time
+		}
+		return a.Dur > b.Dur // the enclosing slice first
+	})
+	end := &traceviewer.Event{}
+	for _, e := range slices {
+		t := p.thread(e.PID, e.TID)
+		start, stop := ns(e.Time), ns(e.Time+e.Dur)
+		var l *lane
+		for _, cand := range t.lanes {
+			// end the slices before e
+			for n := len(cand.open); n > 0 && cand.open[n-1] <= start; n-- {
+				cand.events = append(cand.events, timed{cand.open[n-1], p.trackEvent(typeSliceEnd, cand.uuid, end)})
+				cand.open = cand.open[:n-1]
+			}
+			if n := len(cand.open); n == 0 || cand.open[n-1] >= stop {
+				l = cand
+				break
+			}
+		}
+		if l == nil {
+			name := fmt.Sprintf("%s (%d)", t.name, len(t.lanes)+1)
+			l = &lane{uuid: p.track(p.process(t.pid), name, nil, false)}
+			t.lanes = append(t.lanes, l)
+		}
+		l.events = append(l.events, timed{start, p.trackEvent(typeSliceBegin, l.uuid, e)})
+		l.open = append(l.open, stop)
+	}
+
+	var keys [][2]uint64
+	for k := range p.threads {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	var events []timed
+	for _, k := range keys {
+		for _, l := range p.threads[k].lanes {
+			for n := len(l.open); n > 0; n-- {
+				l.events = append(l.events, timed{l.open[n-1], p.trackEvent(typeSliceEnd, l.uuid, end)})
+			}
+			l.open = nil
+			events = append(events, l.events...)
+		}
+	}
+	return events
+}
+
+// trackEvent returns the track event of type typ on the track uuid, with
+// the name, category, arguments and stack of e.
+func (p *perfetto) trackEvent(typ, uuid uint64, e *traceviewer.Event) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, eventType, protowire.VarintType)
+	b = protowire.AppendVarint(b, typ)
+	b = protowire.AppendTag(b, eventTrackUUID, protowire.VarintType)
+	b = protowire.AppendVarint(b, uuid)
+	if e.Name != "" {
+		b = protowire.AppendTag(b, eventName, protowire.BytesType)
+		b = protowire.AppendString(b, e.Name)
+	}
+	if e.Category != "" {
+		b = protowire.AppendTag(b, eventCategories, protowire.BytesType)
+		b = protowire.AppendString(b, e.Category)
+	}
+	args, _ := e.Arg.(map[string]interface{})
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b = appendAnnotation(b, k, args[k])
+	}
+	if e.Stack != 0 {
+		b = appendAnnotation(b, "stack", p.stack(e.Stack))
+	}
+	return b
+}
+
+// stack returns the frames of the stack of the frame id, the leaf first.
+func (p *perfetto) stack(id int) string {
+	var frames []string
+	for id != 0 {
+		f, ok := p.d.Frames[fmt.Sprint(id)]
+		if !ok {
+			break
+		}
+		frames = append(frames, f.Name)
+		id = f.Parent
+	}
+	return strings.Join(frames, "\n")
+}
+
+func appendAnnotation(b []byte, name string, v interface{}) []byte {
+	var a []byte
+	a = protowire.AppendTag(a, annotationName, protowire.BytesType)
+	a = protowire.AppendString(a, name)
+	switch v := v.(type) {
+	case string:
+		a = protowire.AppendTag(a, annotationString, protowire.BytesType)
+		a = protowire.AppendString(a, v)
+	case uint64:
+		a = protowire.AppendTag(a, annotationUint, protowire.VarintType)
+		a = protowire.AppendVarint(a, v)
+	case int:
+		a = protowire.AppendTag(a, annotationInt, protowire.VarintType)
+		a = protowire.AppendVarint(a, uint64(v))
+	case int64:
+		a = protowire.AppendTag(a, annotationInt, protowire.VarintType)
+		a = protowire.AppendVarint(a, uint64(v))
+	case float64:
+		a = protowire.AppendTag(a, annotationDouble, protowire.Fixed64Type)
+		a = protowire.AppendFixed64(a, math.Float64bits(v))
+	default:
+		a = protowire.AppendTag(a, annotationString, protowire.BytesType)
+		a = protowire.AppendString(a, fmt.Sprint(v))
+	}
+	b = protowire.AppendTag(b, eventAnnotations, protowire.BytesType)
+	return protowire.AppendBytes(b, a)
+}
+
+// number returns v, a counter value, as a float64.
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case uint64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// ns converts ts, in microseconds, to nanoseconds.
+func ns(ts float64) uint64 {
+	return uint64(math.Round(ts * 1e3))
+}
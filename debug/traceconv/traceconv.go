@@ -0,0 +1,712 @@
+// Package traceconv converts the execution traces of runtime/trace, read
+// with golang.org/x/exp/trace, to the JSON of the Chrome trace viewer, and
+// to the protobuf traces of Perfetto with WritePerfetto.
+//
+// The trace has three processes:
+//
+//   - Procs, a thread by P with the goroutines running on it, a thread with
+//     the GC phases and a thread with the stops of the world, and the
+//     runtime metrics as counters;
+//   - Goroutines, a thread by goroutine with its states, running,
+//     runnable, in a syscall or waiting with the reason and the stack where
+//     it blocked, and its GC mark assists;
+//   - Tasks, the user tasks as async spans, and a thread by goroutine with
+//     its user regions and logs.
+//
+// The trace can be limited to some goroutines, to some tasks and the
+// goroutines taking part in them, and to a window of time, to slice the
+// large traces.
+package traceconv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/trace"
+
+	"github.com/hitzhangjie/codemaster/debug/internal/traceviewer"
+)
+
+// The processes of the trace, and the threads of Procs for the ranges of
+// the whole program.
+const (
+	procsPID      = 1
+	goroutinesPID = 2
+	tasksPID      = 3
+
+	gcTID  = 1 << 20
+	stwTID = gcTID + 1
+)
+
+// Options selects the events converted.
+type Options struct {
+	// Goroutines only keeps the goroutines with these IDs, all if empty.
+	Goroutines []int64
+	// Tasks only keeps the tasks with these IDs or types and their
+	// subtasks, and the goroutines with their regions and logs, all if
+	// empty. The window is the time of the tasks, unless Start or End is
+	// set.
+	Tasks []string
+	// Start and End are the window kept, relative to the first event of
+	// the trace. An End of 0 is the end of the trace.
+	Start, End time.Duration
+}
+
+// Convert converts the execution trace to the JSON data of the trace
+// viewer.
+func Convert(data []byte, opts Options) (*traceviewer.Data, error) {
+	c := &converter{
+		out: &traceviewer.Data{
+			Events:   []*traceviewer.Event{},
+			Frames:   map[string]traceviewer.Frame{},
+			TimeUnit: "ns",
+		},
+		gs:       map[trace.GoID]*goroutine{},
+		gnames:   map[trace.GoID]string{},
+		tasks:    map[trace.TaskID]*task{},
+		ranges:   map[rangeKey]trace.Time{},
+		threads:  map[[2]uint64]bool{},
+		frames:   map[string]int{},
+		counters: map[string]*traceviewer.Event{},
+		start:    opts.Start,
+		end:      opts.End,
+	}
+	if len(opts.Goroutines) > 0 {
+		c.keepG = map[trace.GoID]bool{}
+		for _, id := range opts.Goroutines {
+			c.keepG[trace.GoID(id)] = true
+		}
+	}
+	if len(opts.Tasks) > 0 {
+		sel, err := selectTasks(data, opts.Tasks)
+		if err != nil {
+			return nil, err
+		}
+		c.keepTask = sel.tasks
+		c.taskG = sel.goroutines
+		if opts.Start == 0 && opts.End == 0 {
+			c.start, c.end = sel.start, sel.end
+		}
+	}
+	if err := c.convert(data); err != nil {
+		return nil, err
+	}
+	return c.out, nil
+}
+
+// Span returns the time from the first to the last event of the trace.
+func Span(data []byte) (time.Duration, error) {
+	var first, last trace.Time
+	err := read(data, func(e *trace.Event) bool {
+		if first == 0 {
+			first = e.Time()
+		}
+		last = e.Time()
+		return true
+	})
+	return last.Sub(first), err
+}
+
+// read reads the events of the trace until f returns false.
+func read(data []byte, f func(e *trace.Event) bool) error {
+	r, err := trace.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for {
+		e, err := r.ReadEvent()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !f(&e) {
+			return nil
+		}
+	}
+}
+
+// taskSelection is the tasks selected by Options.Tasks.
+type taskSelection struct {
+	tasks      map[trace.TaskID]bool
+	goroutines map[trace.GoID]bool // beginning them, or with their regions and logs
+	start, end time.Duration
+}
+
+// selectTasks selects the tasks with the IDs or types of names and their
+// subtasks, in a first pass.
+func selectTasks(data []byte, names []string) (*taskSelection, error) {
+	sel := &taskSelection{tasks: map[trace.TaskID]bool{}, goroutines: map[trace.GoID]bool{}}
+	match := map[string]bool{}
+	for _, n := range names {
+		match[n] = true
+	}
+	var t0, first, last trace.Time
+	open := 0
+	err := read(data, func(e *trace.Event) bool {
+		if t0 == 0 {
+			t0 = e.Time()
+		}
+		switch e.Kind() {
+		case trace.EventTaskBegin:
+			t := e.Task()
+			if match[t.Type] || match[strconv.FormatUint(uint64(t.ID), 10)] || sel.tasks[t.Parent] {
+				sel.tasks[t.ID] = true
+				sel.goroutines[e.Goroutine()] = true
+				if first == 0 {
+					first = e.Time()
+				}
+				open++
+			}
+		case trace.EventTaskEnd:
+			if sel.tasks[e.Task().ID] {
+				last = e.Time()
+				open--
+			}
+		case trace.EventRegionBegin, trace.EventRegionEnd:
+			if sel.tasks[e.Region().Task] {
+				sel.goroutines[e.Goroutine()] = true
+			}
+		case trace.EventLog:
+			if sel.tasks[e.Log().Task] {
+				sel.goroutines[e.Goroutine()] = true
+			}
+		}
+		if open > 0 {
+			last = e.Time()
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(sel.tasks) == 0 {
+		return nil, fmt.Errorf("no task %s", strings.Join(names, ", "))
+	}
+	sel.start, sel.end = first.Sub(t0), last.Sub(t0)
+	if sel.end == 0 {
+		sel.end = 1
+	}
+	return sel, nil
+}
+
+// converter converts the events of a trace.
+type converter struct {
+	out *traceviewer.Data
+
+	keepG    map[trace.GoID]bool   // nil for all
+	keepTask map[trace.TaskID]bool // nil for all
+	taskG    map[trace.GoID]bool   // of the tasks kept, nil for all
+
+	t0         trace.Time // of the first event
+	last       trace.Time
+	start, end time.Duration // window, relative to t0
+	stopped    bool          // at the end of the window
+
+	gs       map[trace.GoID]*goroutine
+	gnames   map[trace.GoID]string // of the goroutines seen
+	tasks    map[trace.TaskID]*task
+	ranges   map[rangeKey]trace.Time // begin of the ranges of the Ps and the program
+	threads  map[[2]uint64]bool      // by pid and tid
+	frames   map[string]int          // IDs by parent ID and frame
+	counters map[string]*traceviewer.Event
+}
+
+// goroutine is the state of a goroutine.
+type goroutine struct {
+	id     trace.GoID
+	name   string // of its outermost function
+	state  trace.GoState
+	since  trace.Time
+	reason string
+	stack  trace.Stack
+	proc   trace.ProcID // running it
+
+	regions []region
+	ranges  map[string]trace.Time
+}
+
+type region struct {
+	typ   string
+	task  trace.TaskID
+	start trace.Time
+}
+
+type task struct {
+	typ    string
+	parent trace.TaskID
+	start  trace.Time
+	g      trace.GoID
+}
+
+type rangeKey struct {
+	name string
+	proc trace.ProcID // -1 for the program
+}
+
+func (c *converter) convert(data []byte) error {
+	err := read(data, func(e *trace.Event) bool {
+		if c.t0 == 0 {
+			c.t0 = e.Time()
+		}
+		if c.end > 0 && e.Time().Sub(c.t0) > c.end {
+			c.stopped = true
+			return false
+		}
+		c.last = e.Time()
+		c.event(e)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	c.finish()
+	return nil
+}
+
+func (c *converter) event(e *trace.Event) {
+	switch e.Kind() {
+	case trace.EventStateTransition:
+		st := e.StateTransition()
+		if st.Resource.Kind == trace.ResourceGoroutine {
+			c.goroutineState(e, st)
+		}
+	case trace.EventRangeBegin, trace.EventRangeActive:
+		r := e.Range()
+		switch r.Scope.Kind {
+		case trace.ResourceGoroutine:
+			g := c.goroutine(r.Scope.Goroutine())
+			if _, ok := g.ranges[r.Name]; !ok {
+				g.ranges[r.Name] = e.Time()
+			}
+		case trace.ResourceProc:
+			c.rangeBegin(rangeKey{r.Name, r.Scope.Proc()}, e.Time())
+		default:
+			c.rangeBegin(rangeKey{r.Name, -1}, e.Time())
+		}
+	case trace.EventRangeEnd:
+		r := e.Range()
+		switch r.Scope.Kind {
+		case trace.ResourceGoroutine:
+			g := c.goroutine(r.Scope.Goroutine())
+			start, ok := g.ranges[r.Name]
+			if !ok {
+				start = c.t0
+			}
+			delete(g.ranges, r.Name)
+			if c.keepGoroutine(g.id) {
+				c.slice(goroutinesPID, uint64(g.id), r.Name, "gc", start, e.Time(), nil, trace.NoStack)
+			}
+			if strings.HasPrefix(r.Name, "stop-the-world") {
+				// the world stopped by g stops for all
+				c.slice(procsPID, stwTID, r.Name, "gc", start, e.Time(),
+					map[string]interface{}{"goroutine": uint64(g.id)}, trace.NoStack)
+			}
+		case trace.ResourceProc:
+			c.rangeEnd(rangeKey{r.Name, r.Scope.Proc()}, e.Time())
+		default:
+			c.rangeEnd(rangeKey{r.Name, -1}, e.Time())
+		}
+	case trace.EventTaskBegin:
+		t := e.Task()
+		c.tasks[t.ID] = &task{typ: t.Type, parent: t.Parent, start: e.Time(), g: e.Goroutine()}
+	case trace.EventTaskEnd:
+		t := e.Task()
+		if tk, ok := c.tasks[t.ID]; ok {
+			c.taskSpan(t.ID, tk, e.Time())
+			delete(c.tasks, t.ID)
+		} else {
+			// begun before the trace
+			c.taskSpan(t.ID, &task{typ: t.Type, parent: t.Parent, start: c.t0, g: e.Goroutine()}, e.Time())
+		}
+	case trace.EventRegionBegin:
+		r := e.Region()
+		g := c.goroutine(e.Goroutine())
+		g.regions = append(g.regions, region{r.Type, r.Task, e.Time()})
+	case trace.EventRegionEnd:
+		r := e.Region()
+		g := c.goroutine(e.Goroutine())
+		reg := region{r.Type, r.Task, c.t0} // begun before the trace
+		for i := len(g.regions) - 1; i >= 0; i-- {
+			if g.regions[i].typ == r.Type {
+				reg = g.regions[i]
+				g.regions = g.regions[:i]
+				break
+			}
+		}
+		c.region(g, reg, e.Time())
+	case trace.EventLog:
+		l := e.Log()
+		g := e.Goroutine()
+		if !c.keepGoroutine(g) || !c.keepTaskID(l.Task) || !c.inWindow(e.Time()) {
+			return
+		}
+		name := l.Category
+		if name == "" {
+			name = "log"
+		}
+		c.emit(&traceviewer.Event{
+			Name:     name,
+			Phase:    "i",
+			Scope:    "t",
+			Time:     c.ts(e.Time()),
+			PID:      tasksPID,
+			TID:      c.thread(tasksPID, uint64(g)),
+			Category: "log",
+			Stack:    c.stack(e.Stack()),
+			Arg:      map[string]interface{}{"message": l.Message, "task": uint64(l.Task)},
+		})
+	case trace.EventMetric:
+		m := e.Metric()
+		if m.Value.Kind() != trace.ValueUint64 {
+			return
+		}
+		ev := &traceviewer.Event{
+			Name:  m.Name,
+			Phase: "C",
+			Time:  c.ts(e.Time()),
+			PID:   procsPID,
+			Arg:   map[string]interface{}{"value": m.Value.Uint64()},
+		}
+		if e.Time().Sub(c.t0) < c.start {
+			// the value at the start of the window
+			c.counters[m.Name] = ev
+			return
+		}
+		if prev, ok := c.counters[m.Name]; ok {
+			prev.Time = c.ts(c.at(c.start))
+			c.emit(prev)
+			delete(c.counters, m.Name)
+		}
+		c.emit(ev)
+	}
+}
+
+// goroutineState handles a state transition of a goroutine.
+func (c *converter) goroutineState(e *trace.Event, st trace.StateTransition) {
+	from, to := st.Goroutine()
+	g := c.goroutine(st.Resource.Goroutine())
+	if g.name == "" {
+		if g.name = rootFunc(st.Stack); g.name != "" {
+			c.gnames[g.id] = g.name
+		}
+	}
+	if from != to {
+		c.endState(g, e.Time())
+	}
+	g.state, g.since, g.reason = to, e.Time(), st.Reason
+	g.stack = st.Stack
+	if g.stack == trace.NoStack {
+		g.stack = e.Stack()
+	}
+	if to == trace.GoRunning || to == trace.GoSyscall && e.Proc() != trace.NoProc {
+		g.proc = e.Proc()
+	}
+	if to == trace.GoNotExist {
+		delete(c.gs, g.id)
+	}
+}
+
+// endState ends the current state of g at t.
+func (c *converter) endState(g *goroutine, t trace.Time) {
+	if g.since == 0 || !c.keepGoroutine(g.id) {
+		return
+	}
+	var name string
+	stack := trace.NoStack
+	switch g.state {
+	case trace.GoRunning:
+		name = "running"
+		if g.proc != trace.NoProc {
+			c.slice(procsPID, uint64(g.proc), goroutineName(g), "goroutine", g.since, t,
+				map[string]interface{}{"goroutine": uint64(g.id)}, trace.NoStack)
+		}
+	case trace.GoRunnable:
+		name = "runnable"
+	case trace.GoSyscall:
+		name, stack = "syscall", g.stack
+	case trace.GoWaiting:
+		name, stack = "waiting", g.stack
+		if g.reason != "" {
+			name += ": " + g.reason
+		}
+	default:
+		return
+	}
+	c.slice(goroutinesPID, uint64(g.id), name, "state", g.since, t, nil, stack)
+}
+
+func (c *converter) rangeBegin(k rangeKey, t trace.Time) {
+	if _, ok := c.ranges[k]; !ok {
+		c.ranges[k] = t
+	}
+}
+
+func (c *converter) rangeEnd(k rangeKey, t trace.Time) {
+	start, ok := c.ranges[k]
+	if !ok {
+		start = c.t0
+	}
+	delete(c.ranges, k)
+	tid := uint64(k.proc)
+	switch {
+	case k.proc >= 0:
+	case strings.HasPrefix(k.name, "stop-the-world"):
+		tid = stwTID
+	default:
+		tid = gcTID
+	}
+	c.slice(procsPID, tid, k.name, "gc", start, t, nil, trace.NoStack)
+}
+
+// taskSpan writes the async span of the task id.
+func (c *converter) taskSpan(id trace.TaskID, t *task, end trace.Time) {
+	if !c.keepTaskID(id) {
+		return
+	}
+	start, end, ok := c.clip(t.start, end)
+	if !ok {
+		return
+	}
+	args := map[string]interface{}{"goroutine": uint64(t.g)}
+	if t.parent != trace.NoTask && t.parent != trace.BackgroundTask {
+		args["parent"] = uint64(t.parent)
+	}
+	name := t.typ
+	if name == "" {
+		name = fmt.Sprintf("task %d", id)
+	}
+	tid := c.thread(tasksPID, 0)
+	c.emit(&traceviewer.Event{Name: name, Phase: "b", Time: c.ts(start), PID: tasksPID, TID: tid, ID: uint64(id), Category: "task", Arg: args})
+	c.emit(&traceviewer.Event{Name: name, Phase: "e", Time: c.ts(end), PID: tasksPID, TID: tid, ID: uint64(id), Category: "task"})
+}
+
+// region writes the region reg of g ending at end.
+func (c *converter) region(g *goroutine, reg region, end trace.Time) {
+	if !c.keepGoroutine(g.id) || !c.keepTaskID(reg.task) {
+		return
+	}
+	c.slice(tasksPID, uint64(g.id), reg.typ, "region", reg.start, end,
+		map[string]interface{}{"task": uint64(reg.task)}, trace.NoStack)
+}
+
+// finish ends the states, ranges, regions and tasks at the last event, or
+// at the end of the window, and names the processes and threads.
+func (c *converter) finish() {
+	end := c.last
+	if c.stopped {
+		end = c.at(c.end)
+	}
+	var gids []trace.GoID
+	for id := range c.gs {
+		gids = append(gids, id)
+	}
+	sort.Slice(gids, func(i, j int) bool { return gids[i] < gids[j] })
+	for _, id := range gids {
+		g := c.gs[id]
+		c.endState(g, end)
+		var names []string
+		for name := range g.ranges {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if c.keepGoroutine(g.id) {
+				c.slice(goroutinesPID, uint64(g.id), name, "gc", g.ranges[name], end, nil, trace.NoStack)
+			}
+		}
+		for i := len(g.regions) - 1; i >= 0; i-- {
+			c.region(g, g.regions[i], end)
+		}
+	}
+	var ranges []rangeKey
+	for k := range c.ranges {
+		ranges = append(ranges, k)
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i].proc != ranges[j].proc {
+			return ranges[i].proc < ranges[j].proc
+		}
+		return ranges[i].name < ranges[j].name
+	})
+	for _, k := range ranges {
+		c.rangeEnd(k, end)
+	}
+	var tasks []trace.TaskID
+	for id := range c.tasks {
+		tasks = append(tasks, id)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i] < tasks[j] })
+	for _, id := range tasks {
+		c.taskSpan(id, c.tasks[id], end)
+	}
+	var counters []string
+	for name := range c.counters {
+		counters = append(counters, name)
+	}
+	sort.Strings(counters)
+	for _, name := range counters {
+		ev := c.counters[name]
+		ev.Time = c.ts(c.at(c.start))
+		c.emit(ev)
+	}
+
+	meta := func(name string, pid, tid uint64, args map[string]interface{}) {
+		c.emit(&traceviewer.Event{Name: name, Phase: "M", PID: pid, TID: tid, Arg: args})
+	}
+	for i, name := range []string{"Procs", "Goroutines", "Tasks"} {
+		meta("process_name", uint64(i+1), 0, map[string]interface{}{"name": name})
+		meta("process_sort_index", uint64(i+1), 0, map[string]interface{}{"sort_index": i})
+	}
+	var threads [][2]uint64
+	for k := range c.threads {
+		threads = append(threads, k)
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		if threads[i][0] != threads[j][0] {
+			return threads[i][0] < threads[j][0]
+		}
+		return threads[i][1] < threads[j][1]
+	})
+	for _, k := range threads {
+		meta("thread_name", k[0], k[1], map[string]interface{}{"name": c.threadName(k[0], k[1])})
+		meta("thread_sort_index", k[0], k[1], map[string]interface{}{"sort_index": k[1]})
+	}
+}
+
+// slice writes the slice of [start, end) clipped to the window.
+func (c *converter) slice(pid, tid uint64, name, cat string, start, end trace.Time, args map[string]interface{}, stack trace.Stack) {
+	start, end, ok := c.clip(start, end)
+	if !ok {
+		return
+	}
+	ev := &traceviewer.Event{
+		Name:     name,
+		Phase:    "X",
+		Time:     c.ts(start),
+		Dur:      float64(end.Sub(start).Nanoseconds()) / 1e3,
+		PID:      pid,
+		TID:      c.thread(pid, tid),
+		Category: cat,
+		Stack:    c.stack(stack),
+	}
+	if args != nil {
+		ev.Arg = args
+	}
+	c.emit(ev)
+}
+
+// clip clips [start, end] to the window, and reports whether it overlaps.
+func (c *converter) clip(start, end trace.Time) (trace.Time, trace.Time, bool) {
+	if ws := c.at(c.start); start < ws {
+		start = ws
+	}
+	if c.end > 0 {
+		if we := c.at(c.end); end > we {
+			end = we
+		}
+	}
+	return start, end, start <= end
+}
+
+func (c *converter) inWindow(t trace.Time) bool {
+	d := t.Sub(c.t0)
+	return d >= c.start && (c.end == 0 || d <= c.end)
+}
+
+// at returns the time d after the first event.
+func (c *converter) at(d time.Duration) trace.Time {
+	return c.t0 + trace.Time(d)
+}
+
+func (c *converter) emit(ev *traceviewer.Event) {
+	c.out.Events = append(c.out.Events, ev)
+}
+
+// ts returns the time of t in the trace, in microseconds.
+func (c *converter) ts(t trace.Time) float64 {
+	return float64(t.Sub(c.t0).Nanoseconds()) / 1e3
+}
+
+// thread records the thread tid of pid, to be named, and returns it.
+func (c *converter) thread(pid, tid uint64) uint64 {
+	c.threads[[2]uint64{pid, tid}] = true
+	return tid
+}
+
+// threadName returns the name of the thread tid of pid.
+func (c *converter) threadName(pid, tid uint64) string {
+	switch {
+	case pid == procsPID && tid == gcTID:
+		return "GC"
+	case pid == procsPID && tid == stwTID:
+		return "STW"
+	case pid == procsPID:
+		return fmt.Sprintf("P%d", tid)
+	case pid == tasksPID && tid == 0:
+		return "tasks"
+	}
+	id := trace.GoID(tid)
+	return goroutineName(&goroutine{id: id, name: c.gnames[id]})
+}
+
+func (c *converter) goroutine(id trace.GoID) *goroutine {
+	g := c.gs[id]
+	if g == nil {
+		g = &goroutine{id: id, proc: trace.NoProc, ranges: map[string]trace.Time{}}
+		c.gs[id] = g
+	}
+	return g
+}
+
+func (c *converter) keepGoroutine(id trace.GoID) bool {
+	return (c.keepG == nil || c.keepG[id]) && (c.taskG == nil || c.taskG[id])
+}
+
+func (c *converter) keepTaskID(id trace.TaskID) bool {
+	return c.keepTask == nil || c.keepTask[id]
+}
+
+// stack returns the ID of the frame of the leaf of s, 0 for none.
+func (c *converter) stack(s trace.Stack) int {
+	var frames []trace.StackFrame
+	for f := range s.Frames() {
+		frames = append(frames, f)
+	}
+	id := 0
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+		name := fmt.Sprintf("%s %s:%d", f.Func, f.File, f.Line)
+		k := strconv.Itoa(id) + "/" + name
+		next, ok := c.frames[k]
+		if !ok {
+			next = len(c.frames) + 1
+			c.frames[k] = next
+			c.out.Frames[strconv.Itoa(next)] = traceviewer.Frame{Name: name, Parent: id}
+		}
+		id = next
+	}
+	return id
+}
+
+// rootFunc returns the outermost function of s.
+func rootFunc(s trace.Stack) string {
+	var name string
+	for f := range s.Frames() {
+		name = f.Func
+	}
+	return name
+}
+
+func goroutineName(g *goroutine) string {
+	if g.name == "" {
+		return fmt.Sprintf("G%d", g.id)
+	}
+	return fmt.Sprintf("G%d %s", g.id, g.name)
+}
@@ -0,0 +1,360 @@
+package traceconv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"runtime"
+	"runtime/trace"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/hitzhangjie/codemaster/debug/internal/traceviewer"
+)
+
+// record traces a day of tasks and regions like trace/trace_test.go, with
+// a GC and syscalls.
+func record(t *testing.T) []byte {
+	var buf bytes.Buffer
+	require.Nil(t, trace.Start(&buf))
+
+	ctx, day := trace.NewTask(context.Background(), "one day")
+	trace.WithRegion(ctx, "get up", func() { time.Sleep(100 * time.Microsecond) })
+	var wg sync.WaitGroup
+	ch := make(chan int)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		trace.WithRegion(ctx, "washing", func() { time.Sleep(100 * time.Microsecond) })
+		ch <- 1
+	}()
+	go func() {
+		defer wg.Done()
+		<-ch
+		wctx, work := trace.NewTask(ctx, "work")
+		trace.WithRegion(wctx, "programming", func() {
+			trace.Log(wctx, "msg", "this is my day")
+			_, _ = os.ReadFile("/proc/self/stat")
+			runtime.GC()
+		})
+		work.End()
+	}()
+	wg.Wait()
+	day.End()
+
+	_, other := trace.NewTask(context.Background(), "other")
+	trace.WithRegion(context.Background(), "background", func() { time.Sleep(100 * time.Microsecond) })
+	other.End()
+
+	trace.Stop()
+	return buf.Bytes()
+}
+
+// named returns the events named name of phase.
+func named(d *traceviewer.Data, phase, name string) []*traceviewer.Event {
+	var events []*traceviewer.Event
+	for _, e := range d.Events {
+		if e.Phase == phase && e.Name == name {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func TestConvert(t *testing.T) {
+	data := record(t)
+	d, err := Convert(data, Options{})
+	require.Nil(t, err)
+
+	// processes
+	var procs []string
+	for _, e := range named(d, "M", "process_name") {
+		procs = append(procs, e.Arg.(map[string]interface{})["name"].(string))
+	}
+	assert.Equal(t, []string{"Procs", "Goroutines", "Tasks"}, procs)
+
+	// tasks, with the subtask work
+	tasks := map[string]*traceviewer.Event{}
+	for _, e := range d.Events {
+		if e.Phase == "b" {
+			tasks[e.Name] = e
+		}
+	}
+	require.Contains(t, tasks, "one day")
+	require.Contains(t, tasks, "work")
+	require.Contains(t, tasks, "other")
+	assert.Equal(t, tasks["one day"].ID, tasks["work"].Arg.(map[string]interface{})["parent"])
+	assert.Len(t, named(d, "e", "work"), 1)
+
+	// regions on the threads of their goroutines in Tasks
+	for _, name := range []string{"get up", "washing", "programming", "background"} {
+		regions := named(d, "X", name)
+		require.Len(t, regions, 1, name)
+		assert.Equal(t, uint64(tasksPID), regions[0].PID)
+		assert.True(t, regions[0].Dur > 0, name)
+	}
+	washing, programming := named(d, "X", "washing")[0], named(d, "X", "programming")[0]
+	assert.NotEqual(t, washing.TID, programming.TID)
+	assert.Equal(t, tasks["work"].ID, programming.Arg.(map[string]interface{})["task"])
+
+	logs := named(d, "i", "msg")
+	require.Len(t, logs, 1)
+	assert.Equal(t, "this is my day", logs[0].Arg.(map[string]interface{})["message"])
+	assert.Equal(t, programming.TID, logs[0].TID)
+	assert.NotZero(t, logs[0].Stack)
+
+	// goroutine states, the Ps running them, GC and syscalls
+	var running, syscall, waiting, procSlices, gc, stw int
+	for _, e := range d.Events {
+		if e.Phase != "X" {
+			continue
+		}
+		switch {
+		case e.PID == goroutinesPID && e.Name == "running":
+			running++
+		case e.PID == goroutinesPID && e.Name == "syscall":
+			syscall++
+		case e.PID == goroutinesPID && len(e.Name) > 8 && e.Name[:8] == "waiting:":
+			waiting++
+			assert.NotZero(t, e.Stack, e.Name)
+		case e.PID == procsPID && e.TID == gcTID:
+			gc++
+		case e.PID == procsPID && e.TID == stwTID:
+			stw++
+		case e.PID == procsPID:
+			procSlices++
+			assert.Contains(t, e.Name, "G")
+		}
+	}
+	assert.NotZero(t, running)
+	assert.NotZero(t, syscall)
+	assert.NotZero(t, waiting)
+	assert.NotZero(t, procSlices)
+	assert.NotZero(t, gc)
+	assert.NotZero(t, stw)
+	assert.NotEmpty(t, named(d, "X", "GC concurrent mark phase"))
+
+	// counters of the runtime metrics
+	var counters int
+	for _, e := range d.Events {
+		if e.Phase == "C" {
+			counters++
+		}
+	}
+	assert.NotZero(t, counters)
+
+	// frames
+	for id, f := range d.Frames {
+		if f.Parent != 0 {
+			assert.Contains(t, d.Frames, jsonInt(f.Parent), id)
+		}
+	}
+	_, err = json.Marshal(d)
+	assert.Nil(t, err)
+}
+
+func jsonInt(i int) string {
+	b, _ := json.Marshal(i)
+	return string(b)
+}
+
+func TestFilters(t *testing.T) {
+	data := record(t)
+	all, err := Convert(data, Options{})
+	require.Nil(t, err)
+
+	// the task one day, its subtask work and the goroutines taking part
+	d, err := Convert(data, Options{Tasks: []string{"one day"}})
+	require.Nil(t, err)
+	var tasks []string
+	for _, e := range d.Events {
+		if e.Phase == "b" {
+			tasks = append(tasks, e.Name)
+		}
+	}
+	assert.ElementsMatch(t, []string{"one day", "work"}, tasks)
+	assert.Empty(t, named(d, "X", "background"))
+	assert.Len(t, named(d, "X", "washing"), 1)
+	day := named(all, "b", "one day")[0]
+	dayEnd := named(all, "e", "one day")[0]
+	for _, e := range d.Events {
+		if e.Phase != "M" {
+			assert.True(t, e.Time >= day.Time-1 && e.Time+e.Dur <= dayEnd.Time+1, "%s at %v", e.Name, e.Time)
+		}
+	}
+	gs := map[uint64]bool{}
+	for _, e := range d.Events {
+		if e.PID == goroutinesPID && e.Phase == "X" {
+			gs[e.TID] = true
+		}
+	}
+	assert.Len(t, gs, 3) // the main goroutine and the two of the day
+
+	// by ID
+	byID, err := Convert(data, Options{Tasks: []string{jsonInt(int(named(all, "b", "work")[0].ID))}})
+	require.Nil(t, err)
+	assert.Len(t, named(byID, "X", "programming"), 1)
+	assert.Empty(t, named(byID, "X", "washing"))
+	_, err = Convert(data, Options{Tasks: []string{"none"}})
+	assert.NotNil(t, err)
+
+	// the goroutine washing
+	g := named(all, "X", "washing")[0].TID
+	d, err = Convert(data, Options{Goroutines: []int64{int64(g)}})
+	require.Nil(t, err)
+	for _, e := range d.Events {
+		switch {
+		case e.Phase == "X" && (e.PID == goroutinesPID || e.PID == tasksPID):
+			assert.Equal(t, g, e.TID)
+		case e.Phase == "X" && e.Category == "goroutine":
+			assert.Equal(t, g, e.Arg.(map[string]interface{})["goroutine"])
+		}
+	}
+	assert.Len(t, named(d, "X", "washing"), 1)
+	assert.NotEmpty(t, named(d, "b", "one day"))
+
+	// the second half of the trace
+	span, err := Span(data)
+	require.Nil(t, err)
+	half := span / 2
+	d, err = Convert(data, Options{Start: half, End: span})
+	require.Nil(t, err)
+	start := float64(half.Nanoseconds()) / 1e3
+	end := float64(span.Nanoseconds()) / 1e3
+	for _, e := range d.Events {
+		if e.Phase != "M" {
+			assert.True(t, e.Time >= start-1e-3 && e.Time+e.Dur <= end+1e-3, "%s %s at %v+%v", e.Phase, e.Name, e.Time, e.Dur)
+		}
+	}
+	assert.Less(t, len(d.Events), len(all.Events))
+}
+
+func TestWritePerfetto(t *testing.T) {
+	d, err := Convert(record(t), Options{})
+	require.Nil(t, err)
+	var buf bytes.Buffer
+	require.Nil(t, WritePerfetto(&buf, d))
+
+	type trackEvent struct {
+		typ, track uint64
+		name       string
+		ts         uint64
+	}
+	tracks := map[uint64]string{}
+	processes := map[uint64]bool{}
+	var events []trackEvent
+	b := buf.Bytes()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.True(t, n > 0)
+		require.Equal(t, protowire.Number(tracePacket), num)
+		require.Equal(t, protowire.BytesType, typ)
+		b = b[n:]
+		packet, n := protowire.ConsumeBytes(b)
+		require.True(t, n > 0)
+		b = b[n:]
+
+		var ts uint64
+		fields(t, packet, func(num protowire.Number, v uint64, msg []byte) {
+			switch num {
+			case packetTimestamp:
+				ts = v
+			case packetSequenceID:
+				assert.Equal(t, uint64(1), v)
+			case packetTrackDescriptor:
+				var uuid uint64
+				var name string
+				fields(t, msg, func(num protowire.Number, v uint64, msg []byte) {
+					switch num {
+					case trackUUID:
+						uuid = v
+					case trackName:
+						name = string(msg)
+					case trackProcess:
+						processes[uuid] = true
+					case trackParent:
+						assert.True(t, processes[v], "parent %d", v)
+					}
+				})
+				tracks[uuid] = name
+			case packetTrackEvent:
+				var e trackEvent
+				fields(t, msg, func(num protowire.Number, v uint64, msg []byte) {
+					switch num {
+					case eventType:
+						e.typ = v
+					case eventTrackUUID:
+						e.track = v
+					case eventName:
+						e.name = string(msg)
+					}
+				})
+				e.ts = ts
+				events = append(events, e)
+			}
+		})
+	}
+	assert.Len(t, processes, 3)
+
+	// the slices nest on each track, in time order
+	var last uint64
+	open := map[uint64]int{}
+	var begins, slices int
+	for _, e := range events {
+		assert.Contains(t, tracks, e.track)
+		assert.True(t, e.ts >= last)
+		last = e.ts
+		switch e.typ {
+		case typeSliceBegin:
+			open[e.track]++
+			begins++
+		case typeSliceEnd:
+			open[e.track]--
+			assert.True(t, open[e.track] >= 0, "end without begin on %s", tracks[e.track])
+		}
+	}
+	for track, n := range open {
+		assert.Zero(t, n, tracks[track])
+	}
+	for _, e := range d.Events {
+		if e.Phase == "X" || e.Phase == "b" {
+			slices++
+		}
+	}
+	assert.Equal(t, slices, begins)
+}
+
+// fields calls f with the fields of msg, the value of the varints and
+// fixed64 or the bytes.
+func fields(t *testing.T, msg []byte, f func(num protowire.Number, v uint64, msg []byte)) {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		require.True(t, n > 0)
+		msg = msg[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(msg)
+			require.True(t, n > 0)
+			f(num, v, nil)
+			msg = msg[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(msg)
+			require.True(t, n > 0)
+			f(num, v, nil)
+			msg = msg[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(msg)
+			require.True(t, n > 0)
+			f(num, 0, v)
+			msg = msg[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+//go:build linux && amd64
+// +build linux,amd64
+
+package main
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hitzhangjie/codemaster/dwarf/eval"
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+	"github.com/hitzhangjie/codemaster/dwarf/line"
+	"github.com/hitzhangjie/codemaster/dwarf/loclist"
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+	"github.com/hitzhangjie/codemaster/dwarf/regnum"
+	"github.com/hitzhangjie/codemaster/dwarf/stack"
+)
+
+// location is where the current thread is stopped.
+type location struct {
+	PC       uint64
+	Function string
+	File     string
+	Line     int
+}
+
+func (l location) String() string {
+	if l.Function == "" {
+		return fmt.Sprintf("%#x", l.PC)
+	}
+	return fmt.Sprintf("%s() %s:%d (%#x)", l.Function, l.File, l.Line, l.PC)
+}
+
+// debugger drives a traced process with the DWARF of its executable.
+type debugger struct {
+	proc     *process
+	dwarf    *dwarf.Data
+	unwinder *stack.Unwinder
+
+	loclist   loclist.Reader
+	debugAddr *godwarf.DebugAddrSection
+}
+
+// launchDebugger starts the program exe stopped at its first instruction.
+func launchDebugger(exe string, args []string, stdout, stderr io.Writer) (*debugger, error) {
+	d, err := newDebugger(exe)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if d.proc, err = launch(cmd); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// attachDebugger stops the running process pid.
+func attachDebugger(pid int) (*debugger, error) {
+	d, err := newDebugger("/proc/" + strconv.Itoa(pid) + "/exe")
+	if err != nil {
+		return nil, err
+	}
+	if d.proc, err = attach(pid); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func newDebugger(exe string) (*debugger, error) {
+	f, err := godwarf.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if f.Machine != elf.EM_X86_64 {
+		return nil, fmt.Errorf("unsupported architecture %s", f.Machine)
+	}
+	if f.Type != elf.ET_EXEC {
+		return nil, errors.New("position independent executables are not supported")
+	}
+
+	d := &debugger{}
+	if d.dwarf, err = f.DWARF(); err != nil {
+		return nil, err
+	}
+	if d.unwinder, err = stack.NewFromFile(f, 0); err != nil {
+		return nil, err
+	}
+	if data, _ := godwarf.GetDebugSection(f, "loc"); data != nil {
+		d.loclist = loclist.NewDwarf2Reader(data, 8)
+	} else if data, _ := godwarf.GetDebugSection(f, "loclists"); data != nil {
+		d.loclist = loclist.NewDwarf5Reader(data)
+	}
+	if data, _ := godwarf.GetDebugSection(f, "addr"); data != nil {
+		d.debugAddr = godwarf.ParseAddr(data)
+	}
+	return d, nil
+}
+
+// close kills a launched process and detaches from an attached one.
+func (d *debugger) close() error {
+	if d.proc.cmd != nil {
+		d.proc.kill()
+		return nil
+	}
+	return d.proc.detach()
+}
+
+// breakpoint sets a breakpoint at loc, either file:line, the file being
+// matched by suffix, or the name of a function. It returns the addresses
+// of the breakpoints set.
+func (d *debugger) breakpoint(loc string) ([]uint64, error) {
+	addrs, err := d.findLocation(loc)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if err := d.proc.setBreakpoint(addr, false); err != nil {
+			return nil, err
+		}
+	}
+	return addrs, nil
+}
+
+func (d *debugger) findLocation(loc string) ([]uint64, error) {
+	i := strings.LastIndex(loc, ":")
+	if i < 0 {
+		for _, fn := range d.unwinder.Functions() {
+			if fn.Name == loc {
+				return []uint64{d.prologueEnd(fn)}, nil
+			}
+		}
+		return nil, fmt.Errorf("function %s not found", loc)
+	}
+
+	file := loc[:i]
+	lineno, err := strconv.Atoi(loc[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid line %q", loc[i+1:])
+	}
+
+	// the first statement of the line in each function, a line may be in
+	// several functions if it's inlined
+	first := map[*stack.Function]uint64{}
+	seen := map[*line.DebugLineInfo]bool{}
+	for _, fn := range d.unwinder.Functions() {
+		lines := fn.LineInfo()
+		if lines == nil || seen[lines] {
+			continue
+		}
+		seen[lines] = true
+		for _, entry := range lines.FileNames {
+			if !matchFile(entry.Path, file) {
+				continue
+			}
+			for _, pc := range lines.LineToPCs(entry.Path, lineno) {
+				fn := d.unwinder.PCToFunc(pc.PC)
+				if !pc.Stmt || fn == nil {
+					continue
+				}
+				if addr, ok := first[fn]; !ok || pc.PC < addr {
+					first[fn] = pc.PC
+				}
+			}
+		}
+	}
+	if len(first) == 0 {
+		return nil, fmt.Errorf("no code at %s", loc)
+	}
+
+	var addrs []uint64
+	for fn, addr := range first {
+		if addr == fn.Entry {
+			// the declaration line of a function
+			addr = d.prologueEnd(fn)
+		}
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs, nil
+}
+
+// matchFile reports whether path is file, or ends with file
+func matchFile(path, file string) bool {
+	return path == file || strings.HasSuffix(path, "/"+filepath.ToSlash(file))
+}
+
+// prologueEnd returns the first pc of fn after the stack check
+func (d *debugger) prologueEnd(fn *stack.Function) uint64 {
+	if pc, _, _, ok := fn.LineInfo().PrologueEndPC(fn.Entry, fn.End); ok {
+		return pc
+	}
+	return fn.Entry
+}
+
+// cont resumes the process until a breakpoint is hit.
+func (d *debugger) cont() (location, error) {
+	if err := d.proc.resume(); err != nil {
+		return location{}, err
+	}
+	if err := d.proc.clearTempBreakpoints(); err != nil {
+		return location{}, err
+	}
+	return d.location()
+}
+
+// step executes the current line, stepping into the functions it calls,
+// except the runtime's.
+func (d *debugger) step() (location, error) {
+	return d.stepLine(true)
+}
+
+// next executes the current line, stepping over the functions it calls.
+func (d *debugger) next() (location, error) {
+	return d.stepLine(false)
+}
+
+func (d *debugger) stepLine(into bool) (location, error) {
+	start, err := d.topFrame()
+	if err != nil {
+		return location{}, err
+	}
+	for {
+		before, err := d.proc.regs()
+		if err != nil {
+			return location{}, err
+		}
+		if err := d.proc.stepOverBreakpoint(); err != nil {
+			return location{}, err
+		}
+		regs, err := d.proc.regs()
+		if err != nil {
+			return location{}, err
+		}
+		if regs.Rip == before.Rip {
+			// not a breakpoint, execute the instruction
+			if err := d.proc.singleStep(); err != nil {
+				return location{}, err
+			}
+			if regs, err = d.proc.regs(); err != nil {
+				return location{}, err
+			}
+		}
+
+		if ret, ok := d.called(before, regs); ok {
+			fn := d.unwinder.PCToFunc(regs.Rip)
+			if into && fn != nil && (!strings.HasPrefix(fn.Name, "runtime.") || strings.HasPrefix(start.Function, "runtime.")) {
+				// stop once the callee set up its frame
+				pc, cfa := d.prologueEnd(fn), int64(regs.Rsp)+8
+				if pc == regs.Rip {
+					return d.location()
+				}
+				return d.runTo(pc, func(f stack.Frame) bool { return f.Regs.CFA == cfa })
+			}
+			stopped, err := d.runTo(ret, func(f stack.Frame) bool { return f.Regs.CFA == start.Regs.CFA })
+			if err != nil || stopped.PC != ret {
+				// exited, or stopped at another breakpoint
+				return stopped, err
+			}
+		}
+
+		f, err := d.topFrame()
+		if err != nil {
+			return location{}, err
+		}
+		if f.Regs.CFA > start.Regs.CFA {
+			// returned to the caller
+			return d.location()
+		}
+		if f.Line != 0 && f.Regs.CFA == start.Regs.CFA && (f.Line != start.Line || f.File != start.File) {
+			return d.location()
+		}
+	}
+}
+
+// called reports whether the instruction executed between before and
+// after is a call, and returns the return address.
+func (d *debugger) called(before, after syscall.PtraceRegs) (uint64, bool) {
+	if after.Rsp != before.Rsp-8 {
+		return 0, false
+	}
+	buf := make([]byte, 8)
+	if _, err := d.proc.readMemory(buf, after.Rsp); err != nil {
+		return 0, false
+	}
+	ret := stack.AMD64.ByteOrder.Uint64(buf)
+	// a call instruction is at most 15 bytes long
+	return ret, ret > before.Rip && ret <= before.Rip+15
+}
+
+// runTo continues to addr until cond is true for the top frame there, it
+// returns early if another breakpoint is hit.
+func (d *debugger) runTo(addr uint64, cond func(stack.Frame) bool) (location, error) {
+	if err := d.proc.setBreakpoint(addr, true); err != nil {
+		return location{}, err
+	}
+	for {
+		if err := d.proc.resume(); err != nil {
+			return location{}, err
+		}
+		regs, err := d.proc.regs()
+		if err != nil {
+			return location{}, err
+		}
+		bp, ok := d.proc.breakpoints[regs.Rip]
+		if ok && bp.temp {
+			f, err := d.topFrame()
+			if err != nil {
+				return location{}, err
+			}
+			if regs.Rip != addr || !cond(f) {
+				continue
+			}
+		}
+		if err := d.proc.clearTempBreakpoints(); err != nil {
+			return location{}, err
+		}
+		return d.location()
+	}
+}
+
+// location returns where the current thread is stopped.
+func (d *debugger) location() (location, error) {
+	f, err := d.topFrame()
+	if err != nil {
+		return location{}, err
+	}
+	return location{PC: f.PC, Function: f.Function, File: f.File, Line: f.Line}, nil
+}
+
+// registers returns the DWARF registers of the current thread.
+func (d *debugger) registers() (*op.DwarfRegisters, error) {
+	r, err := d.proc.regs()
+	if err != nil {
+		return nil, err
+	}
+	dregs := stack.AMD64.Registers(0, r.Rip, r.Rsp, r.Rbp)
+	for num, val := range map[uint64]uint64{
+		regnum.AMD64_Rax: r.Rax,
+		regnum.AMD64_Rdx: r.Rdx,
+		regnum.AMD64_Rcx: r.Rcx,
+		regnum.AMD64_Rbx: r.Rbx,
+		regnum.AMD64_Rsi: r.Rsi,
+		regnum.AMD64_Rdi: r.Rdi,
+		regnum.AMD64_R8:  r.R8,
+		regnum.AMD64_R9:  r.R9,
+		regnum.AMD64_R10: r.R10,
+		regnum.AMD64_R11: r.R11,
+		regnum.AMD64_R12: r.R12,
+		regnum.AMD64_R13: r.R13,
+		regnum.AMD64_R14: r.R14,
+		regnum.AMD64_R15: r.R15,
+	} {
+		dregs.AddReg(num, op.DwarfRegisterFromUint64(val))
+	}
+	return dregs, nil
+}
+
+// stack returns the call stack of the current thread.
+func (d *debugger) stack(depth int) ([]stack.Frame, error) {
+	if d.proc.exited {
+		return nil, errors.New("process exited")
+	}
+	regs, err := d.registers()
+	if err != nil {
+		return nil, err
+	}
+	return d.unwinder.Stack(regs, d.proc.readMemory, depth)
+}
+
+// topFrame returns the innermost frame, the CFA of its registers is set.
+func (d *debugger) topFrame() (stack.Frame, error) {
+	frames, err := d.stack(1)
+	if len(frames) == 0 {
+		if err == nil {
+			err = errors.New("empty stack")
+		}
+		return stack.Frame{}, err
+	}
+	return frames[0], nil
+}
+
+// scope returns the scope to evaluate variables in the top frame.
+func (d *debugger) scope() (*eval.Scope, error) {
+	f, err := d.topFrame()
+	if err != nil {
+		return nil, err
+	}
+	s, err := eval.New(d.dwarf, d.proc.readMemory, f.Regs, f.PC)
+	if err != nil {
+		return nil, err
+	}
+	s.Line = f.Line
+	s.Loclist, s.DebugAddr = d.loclist, d.debugAddr
+	return s, nil
+}
+
+// locals returns the local variables visible in the top frame.
+func (d *debugger) locals() ([]*eval.Variable, error) {
+	s, err := d.scope()
+	if err != nil {
+		return nil, err
+	}
+	return s.Locals()
+}
+
+// args returns the arguments of the function of the top frame.
+func (d *debugger) args() ([]*eval.Variable, error) {
+	s, err := d.scope()
+	if err != nil {
+		return nil, err
+	}
+	return s.Args()
+}
+
+// variable returns the local, argument or package variable name.
+func (d *debugger) variable(name string) (*eval.Variable, error) {
+	s, err := d.scope()
+	if err != nil {
+		return nil, err
+	}
+	locals, _ := s.Locals()
+	args, _ := s.Args()
+	// inner declarations come last
+	vars := append(args, locals...)
+	for i := len(vars) - 1; i >= 0; i-- {
+		if vars[i].Name == name {
+			return vars[i], nil
+		}
+	}
+	if !strings.Contains(name, ".") {
+		name = "main." + name
+	}
+	return s.Global(name)
+}
@@ -0,0 +1,144 @@
+//go:build linux && amd64
+// +build linux,amd64
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/hitzhangjie/codemaster/dwarf/eval"
+)
+
+// formatVariable formats v as "name = value".
+func formatVariable(v *eval.Variable) string {
+	return v.Name + " = " + formatValue(v)
+}
+
+// formatValue formats the value of v in a Go like syntax, for example
+// `main.Student {Name: "x", Age: 1}` or `[]int len: 3, cap: 4, [1,2,3]`.
+func formatValue(v *eval.Variable) string {
+	var b strings.Builder
+	writeValue(&b, v, true)
+	return b.String()
+}
+
+func writeValue(b *strings.Builder, v *eval.Variable, top bool) {
+	if v.Unreadable != nil {
+		fmt.Fprintf(b, "(unreadable %v)", v.Unreadable)
+		return
+	}
+	typ := "?"
+	if v.Type != nil {
+		typ = v.Type.String()
+	}
+
+	switch v.Kind {
+	case reflect.String:
+		s, _ := v.Value.(string)
+		b.WriteString(strconv.Quote(s))
+		if int64(len(s)) < v.Len {
+			fmt.Fprintf(b, "...+%d more", v.Len-int64(len(s)))
+		}
+
+	case reflect.Ptr, reflect.UnsafePointer:
+		addr, _ := v.Value.(uint64)
+		if addr == 0 {
+			b.WriteString("nil")
+		} else if len(v.Children) == 1 {
+			b.WriteString("*")
+			writeValue(b, v.Children[0], false)
+		} else {
+			fmt.Fprintf(b, "(%s)(%#x)", typ, addr)
+		}
+
+	case reflect.Struct:
+		if top {
+			b.WriteString(typ + " ")
+		}
+		if v.Children == nil && v.Type != nil && v.Type.Size() > 0 {
+			b.WriteString("{...}")
+			return
+		}
+		b.WriteString("{")
+		for i, c := range v.Children {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(c.Name + ": ")
+			writeValue(b, c, false)
+		}
+		b.WriteString("}")
+
+	case reflect.Slice, reflect.Array:
+		if top {
+			fmt.Fprintf(b, "%s len: %d, cap: %d, ", typ, v.Len, v.Cap)
+		}
+		writeList(b, v, v.Children)
+
+	case reflect.Map:
+		if top {
+			fmt.Fprintf(b, "%s len: %d, ", typ, v.Len)
+		}
+		b.WriteString("[")
+		for i := 0; i+1 < len(v.Children); i += 2 {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeValue(b, v.Children[i], false)
+			b.WriteString(": ")
+			writeValue(b, v.Children[i+1], false)
+		}
+		writeMore(b, v.Len-int64(len(v.Children)/2))
+		b.WriteString("]")
+
+	case reflect.Chan:
+		fmt.Fprintf(b, "%s %d/%d", typ, v.Len, v.Cap)
+		if len(v.Children) > 0 {
+			b.WriteString(" ")
+			writeList(b, v, v.Children)
+		}
+
+	case reflect.Interface:
+		if len(v.Children) == 0 {
+			b.WriteString("nil")
+			return
+		}
+		fmt.Fprintf(b, "%s(%s) ", typ, v.Children[0].Name)
+		writeValue(b, v.Children[0], false)
+
+	case reflect.Func:
+		if pc, _ := v.Value.(uint64); pc != 0 {
+			fmt.Fprintf(b, "%s %#x", typ, pc)
+		} else {
+			b.WriteString("nil")
+		}
+
+	default:
+		if v.Value == nil {
+			fmt.Fprintf(b, "(%s)(%#x)", typ, v.Addr)
+			return
+		}
+		fmt.Fprint(b, v.Value)
+	}
+}
+
+func writeList(b *strings.Builder, v *eval.Variable, elems []*eval.Variable) {
+	b.WriteString("[")
+	for i, c := range elems {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		writeValue(b, c, false)
+	}
+	writeMore(b, v.Len-int64(len(elems)))
+	b.WriteString("]")
+}
+
+func writeMore(b *strings.Builder, n int64) {
+	if n > 0 {
+		fmt.Fprintf(b, "...+%d more", n)
+	}
+}
@@ -0,0 +1,125 @@
+//go:build linux && amd64
+// +build linux,amd64
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fixture = "../../test/fixtures/elf_read_dwarf"
+
+// debugFixture launches the fixture, its stdout is written to the
+// returned file.
+func debugFixture(t *testing.T) (*debugger, *os.File) {
+	stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	require.Nil(t, err)
+	t.Cleanup(func() { stdout.Close() })
+
+	d, err := launchDebugger(fixture, nil, stdout, stdout)
+	if err != nil && strings.Contains(err.Error(), "operation not permitted") {
+		t.Skipf("ptrace not permitted: %v", err)
+	}
+	require.Nil(t, err)
+	t.Cleanup(func() { d.close() })
+	return d, stdout
+}
+
+func TestBreakpointNextContinue(t *testing.T) {
+	d, stdout := debugFixture(t)
+
+	addrs, err := d.breakpoint("elf_read_dwarf.go:14")
+	require.Nil(t, err)
+	require.Len(t, addrs, 1)
+
+	loc, err := d.cont()
+	require.Nil(t, err)
+	assert.Equal(t, "main.main", loc.Function)
+	assert.Equal(t, "elf_read_dwarf.go", filepath.Base(loc.File))
+	assert.Equal(t, 14, loc.Line)
+	assert.Equal(t, addrs[0], loc.PC)
+
+	vars, err := d.locals()
+	require.Nil(t, err)
+	require.Len(t, vars, 1)
+	assert.Equal(t, `s = main.Student {Name: "", Age: 0}`, formatVariable(vars[0]))
+
+	loc, err = d.next()
+	require.Nil(t, err)
+	assert.Equal(t, "main.main", loc.Function)
+	assert.Equal(t, 15, loc.Line)
+	out, err := ioutil.ReadFile(stdout.Name())
+	require.Nil(t, err)
+	assert.Equal(t, "{ 0}\n", string(out))
+
+	_, err = d.cont()
+	assert.Equal(t, errExited{status: 0}, err)
+}
+
+func TestStep(t *testing.T) {
+	d, _ := debugFixture(t)
+
+	_, err := d.breakpoint("main.main")
+	require.Nil(t, err)
+	loc, err := d.cont()
+	require.Nil(t, err)
+	assert.Equal(t, "main.main", loc.Function)
+	assert.Equal(t, 12, loc.Line)
+
+	for _, line := range []int{13, 14} {
+		loc, err = d.step()
+		require.Nil(t, err)
+		assert.Equal(t, line, loc.Line)
+	}
+
+	loc, err = d.step()
+	require.Nil(t, err)
+	assert.Equal(t, "fmt.Println", loc.Function)
+	assert.Equal(t, "print.go", filepath.Base(loc.File))
+
+	frames, err := d.stack(10)
+	require.Nil(t, err)
+	require.True(t, len(frames) >= 2)
+	assert.Equal(t, "main.main", frames[1].Function)
+	assert.Equal(t, 14, frames[1].Line)
+
+	args, err := d.args()
+	require.Nil(t, err)
+	require.NotEmpty(t, args)
+	assert.Equal(t, "a", args[0].Name)
+}
+
+func TestREPL(t *testing.T) {
+	d, _ := debugFixture(t)
+
+	script := strings.Join([]string{
+		"b elf_read_dwarf.go:14",
+		"c",
+		"locals",
+		"p s",
+		"bt",
+		"n",
+		"c",
+	}, "\n")
+	var out strings.Builder
+	repl(d, strings.NewReader(script), &out)
+
+	for _, want := range []string{
+		"breakpoint set at 0x",
+		"> main.main() ",
+		"elf_read_dwarf.go:14 (0x",
+		`s = main.Student {Name: "", Age: 0}`,
+		"in main.main\n",
+		"elf_read_dwarf.go:15 (0x",
+		"process exited with status 0",
+	} {
+		assert.Contains(t, out.String(), want)
+	}
+}
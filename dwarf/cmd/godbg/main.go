@@ -0,0 +1,175 @@
+//go:build linux && amd64
+// +build linux,amd64
+
+// Command godbg is a minimal debugger for Go programs on linux/amd64, built
+// on the dwarf packages of this module.
+//
+// Usage:
+//
+//	godbg [-x script] prog [args...]
+//	godbg [-x script] -p pid
+//
+// It reads commands from stdin, or from the script file given with -x:
+//
+//	break, b <file:line|function>  set a breakpoint
+//	continue, c                    run until a breakpoint is hit
+//	step, s                        run the current line, entering calls
+//	next, n                        run the current line, skipping calls
+//	locals                         print the local variables
+//	args                           print the function arguments
+//	print, p <name>                print a variable
+//	stack, bt                      print the call stack
+//	quit, q                        kill or detach from the process
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	pid := flag.Int("p", 0, "attach to the process `pid`")
+	script := flag.String("x", "", "read commands from `file`")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: godbg [-x script] prog [args...]\n       godbg [-x script] -p pid\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var (
+		d   *debugger
+		err error
+	)
+	switch {
+	case *pid != 0:
+		d, err = attachDebugger(*pid)
+	case flag.NArg() > 0:
+		d, err = launchDebugger(flag.Arg(0), flag.Args()[1:], os.Stdout, os.Stderr)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "godbg:", err)
+		os.Exit(1)
+	}
+	defer d.close()
+
+	in := io.Reader(os.Stdin)
+	if *script != "" {
+		f, err := os.Open(*script)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "godbg:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+	repl(d, in, os.Stdout)
+}
+
+// repl runs the commands read from in until quit or EOF.
+func repl(d *debugger, in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "(godbg) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "q" {
+			return
+		}
+		if err := command(d, out, fields[0], fields[1:]); err != nil {
+			var exited errExited
+			if errors.As(err, &exited) {
+				fmt.Fprintln(out, err)
+				return
+			}
+			fmt.Fprintln(out, "error:", err)
+		}
+	}
+}
+
+func command(d *debugger, out io.Writer, cmd string, args []string) error {
+	switch cmd {
+	case "break", "b":
+		if len(args) != 1 {
+			return errors.New("usage: break <file:line|function>")
+		}
+		addrs, err := d.breakpoint(args[0])
+		if err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			fmt.Fprintf(out, "breakpoint set at %#x\n", addr)
+		}
+
+	case "continue", "c":
+		return printStop(out, d.cont)
+	case "step", "s":
+		return printStop(out, d.step)
+	case "next", "n":
+		return printStop(out, d.next)
+
+	case "locals", "args":
+		get := d.locals
+		if cmd == "args" {
+			get = d.args
+		}
+		vars, err := get()
+		if err != nil {
+			return err
+		}
+		if len(vars) == 0 {
+			fmt.Fprintf(out, "(no %s)\n", cmd)
+		}
+		for _, v := range vars {
+			fmt.Fprintln(out, formatVariable(v))
+		}
+
+	case "print", "p":
+		if len(args) != 1 {
+			return errors.New("usage: print <name>")
+		}
+		v, err := d.variable(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, formatValue(v))
+
+	case "stack", "bt":
+		frames, err := d.stack(50)
+		for i, f := range frames {
+			inlined := ""
+			if f.Inlined {
+				inlined = " (inlined)"
+			}
+			fmt.Fprintf(out, "%2d  %#x in %s%s\n        at %s:%d\n", i, f.PC, f.Function, inlined, f.File, f.Line)
+		}
+		return err
+
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
+
+// printStop runs the process with run, and prints where it stopped
+func printStop(out io.Writer, run func() (location, error)) error {
+	loc, err := run()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "> %s\n", loc)
+	return nil
+}
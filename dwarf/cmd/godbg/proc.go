@@ -0,0 +1,457 @@
+//go:build linux && amd64
+// +build linux,amd64
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// int3 is the x86 breakpoint instruction
+var int3 = []byte{0xcc}
+
+// ptraceOExitKill kills the tracee if the debugger exits, it's missing
+// from package syscall.
+const ptraceOExitKill = 0x100000
+
+// errExited is returned when the process exited while it was running.
+type errExited struct {
+	status int
+}
+
+func (e errExited) Error() string {
+	return fmt.Sprintf("process exited with status %d", e.status)
+}
+
+type breakpoint struct {
+	addr uint64
+	orig []byte
+
+	// temp breakpoints are set by next and step, and are removed once
+	// they stop the process
+	temp bool
+}
+
+// process is a traced process, every thread of it is traced.
+//
+// Linux requires all the ptrace requests of a tracee to come from the
+// thread that attached to it, so they are all run by a goroutine locked
+// to its thread.
+type process struct {
+	pid     int
+	cmd     *exec.Cmd // nil if attached
+	threads map[int]*thread
+	current *thread // thread that stopped last
+
+	breakpoints map[uint64]*breakpoint
+
+	ptraceChan chan func()
+	exited     bool
+}
+
+type thread struct {
+	tid     int
+	stopped bool
+	// sig is the signal to deliver when the thread is resumed
+	sig syscall.Signal
+}
+
+func newProcess() *process {
+	p := &process{
+		threads:     map[int]*thread{},
+		breakpoints: map[uint64]*breakpoint{},
+		ptraceChan:  make(chan func()),
+	}
+	go func() {
+		runtime.LockOSThread()
+		for fn := range p.ptraceChan {
+			fn()
+		}
+	}()
+	return p
+}
+
+// ptrace runs fn on the thread tracing the process
+func (p *process) ptrace(fn func()) {
+	done := make(chan struct{})
+	p.ptraceChan <- func() {
+		fn()
+		close(done)
+	}
+	<-done
+}
+
+// launch starts cmd stopped at its first instruction.
+func launch(cmd *exec.Cmd) (*process, error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Ptrace = true
+	cmd.SysProcAttr.Setpgid = true
+
+	p := newProcess()
+	var err error
+	p.ptrace(func() {
+		if err = cmd.Start(); err != nil {
+			return
+		}
+		p.pid, p.cmd = cmd.Process.Pid, cmd
+
+		// the process stops with SIGTRAP on exec
+		var ws syscall.WaitStatus
+		if _, err = syscall.Wait4(p.pid, &ws, syscall.WALL, nil); err != nil {
+			return
+		}
+		if !ws.Stopped() {
+			err = fmt.Errorf("process not stopped after exec: %#x", ws)
+			return
+		}
+		err = syscall.PtraceSetOptions(p.pid, syscall.PTRACE_O_TRACECLONE|ptraceOExitKill)
+	})
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	p.addThread(p.pid, true)
+	return p, nil
+}
+
+// attach stops and traces all the threads of process pid.
+func attach(pid int) (*process, error) {
+	p := newProcess()
+	p.pid = pid
+
+	tasks, err := ioutil.ReadDir("/proc/" + strconv.Itoa(pid) + "/task")
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	p.ptrace(func() {
+		for _, task := range tasks {
+			tid, _ := strconv.Atoi(task.Name())
+			if err = syscall.PtraceAttach(tid); err != nil {
+				return
+			}
+			var ws syscall.WaitStatus
+			if _, err = syscall.Wait4(tid, &ws, syscall.WALL, nil); err != nil {
+				return
+			}
+			if err = syscall.PtraceSetOptions(tid, syscall.PTRACE_O_TRACECLONE); err != nil {
+				return
+			}
+			p.addThread(tid, true)
+		}
+	})
+	if err != nil {
+		p.detach()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *process) addThread(tid int, stopped bool) *thread {
+	th := &thread{tid: tid, stopped: stopped}
+	p.threads[tid] = th
+	if p.current == nil || tid == p.pid {
+		p.current = th
+	}
+	return th
+}
+
+// regs returns the registers of the current thread
+func (p *process) regs() (regs syscall.PtraceRegs, err error) {
+	p.ptrace(func() { err = syscall.PtraceGetRegs(p.current.tid, &regs) })
+	return regs, err
+}
+
+func (p *process) setPC(pc uint64) error {
+	regs, err := p.regs()
+	if err != nil {
+		return err
+	}
+	regs.Rip = pc
+	p.ptrace(func() { err = syscall.PtraceSetRegs(p.current.tid, &regs) })
+	return err
+}
+
+// readMemory implements op.ReadMemoryFunc, breakpoints are hidden.
+func (p *process) readMemory(buf []byte, addr uint64) (n int, err error) {
+	if p.exited {
+		return 0, errors.New("process exited")
+	}
+	p.ptrace(func() { n, err = syscall.PtracePeekData(p.pid, uintptr(addr), buf) })
+	if err != nil {
+		return n, fmt.Errorf("could not read memory at %#x: %v", addr, err)
+	}
+	for _, bp := range p.breakpoints {
+		if bp.addr >= addr && bp.addr < addr+uint64(len(buf)) {
+			buf[bp.addr-addr] = bp.orig[0]
+		}
+	}
+	return n, nil
+}
+
+func (p *process) writeMemory(addr uint64, data []byte) (err error) {
+	p.ptrace(func() { _, err = syscall.PtracePokeData(p.pid, uintptr(addr), data) })
+	return err
+}
+
+// setBreakpoint inserts a breakpoint at addr, it's a no-op if there's
+// already one.
+func (p *process) setBreakpoint(addr uint64, temp bool) error {
+	if bp, ok := p.breakpoints[addr]; ok {
+		bp.temp = bp.temp && temp
+		return nil
+	}
+	bp := &breakpoint{addr: addr, orig: make([]byte, len(int3)), temp: temp}
+	var err error
+	p.ptrace(func() { _, err = syscall.PtracePeekData(p.pid, uintptr(addr), bp.orig) })
+	if err != nil {
+		return fmt.Errorf("could not set breakpoint at %#x: %v", addr, err)
+	}
+	if err := p.writeMemory(addr, int3); err != nil {
+		return fmt.Errorf("could not set breakpoint at %#x: %v", addr, err)
+	}
+	p.breakpoints[addr] = bp
+	return nil
+}
+
+func (p *process) clearBreakpoint(addr uint64) error {
+	bp, ok := p.breakpoints[addr]
+	if !ok {
+		return nil
+	}
+	delete(p.breakpoints, addr)
+	if p.exited {
+		return nil
+	}
+	return p.writeMemory(addr, bp.orig)
+}
+
+func (p *process) clearTempBreakpoints() error {
+	for addr, bp := range p.breakpoints {
+		if bp.temp {
+			if err := p.clearBreakpoint(addr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// stepOverBreakpoint moves the current thread past the breakpoint it's
+// stopped at, if any.
+func (p *process) stepOverBreakpoint() error {
+	regs, err := p.regs()
+	if err != nil {
+		return err
+	}
+	bp, ok := p.breakpoints[regs.Rip]
+	if !ok {
+		return nil
+	}
+	if err := p.writeMemory(bp.addr, bp.orig); err != nil {
+		return err
+	}
+	if err := p.singleStep(); err != nil {
+		return err
+	}
+	return p.writeMemory(bp.addr, int3)
+}
+
+// singleStep executes one instruction of the current thread.
+func (p *process) singleStep() error {
+	th := p.current
+	var err error
+	p.ptrace(func() {
+		if err = syscall.PtraceSingleStep(th.tid); err != nil {
+			return
+		}
+		for {
+			var ws syscall.WaitStatus
+			if _, err = syscall.Wait4(th.tid, &ws, syscall.WALL, nil); err != nil {
+				return
+			}
+			if ws.Exited() || ws.Signaled() {
+				err = p.threadExited(th.tid, ws)
+				return
+			}
+			if ws.StopSignal() == syscall.SIGTRAP && ws.TrapCause() == syscall.PTRACE_EVENT_CLONE {
+				// a clone syscall was stepped over
+				err = p.cloned(th.tid)
+				if err == nil {
+					err = syscall.PtraceSingleStep(th.tid)
+				}
+				if err != nil {
+					return
+				}
+				continue
+			}
+			if sig := ws.StopSignal(); sig != syscall.SIGTRAP {
+				// the signal is delivered when the thread is resumed,
+				// stepping into its handler would be confusing
+				th.sig = sig
+				if err = syscall.PtraceSingleStep(th.tid); err != nil {
+					return
+				}
+				continue
+			}
+			return
+		}
+	})
+	return err
+}
+
+// resume continues the current thread and every stopped thread, and
+// returns once a thread stops at a breakpoint.
+func (p *process) resume() error {
+	if err := p.stepOverBreakpoint(); err != nil {
+		return err
+	}
+
+	var err error
+	p.ptrace(func() {
+		for _, th := range p.threads {
+			if th.stopped {
+				if err = syscall.PtraceCont(th.tid, int(th.sig)); err != nil {
+					return
+				}
+				th.stopped, th.sig = false, 0
+			}
+		}
+		err = p.wait()
+	})
+	return err
+}
+
+// wait waits for a thread to stop at a breakpoint, other events are
+// handled transparently. It runs on the ptrace thread.
+func (p *process) wait() error {
+	for {
+		var ws syscall.WaitStatus
+		tid, err := syscall.Wait4(-1, &ws, syscall.WALL, nil)
+		if err != nil {
+			return err
+		}
+
+		th, ok := p.threads[tid]
+		if !ok {
+			// a new thread may report its initial stop before its
+			// parent reports the clone event
+			th = p.addThread(tid, true)
+		}
+
+		switch {
+		case ws.Exited() || ws.Signaled():
+			if err := p.threadExited(tid, ws); err != nil {
+				return err
+			}
+			continue
+		case !ws.Stopped():
+			continue
+		}
+
+		th.stopped = true
+		switch sig := ws.StopSignal(); {
+		case sig == syscall.SIGTRAP && ws.TrapCause() == syscall.PTRACE_EVENT_CLONE:
+			if err := p.cloned(tid); err != nil {
+				return err
+			}
+		case sig == syscall.SIGTRAP:
+			var regs syscall.PtraceRegs
+			if err := syscall.PtraceGetRegs(tid, &regs); err != nil {
+				return err
+			}
+			if _, ok := p.breakpoints[regs.Rip-1]; ok {
+				// the pc is after the int3
+				regs.Rip--
+				if err := syscall.PtraceSetRegs(tid, &regs); err != nil {
+					return err
+				}
+				p.current = th
+				return nil
+			}
+			// runtime.Breakpoint, stop there as well
+			p.current = th
+			return nil
+		case sig == syscall.SIGSTOP:
+			// initial stop of new threads, or a SIGSTOP of ours
+		default:
+			th.sig = sig
+		}
+
+		if err := syscall.PtraceCont(tid, int(th.sig)); err != nil && err != syscall.ESRCH {
+			return err
+		}
+		th.stopped, th.sig = false, 0
+	}
+}
+
+// cloned registers the thread cloned by tid, it runs on the ptrace thread.
+func (p *process) cloned(tid int) error {
+	msg, err := syscall.PtraceGetEventMsg(tid)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.threads[int(msg)]; !ok {
+		// it will report its initial stop
+		p.addThread(int(msg), false)
+	}
+	return nil
+}
+
+func (p *process) threadExited(tid int, ws syscall.WaitStatus) error {
+	delete(p.threads, tid)
+	if tid != p.pid {
+		return nil
+	}
+	p.exited = true
+	if ws.Signaled() {
+		return errExited{status: 128 + int(ws.Signal())}
+	}
+	return errExited{status: ws.ExitStatus()}
+}
+
+// kill terminates a launched process.
+func (p *process) kill() {
+	if !p.exited {
+		syscall.Kill(-p.pid, syscall.SIGKILL)
+		p.ptrace(func() {
+			var ws syscall.WaitStatus
+			for {
+				if _, err := syscall.Wait4(-1, &ws, syscall.WALL, nil); err != nil {
+					return
+				}
+			}
+		})
+		p.exited = true
+	}
+	p.close()
+}
+
+// detach removes the breakpoints and lets an attached process run.
+func (p *process) detach() error {
+	for addr := range p.breakpoints {
+		p.clearBreakpoint(addr)
+	}
+	var err error
+	p.ptrace(func() {
+		for tid := range p.threads {
+			if e := syscall.PtraceDetach(tid); e != nil && err == nil && e != syscall.ESRCH {
+				err = e
+			}
+		}
+	})
+	p.close()
+	return err
+}
+
+func (p *process) close() {
+	close(p.ptraceChan)
+}
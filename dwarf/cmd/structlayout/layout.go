@@ -0,0 +1,253 @@
+package main
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+)
+
+// Layout is the memory layout of a struct.
+type Layout struct {
+	Name    string  `json:"name"`
+	Size    int64   `json:"size"`
+	Align   int64   `json:"align"`
+	Padding int64   `json:"padding"`
+	Fields  []Field `json:"fields"`
+	Holes   []Hole  `json:"holes,omitempty"`
+
+	// CacheLineCrossings are the fields that don't fit in a cache line
+	// while they could.
+	CacheLineCrossings []string `json:"cache_line_crossings,omitempty"`
+	// FalseSharing are the pairs of atomic fields in the same cache line.
+	FalseSharing [][2]string `json:"false_sharing,omitempty"`
+
+	// Reordered is the order of the fields that minimises the size,
+	// empty if the fields are already in that order.
+	Reordered     []string `json:"reordered,omitempty"`
+	ReorderedSize int64    `json:"reordered_size,omitempty"`
+}
+
+// Field is a field of a struct.
+type Field struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Align  int64  `json:"align"`
+	Atomic bool   `json:"atomic,omitempty"`
+}
+
+// Hole is padding between two fields, or at the end of the struct when
+// After is the last field.
+type Hole struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	After  string `json:"after"`
+}
+
+// analyzer computes the layout of structs.
+type analyzer struct {
+	ptrSize   int64
+	cacheLine int64
+	// atomics are the fields, as struct.field, accessed with atomic
+	// operations besides those of sync/atomic types
+	atomics map[string]bool
+}
+
+// atomicTypes are the types whose fields are only accessed atomically
+var atomicTypes = regexp.MustCompile(`^sync/atomic\.|^go\.uber\.org/atomic\.`)
+
+// layout returns the layout of t.
+func (a *analyzer) layout(t *godwarf.StructType) *Layout {
+	l := &Layout{Name: t.StructName, Size: t.Size(), Align: a.alignOf(t)}
+	var end int64
+	for i, f := range t.Field {
+		field := Field{
+			Name:   f.Name,
+			Type:   typeName(f.Type),
+			Offset: f.ByteOffset,
+			Size:   f.Type.Size(),
+			Align:  a.alignOf(f.Type),
+		}
+		field.Atomic = atomicTypes.MatchString(field.Type) || a.atomics[t.StructName+"."+f.Name]
+		if i > 0 && f.ByteOffset > end {
+			l.Holes = append(l.Holes, Hole{Offset: end, Size: f.ByteOffset - end, After: t.Field[i-1].Name})
+		}
+		if f.ByteOffset+field.Size > end {
+			end = f.ByteOffset + field.Size
+		}
+		l.Fields = append(l.Fields, field)
+	}
+	if n := len(t.Field); n > 0 && l.Size > end {
+		l.Holes = append(l.Holes, Hole{Offset: end, Size: l.Size - end, After: t.Field[n-1].Name})
+	}
+	for _, h := range l.Holes {
+		l.Padding += h.Size
+	}
+
+	// the struct is assumed to start at the beginning of a cache line
+	line := func(off int64) int64 { return off / a.cacheLine }
+	for _, f := range l.Fields {
+		if f.Size > 0 && f.Size <= a.cacheLine && line(f.Offset) != line(f.Offset+f.Size-1) {
+			l.CacheLineCrossings = append(l.CacheLineCrossings, f.Name)
+		}
+	}
+	for i, f := range l.Fields {
+		for _, g := range l.Fields[i+1:] {
+			if f.Atomic && g.Atomic && f.Size > 0 && g.Size > 0 && line(f.Offset+f.Size-1) >= line(g.Offset) {
+				l.FalseSharing = append(l.FalseSharing, [2]string{f.Name, g.Name})
+			}
+		}
+	}
+
+	if order, size := a.reorder(l); size < l.Size {
+		l.Reordered, l.ReorderedSize = order, size
+	}
+	return l
+}
+
+// reorder returns the order of the fields of l sorted by decreasing
+// alignment, and the size of the struct with that order. Zero sized
+// fields go first, a zero sized last field would be padded.
+func (a *analyzer) reorder(l *Layout) ([]string, int64) {
+	fields := append([]Field(nil), l.Fields...)
+	sort.SliceStable(fields, func(i, j int) bool {
+		fi, fj := fields[i], fields[j]
+		if (fi.Size == 0) != (fj.Size == 0) {
+			return fi.Size == 0
+		}
+		if fi.Align != fj.Align {
+			return fi.Align > fj.Align
+		}
+		return fi.Size > fj.Size
+	})
+	var off int64
+	order := make([]string, 0, len(fields))
+	for _, f := range fields {
+		off = alignUp(off, f.Align) + f.Size
+		order = append(order, f.Name)
+	}
+	return order, alignUp(off, l.Align)
+}
+
+// typeName returns the name of t, without the kind of named structs
+func typeName(t godwarf.Type) string {
+	if st, ok := t.(*godwarf.StructType); ok && st.StructName != "" {
+		return st.StructName
+	}
+	return t.String()
+}
+
+// alignOf returns the alignment of t following the rules of the gc
+// compiler, the Align method of godwarf types only looks at the first
+// field of structs and uses the size of complex numbers.
+func (a *analyzer) alignOf(t godwarf.Type) int64 {
+	switch t := t.(type) {
+	case *godwarf.StructType:
+		return a.alignOfFields(t.Field)
+	case *godwarf.SliceType:
+		return a.alignOfFields(t.Field)
+	case *godwarf.StringType:
+		return a.alignOfFields(t.Field)
+	case *godwarf.ArrayType:
+		return a.alignOf(t.Type)
+	case *godwarf.QualType:
+		return a.alignOf(t.Type)
+	case *godwarf.ComplexType:
+		return t.ByteSize / 2
+	case *godwarf.InterfaceType, *godwarf.MapType, *godwarf.ChanType, *godwarf.FuncType, *godwarf.PtrType:
+		return a.ptrSize
+	case *godwarf.TypedefType:
+		return a.alignOf(t.Type)
+	}
+	align := t.Size()
+	if align > a.ptrSize {
+		align = a.ptrSize
+	}
+	if align < 1 {
+		align = 1
+	}
+	return align
+}
+
+func (a *analyzer) alignOfFields(fields []*godwarf.StructField) int64 {
+	align := int64(1)
+	for _, f := range fields {
+		if fa := a.alignOf(f.Type); fa > align {
+			align = fa
+		}
+	}
+	return align
+}
+
+func alignUp(off, align int64) int64 {
+	if align <= 1 {
+		return off
+	}
+	return (off + align - 1) / align * align
+}
+
+// readStructs returns the layouts of the named structs of dw matching
+// filter, sorted by name.
+func (a *analyzer) readStructs(dw *dwarf.Data, filter *regexp.Regexp) ([]*Layout, error) {
+	seen := map[string]bool{}
+	cache := map[dwarf.Offset]godwarf.Type{}
+	var layouts []*Layout
+	r := dw.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagStructType {
+			continue
+		}
+		name, _ := e.Val(dwarf.AttrName).(string)
+		if name == "" || seen[name] || (filter != nil && !filter.MatchString(name)) {
+			continue
+		}
+		typ, err := godwarf.ReadType(dw, 0, e.Offset, cache)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %v", name, err)
+		}
+		st, ok := typ.(*godwarf.StructType)
+		if !ok || st.Incomplete || st.Kind != "struct" {
+			continue
+		}
+		seen[name] = true
+		layouts = append(layouts, a.layout(st))
+	}
+	sort.Slice(layouts, func(i, j int) bool { return layouts[i].Name < layouts[j].Name })
+	return layouts, nil
+}
+
+// report writes the layout in a human readable format.
+func (l *Layout) report(sb *strings.Builder) {
+	fmt.Fprintf(sb, "%s: size %d, align %d, padding %d\n", l.Name, l.Size, l.Align, l.Padding)
+	for _, f := range l.Fields {
+		atomic := ""
+		if f.Atomic {
+			atomic = " (atomic)"
+		}
+		fmt.Fprintf(sb, "  %6d %6d  %s %s%s\n", f.Offset, f.Size, f.Name, f.Type, atomic)
+	}
+	for _, h := range l.Holes {
+		fmt.Fprintf(sb, "  hole of %d bytes at %d after %s\n", h.Size, h.Offset, h.After)
+	}
+	for _, name := range l.CacheLineCrossings {
+		fmt.Fprintf(sb, "  %s crosses a cache line\n", name)
+	}
+	for _, pair := range l.FalseSharing {
+		fmt.Fprintf(sb, "  false sharing: %s and %s share a cache line\n", pair[0], pair[1])
+	}
+	if l.Reordered != nil {
+		fmt.Fprintf(sb, "  reorder to size %d: %s\n", l.ReorderedSize, strings.Join(l.Reordered, ", "))
+	}
+}
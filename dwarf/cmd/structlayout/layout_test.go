@@ -0,0 +1,104 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hitzhangjie/codemaster/dwarf/dwarfbuilder"
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+)
+
+// writeBinary writes an ELF file with the DWARF of
+//
+//	type T struct {
+//		a    bool
+//		b    int64
+//		c    bool
+//		hits atomic.Int64
+//		miss atomic.Int64
+//		buf  [60]byte
+//		n    int64
+//	}
+func writeBinary(t *testing.T) string {
+	b := dwarfbuilder.New()
+	member := func(name string, typ dwarf.Offset, off uint) {
+		b.AddMember(name, typ, dwarfbuilder.LocationBlock(op.DW_OP_plus_uconst, off))
+	}
+	boolType := b.AddBaseType("bool", dwarfbuilder.DW_ATE_boolean, 1)
+	int64Type := b.AddBaseType("int64", dwarfbuilder.DW_ATE_signed, 8)
+	byteType := b.AddBaseType("uint8", dwarfbuilder.DW_ATE_unsigned, 1)
+	bufType := b.TagOpen(dwarf.TagArrayType, "[60]uint8")
+	b.Attr(dwarf.AttrType, byteType)
+	b.Attr(dwarf.AttrByteSize, uint64(60))
+	b.TagOpen(dwarf.TagSubrangeType, "")
+	b.Attr(dwarf.AttrCount, uint64(60))
+	b.TagClose()
+	b.TagClose()
+	atomicType := b.AddStructType("sync/atomic.Int64", 8)
+	member("v", int64Type, 0)
+	b.TagClose()
+
+	b.AddStructType("main.T", 152)
+	member("a", boolType, 0)
+	member("b", int64Type, 8)
+	member("c", boolType, 16)
+	member("hits", atomicType, 24)
+	member("miss", atomicType, 32)
+	member("buf", bufType, 40)
+	member("n", int64Type, 104)
+	b.TagClose()
+
+	sections, err := b.BuildSections()
+	require.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "a.out")
+	f, err := os.Create(path)
+	require.Nil(t, err)
+	defer f.Close()
+	require.Nil(t, dwarfbuilder.WriteELF(f, elf.EM_X86_64, sections, nil, dwarfbuilder.Text{}))
+	return path
+}
+
+func TestLayout(t *testing.T) {
+	a := &analyzer{cacheLine: 64, atomics: map[string]bool{"main.T.n": true}}
+	layouts, err := a.load(writeBinary(t), regexp.MustCompile(`^main\.`))
+	require.Nil(t, err)
+	require.Len(t, layouts, 1)
+	l := layouts[0]
+
+	assert.Equal(t, "main.T", l.Name)
+	assert.Equal(t, int64(152), l.Size)
+	assert.Equal(t, int64(8), l.Align)
+	assert.Equal(t, []Hole{
+		{Offset: 1, Size: 7, After: "a"},
+		{Offset: 17, Size: 7, After: "c"},
+		{Offset: 100, Size: 4, After: "buf"},
+		{Offset: 112, Size: 40, After: "n"},
+	}, l.Holes)
+	assert.Equal(t, int64(58), l.Padding)
+	assert.Equal(t, []string{"buf"}, l.CacheLineCrossings)
+	assert.Equal(t, [][2]string{{"hits", "miss"}}, l.FalseSharing)
+	assert.True(t, l.Fields[6].Atomic)
+
+	// int64s first, then the array and the bools
+	assert.Equal(t, []string{"b", "hits", "miss", "n", "buf", "a", "c"}, l.Reordered)
+	assert.Equal(t, int64(96), l.ReorderedSize)
+
+	var sb strings.Builder
+	l.report(&sb)
+	assert.Contains(t, sb.String(), "false sharing: hits and miss share a cache line")
+	assert.Contains(t, sb.String(), "reorder to size 96: b, hits, miss, n, buf, a, c")
+}
+
+func TestCompare(t *testing.T) {
+	old := []*Layout{{Name: "main.T", Size: 16}, {Name: "main.U", Size: 8}, {Name: "main.Gone", Size: 8}}
+	cur := []*Layout{{Name: "main.T", Size: 24, Padding: 8}, {Name: "main.U", Size: 8}}
+	assert.Equal(t, []string{"main.T grew from 16 to 24 bytes (padding 0 to 8)"}, compare(old, cur))
+}
@@ -0,0 +1,143 @@
+// Command structlayout reports the memory layout of the structs of a
+// binary, read from its DWARF: size, padding holes, fields crossing cache
+// lines and atomic fields sharing a cache line, with a field order that
+// minimises the size.
+//
+// Usage:
+//
+//	structlayout [flags] binary
+//
+// With -baseline the sizes are compared to those of a previous -json
+// report and the command fails if a struct grew, to catch regressions of
+// hot structs in CI.
+package main
+
+import (
+	"debug/elf"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+)
+
+func main() {
+	var (
+		match      = flag.String("match", "", "only report the structs whose name matches `regexp`")
+		cacheLine  = flag.Int64("cacheline", 64, "cache line size in bytes")
+		atomics    = flag.String("atomic", "", "comma separated `struct.field` list of fields accessed atomically")
+		minPadding = flag.Int64("min-padding", 0, "only report the structs with at least `n` bytes of padding")
+		asJSON     = flag.Bool("json", false, "write the report as JSON")
+		baseline   = flag.String("baseline", "", "fail if a struct is larger than in the JSON report `file`")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: structlayout [flags] binary\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var filter *regexp.Regexp
+	if *match != "" {
+		var err error
+		if filter, err = regexp.Compile(*match); err != nil {
+			fatal(err)
+		}
+	}
+	a := &analyzer{cacheLine: *cacheLine, atomics: map[string]bool{}}
+	for _, name := range strings.Split(*atomics, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			a.atomics[name] = true
+		}
+	}
+
+	layouts, err := a.load(flag.Arg(0), filter)
+	if err != nil {
+		fatal(err)
+	}
+	var reported []*Layout
+	for _, l := range layouts {
+		if l.Padding >= *minPadding {
+			reported = append(reported, l)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reported); err != nil {
+			fatal(err)
+		}
+	} else {
+		var sb strings.Builder
+		for _, l := range reported {
+			l.report(&sb)
+			sb.WriteString("\n")
+		}
+		io.WriteString(os.Stdout, sb.String())
+	}
+
+	if *baseline != "" {
+		data, err := ioutil.ReadFile(*baseline)
+		if err != nil {
+			fatal(err)
+		}
+		var old []*Layout
+		if err := json.Unmarshal(data, &old); err != nil {
+			fatal(fmt.Errorf("could not read baseline: %v", err))
+		}
+		if grown := compare(old, layouts); len(grown) > 0 {
+			for _, msg := range grown {
+				fmt.Fprintln(os.Stderr, msg)
+			}
+			os.Exit(1)
+		}
+	}
+}
+
+// load returns the layouts of the structs of the binary at path.
+func (a *analyzer) load(path string, filter *regexp.Regexp) ([]*Layout, error) {
+	f, err := godwarf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dw, err := f.DWARF()
+	if err != nil {
+		return nil, err
+	}
+	a.ptrSize = 8
+	if f.Class == elf.ELFCLASS32 {
+		a.ptrSize = 4
+	}
+	return a.readStructs(dw, filter)
+}
+
+// compare returns a message for each struct of old that is larger in
+// layouts.
+func compare(old, layouts []*Layout) []string {
+	cur := map[string]*Layout{}
+	for _, l := range layouts {
+		cur[l.Name] = l
+	}
+	var grown []string
+	for _, o := range old {
+		if l, ok := cur[o.Name]; ok && l.Size > o.Size {
+			grown = append(grown, fmt.Sprintf("%s grew from %d to %d bytes (padding %d to %d)", o.Name, o.Size, l.Size, o.Padding, l.Padding))
+		}
+	}
+	return grown
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "structlayout:", err)
+	os.Exit(1)
+}
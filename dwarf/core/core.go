@@ -0,0 +1,187 @@
+// Package core reads ELF core files of Go processes on linux/amd64.
+//
+// The memory of the process is rebuilt from the PT_LOAD segments of the
+// core, completed by the executable for the mappings the kernel didn't
+// dump. Threads come from the NT_PRSTATUS notes, goroutines are found by
+// walking runtime.allgs with the DWARF of the executable.
+package core
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/hitzhangjie/codemaster/dwarf/eval"
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+	"github.com/hitzhangjie/codemaster/dwarf/loclist"
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+	"github.com/hitzhangjie/codemaster/dwarf/stack"
+)
+
+// Core is a core file and the executable it was dumped from.
+type Core struct {
+	Threads []*Thread
+
+	// StaticBase is the address the executable was loaded at, non-zero
+	// for position independent executables.
+	StaticBase uint64
+
+	Dwarf    *dwarf.Data
+	Unwinder *stack.Unwinder
+
+	mem       *eval.Snapshot
+	loclist   loclist.Reader
+	debugAddr *godwarf.DebugAddrSection
+}
+
+// Open opens the core file core dumped by the executable exe. The DWARF of
+// a stripped exe is read from its separate debug file, see godwarf.Open.
+func Open(core, exe string) (*Core, error) {
+	cf, err := elf.Open(core)
+	if err != nil {
+		return nil, err
+	}
+	defer cf.Close()
+	if cf.Type != elf.ET_CORE {
+		return nil, fmt.Errorf("%s is not a core file", core)
+	}
+	if cf.Machine != elf.EM_X86_64 {
+		return nil, fmt.Errorf("unsupported architecture %s", cf.Machine)
+	}
+
+	ef, err := godwarf.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+	defer ef.Close()
+
+	c := &Core{mem: &eval.Snapshot{}}
+	entry, err := c.readNotes(cf)
+	if err != nil {
+		return nil, err
+	}
+	if ef.Type == elf.ET_DYN && entry != 0 {
+		c.StaticBase = entry - ef.Entry
+	}
+	if err := c.loadMemory(cf, ef.File); err != nil {
+		return nil, err
+	}
+
+	if c.Dwarf, err = ef.DWARF(); err != nil {
+		return nil, err
+	}
+	if c.Unwinder, err = stack.NewFromFile(ef, c.StaticBase); err != nil {
+		return nil, err
+	}
+	if data, _ := godwarf.GetDebugSection(ef, "loc"); data != nil {
+		c.loclist = loclist.NewDwarf2Reader(data, 8)
+	} else if data, _ := godwarf.GetDebugSection(ef, "loclists"); data != nil {
+		c.loclist = loclist.NewDwarf5Reader(data)
+	}
+	if data, _ := godwarf.GetDebugSection(ef, "addr"); data != nil {
+		c.debugAddr = godwarf.ParseAddr(data)
+	}
+	return c, nil
+}
+
+// loadMemory maps the PT_LOAD segments of the core. The part of a segment
+// past its file size wasn't dumped, it's read from the executable if it
+// maps it, e.g. the text of the program.
+func (c *Core) loadMemory(cf, ef *elf.File) error {
+	for _, p := range cf.Progs {
+		if p.Type != elf.PT_LOAD {
+			continue
+		}
+		if p.Filesz > 0 {
+			data := make([]byte, p.Filesz)
+			if _, err := io.ReadFull(p.Open(), data); err != nil {
+				return fmt.Errorf("could not read segment at %#x: %v", p.Vaddr, err)
+			}
+			c.mem.Add(p.Vaddr, data)
+		}
+
+		for _, e := range ef.Progs {
+			if e.Type != elf.PT_LOAD || e.Filesz == 0 {
+				continue
+			}
+			lo := maxUint64(p.Vaddr+p.Filesz, e.Vaddr+c.StaticBase)
+			hi := minUint64(p.Vaddr+p.Memsz, e.Vaddr+c.StaticBase+e.Filesz)
+			if lo >= hi {
+				continue
+			}
+			data := make([]byte, hi-lo)
+			if _, err := e.ReadAt(data, int64(lo-e.Vaddr-c.StaticBase)); err != nil {
+				return fmt.Errorf("could not read executable segment at %#x: %v", e.Vaddr, err)
+			}
+			c.mem.Add(lo, data)
+		}
+	}
+	return nil
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// ReadMemory implements op.ReadMemoryFunc.
+func (c *Core) ReadMemory(buf []byte, addr uint64) (int, error) {
+	return c.mem.ReadMemory(buf, addr)
+}
+
+func (c *Core) readUint(addr uint64, size int64) (uint64, error) {
+	buf := make([]byte, 8)
+	if size > 8 {
+		return 0, fmt.Errorf("can't read %d bytes integer", size)
+	}
+	if _, err := c.ReadMemory(buf[:size], addr); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// Stack returns the call stack of thread th.
+func (c *Core) Stack(th *Thread, depth int) ([]stack.Frame, error) {
+	return c.Unwinder.Stack(th.Regs.Dwarf(c.StaticBase), c.ReadMemory, depth)
+}
+
+// Scope returns the scope to evaluate the variables of frame f.
+func (c *Core) Scope(f stack.Frame) (*eval.Scope, error) {
+	s, err := eval.New(c.Dwarf, c.ReadMemory, f.Regs, f.PC)
+	if err != nil {
+		return nil, err
+	}
+	s.Line = f.Line
+	s.Loclist, s.DebugAddr = c.loclist, c.debugAddr
+	return s, nil
+}
+
+// Global returns the value of the package variable name, e.g. "main.x".
+func (c *Core) Global(name string) (*eval.Variable, error) {
+	s, err := c.globalScope()
+	if err != nil {
+		return nil, err
+	}
+	return s.Global(name)
+}
+
+func (c *Core) globalScope() (*eval.Scope, error) {
+	regs := op.DwarfRegisters{StaticBase: c.StaticBase, ByteOrder: binary.LittleEndian}
+	s, err := eval.New(c.Dwarf, c.ReadMemory, regs, 0)
+	if err != nil {
+		return nil, err
+	}
+	s.Loclist, s.DebugAddr = c.loclist, c.debugAddr
+	return s, nil
+}
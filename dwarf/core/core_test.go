@@ -0,0 +1,156 @@
+package core
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hitzhangjie/codemaster/dwarf/stack"
+)
+
+// crashCore builds testdata/crash and runs it with GOTRACEBACK=crash, it
+// returns the paths of the executable and of the core it dumped.
+func crashCore(t *testing.T) (string, string) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("needs a linux/amd64 core")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not found")
+	}
+	pattern, err := ioutil.ReadFile("/proc/sys/kernel/core_pattern")
+	if err != nil || !strings.HasPrefix(string(pattern), "core") {
+		t.Skipf("cores aren't dumped in the working directory: %q", pattern)
+	}
+
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "crash")
+	build := exec.Command(gobin, "build", "-gcflags=all=-N -l", "-o", exe, "./testdata/crash")
+	out, err := build.CombinedOutput()
+	require.Nil(t, err, string(out))
+
+	var rlim syscall.Rlimit
+	require.Nil(t, syscall.Getrlimit(syscall.RLIMIT_CORE, &rlim))
+	defer syscall.Setrlimit(syscall.RLIMIT_CORE, &rlim)
+	if err := syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{Cur: rlim.Max, Max: rlim.Max}); err != nil || rlim.Max == 0 {
+		t.Skip("can't enable core dumps")
+	}
+
+	cmd := exec.Command(exe)
+	cmd.Dir = dir
+	cmd.Env = append(cmd.Environ(), "GOTRACEBACK=crash")
+	out, err = cmd.CombinedOutput()
+	require.NotNil(t, err)
+	require.Contains(t, string(out), "crashing")
+
+	cores, _ := filepath.Glob(filepath.Join(dir, "core*"))
+	if len(cores) == 0 {
+		t.Skip("no core dumped")
+	}
+	return exe, cores[0]
+}
+
+func functions(frames []stack.Frame) []string {
+	var fns []string
+	for _, f := range frames {
+		fns = append(fns, f.Function)
+	}
+	return fns
+}
+
+func TestCore(t *testing.T) {
+	exe, corefile := crashCore(t)
+	c, err := Open(corefile, exe)
+	require.Nil(t, err)
+
+	t.Run("threads", func(t *testing.T) {
+		require.NotEmpty(t, c.Threads)
+		for _, th := range c.Threads {
+			assert.NotZero(t, th.ID)
+			assert.NotZero(t, th.Regs.Rip)
+			assert.Equal(t, int(syscall.SIGABRT), th.Signal)
+		}
+
+		// the thread that dumped the core comes first, its stack goes
+		// through the signal handler up to the crashed goroutine
+		frames, err := c.Stack(c.Threads[0], 50)
+		require.Nil(t, err)
+		fns := functions(frames)
+		assert.Equal(t, "runtime.raise", fns[0])
+		assert.Contains(t, fns, "runtime.sigtramp")
+		assert.Contains(t, fns, "main.main")
+	})
+
+	t.Run("globals", func(t *testing.T) {
+		v, err := c.Global("main.message")
+		require.Nil(t, err)
+		assert.Equal(t, "hello core", v.Value)
+
+		v, err = c.Global("main.counter")
+		require.Nil(t, err)
+		assert.Equal(t, int64(42), v.Value)
+	})
+
+	gs, err := c.Goroutines()
+	require.Nil(t, err)
+	stacks := map[string][]stack.Frame{}
+	for _, g := range gs {
+		assert.NotZero(t, g.ID)
+		frames, err := c.GoroutineStack(g, 50)
+		if err != nil {
+			continue
+		}
+		for _, f := range frames {
+			if strings.HasPrefix(f.Function, "main.") {
+				stacks[f.Function] = frames
+			}
+		}
+		if g.ID == 1 {
+			assert.Equal(t, "running", g.StatusString())
+			assert.NotNil(t, g.Thread)
+		}
+	}
+
+	t.Run("crashed goroutine", func(t *testing.T) {
+		frames, ok := stacks["main.main"]
+		require.True(t, ok, "main.main not found in any goroutine")
+		assert.Contains(t, functions(frames), "runtime.main")
+	})
+
+	t.Run("blocked goroutine", func(t *testing.T) {
+		frames, ok := stacks["main.blocked"]
+		require.True(t, ok, "main.blocked not found in any goroutine")
+		fns := functions(frames)
+		assert.Equal(t, "runtime.gopark", fns[0])
+		assert.Equal(t, "runtime.goexit", fns[len(fns)-1])
+
+		var f stack.Frame
+		for _, f = range frames {
+			if f.Function == "main.blocked" {
+				break
+			}
+		}
+		s, err := c.Scope(f)
+		require.Nil(t, err)
+		args, err := s.Args()
+		require.Nil(t, err)
+		vars := map[string]interface{}{}
+		for _, v := range args {
+			vars[v.Name] = v.Value
+		}
+		locals, err := s.Locals()
+		require.Nil(t, err)
+		for _, v := range locals {
+			vars[v.Name] = v.Value
+		}
+		assert.Equal(t, int64(21), vars["n"])
+		assert.Equal(t, int64(42), vars["local"])
+	})
+}
@@ -0,0 +1,219 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hitzhangjie/codemaster/dwarf/eval"
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+	"github.com/hitzhangjie/codemaster/dwarf/stack"
+)
+
+// Goroutine is a goroutine read from runtime.allgs.
+type Goroutine struct {
+	ID     uint64
+	Status uint32 // runtime._Grunning etc.
+	Addr   uint64 // address of the runtime.g
+
+	// PC, SP and BP are saved in g.sched when the goroutine is switched
+	// out, or runs on the system stack.
+	PC, SP, BP uint64
+
+	// StackLo and StackHi are the bounds of the goroutine stack.
+	StackLo, StackHi uint64
+
+	// Thread is the thread running the goroutine, nil if it's not
+	// running.
+	Thread *Thread
+}
+
+// goroutine statuses, see runtime/runtime2.go
+var statuses = []string{
+	0: "idle",
+	1: "runnable",
+	2: "running",
+	3: "syscall",
+	4: "waiting",
+	6: "dead",
+	8: "copystack",
+	9: "preempted",
+}
+
+// StatusString returns the name of the status of g.
+func (g *Goroutine) StatusString() string {
+	// scan states add 0x1000 to the status
+	st := g.Status &^ 0x1000
+	if int(st) < len(statuses) && statuses[st] != "" {
+		return statuses[st]
+	}
+	return fmt.Sprintf("status %d", g.Status)
+}
+
+const gDead = 6
+
+// Goroutines returns the goroutines of the process, dead ones excluded.
+func (c *Core) Goroutines() ([]*Goroutine, error) {
+	s, err := c.globalScope()
+	if err != nil {
+		return nil, err
+	}
+	// only the slice header is needed
+	s.Config = eval.LoadConfig{MaxVariableRecurse: -1}
+	allgs, err := s.Global("runtime.allgs")
+	if err != nil {
+		return nil, err
+	}
+	if allgs.Unreadable != nil {
+		return nil, fmt.Errorf("could not read runtime.allgs: %v", allgs.Unreadable)
+	}
+	st, ok := allgs.Type.(*godwarf.SliceType)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type of runtime.allgs %s", allgs.Type)
+	}
+	ptr, ok := resolveTypedef(st.ElemType).(*godwarf.PtrType)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type of runtime.allgs %s", allgs.Type)
+	}
+	gtype := ptr.Type
+
+	// the thread running each m
+	threads := map[uint64]*Thread{}
+	for _, th := range c.Threads {
+		threads[uint64(th.ID)] = th
+	}
+
+	array, _ := allgs.Value.(uint64)
+	var gs []*Goroutine
+	for i := int64(0); i < allgs.Len; i++ {
+		addr, err := c.readUint(array+uint64(i)*8, 8)
+		if err != nil {
+			return nil, err
+		}
+		g, err := c.readG(addr, gtype, threads)
+		if err != nil {
+			return nil, fmt.Errorf("could not read goroutine at %#x: %v", addr, err)
+		}
+		if g.Status != gDead {
+			gs = append(gs, g)
+		}
+	}
+	return gs, nil
+}
+
+// readG reads the runtime.g at addr
+func (c *Core) readG(addr uint64, gtype godwarf.Type, threads map[uint64]*Thread) (*Goroutine, error) {
+	g := &Goroutine{Addr: addr}
+	var err error
+	read := func(dst *uint64, path ...string) {
+		if err == nil {
+			*dst, err = c.readField(addr, gtype, path...)
+		}
+	}
+	var status, m uint64
+	read(&g.ID, "goid")
+	read(&status, "atomicstatus")
+	read(&g.PC, "sched", "pc")
+	read(&g.SP, "sched", "sp")
+	read(&g.BP, "sched", "bp")
+	read(&g.StackLo, "stack", "lo")
+	read(&g.StackHi, "stack", "hi")
+	read(&m, "m")
+	if err != nil {
+		return nil, err
+	}
+	g.Status = uint32(status)
+
+	if m != 0 {
+		mtype, err := fieldType(gtype, "m")
+		if err != nil {
+			return nil, err
+		}
+		ptr, ok := mtype.(*godwarf.PtrType)
+		if !ok {
+			return nil, fmt.Errorf("unexpected type of g.m %s", mtype)
+		}
+		// the goroutine is running if it's the current one of its m
+		curg, err := c.readField(m, ptr.Type, "curg")
+		if err != nil {
+			return nil, err
+		}
+		procid, err := c.readField(m, ptr.Type, "procid")
+		if err != nil {
+			return nil, err
+		}
+		if curg == addr {
+			g.Thread = threads[procid]
+		}
+	}
+	return g, nil
+}
+
+// GoroutineStack returns the call stack of g.
+//
+// The registers of the thread are used for running goroutines, unless the
+// thread is on the system stack, then g.sched holds where the goroutine
+// switched to it. A goroutine running a signal handler has no g.sched,
+// its stack is found by unwinding the signal frame.
+func (c *Core) GoroutineStack(g *Goroutine, depth int) ([]stack.Frame, error) {
+	if th := g.Thread; th != nil && (g.SP == 0 || th.Regs.Rsp >= g.StackLo && th.Regs.Rsp < g.StackHi) {
+		return c.Stack(th, depth)
+	}
+	if g.PC == 0 {
+		return nil, errors.New("goroutine has no saved registers")
+	}
+	regs := stack.AMD64.Registers(c.StaticBase, g.PC, g.SP, g.BP)
+	return c.Unwinder.Stack(regs, c.ReadMemory, depth)
+}
+
+// readField reads the integer field at path in the struct of type typ at
+// addr. Atomic types such as atomic.Uint32 are read through their value.
+func (c *Core) readField(addr uint64, typ godwarf.Type, path ...string) (uint64, error) {
+	for _, name := range path {
+		f, err := field(typ, name)
+		if err != nil {
+			return 0, err
+		}
+		addr += uint64(f.ByteOffset)
+		typ = f.Type
+	}
+	if st, ok := resolveTypedef(typ).(*godwarf.StructType); ok {
+		f, err := field(st, "value")
+		if err != nil {
+			return 0, err
+		}
+		addr += uint64(f.ByteOffset)
+		typ = f.Type
+	}
+	return c.readUint(addr, typ.Size())
+}
+
+func fieldType(typ godwarf.Type, name string) (godwarf.Type, error) {
+	f, err := field(typ, name)
+	if err != nil {
+		return nil, err
+	}
+	return resolveTypedef(f.Type), nil
+}
+
+func field(typ godwarf.Type, name string) (*godwarf.StructField, error) {
+	st, ok := resolveTypedef(typ).(*godwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a struct", typ)
+	}
+	for _, f := range st.Field {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no field %s", typ, name)
+}
+
+func resolveTypedef(typ godwarf.Type) godwarf.Type {
+	for {
+		t, ok := typ.(*godwarf.TypedefType)
+		if !ok {
+			return typ
+		}
+		typ = t.Type
+	}
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+	"github.com/hitzhangjie/codemaster/dwarf/regnum"
+	"github.com/hitzhangjie/codemaster/dwarf/stack"
+)
+
+// Thread is a thread of the process, described by a NT_PRSTATUS note.
+type Thread struct {
+	ID     int // LWP id
+	Signal int // signal being delivered when the core was dumped
+	Regs   Registers
+}
+
+// Registers are the general purpose registers of an amd64 thread, laid
+// out as the kernel's user_regs_struct.
+type Registers struct {
+	R15, R14, R13, R12, Rbp, Rbx, R11, R10 uint64
+	R9, R8, Rax, Rcx, Rdx, Rsi, Rdi        uint64
+	OrigRax, Rip, Cs, Eflags, Rsp, Ss      uint64
+	FsBase, GsBase, Ds, Es, Fs, Gs         uint64
+}
+
+// Dwarf returns the registers numbered as in DWARF.
+func (r *Registers) Dwarf(staticBase uint64) *op.DwarfRegisters {
+	regs := stack.AMD64.Registers(staticBase, r.Rip, r.Rsp, r.Rbp)
+	for num, val := range map[uint64]uint64{
+		regnum.AMD64_Rax: r.Rax,
+		regnum.AMD64_Rdx: r.Rdx,
+		regnum.AMD64_Rcx: r.Rcx,
+		regnum.AMD64_Rbx: r.Rbx,
+		regnum.AMD64_Rsi: r.Rsi,
+		regnum.AMD64_Rdi: r.Rdi,
+		regnum.AMD64_R8:  r.R8,
+		regnum.AMD64_R9:  r.R9,
+		regnum.AMD64_R10: r.R10,
+		regnum.AMD64_R11: r.R11,
+		regnum.AMD64_R12: r.R12,
+		regnum.AMD64_R13: r.R13,
+		regnum.AMD64_R14: r.R14,
+		regnum.AMD64_R15: r.R15,
+	} {
+		regs.AddReg(num, op.DwarfRegisterFromUint64(val))
+	}
+	return regs
+}
+
+// note types, see linux/elf.h
+const (
+	ntPrstatus = 1
+	ntAuxv     = 6
+)
+
+// auxv entry holding the entry point of the program
+const atEntry = 9
+
+// readNotes reads the threads of the core, and returns the entry point of
+// the program found in the auxiliary vector.
+func (c *Core) readNotes(cf *elf.File) (entry uint64, err error) {
+	for _, p := range cf.Progs {
+		if p.Type != elf.PT_NOTE {
+			continue
+		}
+		data, err := ioutil.ReadAll(p.Open())
+		if err != nil {
+			return 0, err
+		}
+		for len(data) > 0 {
+			var typ uint32
+			var desc []byte
+			if typ, desc, data, err = nextNote(data); err != nil {
+				return 0, err
+			}
+			switch typ {
+			case ntPrstatus:
+				th, err := parsePrstatus(desc)
+				if err != nil {
+					return 0, err
+				}
+				c.Threads = append(c.Threads, th)
+			case ntAuxv:
+				for ; len(desc) >= 16; desc = desc[16:] {
+					if binary.LittleEndian.Uint64(desc) == atEntry {
+						entry = binary.LittleEndian.Uint64(desc[8:])
+					}
+				}
+			}
+		}
+	}
+	if len(c.Threads) == 0 {
+		return 0, errors.New("no NT_PRSTATUS note in core file")
+	}
+	return entry, nil
+}
+
+// nextNote decodes the note at the start of data, name and descriptor are
+// padded to 4 bytes.
+func nextNote(data []byte) (typ uint32, desc, rest []byte, err error) {
+	if len(data) < 12 {
+		return 0, nil, nil, errors.New("truncated note")
+	}
+	namesz := binary.LittleEndian.Uint32(data)
+	descsz := binary.LittleEndian.Uint32(data[4:])
+	typ = binary.LittleEndian.Uint32(data[8:])
+	off := 12 + align4(namesz)
+	end := off + align4(descsz)
+	if uint64(end) > uint64(len(data)) {
+		return 0, nil, nil, fmt.Errorf("truncated note of type %d", typ)
+	}
+	return typ, data[off : off+descsz], data[end:], nil
+}
+
+func align4(n uint32) uint32 {
+	return (n + 3) &^ 3
+}
+
+// offsets in struct elf_prstatus on amd64
+const (
+	prstatusCursig = 12
+	prstatusPid    = 32
+	prstatusReg    = 112
+)
+
+func parsePrstatus(desc []byte) (*Thread, error) {
+	var regs Registers
+	if len(desc) < prstatusReg+binary.Size(regs) {
+		return nil, fmt.Errorf("NT_PRSTATUS note too short: %d bytes", len(desc))
+	}
+	th := &Thread{
+		ID:     int(int32(binary.LittleEndian.Uint32(desc[prstatusPid:]))),
+		Signal: int(binary.LittleEndian.Uint16(desc[prstatusCursig:])),
+	}
+	if err := binary.Read(bytes.NewReader(desc[prstatusReg:]), binary.LittleEndian, &th.Regs); err != nil {
+		return nil, err
+	}
+	return th, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "os"
+
+var message = "hello core"
+
+var counter = 42
+
+//go:noinline
+func blocked(ready chan<- bool, n int) {
+	local := n * 2
+	ready <- true
+	select {}
+	println(local)
+}
+
+func main() {
+	ready := make(chan bool)
+	go blocked(ready, 21)
+	<-ready
+
+	os.Stdout.WriteString(message + ", crashing\n")
+	var p *int
+	*p = counter
+}
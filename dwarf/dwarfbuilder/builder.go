@@ -13,6 +13,8 @@ import (
 type Builder struct {
 	info     bytes.Buffer
 	loc      bytes.Buffer
+	line     bytes.Buffer
+	frame    *FrameBuilder
 	abbrevs  []tagDescr
 	tagStack []*tagState
 }
@@ -47,6 +49,26 @@ func (b *Builder) Build() (abbrev, aranges, frame, info, line, pubnames, ranges,
 	info = b.info.Bytes()
 	binary.LittleEndian.PutUint32(info, uint32(len(info)-4))
 	loc = b.loc.Bytes()
+	line = b.line.Bytes()
+	if b.frame != nil {
+		frame = b.frame.Bytes()
+	}
 
 	return
 }
+
+// AddLineProgram appends p to debug_line and returns its offset, the value
+// of the DW_AT_stmt_list attribute of the compile unit.
+func (b *Builder) AddLineProgram(p *LineProgram) SecOffset {
+	off := SecOffset(b.line.Len())
+	b.line.Write(p.Bytes())
+	return off
+}
+
+// Frame returns the builder of debug_frame.
+func (b *Builder) Frame() *FrameBuilder {
+	if b.frame == nil {
+		b.frame = NewFrameBuilder()
+	}
+	return b.frame
+}
@@ -0,0 +1,349 @@
+package dwarfbuilder
+
+import (
+	"bytes"
+	"compress/zlib"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Compression is the way a section is compressed in the ELF file.
+type Compression int
+
+const (
+	// CompressNone writes the section as is.
+	CompressNone Compression = iota
+	// CompressZlibGNU writes a .zdebug_ section, the data prefixed by
+	// "ZLIB" and the uncompressed size, the format read by
+	// godwarf.GetDebugSection.
+	CompressZlibGNU
+	// CompressZlib writes a SHF_COMPRESSED section with ELFCOMPRESS_ZLIB.
+	CompressZlib
+	// CompressZstd writes a SHF_COMPRESSED section with ELFCOMPRESS_ZSTD.
+	CompressZstd
+)
+
+// Section is a section of the ELF file written by WriteELF.
+type Section struct {
+	Name     string
+	Data     []byte
+	Compress Compression
+}
+
+// Symbol is an entry of the symbol table, symbols in the address range of
+// the text are defined in .text, the others are absolute.
+type Symbol struct {
+	Name  string
+	Value uint64
+	Size  uint64
+	Type  elf.SymType
+}
+
+// Text is the code of the ELF file, loaded at Addr.
+type Text struct {
+	Addr uint64
+	Data []byte
+}
+
+// BuildSections closes b and returns its non empty sections, ready to be
+// written by WriteELF.
+func (b *Builder) BuildSections() ([]Section, error) {
+	abbrev, aranges, frame, info, line, pubnames, ranges, str, loc, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	var sections []Section
+	for _, s := range []Section{
+		{Name: ".debug_abbrev", Data: abbrev},
+		{Name: ".debug_aranges", Data: aranges},
+		{Name: ".debug_frame", Data: frame},
+		{Name: ".debug_info", Data: info},
+		{Name: ".debug_line", Data: line},
+		{Name: ".debug_pubnames", Data: pubnames},
+		{Name: ".debug_ranges", Data: ranges},
+		{Name: ".debug_str", Data: str},
+		{Name: ".debug_loc", Data: loc},
+	} {
+		if len(s.Data) > 0 {
+			sections = append(sections, s)
+		}
+	}
+	return sections, nil
+}
+
+const (
+	elfHeaderSize     = 64
+	elfProgHeaderSize = 56
+	elfSectHeaderSize = 64
+	elfSymSize        = 24
+	elfChdrSize       = 24
+	elfPageSize       = 0x1000
+)
+
+// elfSection is a section header and the data of the section
+type elfSection struct {
+	elf.Section64
+	name string
+	data []byte
+}
+
+// WriteELF writes a little endian ELF64 executable for machine arch, with
+// text in a loadable segment, the symbol table of symbols and sections.
+func WriteELF(w io.Writer, arch elf.Machine, sections []Section, symbols []Symbol, text Text) error {
+	var shstrtab stringTable
+	shstrtab.add("")
+
+	sects := []*elfSection{{}} // SHN_UNDEF
+	var textIdx uint16
+	if len(text.Data) > 0 {
+		textIdx = uint16(len(sects))
+		sects = append(sects, &elfSection{
+			Section64: elf.Section64{
+				Type:      uint32(elf.SHT_PROGBITS),
+				Flags:     uint64(elf.SHF_ALLOC | elf.SHF_EXECINSTR),
+				Addr:      text.Addr,
+				Addralign: 16,
+			},
+			name: ".text",
+			data: text.Data,
+		})
+	}
+	for _, s := range sections {
+		sect, err := compressSection(s)
+		if err != nil {
+			return fmt.Errorf("could not compress %s: %v", s.Name, err)
+		}
+		sects = append(sects, sect)
+	}
+
+	var strtab stringTable
+	strtab.add("")
+	var symtab bytes.Buffer
+	symtab.Write(make([]byte, elfSymSize))
+	for _, sym := range symbols {
+		shndx := uint16(elf.SHN_ABS)
+		if textIdx != 0 && sym.Value >= text.Addr && sym.Value < text.Addr+uint64(len(text.Data)) {
+			shndx = textIdx
+		}
+		binary.Write(&symtab, binary.LittleEndian, elf.Sym64{
+			Name:  strtab.add(sym.Name),
+			Info:  elf.ST_INFO(elf.STB_GLOBAL, sym.Type),
+			Shndx: shndx,
+			Value: sym.Value,
+			Size:  sym.Size,
+		})
+	}
+	symtabIdx := len(sects)
+	sects = append(sects,
+		&elfSection{
+			Section64: elf.Section64{
+				Type:      uint32(elf.SHT_SYMTAB),
+				Link:      uint32(symtabIdx + 1),
+				Info:      1, // the first global symbol
+				Addralign: 8,
+				Entsize:   elfSymSize,
+			},
+			name: ".symtab",
+			data: symtab.Bytes(),
+		},
+		&elfSection{
+			Section64: elf.Section64{Type: uint32(elf.SHT_STRTAB), Addralign: 1},
+			name:      ".strtab",
+			data:      strtab.Bytes(),
+		})
+	shstrndx := len(sects)
+	sects = append(sects, &elfSection{
+		Section64: elf.Section64{Type: uint32(elf.SHT_STRTAB), Addralign: 1},
+		name:      ".shstrtab",
+	})
+	for _, s := range sects[1:] {
+		s.Name = shstrtab.add(s.name)
+	}
+	sects[shstrndx].data = shstrtab.Bytes()
+
+	// layout: headers, text at an offset congruent to its address modulo
+	// the page size, then the other sections and the section headers
+	var phnum uint16
+	off := uint64(elfHeaderSize)
+	if textIdx != 0 {
+		phnum = 1
+		off += elfProgHeaderSize
+	}
+	for _, s := range sects[1:] {
+		if s.Flags&uint64(elf.SHF_ALLOC) != 0 {
+			off = alignUp(off, elfPageSize) + s.Addr%elfPageSize
+		} else if s.Addralign > 1 {
+			off = alignUp(off, s.Addralign)
+		}
+		s.Off = off
+		s.Size = uint64(len(s.data))
+		off += s.Size
+	}
+	shoff := alignUp(off, 8)
+
+	var out bytes.Buffer
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(arch),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     elfHeaderSize,
+		Shoff:     shoff,
+		Ehsize:    elfHeaderSize,
+		Phentsize: elfProgHeaderSize,
+		Phnum:     phnum,
+		Shentsize: elfSectHeaderSize,
+		Shnum:     uint16(len(sects)),
+		Shstrndx:  uint16(shstrndx),
+	}
+	if phnum == 0 {
+		hdr.Phoff = 0
+	} else {
+		hdr.Entry = text.Addr
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	hdr.Ident[elf.EI_OSABI] = byte(elf.ELFOSABI_NONE)
+	binary.Write(&out, binary.LittleEndian, hdr)
+	if textIdx != 0 {
+		s := sects[textIdx]
+		binary.Write(&out, binary.LittleEndian, elf.Prog64{
+			Type:   uint32(elf.PT_LOAD),
+			Flags:  uint32(elf.PF_R | elf.PF_X),
+			Off:    s.Off,
+			Vaddr:  s.Addr,
+			Paddr:  s.Addr,
+			Filesz: s.Size,
+			Memsz:  s.Size,
+			Align:  elfPageSize,
+		})
+	}
+	for _, s := range sects[1:] {
+		out.Write(make([]byte, int(s.Off)-out.Len()))
+		out.Write(s.data)
+	}
+	out.Write(make([]byte, int(shoff)-out.Len()))
+	for _, s := range sects {
+		binary.Write(&out, binary.LittleEndian, s.Section64)
+	}
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// compressSection returns the section header and data of s
+func compressSection(s Section) (*elfSection, error) {
+	sect := &elfSection{
+		Section64: elf.Section64{Type: uint32(elf.SHT_PROGBITS), Addralign: 1},
+		name:      s.Name,
+		data:      s.Data,
+	}
+	var buf bytes.Buffer
+	switch s.Compress {
+	case CompressNone:
+		return sect, nil
+
+	case CompressZlibGNU:
+		if !strings.HasPrefix(s.Name, ".debug_") {
+			return nil, fmt.Errorf("not a debug section")
+		}
+		sect.name = ".zdebug_" + strings.TrimPrefix(s.Name, ".debug_")
+		buf.WriteString("ZLIB")
+		binary.Write(&buf, binary.BigEndian, uint64(len(s.Data)))
+		if err := writeZlib(&buf, s.Data); err != nil {
+			return nil, err
+		}
+
+	case CompressZlib, CompressZstd:
+		typ := elf.COMPRESS_ZLIB
+		if s.Compress == CompressZstd {
+			typ = elf.COMPRESS_ZSTD
+		}
+		binary.Write(&buf, binary.LittleEndian, elf.Chdr64{
+			Type:      uint32(typ),
+			Size:      uint64(len(s.Data)),
+			Addralign: 1,
+		})
+		var err error
+		if typ == elf.COMPRESS_ZLIB {
+			err = writeZlib(&buf, s.Data)
+		} else {
+			err = writeZstd(&buf, s.Data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sect.Flags |= uint64(elf.SHF_COMPRESSED)
+		sect.Addralign = 8
+
+	default:
+		return nil, fmt.Errorf("unknown compression %d", s.Compress)
+	}
+	sect.data = buf.Bytes()
+	return sect, nil
+}
+
+func writeZlib(w io.Writer, data []byte) error {
+	zw := zlib.NewWriter(w)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// zstd frame constants (see RFC 8878)
+const (
+	zstdMagic        = 0xFD2FB528
+	zstdMaxBlockSize = 128 << 10
+)
+
+// writeZstd writes data as a zstd frame of raw blocks, stored without
+// compression: the standard library has no zstd encoder and readers only
+// need a valid frame.
+func writeZstd(w io.Writer, data []byte) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(zstdMagic))
+	// frame header descriptor: 8 bytes content size, single segment
+	buf.WriteByte(0xe0)
+	binary.Write(&buf, binary.LittleEndian, uint64(len(data)))
+	for {
+		n := len(data)
+		if n > zstdMaxBlockSize {
+			n = zstdMaxBlockSize
+		}
+		// block header: last block flag, raw block type and block size
+		hdr := uint32(n) << 3
+		if n == len(data) {
+			hdr |= 1
+		}
+		buf.Write([]byte{byte(hdr), byte(hdr >> 8), byte(hdr >> 16)})
+		buf.Write(data[:n])
+		data = data[n:]
+		if hdr&1 != 0 {
+			break
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func alignUp(off, align uint64) uint64 {
+	return (off + align - 1) &^ (align - 1)
+}
+
+// stringTable is a string table section.
+type stringTable struct {
+	bytes.Buffer
+}
+
+// add adds s to the table and returns its offset.
+func (t *stringTable) add(s string) uint32 {
+	off := uint32(t.Len())
+	t.WriteString(s)
+	t.WriteByte(0)
+	return off
+}
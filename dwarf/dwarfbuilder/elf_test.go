@@ -0,0 +1,110 @@
+package dwarfbuilder
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hitzhangjie/codemaster/dwarf/frame"
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+)
+
+func TestWriteELF(t *testing.T) {
+	const textAddr = 0x401000
+	text := Text{Addr: textAddr, Data: bytes.Repeat([]byte{0x90}, 0x40)}
+	symbols := []Symbol{
+		{Name: "main.main", Value: textAddr, Size: 0x20, Type: elf.STT_FUNC},
+		{Name: "main.f", Value: textAddr + 0x20, Size: 0x20, Type: elf.STT_FUNC},
+		{Name: "main.v", Value: 0x500000, Size: 8, Type: elf.STT_OBJECT},
+	}
+
+	for _, compress := range []Compression{CompressNone, CompressZlibGNU, CompressZlib, CompressZstd} {
+		b := New()
+		lp := NewLineProgram(4, "/src")
+		file := lp.AddFile("main.go", 0, nil)
+		lp.AddRow(LineRow{Address: textAddr, File: file, Line: 3, IsStmt: true})
+		lp.AddRow(LineRow{Address: textAddr + 0x20, File: file, Line: 7, IsStmt: true})
+		lp.EndSequence(textAddr + 0x40)
+		b.Attr(dwarf.AttrCompDir, "/src")
+		b.Attr(dwarf.AttrStmtList, b.AddLineProgram(lp))
+		cie := b.Frame().AddCIE(1, -8, 16)
+		cie.Initial.DefCFA(7, 8).Offset(16, -8)
+		b.Frame().AddFDE(cie, textAddr, 0x20).Instructions.AdvanceLoc(4).DefCFAOffset(16)
+		b.AddSubprogram("main.main", textAddr, textAddr+0x20)
+		b.TagClose()
+
+		sections, err := b.BuildSections()
+		require.Nil(t, err)
+		want := map[string][]byte{}
+		for i := range sections {
+			want[sections[i].Name] = sections[i].Data
+			sections[i].Compress = compress
+		}
+
+		path := filepath.Join(t.TempDir(), "a.out")
+		out, err := os.Create(path)
+		require.Nil(t, err)
+		require.Nil(t, WriteELF(out, elf.EM_X86_64, sections, symbols, text))
+		require.Nil(t, out.Close())
+
+		f, err := godwarf.Open(path)
+		require.Nil(t, err, "compression %d", compress)
+		defer f.Close()
+		assert.Equal(t, elf.EM_X86_64, f.Machine)
+
+		// sections read back decompressed
+		for name, data := range want {
+			got, err := godwarf.GetDebugSection(f, name[len(".debug_"):])
+			require.Nil(t, err, name)
+			assert.Equal(t, data, got, name)
+		}
+
+		gotText, err := f.Section(".text").Data()
+		require.Nil(t, err)
+		assert.Equal(t, text.Data, gotText)
+		syms, err := f.Symbols()
+		require.Nil(t, err)
+		require.Len(t, syms, len(symbols))
+		for i, sym := range syms {
+			assert.Equal(t, symbols[i].Name, sym.Name)
+			assert.Equal(t, symbols[i].Value, sym.Value)
+			assert.Equal(t, symbols[i].Size, sym.Size)
+		}
+		assert.Equal(t, elf.SectionIndex(1), syms[0].Section)
+		assert.Equal(t, elf.SHN_ABS, syms[2].Section)
+
+		if compress == CompressZlibGNU {
+			// debug/dwarf doesn't read .zdebug sections
+			continue
+		}
+		dw, err := f.DWARF()
+		require.Nil(t, err)
+		r := dw.Reader()
+		cu, err := r.Next()
+		require.Nil(t, err)
+		lr, err := dw.LineReader(cu)
+		require.Nil(t, err)
+		var entry dwarf.LineEntry
+		require.Nil(t, lr.SeekPC(textAddr+0x24, &entry))
+		assert.Equal(t, "/src/main.go", entry.File.Name)
+		assert.Equal(t, 7, entry.Line)
+		fn, err := r.Next()
+		require.Nil(t, err)
+		assert.Equal(t, "main.main", fn.Val(dwarf.AttrName))
+
+		data, err := godwarf.GetDebugSection(f, "frame")
+		require.Nil(t, err)
+		fdes, err := frame.Parse(data, binary.LittleEndian, 0, 8, 0)
+		require.Nil(t, err)
+		fde, err := fdes.FDEForPC(textAddr + 8)
+		require.Nil(t, err)
+		assert.Equal(t, int64(16), fde.EstablishFrame(textAddr+8).CFA.Offset)
+	}
+}
@@ -0,0 +1,348 @@
+package dwarfbuilder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/hitzhangjie/codemaster/dwarf/util"
+)
+
+// call frame instructions (see section 7.23, DWARF v4)
+const (
+	_DW_CFA_nop                = 0x00
+	_DW_CFA_set_loc            = 0x01
+	_DW_CFA_advance_loc1       = 0x02
+	_DW_CFA_advance_loc2       = 0x03
+	_DW_CFA_advance_loc4       = 0x04
+	_DW_CFA_offset_extended    = 0x05
+	_DW_CFA_restore_extended   = 0x06
+	_DW_CFA_undefined          = 0x07
+	_DW_CFA_same_value         = 0x08
+	_DW_CFA_register           = 0x09
+	_DW_CFA_remember_state     = 0x0a
+	_DW_CFA_restore_state      = 0x0b
+	_DW_CFA_def_cfa            = 0x0c
+	_DW_CFA_def_cfa_register   = 0x0d
+	_DW_CFA_def_cfa_offset     = 0x0e
+	_DW_CFA_def_cfa_expression = 0x0f
+	_DW_CFA_expression         = 0x10
+	_DW_CFA_offset_extended_sf = 0x11
+	_DW_CFA_def_cfa_sf         = 0x12
+	_DW_CFA_def_cfa_offset_sf  = 0x13
+	_DW_CFA_val_offset         = 0x14
+	_DW_CFA_val_offset_sf      = 0x15
+	_DW_CFA_val_expression     = 0x16
+	_DW_CFA_advance_loc        = 0x1 << 6
+	_DW_CFA_offset             = 0x2 << 6
+	_DW_CFA_restore            = 0x3 << 6
+)
+
+// FrameBuilder builds a .debug_frame section.
+type FrameBuilder struct {
+	PtrSize int
+	cies    []*CIE
+	fdes    []*FDE
+}
+
+// CIE is a Common Information Entry, the instructions in Initial are
+// executed before those of each of its FDEs.
+type CIE struct {
+	CodeAlignmentFactor   uint64
+	DataAlignmentFactor   int64
+	ReturnAddressRegister uint64
+	Initial               *FrameProgram
+}
+
+// FDE is a Frame Description Entry covering [Begin, Begin+Size).
+type FDE struct {
+	CIE          *CIE
+	Begin, Size  uint64
+	Instructions *FrameProgram
+}
+
+// NewFrameBuilder creates a builder for a .debug_frame of 64bit addresses.
+func NewFrameBuilder() *FrameBuilder {
+	return &FrameBuilder{PtrSize: 8}
+}
+
+// AddCIE adds a CIE, append its initial instructions to the Initial
+// program of the returned entry.
+func (f *FrameBuilder) AddCIE(codeAlign uint64, dataAlign int64, raReg uint64) *CIE {
+	cie := &CIE{
+		CodeAlignmentFactor:   codeAlign,
+		DataAlignmentFactor:   dataAlign,
+		ReturnAddressRegister: raReg,
+		Initial:               &FrameProgram{codeAlign: codeAlign, dataAlign: dataAlign, ptrSize: f.PtrSize},
+	}
+	f.cies = append(f.cies, cie)
+	return cie
+}
+
+// AddFDE adds a FDE of cie for the function at [begin, begin+size), append
+// the instructions of the function to the Instructions program of the
+// returned entry. Size can be changed until Bytes is called.
+func (f *FrameBuilder) AddFDE(cie *CIE, begin, size uint64) *FDE {
+	fde := &FDE{
+		CIE:   cie,
+		Begin: begin,
+		Size:  size,
+		Instructions: &FrameProgram{
+			codeAlign: cie.CodeAlignmentFactor,
+			dataAlign: cie.DataAlignmentFactor,
+			ptrSize:   f.PtrSize,
+			loc:       begin,
+		},
+	}
+	f.fdes = append(f.fdes, fde)
+	return fde
+}
+
+// Bytes returns the .debug_frame section, the CIEs followed by the FDEs,
+// each entry padded with DW_CFA_nop to the address size.
+func (f *FrameBuilder) Bytes() []byte {
+	var out bytes.Buffer
+	offsets := map[*CIE]uint32{}
+	for _, cie := range f.cies {
+		offsets[cie] = uint32(out.Len())
+		var body bytes.Buffer
+		binary.Write(&body, binary.LittleEndian, uint32(0xffffffff)) // CIE_id
+		body.WriteByte(3)                                            // version
+		body.WriteByte(0)                                            // augmentation
+		util.EncodeULEB128(&body, cie.CodeAlignmentFactor)
+		util.EncodeSLEB128(&body, cie.DataAlignmentFactor)
+		util.EncodeULEB128(&body, cie.ReturnAddressRegister)
+		body.Write(cie.Initial.Bytes())
+		f.writeEntry(&out, body.Bytes())
+	}
+	for _, fde := range f.fdes {
+		off, ok := offsets[fde.CIE]
+		if !ok {
+			panic("FDE of a CIE of another FrameBuilder")
+		}
+		var body bytes.Buffer
+		binary.Write(&body, binary.LittleEndian, off)
+		fde.Instructions.writeAddr(&body, fde.Begin)
+		fde.Instructions.writeAddr(&body, fde.Size)
+		body.Write(fde.Instructions.Bytes())
+		f.writeEntry(&out, body.Bytes())
+	}
+	return out.Bytes()
+}
+
+func (f *FrameBuilder) writeEntry(out *bytes.Buffer, body []byte) {
+	n := len(body)
+	for (n+4)%f.PtrSize != 0 {
+		n++
+	}
+	binary.Write(out, binary.LittleEndian, uint32(n))
+	out.Write(body)
+	for i := len(body); i < n; i++ {
+		out.WriteByte(_DW_CFA_nop)
+	}
+}
+
+// FrameProgram is a sequence of call frame instructions. Offsets and
+// address advances are given in bytes, they are factored by the alignment
+// factors of the CIE and must be multiples of them.
+type FrameProgram struct {
+	buf       bytes.Buffer
+	codeAlign uint64
+	dataAlign int64
+	ptrSize   int
+	loc       uint64
+}
+
+// Bytes returns the encoded instructions.
+func (p *FrameProgram) Bytes() []byte {
+	return p.buf.Bytes()
+}
+
+func (p *FrameProgram) writeAddr(buf *bytes.Buffer, addr uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], addr)
+	buf.Write(b[:p.ptrSize])
+}
+
+func (p *FrameProgram) dataFactored(off int64) int64 {
+	if p.dataAlign == 0 || off%p.dataAlign != 0 {
+		panic(fmt.Sprintf("offset %d not a multiple of the data alignment factor %d", off, p.dataAlign))
+	}
+	return off / p.dataAlign
+}
+
+// AdvanceLoc advances the location by delta bytes, with the shortest
+// advance instruction.
+func (p *FrameProgram) AdvanceLoc(delta uint64) *FrameProgram {
+	if delta%p.codeAlign != 0 {
+		panic(fmt.Sprintf("delta %d not a multiple of the code alignment factor %d", delta, p.codeAlign))
+	}
+	p.loc += delta
+	factored := delta / p.codeAlign
+	switch {
+	case factored < 0x40:
+		p.buf.WriteByte(_DW_CFA_advance_loc | byte(factored))
+	case factored <= 0xff:
+		p.buf.WriteByte(_DW_CFA_advance_loc1)
+		p.buf.WriteByte(byte(factored))
+	case factored <= 0xffff:
+		p.buf.WriteByte(_DW_CFA_advance_loc2)
+		binary.Write(&p.buf, binary.LittleEndian, uint16(factored))
+	default:
+		p.buf.WriteByte(_DW_CFA_advance_loc4)
+		binary.Write(&p.buf, binary.LittleEndian, uint32(factored))
+	}
+	return p
+}
+
+// AdvanceTo advances the location to addr, which can't be lower than the
+// current location.
+func (p *FrameProgram) AdvanceTo(addr uint64) *FrameProgram {
+	if addr < p.loc {
+		panic(fmt.Sprintf("location %#x lower than the current one %#x", addr, p.loc))
+	}
+	return p.AdvanceLoc(addr - p.loc)
+}
+
+// SetLoc sets the location to addr.
+func (p *FrameProgram) SetLoc(addr uint64) *FrameProgram {
+	p.loc = addr
+	p.buf.WriteByte(_DW_CFA_set_loc)
+	p.writeAddr(&p.buf, addr)
+	return p
+}
+
+// DefCFA defines the CFA as reg+off.
+func (p *FrameProgram) DefCFA(reg uint64, off int64) *FrameProgram {
+	if off >= 0 {
+		p.buf.WriteByte(_DW_CFA_def_cfa)
+		util.EncodeULEB128(&p.buf, reg)
+		util.EncodeULEB128(&p.buf, uint64(off))
+		return p
+	}
+	p.buf.WriteByte(_DW_CFA_def_cfa_sf)
+	util.EncodeULEB128(&p.buf, reg)
+	util.EncodeSLEB128(&p.buf, p.dataFactored(off))
+	return p
+}
+
+// DefCFARegister changes the register of the CFA rule.
+func (p *FrameProgram) DefCFARegister(reg uint64) *FrameProgram {
+	p.buf.WriteByte(_DW_CFA_def_cfa_register)
+	util.EncodeULEB128(&p.buf, reg)
+	return p
+}
+
+// DefCFAOffset changes the offset of the CFA rule.
+func (p *FrameProgram) DefCFAOffset(off int64) *FrameProgram {
+	if off >= 0 {
+		p.buf.WriteByte(_DW_CFA_def_cfa_offset)
+		util.EncodeULEB128(&p.buf, uint64(off))
+		return p
+	}
+	p.buf.WriteByte(_DW_CFA_def_cfa_offset_sf)
+	util.EncodeSLEB128(&p.buf, p.dataFactored(off))
+	return p
+}
+
+// DefCFAExpression defines the CFA as the value of the DWARF expression.
+func (p *FrameProgram) DefCFAExpression(expr []byte) *FrameProgram {
+	p.buf.WriteByte(_DW_CFA_def_cfa_expression)
+	p.block(expr)
+	return p
+}
+
+// Offset saves reg at CFA+off.
+func (p *FrameProgram) Offset(reg uint64, off int64) *FrameProgram {
+	factored := p.dataFactored(off)
+	switch {
+	case factored >= 0 && reg < 0x40:
+		p.buf.WriteByte(_DW_CFA_offset | byte(reg))
+		util.EncodeULEB128(&p.buf, uint64(factored))
+	case factored >= 0:
+		p.buf.WriteByte(_DW_CFA_offset_extended)
+		util.EncodeULEB128(&p.buf, reg)
+		util.EncodeULEB128(&p.buf, uint64(factored))
+	default:
+		p.buf.WriteByte(_DW_CFA_offset_extended_sf)
+		util.EncodeULEB128(&p.buf, reg)
+		util.EncodeSLEB128(&p.buf, factored)
+	}
+	return p
+}
+
+// ValOffset defines the value of reg as CFA+off.
+func (p *FrameProgram) ValOffset(reg uint64, off int64) *FrameProgram {
+	p.buf.WriteByte(_DW_CFA_val_offset_sf)
+	util.EncodeULEB128(&p.buf, reg)
+	util.EncodeSLEB128(&p.buf, p.dataFactored(off))
+	return p
+}
+
+// Register saves reg in register other.
+func (p *FrameProgram) Register(reg, other uint64) *FrameProgram {
+	p.buf.WriteByte(_DW_CFA_register)
+	util.EncodeULEB128(&p.buf, reg)
+	util.EncodeULEB128(&p.buf, other)
+	return p
+}
+
+// Expression saves reg at the address computed by the DWARF expression.
+func (p *FrameProgram) Expression(reg uint64, expr []byte) *FrameProgram {
+	p.buf.WriteByte(_DW_CFA_expression)
+	util.EncodeULEB128(&p.buf, reg)
+	p.block(expr)
+	return p
+}
+
+// ValExpression defines the value of reg as the value of the DWARF
+// expression.
+func (p *FrameProgram) ValExpression(reg uint64, expr []byte) *FrameProgram {
+	p.buf.WriteByte(_DW_CFA_val_expression)
+	util.EncodeULEB128(&p.buf, reg)
+	p.block(expr)
+	return p
+}
+
+// Undefined marks reg as not recoverable.
+func (p *FrameProgram) Undefined(reg uint64) *FrameProgram {
+	p.buf.WriteByte(_DW_CFA_undefined)
+	util.EncodeULEB128(&p.buf, reg)
+	return p
+}
+
+// SameValue marks reg as not modified by the function.
+func (p *FrameProgram) SameValue(reg uint64) *FrameProgram {
+	p.buf.WriteByte(_DW_CFA_same_value)
+	util.EncodeULEB128(&p.buf, reg)
+	return p
+}
+
+// Restore restores the rule of reg to the one of the initial
+// instructions.
+func (p *FrameProgram) Restore(reg uint64) *FrameProgram {
+	if reg < 0x40 {
+		p.buf.WriteByte(_DW_CFA_restore | byte(reg))
+		return p
+	}
+	p.buf.WriteByte(_DW_CFA_restore_extended)
+	util.EncodeULEB128(&p.buf, reg)
+	return p
+}
+
+// RememberState pushes the register rules on the state stack.
+func (p *FrameProgram) RememberState() *FrameProgram {
+	p.buf.WriteByte(_DW_CFA_remember_state)
+	return p
+}
+
+// RestoreState pops the register rules from the state stack.
+func (p *FrameProgram) RestoreState() *FrameProgram {
+	p.buf.WriteByte(_DW_CFA_restore_state)
+	return p
+}
+
+func (p *FrameProgram) block(b []byte) {
+	util.EncodeULEB128(&p.buf, uint64(len(b)))
+	p.buf.Write(b)
+}
@@ -69,6 +69,10 @@ const (
 // Address represents a machine address.
 type Address uint64
 
+// SecOffset represents an offset in another debug section, like the
+// DW_AT_stmt_list of a compile unit.
+type SecOffset uint32
+
 type tagDescr struct {
 	tag dwarf.Tag
 
@@ -139,6 +143,13 @@ func (b *Builder) Attr(attr dwarf.Attr, val interface{}) dwarf.Offset {
 		tag.form = append(tag.form, DW_FORM_string)
 		b.info.Write([]byte(x))
 		b.info.WriteByte(0)
+	case bool:
+		tag.form = append(tag.form, DW_FORM_flag)
+		if x {
+			b.info.WriteByte(1)
+		} else {
+			b.info.WriteByte(0)
+		}
 	case uint8:
 		tag.form = append(tag.form, DW_FORM_data1)
 		binary.Write(&b.info, binary.LittleEndian, x)
@@ -151,6 +162,9 @@ func (b *Builder) Attr(attr dwarf.Attr, val interface{}) dwarf.Offset {
 	case Address:
 		tag.form = append(tag.form, DW_FORM_addr)
 		binary.Write(&b.info, binary.LittleEndian, x)
+	case SecOffset:
+		tag.form = append(tag.form, DW_FORM_sec_offset)
+		binary.Write(&b.info, binary.LittleEndian, x)
 	case dwarf.Offset:
 		tag.form = append(tag.form, DW_FORM_ref_addr)
 		binary.Write(&b.info, binary.LittleEndian, x)
@@ -244,6 +258,9 @@ func (b *Builder) makeAbbrevTable() []byte {
 		util.EncodeULEB128(&abbrev, 0)
 	}
 
+	// the table ends with a null entry
+	util.EncodeULEB128(&abbrev, 0)
+
 	return abbrev.Bytes()
 }
 
@@ -0,0 +1,321 @@
+package dwarfbuilder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/hitzhangjie/codemaster/dwarf/util"
+)
+
+// line number program opcodes (see section 6.2.5, DWARF v4)
+const (
+	_DW_LNS_copy         = 0x01
+	_DW_LNS_advance_pc   = 0x02
+	_DW_LNS_advance_line = 0x03
+	_DW_LNS_set_file     = 0x04
+	_DW_LNS_negate_stmt  = 0x06
+	_DW_LNS_const_add_pc = 0x08
+	_DW_LNS_prologue_end = 0x0a
+	_DW_LNS_epilogue_beg = 0x0b
+
+	_DW_LNE_end_sequence = 0x01
+	_DW_LNE_set_address  = 0x02
+
+	_DW_LNCT_path            = 0x1
+	_DW_LNCT_directory_index = 0x2
+	_DW_LNCT_MD5             = 0x5
+
+	_DW_FORM_data16 = 0x1e
+)
+
+// standard opcode lengths of the opcodes up to DW_LNS_set_isa
+var stdOpcodeLengths = []byte{0, 1, 1, 1, 1, 0, 0, 0, 1, 0, 0, 1}
+
+// LineFile is an entry of the file table of a line number program.
+type LineFile struct {
+	Name string
+	Dir  uint64
+	MD5  []byte // DWARF 5 only
+}
+
+// LineRow is a row of the line number matrix.
+type LineRow struct {
+	Address       uint64
+	File          uint64
+	Line          int
+	IsStmt        bool
+	PrologueEnd   bool
+	EpilogueBegin bool
+}
+
+// LineProgram builds the line number program of a compile unit, a unit of
+// the .debug_line section. Set the header fields before adding rows, then
+// add the rows of each sequence in increasing address order and close the
+// sequence with EndSequence.
+type LineProgram struct {
+	Version       uint16 // 2 to 5
+	PtrSize       int
+	MinInstLength uint8
+	DefaultIsStmt bool
+	LineBase      int8
+	LineRange     uint8
+
+	dirs  []string
+	files []LineFile
+	prog  bytes.Buffer
+
+	// state machine registers of the current sequence
+	inSeq  bool
+	addr   uint64
+	file   uint64
+	line   int
+	isStmt bool
+}
+
+// NewLineProgram creates a line number program of the given DWARF version
+// with the header values used by the Go linker. In DWARF 5 compDir is the
+// directory 0 of the directory table, before it is the DW_AT_comp_dir of
+// the compile unit and isn't part of the table.
+func NewLineProgram(version uint16, compDir string) *LineProgram {
+	if version < 2 || version > 5 {
+		panic(fmt.Sprintf("unsupported line table version %d", version))
+	}
+	p := &LineProgram{
+		Version:       version,
+		PtrSize:       8,
+		MinInstLength: 1,
+		DefaultIsStmt: true,
+		LineBase:      -4,
+		LineRange:     10,
+	}
+	if version >= 5 {
+		p.dirs = append(p.dirs, compDir)
+	}
+	return p
+}
+
+// AddDir adds a directory to the directory table and returns its index.
+func (p *LineProgram) AddDir(dir string) uint64 {
+	p.dirs = append(p.dirs, dir)
+	if p.Version >= 5 {
+		return uint64(len(p.dirs) - 1)
+	}
+	return uint64(len(p.dirs))
+}
+
+// AddFile adds a file in directory dir to the file table and returns its
+// index, starting from 1 before DWARF 5 and from 0 in DWARF 5. The MD5
+// column is written when all the files have a digest.
+func (p *LineProgram) AddFile(name string, dir uint64, md5 []byte) uint64 {
+	if md5 != nil && len(md5) != 16 {
+		panic("MD5 digest must be 16 bytes long")
+	}
+	p.files = append(p.files, LineFile{Name: name, Dir: dir, MD5: md5})
+	if p.Version >= 5 {
+		return uint64(len(p.files) - 1)
+	}
+	return uint64(len(p.files))
+}
+
+func (p *LineProgram) opcodeBase() uint8 {
+	if p.Version == 2 {
+		return 10
+	}
+	return 13
+}
+
+// AddRow appends a row to the current sequence, a new sequence is started
+// by the first row after NewLineProgram or EndSequence.
+func (p *LineProgram) AddRow(row LineRow) {
+	if !p.inSeq {
+		p.inSeq = true
+		p.addr = row.Address
+		p.file = ^uint64(0)
+		p.line = 1
+		p.isStmt = p.DefaultIsStmt
+		p.prog.WriteByte(0)
+		util.EncodeULEB128(&p.prog, uint64(1+p.PtrSize))
+		p.prog.WriteByte(_DW_LNE_set_address)
+		p.writeAddr(row.Address)
+	}
+	if row.File != p.file {
+		p.prog.WriteByte(_DW_LNS_set_file)
+		util.EncodeULEB128(&p.prog, row.File)
+		p.file = row.File
+	}
+	if row.IsStmt != p.isStmt {
+		p.prog.WriteByte(_DW_LNS_negate_stmt)
+		p.isStmt = row.IsStmt
+	}
+	if row.PrologueEnd && p.Version >= 3 {
+		p.prog.WriteByte(_DW_LNS_prologue_end)
+	}
+	if row.EpilogueBegin && p.Version >= 3 {
+		p.prog.WriteByte(_DW_LNS_epilogue_beg)
+	}
+
+	addrDelta := p.addrDelta(row.Address)
+	lineDelta := row.Line - p.line
+	p.addr, p.line = row.Address, row.Line
+
+	if lineDelta >= int(p.LineBase) && lineDelta < int(p.LineBase)+int(p.LineRange) {
+		if op, ok := p.specialOpcode(lineDelta, addrDelta); ok {
+			p.prog.WriteByte(op)
+			return
+		}
+		// DW_LNS_const_add_pc advances the address as special opcode 255
+		constAddPC := uint64(255-p.opcodeBase()) / uint64(p.LineRange)
+		if addrDelta >= constAddPC {
+			if op, ok := p.specialOpcode(lineDelta, addrDelta-constAddPC); ok {
+				p.prog.WriteByte(_DW_LNS_const_add_pc)
+				p.prog.WriteByte(op)
+				return
+			}
+		}
+	}
+	if lineDelta != 0 {
+		p.prog.WriteByte(_DW_LNS_advance_line)
+		util.EncodeSLEB128(&p.prog, int64(lineDelta))
+	}
+	if addrDelta != 0 {
+		p.prog.WriteByte(_DW_LNS_advance_pc)
+		util.EncodeULEB128(&p.prog, addrDelta)
+	}
+	p.prog.WriteByte(_DW_LNS_copy)
+}
+
+// EndSequence advances the address to addr, the first byte after the end
+// of the sequence, and ends the sequence.
+func (p *LineProgram) EndSequence(addr uint64) {
+	if !p.inSeq {
+		panic("EndSequence with no rows")
+	}
+	if delta := p.addrDelta(addr); delta != 0 {
+		p.prog.WriteByte(_DW_LNS_advance_pc)
+		util.EncodeULEB128(&p.prog, delta)
+	}
+	p.prog.Write([]byte{0, 1, _DW_LNE_end_sequence})
+	p.inSeq = false
+}
+
+// addrDelta returns the operation advance from the current address to addr
+func (p *LineProgram) addrDelta(addr uint64) uint64 {
+	if addr < p.addr {
+		panic(fmt.Sprintf("address %#x lower than the previous row %#x", addr, p.addr))
+	}
+	delta := addr - p.addr
+	if delta%uint64(p.MinInstLength) != 0 {
+		panic(fmt.Sprintf("address %#x not aligned to the minimum instruction length", addr))
+	}
+	return delta / uint64(p.MinInstLength)
+}
+
+func (p *LineProgram) specialOpcode(lineDelta int, addrDelta uint64) (byte, bool) {
+	op := uint64(lineDelta-int(p.LineBase)) + uint64(p.LineRange)*addrDelta + uint64(p.opcodeBase())
+	if addrDelta > 255 || op > 255 {
+		return 0, false
+	}
+	return byte(op), true
+}
+
+func (p *LineProgram) writeAddr(addr uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], addr)
+	p.prog.Write(buf[:p.PtrSize])
+}
+
+// Bytes returns the line number program, header included.
+func (p *LineProgram) Bytes() []byte {
+	if p.inSeq {
+		panic("line number program with an open sequence")
+	}
+
+	var hdr bytes.Buffer
+	hdr.WriteByte(p.MinInstLength)
+	if p.Version >= 4 {
+		hdr.WriteByte(1) // maximum_operations_per_instruction
+	}
+	if p.DefaultIsStmt {
+		hdr.WriteByte(1)
+	} else {
+		hdr.WriteByte(0)
+	}
+	hdr.WriteByte(byte(p.LineBase))
+	hdr.WriteByte(p.LineRange)
+	hdr.WriteByte(p.opcodeBase())
+	hdr.Write(stdOpcodeLengths[:p.opcodeBase()-1])
+
+	if p.Version >= 5 {
+		p.writeTables5(&hdr)
+	} else {
+		for _, dir := range p.dirs {
+			hdr.WriteString(dir)
+			hdr.WriteByte(0)
+		}
+		hdr.WriteByte(0)
+		for _, file := range p.files {
+			hdr.WriteString(file.Name)
+			hdr.WriteByte(0)
+			util.EncodeULEB128(&hdr, file.Dir)
+			util.EncodeULEB128(&hdr, 0) // modification time
+			util.EncodeULEB128(&hdr, 0) // file length
+		}
+		hdr.WriteByte(0)
+	}
+
+	var out bytes.Buffer
+	out.Write([]byte{0, 0, 0, 0}) // unit_length
+	binary.Write(&out, binary.LittleEndian, p.Version)
+	if p.Version >= 5 {
+		out.WriteByte(byte(p.PtrSize))
+		out.WriteByte(0) // segment_selector_size
+	}
+	binary.Write(&out, binary.LittleEndian, uint32(hdr.Len()))
+	out.Write(hdr.Bytes())
+	out.Write(p.prog.Bytes())
+
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b, uint32(len(b)-4))
+	return b
+}
+
+// writeTables5 writes the directory and file tables of DWARF 5, paths are
+// written inline with DW_FORM_string.
+func (p *LineProgram) writeTables5(hdr *bytes.Buffer) {
+	hdr.WriteByte(1)
+	util.EncodeULEB128(hdr, _DW_LNCT_path)
+	util.EncodeULEB128(hdr, uint64(DW_FORM_string))
+	util.EncodeULEB128(hdr, uint64(len(p.dirs)))
+	for _, dir := range p.dirs {
+		hdr.WriteString(dir)
+		hdr.WriteByte(0)
+	}
+
+	withMD5 := len(p.files) > 0
+	for _, file := range p.files {
+		withMD5 = withMD5 && file.MD5 != nil
+	}
+	if withMD5 {
+		hdr.WriteByte(3)
+	} else {
+		hdr.WriteByte(2)
+	}
+	util.EncodeULEB128(hdr, _DW_LNCT_path)
+	util.EncodeULEB128(hdr, uint64(DW_FORM_string))
+	util.EncodeULEB128(hdr, _DW_LNCT_directory_index)
+	util.EncodeULEB128(hdr, uint64(DW_FORM_udata))
+	if withMD5 {
+		util.EncodeULEB128(hdr, _DW_LNCT_MD5)
+		util.EncodeULEB128(hdr, _DW_FORM_data16)
+	}
+	util.EncodeULEB128(hdr, uint64(len(p.files)))
+	for _, file := range p.files {
+		hdr.WriteString(file.Name)
+		hdr.WriteByte(0)
+		util.EncodeULEB128(hdr, file.Dir)
+		if withMD5 {
+			hdr.Write(file.MD5)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package dwarfbuilder
 
 import (
 	"bytes"
+	"encoding/binary"
 
 	"github.com/hitzhangjie/codemaster/dwarf/op"
 	"github.com/hitzhangjie/codemaster/dwarf/util"
@@ -15,7 +16,9 @@ type LocEntry struct {
 }
 
 // LocationBlock returns a DWARF expression corresponding to the list of
-// arguments.
+// arguments. An int is encoded as a SLEB128, a uint as a ULEB128, the sized
+// unsigned integers as fixed size little endian integers and a []byte as
+// is.
 func LocationBlock(args ...interface{}) []byte {
 	var buf bytes.Buffer
 	for _, arg := range args {
@@ -26,6 +29,16 @@ func LocationBlock(args ...interface{}) []byte {
 			util.EncodeSLEB128(&buf, int64(x))
 		case uint:
 			util.EncodeULEB128(&buf, uint64(x))
+		case uint8:
+			buf.WriteByte(x)
+		case uint16:
+			binary.Write(&buf, binary.LittleEndian, x)
+		case uint32:
+			binary.Write(&buf, binary.LittleEndian, x)
+		case uint64:
+			binary.Write(&buf, binary.LittleEndian, x)
+		case []byte:
+			buf.Write(x)
 		default:
 			panic("unsupported value type")
 		}
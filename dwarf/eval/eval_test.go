@@ -0,0 +1,297 @@
+package eval
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hitzhangjie/codemaster/dwarf/dwarfbuilder"
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+)
+
+const (
+	testCFA     = 0x10000
+	testCounter = 0x5000
+)
+
+// addrLoc returns the location expression DW_OP_addr addr
+func addrLoc(addr uint64) []byte {
+	buf := []byte{byte(op.DW_OP_addr), 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint64(buf[1:], addr)
+	return buf
+}
+
+func addGoType(b *dwarfbuilder.Builder, tag dwarf.Tag, name string, kind reflect.Kind, size uint16, attrs ...interface{}) dwarf.Offset {
+	off := b.TagOpen(tag, name)
+	b.Attr(dwarf.AttrByteSize, size)
+	b.Attr(godwarf.AttrGoKind, uint8(kind))
+	for i := 0; i < len(attrs); i += 2 {
+		b.Attr(attrs[i].(dwarf.Attr), attrs[i+1])
+	}
+	return off
+}
+
+// testDwarf describes:
+//
+//	var counter int
+//
+//	func f(p *point) { var a int; var s string; var xs []int; var r point }
+//	func g() { var hidden int }
+func testDwarf(t *testing.T) *dwarf.Data {
+	b := dwarfbuilder.New()
+
+	intOff := addGoType(b, dwarf.TagBaseType, "int", reflect.Int, 8, dwarf.AttrEncoding, uint16(dwarfbuilder.DW_ATE_signed))
+	b.TagClose()
+	uint8Off := addGoType(b, dwarf.TagBaseType, "uint8", reflect.Uint8, 1, dwarf.AttrEncoding, uint16(dwarfbuilder.DW_ATE_unsigned))
+	b.TagClose()
+	intPtr := b.AddPointerType("*int", intOff)
+	uint8Ptr := b.AddPointerType("*uint8", uint8Off)
+
+	stringOff := addGoType(b, dwarf.TagStructType, "string", reflect.String, 16)
+	b.AddMember("str", uint8Ptr, dwarfbuilder.LocationBlock(op.DW_OP_plus_uconst, uint(0)))
+	b.AddMember("len", intOff, dwarfbuilder.LocationBlock(op.DW_OP_plus_uconst, uint(8)))
+	b.TagClose()
+
+	sliceOff := addGoType(b, dwarf.TagStructType, "[]int", reflect.Slice, 24, godwarf.AttrGoElem, intOff)
+	b.AddMember("array", intPtr, dwarfbuilder.LocationBlock(op.DW_OP_plus_uconst, uint(0)))
+	b.AddMember("len", intOff, dwarfbuilder.LocationBlock(op.DW_OP_plus_uconst, uint(8)))
+	b.AddMember("cap", intOff, dwarfbuilder.LocationBlock(op.DW_OP_plus_uconst, uint(16)))
+	b.TagClose()
+
+	pointOff := addGoType(b, dwarf.TagStructType, "main.point", reflect.Struct, 16)
+	b.AddMember("x", intOff, dwarfbuilder.LocationBlock(op.DW_OP_plus_uconst, uint(0)))
+	b.AddMember("y", intOff, dwarfbuilder.LocationBlock(op.DW_OP_plus_uconst, uint(8)))
+	b.TagClose()
+	pointPtr := b.AddPointerType("*main.point", pointOff)
+
+	b.TagOpen(dwarf.TagVariable, "main.counter")
+	b.Attr(dwarf.AttrType, intOff)
+	b.Attr(dwarf.AttrLocation, addrLoc(testCounter))
+	b.Attr(dwarf.AttrExternal, true)
+	b.TagClose()
+
+	b.AddSubprogram("main.f", 0x1000, 0x2000)
+	b.Attr(dwarf.AttrFrameBase, dwarfbuilder.LocationBlock(op.DW_OP_call_frame_cfa))
+	b.TagOpen(dwarf.TagFormalParameter, "p")
+	b.Attr(dwarf.AttrType, pointPtr)
+	b.Attr(dwarf.AttrLocation, dwarfbuilder.LocationBlock(op.DW_OP_fbreg, -40))
+	b.TagClose()
+	b.AddVariable("a", intOff, dwarfbuilder.LocationBlock(op.DW_OP_fbreg, -8))
+	b.AddVariable("s", stringOff, dwarfbuilder.LocationBlock(op.DW_OP_fbreg, -32))
+	b.AddVariable("xs", sliceOff, dwarfbuilder.LocationBlock(op.DW_OP_fbreg, -64))
+	// r.x lives in a register, r.y on the stack
+	b.AddVariable("r", pointOff, dwarfbuilder.LocationBlock(
+		op.DW_OP_reg0, op.DW_OP_piece, uint(8),
+		op.DW_OP_fbreg, -72, op.DW_OP_piece, uint(8)))
+	b.TagClose()
+
+	b.AddSubprogram("main.g", 0x2000, 0x3000)
+	b.AddVariable("hidden", intOff, dwarfbuilder.LocationBlock(op.DW_OP_fbreg, -8))
+	b.TagClose()
+
+	abbrev, aranges, frame, info, line, pubnames, ranges, str, _, err := b.Build()
+	require.Nil(t, err)
+	dw, err := dwarf.New(abbrev, aranges, frame, info, line, pubnames, ranges, str)
+	require.Nil(t, err)
+	return dw
+}
+
+func putUint64s(data []byte, vals ...uint64) {
+	for i, v := range vals {
+		binary.LittleEndian.PutUint64(data[i*8:], v)
+	}
+}
+
+// testMemory returns the memory of the process described by testDwarf
+func testMemory() *Snapshot {
+	stack := make([]byte, 0x100)
+	base := uint64(testCFA - len(stack))
+	at := func(addr uint64) []byte { return stack[addr-base:] }
+
+	neg42 := int64(-42)
+	putUint64s(at(testCFA-8), uint64(neg42)) // a
+	putUint64s(at(testCFA-32), 0x6000, 11)   // s
+	putUint64s(at(testCFA-40), 0x7000)       // p
+	putUint64s(at(testCFA-64), 0x8000, 3, 4) // xs
+	putUint64s(at(testCFA-72), 7)            // r.y
+
+	counter := make([]byte, 8)
+	putUint64s(counter, 99)
+	point := make([]byte, 16)
+	putUint64s(point, 1, 2)
+	array := make([]byte, 32)
+	putUint64s(array, 10, 20, 30)
+
+	mem := &Snapshot{}
+	mem.Add(base, stack)
+	mem.Add(testCounter, counter)
+	mem.Add(0x6000, []byte("hello world"))
+	mem.Add(0x7000, point)
+	mem.Add(0x8000, array)
+	return mem
+}
+
+func testScope(t *testing.T, pc uint64) *Scope {
+	regs := op.NewDwarfRegisters(0, []*op.DwarfRegister{op.DwarfRegisterFromUint64(42)}, binary.LittleEndian, 16, 7, 6, 0)
+	regs.CFA = testCFA
+	s, err := New(testDwarf(t), testMemory().ReadMemory, *regs, pc)
+	require.Nil(t, err)
+	return s
+}
+
+func byName(vars []*Variable) map[string]*Variable {
+	m := map[string]*Variable{}
+	for _, v := range vars {
+		m[v.Name] = v
+	}
+	return m
+}
+
+func TestLocals(t *testing.T) {
+	s := testScope(t, 0x1010)
+	assert.Equal(t, "main.f", s.Function())
+
+	locals, err := s.Locals()
+	require.Nil(t, err)
+	vars := byName(locals)
+	require.Len(t, vars, 4)
+
+	a := vars["a"]
+	require.Nil(t, a.Unreadable)
+	assert.Equal(t, reflect.Int, a.Kind)
+	assert.Equal(t, int64(-42), a.Value)
+
+	str := vars["s"]
+	require.Nil(t, str.Unreadable)
+	assert.Equal(t, "hello world", str.Value)
+	assert.Equal(t, int64(11), str.Len)
+
+	xs := vars["xs"]
+	require.Nil(t, xs.Unreadable)
+	assert.Equal(t, int64(3), xs.Len)
+	assert.Equal(t, int64(4), xs.Cap)
+	require.Len(t, xs.Children, 3)
+	for i, want := range []int64{10, 20, 30} {
+		assert.Equal(t, want, xs.Children[i].Value)
+	}
+
+	r := vars["r"]
+	require.Nil(t, r.Unreadable)
+	require.Len(t, r.Children, 2)
+	assert.Equal(t, int64(42), r.Children[0].Value)
+	assert.Equal(t, int64(7), r.Children[1].Value)
+}
+
+func TestArgs(t *testing.T) {
+	s := testScope(t, 0x1010)
+	args, err := s.Args()
+	require.Nil(t, err)
+	require.Len(t, args, 1)
+
+	p := args[0]
+	require.Nil(t, p.Unreadable)
+	assert.Equal(t, "p", p.Name)
+	assert.Equal(t, reflect.Ptr, p.Kind)
+	assert.Equal(t, uint64(0x7000), p.Value)
+	require.Len(t, p.Children, 1)
+
+	point := p.Children[0]
+	assert.Equal(t, reflect.Struct, point.Kind)
+	require.Len(t, point.Children, 2)
+	assert.Equal(t, "x", point.Children[0].Name)
+	assert.Equal(t, int64(1), point.Children[0].Value)
+	assert.Equal(t, int64(2), point.Children[1].Value)
+}
+
+func TestGlobals(t *testing.T) {
+	s := testScope(t, 0x1010)
+
+	v, err := s.Global("main.counter")
+	require.Nil(t, err)
+	assert.Equal(t, int64(99), v.Value)
+
+	vars, err := s.Globals("main")
+	require.Nil(t, err)
+	require.Len(t, vars, 1)
+	assert.Equal(t, "main.counter", vars[0].Name)
+
+	vars, err = s.Globals("other")
+	require.Nil(t, err)
+	assert.Empty(t, vars)
+
+	_, err = s.Global("main.missing")
+	assert.NotNil(t, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	s := testScope(t, 0x1010)
+	s.Config = LoadConfig{MaxVariableRecurse: 1, MaxStringLen: 5, MaxArrayValues: 2}
+
+	locals, err := s.Locals()
+	require.Nil(t, err)
+	vars := byName(locals)
+	assert.Equal(t, "hello", vars["s"].Value)
+	assert.Equal(t, int64(11), vars["s"].Len)
+	assert.Len(t, vars["xs"].Children, 2)
+	assert.Equal(t, int64(3), vars["xs"].Len)
+
+	args, err := s.Args()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(0x7000), args[0].Value)
+	assert.Empty(t, args[0].Children, "pointers must not be followed")
+}
+
+func TestScopeOutsideFunction(t *testing.T) {
+	s := testScope(t, 0x4000)
+	assert.Equal(t, "", s.Function())
+	_, err := s.Locals()
+	assert.Equal(t, ErrNoFunction, err)
+}
+
+func TestScopeOtherFunction(t *testing.T) {
+	s := testScope(t, 0x2010)
+	assert.Equal(t, "main.g", s.Function())
+
+	// main.g has no frame base, its variable can't be located
+	locals, err := s.Locals()
+	require.Nil(t, err)
+	require.Len(t, locals, 1)
+	assert.Equal(t, "hidden", locals[0].Name)
+	assert.NotNil(t, locals[0].Unreadable)
+}
+
+func TestUnreadable(t *testing.T) {
+	s := testScope(t, 0x1010)
+	s.readMemory = (&Snapshot{}).ReadMemory
+
+	locals, err := s.Locals()
+	require.Nil(t, err)
+	require.Len(t, locals, 4)
+	for _, v := range locals {
+		assert.NotNil(t, v.Unreadable, v.Name)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	mem := &Snapshot{}
+	mem.Add(0x2000, []byte{4, 5, 6})
+	mem.Add(0x1000, []byte{1, 2, 3})
+	mem.Add(0x1003, []byte{7})
+
+	buf := make([]byte, 4)
+	n, err := mem.ReadMemory(buf, 0x1000)
+	require.Nil(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []byte{1, 2, 3, 7}, buf)
+
+	_, err = mem.ReadMemory(buf, 0x1002)
+	assert.NotNil(t, err, "read across a hole")
+
+	_, err = mem.ReadMemory(buf[:1], 0x3000)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,134 @@
+package eval
+
+import (
+	"debug/dwarf"
+	"fmt"
+
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+)
+
+// loadInterface loads the dynamic type and value of an interface, the
+// value becomes the only child of v and v.Value is the address of the
+// runtime type.
+func (s *Scope) loadInterface(v *Variable, t *godwarf.InterfaceType, depth int) error {
+	// runtime.iface is {tab *itab; data unsafe.Pointer}, runtime.eface
+	// (interface{}) is {_type *_type; data unsafe.Pointer}
+	st, ok := resolveTypedef(t.Type).(*godwarf.StructType)
+	if !ok || len(st.Field) != 2 {
+		return fmt.Errorf("unexpected interface layout %s", t.Type)
+	}
+	tab, err := s.readPtr(v.mem, v.Addr+uint64(st.Field[0].ByteOffset))
+	if err != nil || tab == 0 {
+		return err
+	}
+	dataField := st.Field[1]
+	data, err := s.readPtr(v.mem, v.Addr+uint64(dataField.ByteOffset))
+	if err != nil {
+		return err
+	}
+
+	rtype := tab
+	if st.Field[0].Name == "tab" {
+		// itab.Type follows itab.Inter
+		if rtype, err = s.readPtr(v.mem, tab+uint64(s.PtrSize)); err != nil {
+			return err
+		}
+	}
+	v.Value = rtype
+
+	typ, err := s.runtimeType(rtype)
+	if err != nil {
+		return err
+	}
+
+	// pointer shaped values are stored in the data word itself
+	addr := data
+	if isDirectIface(typ) {
+		addr = v.Addr + uint64(dataField.ByteOffset)
+	}
+	c := v.child(typ.String(), addr, typ)
+	s.load(c, depth)
+	v.Children = []*Variable{c}
+	return nil
+}
+
+// isDirectIface reports whether values of typ are stored directly in the
+// data word of an interface, rather than pointed to by it.
+func isDirectIface(typ godwarf.Type) bool {
+	switch t := resolveTypedef(typ).(type) {
+	case *godwarf.PtrType, *godwarf.MapType, *godwarf.ChanType, *godwarf.FuncType:
+		return true
+	case *godwarf.StructType:
+		return len(t.Field) == 1 && isDirectIface(t.Field[0].Type)
+	case *godwarf.ArrayType:
+		return t.Count == 1 && isDirectIface(t.Type)
+	}
+	return false
+}
+
+// runtimeType returns the DWARF type of the runtime type descriptor at addr
+func (s *Scope) runtimeType(addr uint64) (godwarf.Type, error) {
+	if s.rtypes == nil {
+		if err := s.indexRuntimeTypes(); err != nil {
+			return nil, err
+		}
+	}
+	base, err := s.typesBase()
+	if err != nil {
+		return nil, err
+	}
+	off, ok := s.rtypes[addr-base]
+	if !ok {
+		return nil, fmt.Errorf("no type information for runtime type at %#x", addr)
+	}
+	return godwarf.ReadType(s.Dwarf, 0, off, s.typeCache)
+}
+
+// indexRuntimeTypes maps the offsets in the types section recorded by the
+// linker in AttrGoRuntimeType to the DWARF types.
+func (s *Scope) indexRuntimeTypes() error {
+	s.rtypes = map[uint64]dwarf.Offset{}
+
+	rdr := s.Dwarf.Reader()
+	for {
+		e, err := rdr.Next()
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			return nil
+		}
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			continue
+		case dwarf.TagSubprogram:
+			rdr.SkipChildren()
+			continue
+		}
+		off, ok := e.Val(godwarf.AttrGoRuntimeType).(uint64)
+		if !ok {
+			continue
+		}
+		if _, dup := s.rtypes[off]; !dup {
+			s.rtypes[off] = e.Offset
+		}
+	}
+}
+
+// typesBase returns the address of the types section of the target, read
+// from runtime.firstmoduledata unless Scope.TypesBase is set.
+func (s *Scope) typesBase() (uint64, error) {
+	if s.TypesBase != 0 {
+		return s.TypesBase, nil
+	}
+	md, err := s.global("runtime.firstmoduledata")
+	if err != nil {
+		return 0, err
+	}
+	types, err := s.readField(md.mem, md.Addr, md.Type, "types")
+	if err != nil {
+		return 0, err
+	}
+	s.TypesBase = types
+	return types, nil
+}
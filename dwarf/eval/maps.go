@@ -0,0 +1,367 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+)
+
+// Control bytes of swiss map groups have the high bit clear for full slots.
+const swissCtrlEmpty = 0x80
+
+// Go >= 1.24 swiss maps group their slots by 8
+const swissGroupSlots = 8
+
+// Classic hash maps mark valid slots with a tophash >= minTopHash, smaller
+// values mean the slot is empty or was evacuated to the new buckets.
+const (
+	hmapMinTopHash    = 5
+	hmapSameSizeGrow  = 8
+	hmapBucketEntries = 8
+)
+
+// mapLoader collects the entries of a map as children of v.
+type mapLoader struct {
+	s     *Scope
+	v     *Variable
+	t     *godwarf.MapType
+	depth int
+}
+
+// full reports whether MaxArrayValues entries were loaded
+func (m *mapLoader) full() bool {
+	return len(m.v.Children) >= 2*m.s.Config.MaxArrayValues
+}
+
+// add loads the entry at keyAddr and elemAddr, keyType and elemType are the
+// types of the slots, pointers to the actual types for indirect entries.
+func (m *mapLoader) add(keyAddr uint64, keyType godwarf.Type, elemAddr uint64, elemType godwarf.Type) error {
+	key, err := m.slot(keyAddr, keyType, m.t.KeyType)
+	if err != nil {
+		return err
+	}
+	elem, err := m.slot(elemAddr, elemType, m.t.ElemType)
+	if err != nil {
+		return err
+	}
+	m.s.load(key, m.depth+1)
+	m.s.load(elem, m.depth+1)
+	m.v.Children = append(m.v.Children, key, elem)
+	return nil
+}
+
+// slot returns the variable stored at addr, large keys and values are
+// stored indirectly: the slot has type *typ rather than typ.
+func (m *mapLoader) slot(addr uint64, slotType, typ godwarf.Type) (*Variable, error) {
+	if sameType(slotType, typ) {
+		return m.v.child("", addr, typ), nil
+	}
+	if _, ok := resolveTypedef(slotType).(*godwarf.PtrType); !ok {
+		return nil, fmt.Errorf("unexpected map slot type %s", slotType)
+	}
+	p, err := m.s.readPtr(m.v.mem, addr)
+	if err != nil {
+		return nil, err
+	}
+	return m.v.child("", p, typ), nil
+}
+
+func sameType(a, b godwarf.Type) bool {
+	return resolveTypedef(a).Common().Offset == resolveTypedef(b).Common().Offset
+}
+
+func (s *Scope) loadMap(v *Variable, t *godwarf.MapType, depth int, recurse bool) error {
+	p, err := s.readPtr(v.mem, v.Addr)
+	if err != nil {
+		return err
+	}
+	v.Value = p
+	if p == 0 {
+		return nil
+	}
+
+	ptr, ok := resolveTypedef(t.Type).(*godwarf.PtrType)
+	if !ok {
+		return fmt.Errorf("unexpected map layout %s", t.Type)
+	}
+	m := &mapLoader{s: s, v: v, t: t, depth: depth}
+	if _, err := field(ptr.Type, "dirPtr"); err == nil {
+		return m.loadSwiss(p, ptr.Type, recurse)
+	}
+	return m.loadHmap(p, ptr.Type, recurse)
+}
+
+// loadSwiss loads a Go >= 1.24 map, described by map<K,V>:
+//
+//	map<K,V>   { used uint64; dirPtr **table<K,V>; dirLen int; ... }
+//	table<K,V> { ...; groups groupReference<K,V> }
+//	groupReference<K,V> { data *group; lengthMask uint64 }
+//	group { ctrl uint64; slots [8]struct{ key K; elem V } }
+//
+// A map with dirLen == 0 is small, dirPtr points to a single group.
+func (m *mapLoader) loadSwiss(addr uint64, typ godwarf.Type, recurse bool) error {
+	s := m.s
+	used, err := s.readField(m.v.mem, addr, typ, "used")
+	if err != nil {
+		return err
+	}
+	m.v.Len = int64(used)
+	if !recurse || used == 0 {
+		return nil
+	}
+
+	dirPtr, err := s.readField(m.v.mem, addr, typ, "dirPtr")
+	if err != nil {
+		return err
+	}
+	dirLen, err := s.readField(m.v.mem, addr, typ, "dirLen")
+	if err != nil {
+		return err
+	}
+
+	// **table<K,V> => table<K,V> => groupReference<K,V> => group
+	f, _ := field(typ, "dirPtr")
+	tableType, err := deref(f.Type, 2)
+	if err != nil {
+		return err
+	}
+	groups, err := field(tableType, "groups")
+	if err != nil {
+		return err
+	}
+	data, err := field(groups.Type, "data")
+	if err != nil {
+		return err
+	}
+	groupType, err := deref(data.Type, 1)
+	if err != nil {
+		return err
+	}
+
+	if dirLen == 0 {
+		return m.swissGroup(dirPtr, groupType)
+	}
+
+	// a table appears several times in the directory if it's shared
+	seen := map[uint64]bool{}
+	for i := uint64(0); i < dirLen && !m.full(); i++ {
+		tab, err := s.readPtr(m.v.mem, dirPtr+i*uint64(s.PtrSize))
+		if err != nil {
+			return err
+		}
+		if seen[tab] {
+			continue
+		}
+		seen[tab] = true
+
+		ref := tab + uint64(groups.ByteOffset)
+		groupsData, err := s.readField(m.v.mem, ref, groups.Type, "data")
+		if err != nil {
+			return err
+		}
+		lengthMask, err := s.readField(m.v.mem, ref, groups.Type, "lengthMask")
+		if err != nil {
+			return err
+		}
+		size := uint64(groupType.Size())
+		for g := uint64(0); g <= lengthMask && !m.full(); g++ {
+			if err := m.swissGroup(groupsData+g*size, groupType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// swissGroup loads the full slots of the group at addr, slots are either
+// interleaved key/elem pairs or separate key and elem arrays.
+func (m *mapLoader) swissGroup(addr uint64, typ godwarf.Type) error {
+	s := m.s
+	ctrlField, err := field(typ, "ctrl")
+	if err != nil {
+		return err
+	}
+	ctrl, err := s.readBytes(m.v.mem, addr+uint64(ctrlField.ByteOffset), swissGroupSlots)
+	if err != nil {
+		return err
+	}
+
+	slot, err := swissSlots(typ)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < swissGroupSlots && !m.full(); i++ {
+		if ctrl[i]&swissCtrlEmpty != 0 {
+			continue
+		}
+		keyAddr, keyType, elemAddr, elemType := slot(addr, uint64(i))
+		if err := m.add(keyAddr, keyType, elemAddr, elemType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// swissSlots returns a function computing the address and type of the key
+// and elem of slot i of a group of type typ at addr.
+func swissSlots(typ godwarf.Type) (func(addr, i uint64) (uint64, godwarf.Type, uint64, godwarf.Type), error) {
+	if slots, err := field(typ, "slots"); err == nil {
+		arr, ok := resolveTypedef(slots.Type).(*godwarf.ArrayType)
+		if !ok {
+			return nil, fmt.Errorf("unexpected group layout %s", typ)
+		}
+		key, err := field(arr.Type, "key")
+		if err != nil {
+			return nil, err
+		}
+		elem, err := field(arr.Type, "elem")
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(arr.Type.Size())
+		return func(addr, i uint64) (uint64, godwarf.Type, uint64, godwarf.Type) {
+			slot := addr + uint64(slots.ByteOffset) + i*size
+			return slot + uint64(key.ByteOffset), key.Type, slot + uint64(elem.ByteOffset), elem.Type
+		}, nil
+	}
+
+	keys, err := field(typ, "keys")
+	if err != nil {
+		return nil, err
+	}
+	elems, err := field(typ, "elems")
+	if err != nil {
+		return nil, err
+	}
+	keyArr, ok1 := resolveTypedef(keys.Type).(*godwarf.ArrayType)
+	elemArr, ok2 := resolveTypedef(elems.Type).(*godwarf.ArrayType)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("unexpected group layout %s", typ)
+	}
+	keySize, elemSize := uint64(keyArr.Type.Size()), uint64(elemArr.Type.Size())
+	return func(addr, i uint64) (uint64, godwarf.Type, uint64, godwarf.Type) {
+		return addr + uint64(keys.ByteOffset) + i*keySize, keyArr.Type,
+			addr + uint64(elems.ByteOffset) + i*elemSize, elemArr.Type
+	}, nil
+}
+
+// loadHmap loads a Go < 1.24 map, described by hash<K,V>:
+//
+//	hash<K,V>   { count int; flags uint8; B uint8; ...; buckets *bucket<K,V>; oldbuckets *bucket<K,V>; ... }
+//	bucket<K,V> { tophash [8]uint8; keys [8]K; values [8]V; overflow *bucket<K,V> }
+//
+// While the map grows entries not yet evacuated are still in oldbuckets.
+func (m *mapLoader) loadHmap(addr uint64, typ godwarf.Type, recurse bool) error {
+	s := m.s
+	count, err := s.readField(m.v.mem, addr, typ, "count")
+	if err != nil {
+		return err
+	}
+	m.v.Len = int64(count)
+	if !recurse || count == 0 {
+		return nil
+	}
+
+	b, err := s.readField(m.v.mem, addr, typ, "B")
+	if err != nil {
+		return err
+	}
+	flags, err := s.readField(m.v.mem, addr, typ, "flags")
+	if err != nil {
+		return err
+	}
+	buckets, err := s.readField(m.v.mem, addr, typ, "buckets")
+	if err != nil {
+		return err
+	}
+	oldbuckets, err := s.readField(m.v.mem, addr, typ, "oldbuckets")
+	if err != nil {
+		return err
+	}
+
+	f, err := field(typ, "buckets")
+	if err != nil {
+		return err
+	}
+	bucketType, err := deref(f.Type, 1)
+	if err != nil {
+		return err
+	}
+
+	nbuckets := uint64(1) << b
+	noldbuckets := nbuckets / 2
+	if flags&hmapSameSizeGrow != 0 {
+		noldbuckets = nbuckets
+	}
+
+	if oldbuckets != 0 {
+		if err := m.hmapBuckets(oldbuckets, noldbuckets, bucketType); err != nil {
+			return err
+		}
+	}
+	return m.hmapBuckets(buckets, nbuckets, bucketType)
+}
+
+// hmapBuckets loads the n buckets at addr and their overflow buckets
+func (m *mapLoader) hmapBuckets(addr, n uint64, typ godwarf.Type) error {
+	s := m.s
+	tophash, err := field(typ, "tophash")
+	if err != nil {
+		return err
+	}
+	keys, err := field(typ, "keys")
+	if err != nil {
+		return err
+	}
+	values, err := field(typ, "values")
+	if err != nil {
+		return err
+	}
+	overflow, err := field(typ, "overflow")
+	if err != nil {
+		return err
+	}
+	keyArr, ok1 := resolveTypedef(keys.Type).(*godwarf.ArrayType)
+	valArr, ok2 := resolveTypedef(values.Type).(*godwarf.ArrayType)
+	if !ok1 || !ok2 {
+		return fmt.Errorf("unexpected bucket layout %s", typ)
+	}
+	keySize, valSize := uint64(keyArr.Type.Size()), uint64(valArr.Type.Size())
+
+	size := uint64(typ.Size())
+	for i := uint64(0); i < n && !m.full(); i++ {
+		for b := addr + i*size; b != 0 && !m.full(); {
+			th, err := s.readBytes(m.v.mem, b+uint64(tophash.ByteOffset), hmapBucketEntries)
+			if err != nil {
+				return err
+			}
+			for j := uint64(0); j < hmapBucketEntries && !m.full(); j++ {
+				if th[j] < hmapMinTopHash {
+					continue
+				}
+				err := m.add(b+uint64(keys.ByteOffset)+j*keySize, keyArr.Type,
+					b+uint64(values.ByteOffset)+j*valSize, valArr.Type)
+				if err != nil {
+					return err
+				}
+			}
+			if b, err = s.readPtr(m.v.mem, b+uint64(overflow.ByteOffset)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deref returns the type pointed to by n levels of pointers from typ
+func deref(typ godwarf.Type, n int) (godwarf.Type, error) {
+	for ; n > 0; n-- {
+		ptr, ok := resolveTypedef(typ).(*godwarf.PtrType)
+		if !ok {
+			return nil, errors.New("expected pointer type, got " + typ.String())
+		}
+		typ = ptr.Type
+	}
+	return typ, nil
+}
@@ -0,0 +1,62 @@
+package eval
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+)
+
+// fakeAddress is where values assembled from registers are mapped, it's
+// outside of the user address space so it can't clash with real memory.
+const fakeAddress = 0xbeefd00d00000000
+
+// newCompositeMemory maps data at fakeAddress, reads elsewhere go to mem.
+func newCompositeMemory(mem op.ReadMemoryFunc, data []byte) op.ReadMemoryFunc {
+	return func(buf []byte, addr uint64) (int, error) {
+		if addr < fakeAddress || addr >= fakeAddress+uint64(len(data)) {
+			return mem(buf, addr)
+		}
+		off := addr - fakeAddress
+		if off+uint64(len(buf)) > uint64(len(data)) {
+			return 0, fmt.Errorf("read of %d bytes at %#x out of bounds", len(buf), addr)
+		}
+		return copy(buf, data[off:]), nil
+	}
+}
+
+// Snapshot is a copy of some regions of memory of a process, e.g. the
+// contents of a core file.
+type Snapshot struct {
+	regions []region // sorted by addr
+}
+
+type region struct {
+	addr uint64
+	data []byte
+}
+
+func (r region) end() uint64 { return r.addr + uint64(len(r.data)) }
+
+// Add maps data at addr, it must not overlap previously added regions.
+func (s *Snapshot) Add(addr uint64, data []byte) {
+	i := sort.Search(len(s.regions), func(i int) bool { return s.regions[i].addr >= addr })
+	s.regions = append(s.regions, region{})
+	copy(s.regions[i+1:], s.regions[i:])
+	s.regions[i] = region{addr: addr, data: data}
+}
+
+// ReadMemory implements op.ReadMemoryFunc, reads may span adjacent regions.
+func (s *Snapshot) ReadMemory(buf []byte, addr uint64) (int, error) {
+	n := 0
+	for n < len(buf) {
+		cur := addr + uint64(n)
+		i := sort.Search(len(s.regions), func(i int) bool { return s.regions[i].end() > cur })
+		if i == len(s.regions) || s.regions[i].addr > cur {
+			return n, fmt.Errorf("address %#x not mapped", cur)
+		}
+		r := s.regions[i]
+		n += copy(buf[n:], r.data[cur-r.addr:])
+	}
+	return n, nil
+}
@@ -0,0 +1,138 @@
+package eval
+
+import (
+	"bufio"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+)
+
+// procScope builds and starts testdata/proc, and returns a scope reading
+// its memory through /proc/<pid>/mem once it's ready.
+func procScope(t *testing.T) *Scope {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads /proc/<pid>/mem")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not found")
+	}
+
+	exe := filepath.Join(t.TempDir(), "proc")
+	build := exec.Command(gobin, "build", "-o", exe, "./testdata/proc")
+	out, err := build.CombinedOutput()
+	require.Nil(t, err, string(out))
+
+	f, err := elf.Open(exe)
+	require.Nil(t, err)
+	defer f.Close()
+	if f.Type != elf.ET_EXEC {
+		t.Skip("position independent executable")
+	}
+	dw, err := f.DWARF()
+	require.Nil(t, err)
+
+	cmd := exec.Command(exe)
+	stdin, err := cmd.StdinPipe()
+	require.Nil(t, err)
+	stdout, err := cmd.StdoutPipe()
+	require.Nil(t, err)
+	require.Nil(t, cmd.Start())
+	t.Cleanup(func() {
+		stdin.Close()
+		cmd.Wait()
+	})
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.Nil(t, err)
+	require.Equal(t, "ready\n", line)
+
+	mem, err := os.Open("/proc/" + strconv.Itoa(cmd.Process.Pid) + "/mem")
+	if err != nil {
+		t.Skipf("can't read process memory: %v", err)
+	}
+	t.Cleanup(func() { mem.Close() })
+	readMemory := func(buf []byte, addr uint64) (int, error) {
+		return mem.ReadAt(buf, int64(addr))
+	}
+
+	s, err := New(dw, readMemory, op.DwarfRegisters{ByteOrder: binary.LittleEndian}, 0)
+	require.Nil(t, err)
+	s.Config.MaxArrayValues = 1000
+	return s
+}
+
+func TestProcess(t *testing.T) {
+	s := procScope(t)
+
+	t.Run("map", func(t *testing.T) {
+		v, err := s.Global("main.smallMap")
+		require.Nil(t, err)
+		require.Nil(t, v.Unreadable)
+		assert.Equal(t, int64(3), v.Len)
+
+		got := map[string]int64{}
+		for i := 0; i < len(v.Children); i += 2 {
+			got[v.Children[i].Value.(string)] = v.Children[i+1].Value.(int64)
+		}
+		assert.Equal(t, map[string]int64{"one": 1, "two": 2, "three": 3}, got)
+	})
+
+	t.Run("big map", func(t *testing.T) {
+		v, err := s.Global("main.bigMap")
+		require.Nil(t, err)
+		require.Nil(t, v.Unreadable)
+		assert.Equal(t, int64(100), v.Len)
+		require.Len(t, v.Children, 200)
+
+		for i := 0; i < len(v.Children); i += 2 {
+			k, val := v.Children[i].Value.(int64), v.Children[i+1].Value
+			assert.Equal(t, strconv.Itoa(int(k)), val)
+		}
+	})
+
+	t.Run("interface", func(t *testing.T) {
+		v, err := s.Global("main.iface")
+		require.Nil(t, err)
+		require.Nil(t, v.Unreadable)
+		require.Len(t, v.Children, 1)
+		assert.Equal(t, "int", v.Children[0].Name)
+		assert.Equal(t, int64(42), v.Children[0].Value)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		v, err := s.Global("main.err")
+		require.Nil(t, err)
+		require.Nil(t, v.Unreadable)
+		require.Len(t, v.Children, 1)
+
+		// *errors.errorString is stored in the data word
+		ptr := v.Children[0]
+		assert.Equal(t, "*errors.errorString", ptr.Name)
+		require.Len(t, ptr.Children, 1)
+		require.Len(t, ptr.Children[0].Children, 1)
+		assert.Equal(t, "boom", ptr.Children[0].Children[0].Value)
+	})
+
+	t.Run("chan", func(t *testing.T) {
+		v, err := s.Global("main.ch")
+		require.Nil(t, err)
+		require.Nil(t, v.Unreadable)
+		assert.Equal(t, int64(3), v.Len)
+		assert.Equal(t, int64(4), v.Cap)
+		require.Len(t, v.Children, 3)
+		for i, want := range []int64{3, 4, 5} {
+			assert.Equal(t, want, v.Children[i].Value)
+		}
+	})
+}
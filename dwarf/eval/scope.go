@@ -0,0 +1,391 @@
+// Package eval reads the values of Go variables described by DWARF.
+//
+// It ties together reader.Variables, which finds the variables visible at a
+// PC, op.ExecuteStackProgram, which evaluates their location expressions, and
+// godwarf, which decodes their types. Memory is accessed through an
+// op.ReadMemoryFunc, so the same code works against a live process, a core
+// file or a Snapshot.
+package eval
+
+import (
+	"debug/dwarf"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+	"github.com/hitzhangjie/codemaster/dwarf/loclist"
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+	"github.com/hitzhangjie/codemaster/dwarf/reader"
+)
+
+// ErrNoFunction is returned by Locals and Args when PC is not inside any function.
+var ErrNoFunction = errors.New("no function contains pc")
+
+// Scope is the context variables are evaluated in: the DWARF data of the
+// binary, the memory and registers of the process and the current PC.
+type Scope struct {
+	Dwarf *dwarf.Data
+	Regs  op.DwarfRegisters
+	PC    uint64
+
+	// Line is the current line, variables declared after it are hidden.
+	// Zero disables the check.
+	Line int
+
+	// PtrSize is the size of pointers of the target, 8 by default.
+	PtrSize int
+
+	// Loclist is used for variables whose location is a location list,
+	// typically in optimized binaries. Optional.
+	Loclist loclist.Reader
+
+	// DebugAddr is the .debug_addr section, DWARF 5 location lists refer
+	// to addresses in it. Optional.
+	DebugAddr *godwarf.DebugAddrSection
+
+	// Config limits how much of each variable is loaded.
+	Config LoadConfig
+
+	// TypesBase is the address of the runtime type descriptors, used to
+	// find the dynamic type of interfaces. It's read from
+	// runtime.firstmoduledata when zero.
+	TypesBase uint64
+
+	readMemory op.ReadMemoryFunc
+
+	fn       *godwarf.Tree // function containing PC, nil if none
+	cu       dwarf.Offset  // of the compile unit of fn
+	cuBase   uint64        // lowpc of the compile unit of fn
+	addrBase uint64        // DW_AT_addr_base of the compile unit of fn
+
+	typeCache map[dwarf.Offset]godwarf.Type
+	rtypes    map[uint64]dwarf.Offset // offset in the types section => DWARF type
+}
+
+// New returns a scope for PC.
+//
+// regs.StaticBase is the address the binary is loaded at, non-zero for PIE.
+// regs.CFA must be set, usually by unwinding the stack, for the frame base
+// of the function to be computed; alternatively regs.FrameBase can be set
+// directly.
+func New(dw *dwarf.Data, readMemory op.ReadMemoryFunc, regs op.DwarfRegisters, pc uint64) (*Scope, error) {
+	s := &Scope{
+		Dwarf:      dw,
+		Regs:       regs,
+		PC:         pc,
+		PtrSize:    8,
+		Config:     DefaultLoadConfig,
+		readMemory: readMemory,
+		typeCache:  map[dwarf.Offset]godwarf.Type{},
+	}
+
+	if err := s.findFunction(); err != nil {
+		return nil, err
+	}
+	if s.fn != nil && s.Regs.FrameBase == 0 {
+		if fb, ok := s.fn.Val(dwarf.AttrFrameBase).([]byte); ok {
+			s.Regs.FrameBase, _, _ = op.ExecuteStackProgram(s.Regs, fb, s.PtrSize, s.readMemory)
+		}
+	}
+	return s, nil
+}
+
+// Function returns the name of the function containing PC, "" if none.
+func (s *Scope) Function() string {
+	if s.fn == nil {
+		return ""
+	}
+	name, _ := s.fn.Val(dwarf.AttrName).(string)
+	return name
+}
+
+// findFunction finds the subprogram containing PC, compile units whose
+// ranges are known not to contain PC are skipped.
+func (s *Scope) findFunction() error {
+	rdr := s.Dwarf.Reader()
+	pc := s.PC - s.Regs.StaticBase
+
+	for {
+		e, err := rdr.Next()
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			return nil
+		}
+
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			rngs, _ := s.Dwarf.Ranges(e)
+			if len(rngs) > 0 && !inRanges(rngs, pc) {
+				rdr.SkipChildren()
+				continue
+			}
+			s.cu = e.Offset
+			s.cuBase, _ = e.Val(dwarf.AttrLowpc).(uint64)
+			addrBase, _ := e.Val(dwarf.AttrAddrBase).(int64)
+			s.addrBase = uint64(addrBase)
+
+		case dwarf.TagSubprogram:
+			rngs, _ := s.Dwarf.Ranges(e)
+			if !inRanges(rngs, pc) {
+				rdr.SkipChildren()
+				continue
+			}
+			fn, err := godwarf.LoadTree(e.Offset, s.Dwarf, s.Regs.StaticBase)
+			if err != nil {
+				return err
+			}
+			s.fn = fn
+			return nil
+
+		default:
+			rdr.SkipChildren()
+		}
+	}
+}
+
+func inRanges(rngs [][2]uint64, pc uint64) bool {
+	for _, rng := range rngs {
+		if rng[0] <= pc && pc < rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Locals returns the local variables visible at PC.
+func (s *Scope) Locals() ([]*Variable, error) {
+	return s.functionVariables(dwarf.TagVariable)
+}
+
+// Args returns the arguments and return values of the function containing PC.
+func (s *Scope) Args() ([]*Variable, error) {
+	return s.functionVariables(dwarf.TagFormalParameter)
+}
+
+func (s *Scope) functionVariables(tag dwarf.Tag) ([]*Variable, error) {
+	if s.fn == nil {
+		return nil, ErrNoFunction
+	}
+
+	flags := reader.VariablesOnlyVisible
+	if s.Line == 0 {
+		flags |= reader.VariablesNoDeclLineCheck
+	}
+
+	var vars []*Variable
+	for _, v := range reader.Variables(s.fn, s.PC, s.Line, flags) {
+		if v.Tag != tag {
+			continue
+		}
+		vars = append(vars, s.variable(v.Tree))
+	}
+	return vars, nil
+}
+
+// Globals returns the package variables of package pkg, e.g. "main" or
+// "net/http", all package variables if pkg is empty.
+func (s *Scope) Globals(pkg string) ([]*Variable, error) {
+	var vars []*Variable
+	err := s.packageVariables(func(e *dwarf.Entry, name string) bool {
+		if pkg == "" || strings.HasPrefix(name, pkg+".") && !strings.Contains(name[len(pkg)+1:], "/") {
+			vars = append(vars, s.variable(godwarf.EntryToTree(e)))
+		}
+		return true
+	})
+	return vars, err
+}
+
+// Global returns the package variable with the fully qualified name, e.g.
+// "main.counter".
+func (s *Scope) Global(name string) (*Variable, error) {
+	v, err := s.global(name)
+	if err != nil {
+		return nil, err
+	}
+	s.load(v, 0)
+	return v, nil
+}
+
+// global returns the package variable name without loading its value
+func (s *Scope) global(name string) (*Variable, error) {
+	var v *Variable
+	err := s.packageVariables(func(e *dwarf.Entry, n string) bool {
+		if n != name {
+			return true
+		}
+		v = s.locateVariable(godwarf.EntryToTree(e))
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("could not find package variable %s", name)
+	}
+	if v.Unreadable != nil {
+		return nil, v.Unreadable
+	}
+	return v, nil
+}
+
+func (s *Scope) packageVariables(fn func(e *dwarf.Entry, name string) bool) error {
+	rdr := reader.New(s.Dwarf)
+	for {
+		e, err := rdr.NextPackageVariable()
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			return nil
+		}
+		name, _ := e.Val(dwarf.AttrName).(string)
+		if !fn(e, name) {
+			return nil
+		}
+	}
+}
+
+// variable creates and loads the variable described by the DIE n
+func (s *Scope) variable(n *godwarf.Tree) *Variable {
+	v := s.locateVariable(n)
+	s.load(v, 0)
+	return v
+}
+
+// locateVariable creates the variable described by the DIE n, its value
+// isn't loaded.
+func (s *Scope) locateVariable(n *godwarf.Tree) *Variable {
+	name, _ := n.Val(dwarf.AttrName).(string)
+	v := &Variable{Name: name, mem: s.readMemory}
+
+	typ, err := n.Type(s.Dwarf, 0, s.typeCache)
+	if err != nil {
+		v.Unreadable = err
+		return v
+	}
+	v.Type = typ
+	v.Kind = kindOf(typ)
+
+	if err := s.locate(v, n); err != nil {
+		v.Unreadable = err
+	}
+	return v
+}
+
+// locate evaluates the location of the variable n and sets v.Addr, values
+// split across registers are copied to a composite memory.
+func (s *Scope) locate(v *Variable, n *godwarf.Tree) error {
+	var instr []byte
+	switch loc := n.Val(dwarf.AttrLocation).(type) {
+	case []byte:
+		instr = loc
+	case int64:
+		if s.Loclist == nil || s.Loclist.Empty() {
+			return errors.New("location list not available")
+		}
+		e, err := s.Loclist.Find(int(loc), s.Regs.StaticBase, s.cuBase, s.PC, s.DebugAddr.GetSubsection(s.addrBase))
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			return errors.New("variable not available at pc")
+		}
+		instr = e.Instr
+	default:
+		return errors.New("variable has no location")
+	}
+
+	addr, pieces, err := op.ExecuteStackProgramWithHooks(s.Regs, instr, s.PtrSize, s.readMemory, s.hooks())
+	if err != nil {
+		return err
+	}
+	if pieces == nil {
+		v.Addr = uint64(addr)
+		return nil
+	}
+
+	data, err := s.readPieces(pieces, v.Type.Size())
+	if err != nil {
+		return err
+	}
+	v.mem = newCompositeMemory(s.readMemory, data)
+	v.Addr = fakeAddress
+	return nil
+}
+
+// hooks returns the hooks of the location expressions of the compile unit
+// of fn, the DIEs are read from the DWARF and the indexed addresses from
+// DebugAddr.
+func (s *Scope) hooks() op.Hooks {
+	hooks := op.Hooks{
+		DIE: func(off uint64, unit bool) (*op.DIE, error) {
+			if unit {
+				off += uint64(s.cu)
+			}
+			rdr := s.Dwarf.Reader()
+			rdr.Seek(dwarf.Offset(off))
+			e, err := rdr.Next()
+			if err != nil {
+				return nil, err
+			}
+			if e == nil {
+				return nil, fmt.Errorf("no DIE at %#x", off)
+			}
+			die := &op.DIE{}
+			die.Location, _ = e.Val(dwarf.AttrLocation).([]byte)
+			size, _ := e.Val(dwarf.AttrByteSize).(int64)
+			die.ByteSize = int(size)
+			die.Encoding, _ = e.Val(dwarf.AttrEncoding).(int64)
+			return die, nil
+		},
+	}
+	if s.DebugAddr != nil {
+		hooks.DebugAddr = s.DebugAddr.GetSubsection(s.addrBase).Get
+	}
+	return hooks
+}
+
+// readPieces concatenates the bytes of pieces
+func (s *Scope) readPieces(pieces []op.Piece, size int64) ([]byte, error) {
+	var data []byte
+	for _, p := range pieces {
+		sz := p.Size
+		if sz == 0 && len(pieces) == 1 {
+			sz = int(size)
+		}
+
+		switch p.Kind {
+		case op.RegPiece:
+			reg := s.Regs.Bytes(p.Val)
+			if reg == nil {
+				return nil, fmt.Errorf("register %d not available", p.Val)
+			}
+			if sz > len(reg) {
+				sz = len(reg)
+			}
+			data = append(data, reg[:sz]...)
+		case op.AddrPiece:
+			buf := make([]byte, sz)
+			if _, err := s.readMemory(buf, p.Val); err != nil {
+				return nil, err
+			}
+			data = append(data, buf...)
+		case op.ImmPiece:
+			buf := p.Bytes
+			if buf == nil {
+				buf = make([]byte, 8)
+				s.byteOrder().PutUint64(buf, p.Val)
+			}
+			if sz > len(buf) {
+				buf = append(buf, make([]byte, sz-len(buf))...)
+			}
+			data = append(data, buf[:sz]...)
+		default:
+			return nil, fmt.Errorf("unsupported piece of kind %d", p.Kind)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,38 @@
+// Program proc holds a few package variables for TestProcess to read, it
+// prints "ready" and waits for stdin to be closed.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+var (
+	smallMap             = map[string]int{"one": 1, "two": 2, "three": 3}
+	bigMap               = map[int]string{}
+	iface    interface{} = 42
+	err      error       = errors.New("boom")
+	ch                   = make(chan int, 4)
+)
+
+func main() {
+	for i := 0; i < 100; i++ {
+		bigMap[i] = strconv.Itoa(i)
+	}
+
+	// wrap the ring buffer around: the queue is 3, 4, 5 starting at index 2
+	for i := 1; i <= 4; i++ {
+		ch <- i
+	}
+	<-ch
+	<-ch
+	ch <- 5
+
+	fmt.Println("ready")
+	ioutil.ReadAll(os.Stdin)
+
+	fmt.Println(len(smallMap), len(bigMap), iface, err, len(ch))
+}
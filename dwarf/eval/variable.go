@@ -0,0 +1,469 @@
+package eval
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+)
+
+// LoadConfig controls how much of a variable is read from memory.
+type LoadConfig struct {
+	// FollowPointers loads the value pointed to by pointers.
+	FollowPointers bool
+	// MaxVariableRecurse is how far composite values are loaded, children
+	// of values deeper than this are not loaded.
+	MaxVariableRecurse int
+	// MaxStringLen is the maximum number of bytes read for a string.
+	MaxStringLen int
+	// MaxArrayValues is the maximum number of elements of arrays, slices,
+	// maps and channels loaded.
+	MaxArrayValues int
+}
+
+// DefaultLoadConfig is the LoadConfig of a new Scope.
+var DefaultLoadConfig = LoadConfig{
+	FollowPointers:     true,
+	MaxVariableRecurse: 1,
+	MaxStringLen:       64,
+	MaxArrayValues:     64,
+}
+
+// Variable is a value read from the target.
+type Variable struct {
+	Name string
+	Addr uint64
+	Type godwarf.Type
+	Kind reflect.Kind
+
+	// Value holds basic values: int64 for signed integers, uint64 for
+	// unsigned integers and pointers, float64, complex128, bool and string.
+	// For functions it's the entry PC of the closure.
+	Value interface{}
+
+	// Len and Cap are the length and capacity of strings, arrays, slices,
+	// maps and channels. Len may be larger than len(Children) or the
+	// length of a string Value if the load limits were hit.
+	Len int64
+	Cap int64
+
+	// Children are the fields of structs, elements of arrays, slices and
+	// channels, the value pointed to by pointers and the dynamic value of
+	// interfaces. Map children alternate keys and values.
+	Children []*Variable
+
+	// Unreadable is set if the variable couldn't be read.
+	Unreadable error
+
+	mem op.ReadMemoryFunc
+}
+
+// child returns a new variable of type typ at addr sharing v's memory
+func (v *Variable) child(name string, addr uint64, typ godwarf.Type) *Variable {
+	return &Variable{Name: name, Addr: addr, Type: typ, Kind: kindOf(typ), mem: v.mem}
+}
+
+// resolveTypedef returns the type typ is an alias of, Go maps, channels and
+// interfaces are kept as they are.
+func resolveTypedef(typ godwarf.Type) godwarf.Type {
+	for {
+		switch t := typ.(type) {
+		case *godwarf.TypedefType:
+			typ = t.Type
+		case *godwarf.QualType:
+			typ = t.Type
+		default:
+			return typ
+		}
+	}
+}
+
+// kindOf returns the reflect.Kind of typ, from the Go kind attribute if
+// present or from the DWARF type otherwise.
+func kindOf(typ godwarf.Type) reflect.Kind {
+	if typ == nil {
+		return reflect.Invalid
+	}
+	if k := typ.Common().ReflectKind; k != reflect.Invalid {
+		return k
+	}
+
+	switch t := resolveTypedef(typ).(type) {
+	case *godwarf.IntType, *godwarf.CharType, *godwarf.EnumType:
+		return intKind(t.Size(), reflect.Int8)
+	case *godwarf.UintType, *godwarf.UcharType, *godwarf.AddrType:
+		return intKind(t.Size(), reflect.Uint8)
+	case *godwarf.BoolType:
+		return reflect.Bool
+	case *godwarf.FloatType:
+		if t.Size() == 4 {
+			return reflect.Float32
+		}
+		return reflect.Float64
+	case *godwarf.ComplexType:
+		if t.Size() == 8 {
+			return reflect.Complex64
+		}
+		return reflect.Complex128
+	case *godwarf.StringType:
+		return reflect.String
+	case *godwarf.SliceType:
+		return reflect.Slice
+	case *godwarf.ArrayType:
+		return reflect.Array
+	case *godwarf.StructType:
+		return reflect.Struct
+	case *godwarf.PtrType:
+		if _, ok := t.Type.(*godwarf.VoidType); ok {
+			return reflect.UnsafePointer
+		}
+		return reflect.Ptr
+	case *godwarf.MapType:
+		return reflect.Map
+	case *godwarf.ChanType:
+		return reflect.Chan
+	case *godwarf.InterfaceType:
+		return reflect.Interface
+	case *godwarf.FuncType:
+		return reflect.Func
+	}
+	return reflect.Invalid
+}
+
+// intKind returns the kind of the integer of size bytes, base is Int8 or Uint8
+func intKind(size int64, base reflect.Kind) reflect.Kind {
+	switch size {
+	case 1:
+		return base
+	case 2:
+		return base + 1
+	case 4:
+		return base + 2
+	default:
+		return base + 3
+	}
+}
+
+func (s *Scope) byteOrder() binary.ByteOrder {
+	if s.Regs.ByteOrder != nil {
+		return s.Regs.ByteOrder
+	}
+	return binary.LittleEndian
+}
+
+func (s *Scope) readBytes(mem op.ReadMemoryFunc, addr uint64, n int64) ([]byte, error) {
+	buf := make([]byte, n)
+	if n == 0 {
+		return buf, nil
+	}
+	if _, err := mem(buf, addr); err != nil {
+		return nil, fmt.Errorf("could not read %d bytes at %#x: %v", n, addr, err)
+	}
+	return buf, nil
+}
+
+// readUint reads an unsigned integer of size bytes at addr
+func (s *Scope) readUint(mem op.ReadMemoryFunc, addr uint64, size int64) (uint64, error) {
+	buf, err := s.readBytes(mem, addr, size)
+	if err != nil {
+		return 0, err
+	}
+	bo := s.byteOrder()
+	switch size {
+	case 1:
+		return uint64(buf[0]), nil
+	case 2:
+		return uint64(bo.Uint16(buf)), nil
+	case 4:
+		return uint64(bo.Uint32(buf)), nil
+	case 8:
+		return bo.Uint64(buf), nil
+	default:
+		return 0, fmt.Errorf("unsupported integer size %d", size)
+	}
+}
+
+func (s *Scope) readPtr(mem op.ReadMemoryFunc, addr uint64) (uint64, error) {
+	return s.readUint(mem, addr, int64(s.PtrSize))
+}
+
+// structOf returns the struct describing typ, strings and slices are
+// described by the runtime structs of their headers.
+func structOf(typ godwarf.Type) *godwarf.StructType {
+	switch t := resolveTypedef(typ).(type) {
+	case *godwarf.StructType:
+		return t
+	case *godwarf.StringType:
+		return &t.StructType
+	case *godwarf.SliceType:
+		return &t.StructType
+	}
+	return nil
+}
+
+// field returns the field called name of the struct typ
+func field(typ godwarf.Type, name string) (*godwarf.StructField, error) {
+	st := structOf(typ)
+	if st == nil {
+		return nil, fmt.Errorf("%s is not a struct", typ)
+	}
+	for _, f := range st.Field {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no field %s", typ, name)
+}
+
+// readField reads the integer or pointer field called name of the struct
+// typ at addr.
+func (s *Scope) readField(mem op.ReadMemoryFunc, addr uint64, typ godwarf.Type, name string) (uint64, error) {
+	f, err := field(typ, name)
+	if err != nil {
+		return 0, err
+	}
+	return s.readUint(mem, addr+uint64(f.ByteOffset), f.Type.Size())
+}
+
+// load reads the value of v, depth is how deep v is nested in the variable
+// being loaded.
+func (s *Scope) load(v *Variable, depth int) {
+	if v.Unreadable != nil {
+		return
+	}
+	if err := s.loadValue(v, depth); err != nil {
+		v.Unreadable = err
+	}
+}
+
+func (s *Scope) loadValue(v *Variable, depth int) error {
+	cfg := s.Config
+	recurse := depth <= cfg.MaxVariableRecurse
+
+	switch t := resolveTypedef(v.Type).(type) {
+	case *godwarf.IntType, *godwarf.CharType, *godwarf.EnumType:
+		n, err := s.readUint(v.mem, v.Addr, t.Size())
+		if err != nil {
+			return err
+		}
+		shift := 64 - 8*uint(t.Size())
+		v.Value = int64(n<<shift) >> shift
+
+	case *godwarf.UintType, *godwarf.UcharType, *godwarf.AddrType:
+		n, err := s.readUint(v.mem, v.Addr, t.Size())
+		if err != nil {
+			return err
+		}
+		v.Value = n
+
+	case *godwarf.BoolType:
+		n, err := s.readUint(v.mem, v.Addr, t.Size())
+		if err != nil {
+			return err
+		}
+		v.Value = n != 0
+
+	case *godwarf.FloatType:
+		n, err := s.readUint(v.mem, v.Addr, t.Size())
+		if err != nil {
+			return err
+		}
+		if t.Size() == 4 {
+			v.Value = float64(math.Float32frombits(uint32(n)))
+		} else {
+			v.Value = math.Float64frombits(n)
+		}
+
+	case *godwarf.ComplexType:
+		half := t.Size() / 2
+		re, err := s.readUint(v.mem, v.Addr, half)
+		if err != nil {
+			return err
+		}
+		im, err := s.readUint(v.mem, v.Addr+uint64(half), half)
+		if err != nil {
+			return err
+		}
+		if half == 4 {
+			v.Value = complex(float64(math.Float32frombits(uint32(re))), float64(math.Float32frombits(uint32(im))))
+		} else {
+			v.Value = complex(math.Float64frombits(re), math.Float64frombits(im))
+		}
+
+	case *godwarf.StringType:
+		return s.loadString(v, t)
+
+	case *godwarf.SliceType:
+		return s.loadSlice(v, t, depth, recurse)
+
+	case *godwarf.ArrayType:
+		v.Len = t.Count
+		v.Cap = t.Count
+		if recurse {
+			s.loadElems(v, v.Addr, t.Type, t.Count, depth)
+		}
+
+	case *godwarf.StructType:
+		if !recurse {
+			return nil
+		}
+		for _, f := range t.Field {
+			c := v.child(f.Name, v.Addr+uint64(f.ByteOffset), f.Type)
+			s.load(c, depth+1)
+			v.Children = append(v.Children, c)
+		}
+
+	case *godwarf.PtrType:
+		p, err := s.readPtr(v.mem, v.Addr)
+		if err != nil {
+			return err
+		}
+		v.Value = p
+		if _, void := t.Type.(*godwarf.VoidType); void || p == 0 || !cfg.FollowPointers || !recurse {
+			return nil
+		}
+		c := v.child("", p, t.Type)
+		s.load(c, depth+1)
+		v.Children = []*Variable{c}
+
+	case *godwarf.FuncType:
+		// a func value points to a closure whose first word is the entry pc
+		p, err := s.readPtr(v.mem, v.Addr)
+		if err != nil || p == 0 {
+			return err
+		}
+		pc, err := s.readPtr(v.mem, p)
+		if err != nil {
+			return err
+		}
+		v.Value = pc
+
+	case *godwarf.MapType:
+		return s.loadMap(v, t, depth, recurse)
+
+	case *godwarf.ChanType:
+		return s.loadChan(v, t, depth, recurse)
+
+	case *godwarf.InterfaceType:
+		return s.loadInterface(v, t, depth)
+
+	case *godwarf.UnsupportedType:
+		return fmt.Errorf("unsupported type %s", t)
+
+	case nil:
+		return errors.New("unknown type")
+	}
+	return nil
+}
+
+func (s *Scope) loadString(v *Variable, t *godwarf.StringType) error {
+	ptr, err := s.readField(v.mem, v.Addr, t, "str")
+	if err != nil {
+		return err
+	}
+	n, err := s.readField(v.mem, v.Addr, t, "len")
+	if err != nil {
+		return err
+	}
+	v.Len = int64(n)
+
+	if v.Len > int64(s.Config.MaxStringLen) {
+		n = uint64(s.Config.MaxStringLen)
+	}
+	buf, err := s.readBytes(v.mem, ptr, int64(n))
+	if err != nil {
+		return err
+	}
+	v.Value = string(buf)
+	return nil
+}
+
+func (s *Scope) loadSlice(v *Variable, t *godwarf.SliceType, depth int, recurse bool) error {
+	ptr, err := s.readField(v.mem, v.Addr, t, "array")
+	if err != nil {
+		return err
+	}
+	n, err := s.readField(v.mem, v.Addr, t, "len")
+	if err != nil {
+		return err
+	}
+	c, err := s.readField(v.mem, v.Addr, t, "cap")
+	if err != nil {
+		return err
+	}
+	v.Len, v.Cap = int64(n), int64(c)
+	v.Value = ptr
+
+	if recurse && ptr != 0 {
+		s.loadElems(v, ptr, t.ElemType, v.Len, depth)
+	}
+	return nil
+}
+
+// loadElems loads up to MaxArrayValues elements of type elem of the array
+// at addr as children of v.
+func (s *Scope) loadElems(v *Variable, addr uint64, elem godwarf.Type, n int64, depth int) {
+	if n > int64(s.Config.MaxArrayValues) {
+		n = int64(s.Config.MaxArrayValues)
+	}
+	size := uint64(elem.Size())
+	for i := int64(0); i < n; i++ {
+		c := v.child("", addr+uint64(i)*size, elem)
+		s.load(c, depth+1)
+		v.Children = append(v.Children, c)
+	}
+}
+
+func (s *Scope) loadChan(v *Variable, t *godwarf.ChanType, depth int, recurse bool) error {
+	p, err := s.readPtr(v.mem, v.Addr)
+	if err != nil {
+		return err
+	}
+	v.Value = p
+	if p == 0 {
+		return nil
+	}
+
+	hchan, ok := resolveTypedef(t.Type).(*godwarf.PtrType)
+	if !ok {
+		return fmt.Errorf("unexpected channel layout %s", t.Type)
+	}
+	qcount, err := s.readField(v.mem, p, hchan.Type, "qcount")
+	if err != nil {
+		return err
+	}
+	size, err := s.readField(v.mem, p, hchan.Type, "dataqsiz")
+	if err != nil {
+		return err
+	}
+	v.Len, v.Cap = int64(qcount), int64(size)
+	if !recurse || qcount == 0 {
+		return nil
+	}
+
+	buf, err := s.readField(v.mem, p, hchan.Type, "buf")
+	if err != nil {
+		return err
+	}
+	recvx, err := s.readField(v.mem, p, hchan.Type, "recvx")
+	if err != nil {
+		return err
+	}
+
+	// the queue is a ring buffer starting at recvx
+	n := qcount
+	if n > uint64(s.Config.MaxArrayValues) {
+		n = uint64(s.Config.MaxArrayValues)
+	}
+	elemSize := uint64(t.ElemType.Size())
+	for i := uint64(0); i < n; i++ {
+		c := v.child("", buf+((recvx+i)%size)*elemSize, t.ElemType)
+		s.load(c, depth+1)
+		v.Children = append(v.Children, c)
+	}
+	return nil
+}
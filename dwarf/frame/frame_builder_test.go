@@ -0,0 +1,183 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/hitzhangjie/codemaster/dwarf/dwarfbuilder"
+)
+
+// frameState is the expected CFA and register rules from a location on
+type frameState struct {
+	loc  uint64
+	cfa  DWRule
+	regs map[uint64]DWRule
+}
+
+func (s frameState) clone(loc uint64) frameState {
+	regs := make(map[uint64]DWRule, len(s.regs))
+	for reg, rule := range s.regs {
+		regs[reg] = rule
+	}
+	return frameState{loc: loc, cfa: s.cfa, regs: regs}
+}
+
+// TestFrameRoundTrip parses random .debug_frame sections written by
+// dwarfbuilder and checks the rules of the frame at every location.
+func TestFrameRoundTrip(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		t.Run(fmt.Sprintf("seed%d", seed), func(t *testing.T) {
+			testFrameRoundTrip(t, rand.New(rand.NewSource(seed)))
+		})
+	}
+}
+
+func testFrameRoundTrip(t *testing.T, rnd *rand.Rand) {
+	const (
+		rsp = 7
+		rip = 16
+	)
+	b := dwarfbuilder.New()
+	fb := b.Frame()
+
+	codeAlign := uint64(1 + rnd.Intn(2)*3)
+	dataAlign := int64(-8)
+	cie := fb.AddCIE(codeAlign, dataAlign, rip)
+	cie.Initial.DefCFA(rsp, 8).Offset(rip, -8)
+	initial := frameState{
+		cfa:  DWRule{Rule: RuleCFA, Reg: rsp, Offset: 8},
+		regs: map[uint64]DWRule{rip: {Rule: RuleOffset, Offset: -8}},
+	}
+
+	// a random offset multiple of the data alignment factor
+	offset := func() int64 {
+		return int64(rnd.Intn(65)-32) * dataAlign
+	}
+	expr := func() []byte {
+		return []byte{byte(0x70 + rnd.Intn(16)), byte(rnd.Intn(64))} // DW_OP_bregN
+	}
+
+	fdes := map[uint64][]frameState{}
+	begin := uint64(0x401000)
+	for i := 0; i < 1+rnd.Intn(4); i++ {
+		fde := fb.AddFDE(cie, begin, 0)
+		prog := fde.Instructions
+		states := []frameState{initial.clone(begin)}
+		loc := begin
+		for j := 0; j < 1+rnd.Intn(30); j++ {
+			cur := &states[len(states)-1]
+			reg := uint64(rnd.Intn(80))
+			switch rnd.Intn(11) {
+			case 0:
+				off := offset()
+				prog.DefCFA(reg, off)
+				cur.cfa = DWRule{Rule: RuleCFA, Reg: reg, Offset: off}
+			case 1:
+				if cur.cfa.Rule == RuleCFA {
+					prog.DefCFARegister(reg)
+					cur.cfa.Reg = reg
+				}
+			case 2:
+				if cur.cfa.Rule == RuleCFA {
+					off := offset()
+					prog.DefCFAOffset(off)
+					cur.cfa.Offset = off
+				}
+			case 3:
+				e := expr()
+				prog.DefCFAExpression(e)
+				cur.cfa = DWRule{Rule: RuleExpression, Expression: e}
+			case 4:
+				off := offset()
+				prog.Offset(reg, off)
+				cur.regs[reg] = DWRule{Rule: RuleOffset, Offset: off}
+			case 5:
+				off := offset()
+				prog.ValOffset(reg, off)
+				cur.regs[reg] = DWRule{Rule: RuleValOffset, Offset: off}
+			case 6:
+				other := uint64(rnd.Intn(80))
+				prog.Register(reg, other)
+				cur.regs[reg] = DWRule{Rule: RuleRegister, Reg: other}
+			case 7:
+				prog.Undefined(reg)
+				cur.regs[reg] = DWRule{Rule: RuleUndefined}
+			case 8:
+				prog.SameValue(reg)
+				cur.regs[reg] = DWRule{Rule: RuleSameVal}
+			case 9:
+				e := expr()
+				prog.Expression(reg, e)
+				cur.regs[reg] = DWRule{Rule: RuleExpression, Expression: e}
+			case 10:
+				e := expr()
+				prog.ValExpression(reg, e)
+				cur.regs[reg] = DWRule{Rule: RuleValExpression, Expression: e}
+			}
+			if rnd.Intn(3) == 0 {
+				// advances of all the sizes of DW_CFA_advance_loc*
+				delta := []uint64{1 + uint64(rnd.Intn(0x3f)), 0x40 + uint64(rnd.Intn(0xc0)), 0x100 + uint64(rnd.Intn(0xff00)), 0x10000 + uint64(rnd.Intn(0x10000))}[rnd.Intn(4)]
+				loc += delta * codeAlign
+				prog.AdvanceTo(loc)
+				states = append(states, cur.clone(loc))
+			}
+		}
+		end := loc + uint64(1+rnd.Intn(16))*codeAlign
+		fde.Size = end - begin
+		fdes[begin] = append(states, frameState{loc: end})
+		begin = end + 0x10
+	}
+	_, _, frame, _, _, _, _, _, _, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := Parse(frame, binary.LittleEndian, 0, 8, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(fdes) {
+		t.Fatalf("%d FDEs, expected %d", len(entries), len(fdes))
+	}
+
+	for begin, states := range fdes {
+		fde, err := entries.FDEForPC(begin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := states[len(states)-1].loc
+		if fde.Begin() != begin || fde.End() != end {
+			t.Fatalf("FDE [%#x, %#x), expected [%#x, %#x)", fde.Begin(), fde.End(), begin, end)
+		}
+		for i, state := range states[:len(states)-1] {
+			next := states[i+1].loc
+			for _, pc := range []uint64{state.loc, (state.loc + next) / 2, next - 1} {
+				ctx := fde.EstablishFrame(pc)
+				if !sameCFA(ctx.CFA, state.cfa) {
+					t.Fatalf("CFA at %#x: %+v, expected %+v", pc, ctx.CFA, state.cfa)
+				}
+				if !reflect.DeepEqual(ctx.Regs, state.regs) {
+					t.Fatalf("rules at %#x: %+v, expected %+v", pc, ctx.Regs, state.regs)
+				}
+				if ctx.RetAddrReg != rip {
+					t.Fatalf("return address register %d", ctx.RetAddrReg)
+				}
+			}
+		}
+	}
+}
+
+// sameCFA compares the fields of the CFA rules used by their kind, the
+// other fields are left over from the previous rules.
+func sameCFA(a, b DWRule) bool {
+	if a.Rule != b.Rule {
+		return false
+	}
+	if a.Rule == RuleExpression {
+		return bytes.Equal(a.Expression, b.Expression)
+	}
+	return a.Reg == b.Reg && a.Offset == b.Offset
+}
@@ -0,0 +1,234 @@
+package godwarf
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DebugDirs are the directories searched for separate debug files, as
+// gdb does: <dir>/.build-id/xx/yyyy.debug for build IDs and
+// <dir>/<binary dir>/<debuglink> for .gnu_debuglink.
+var DebugDirs = []string{"/usr/lib/debug"}
+
+// ErrNoDebugFile is returned when the separate debug file of a binary
+// can't be found.
+var ErrNoDebugFile = errors.New("separate debug file not found")
+
+// File is an ELF binary whose debug sections may be in a separate debug
+// file. Section and DWARF look in the binary first, and then in the debug
+// file, so a stripped binary can be used like one with debug info.
+type File struct {
+	*elf.File
+
+	// Path is the path the binary was opened from.
+	Path string
+
+	// Debug is the separate debug file, nil if the binary has its DWARF.
+	Debug *elf.File
+	// DebugPath is the path of Debug.
+	DebugPath string
+}
+
+// sectionReader is implemented by *elf.File and *File.
+type sectionReader interface {
+	Section(name string) *elf.Section
+}
+
+// Open opens the ELF binary at path, and its separate debug file if it has
+// no DWARF, looked up in DebugDirs.
+func Open(path string) (*File, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &File{File: f, Path: path}
+	if hasDWARF(f) {
+		return file, nil
+	}
+
+	debugPath, err := FindDebugFile(path, f, DebugDirs)
+	if err == ErrNoDebugFile {
+		return file, nil
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if file.Debug, err = elf.Open(debugPath); err != nil {
+		f.Close()
+		return nil, err
+	}
+	file.DebugPath = debugPath
+	return file, nil
+}
+
+// Close closes the binary and its debug file.
+func (f *File) Close() error {
+	err := f.File.Close()
+	if f.Debug != nil {
+		if e := f.Debug.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Section returns the section name of the binary, or of the debug file if
+// the binary doesn't have it or it's empty, as stripped sections are.
+func (f *File) Section(name string) *elf.Section {
+	sec := f.File.Section(name)
+	if (sec != nil && sec.Type != elf.SHT_NOBITS) || f.Debug == nil {
+		return sec
+	}
+	if dsec := f.Debug.Section(name); dsec != nil && dsec.Type != elf.SHT_NOBITS {
+		return dsec
+	}
+	return sec
+}
+
+// DWARF returns the DWARF of the binary, read from the debug file if any.
+func (f *File) DWARF() (*dwarf.Data, error) {
+	if f.Debug != nil {
+		return f.Debug.DWARF()
+	}
+	return f.File.DWARF()
+}
+
+func hasDWARF(f *elf.File) bool {
+	for _, name := range []string{".debug_info", ".zdebug_info"} {
+		if sec := f.Section(name); sec != nil && sec.Type != elf.SHT_NOBITS {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildID returns the GNU build ID of f in hex, "" if it has none.
+func BuildID(f *elf.File) (string, error) {
+	sec := f.Section(".note.gnu.build-id")
+	if sec == nil {
+		return "", nil
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return "", err
+	}
+	// a note is namesz, descsz, type, then name and desc padded to 4
+	if len(data) < 16 {
+		return "", errors.New("truncated build ID note")
+	}
+	namesz := f.ByteOrder.Uint32(data)
+	descsz := f.ByteOrder.Uint32(data[4:])
+	off := 12 + (namesz+3)&^3
+	if uint64(off)+uint64(descsz) > uint64(len(data)) {
+		return "", errors.New("truncated build ID note")
+	}
+	return hex.EncodeToString(data[off : off+descsz]), nil
+}
+
+// DebugLink returns the file name and the CRC32 of the debug file in the
+// .gnu_debuglink section of f, "" if it has none.
+func DebugLink(f *elf.File) (string, uint32, error) {
+	sec := f.Section(".gnu_debuglink")
+	if sec == nil {
+		return "", 0, nil
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return "", 0, err
+	}
+	// the name is NUL terminated and padded to 4, followed by the CRC
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return "", 0, errors.New("malformed .gnu_debuglink")
+	}
+	off := (i + 4) &^ 3
+	if off+4 > len(data) {
+		return "", 0, errors.New("malformed .gnu_debuglink")
+	}
+	return string(data[:i]), f.ByteOrder.Uint32(data[off:]), nil
+}
+
+// FindDebugFile returns the path of the separate debug file of the binary
+// f opened from path. The build ID is looked up first in dirs, then the
+// debug link next to the binary, in its .debug directory and in dirs.
+// Debug files found by build ID must have the same build ID, those found
+// by debug link must match its CRC32.
+func FindDebugFile(path string, f *elf.File, dirs []string) (string, error) {
+	id, err := BuildID(f)
+	if err != nil {
+		return "", err
+	}
+	if len(id) > 2 {
+		for _, dir := range dirs {
+			p := filepath.Join(dir, ".build-id", id[:2], id[2:]+".debug")
+			if ok, err := matchBuildID(p, id); err != nil {
+				return "", err
+			} else if ok {
+				return p, nil
+			}
+		}
+	}
+
+	link, crc, err := DebugLink(f)
+	if err != nil || link == "" {
+		return "", ErrNoDebugFile
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	bindir := filepath.Dir(abs)
+	candidates := []string{
+		filepath.Join(bindir, link),
+		filepath.Join(bindir, ".debug", link),
+	}
+	for _, dir := range dirs {
+		candidates = append(candidates, filepath.Join(dir, bindir, link))
+	}
+
+	var mismatch error
+	for _, p := range candidates {
+		if p == abs {
+			continue
+		}
+		data, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if sum := crc32.ChecksumIEEE(data); sum != crc {
+			mismatch = fmt.Errorf("debug file %s has CRC32 %#x, want %#x", p, sum, crc)
+			continue
+		}
+		return p, nil
+	}
+	if mismatch != nil {
+		return "", mismatch
+	}
+	return "", ErrNoDebugFile
+}
+
+// matchBuildID reports whether the ELF file at path has build ID id
+func matchBuildID(path, id string) (bool, error) {
+	f, err := elf.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	got, err := BuildID(f)
+	return got == id, err
+}
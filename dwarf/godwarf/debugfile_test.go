@@ -0,0 +1,144 @@
+package godwarf
+
+import (
+	"debug/dwarf"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testBuildID = "0123456789abcdef0123456789abcdef01234567"
+
+// stripHello builds testdata/hello with a GNU build ID, moves its DWARF to
+// hello.debug and strips it, like distributions do. It returns the paths of
+// the stripped binary and of its debug file.
+func stripHello(t *testing.T) (string, string) {
+	for _, tool := range []string{"go", "objcopy"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "hello")
+	debug := exe + ".debug"
+	cmds := [][]string{
+		{"go", "build", "-ldflags=-B 0x" + testBuildID, "-o", exe, "./testdata/hello"},
+		{"objcopy", "--only-keep-debug", exe, debug},
+		{"objcopy", "--strip-debug", "--add-gnu-debuglink=" + debug, exe},
+	}
+	for _, args := range cmds {
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	return exe, debug
+}
+
+func TestDebugFile(t *testing.T) {
+	exe, debug := stripHello(t)
+
+	t.Run("debuglink", func(t *testing.T) {
+		f, err := Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if f.DebugPath != debug {
+			t.Fatalf("debug file %q, want %q", f.DebugPath, debug)
+		}
+		checkDebugFile(t, f)
+	})
+
+	t.Run("build-id", func(t *testing.T) {
+		dir := t.TempDir()
+		p := filepath.Join(dir, ".build-id", testBuildID[:2], testBuildID[2:]+".debug")
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(debug, p); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Rename(p, debug)
+
+		saved := DebugDirs
+		DebugDirs = []string{dir}
+		defer func() { DebugDirs = saved }()
+
+		f, err := Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if f.DebugPath != p {
+			t.Fatalf("debug file %q, want %q", f.DebugPath, p)
+		}
+		checkDebugFile(t, f)
+	})
+
+	t.Run("crc mismatch", func(t *testing.T) {
+		data, err := ioutil.ReadFile(debug)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ioutil.WriteFile(debug, data, 0644)
+		if err := ioutil.WriteFile(debug, append(data[:len(data):len(data)], 0), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(exe); err == nil || !strings.Contains(err.Error(), "CRC32") {
+			t.Fatalf("got error %v, want a CRC32 mismatch", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		tmp := debug + ".moved"
+		if err := os.Rename(debug, tmp); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Rename(tmp, debug)
+
+		f, err := Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if f.Debug != nil {
+			t.Fatalf("unexpected debug file %s", f.DebugPath)
+		}
+		if _, err := GetDebugSection(f, "line"); err == nil {
+			t.Fatal("stripped binary has .debug_line")
+		}
+	})
+}
+
+// checkDebugFile checks the DWARF of f is read from its debug file.
+func checkDebugFile(t *testing.T, f *File) {
+	t.Helper()
+	if id, err := BuildID(f.File); err != nil || id != testBuildID {
+		t.Fatalf("build ID %q, %v, want %q", id, err, testBuildID)
+	}
+	if data, err := GetDebugSection(f, "line"); err != nil || len(data) == 0 {
+		t.Fatalf("could not read .debug_line: %v", err)
+	}
+
+	dw, err := f.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := dw.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e == nil {
+			t.Fatal("main.main not found")
+		}
+		if e.Tag == dwarf.TagSubprogram && e.Val(dwarf.AttrName) == "main.main" {
+			return
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package godwarf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/hitzhangjie/codemaster/dwarf/util"
+)
+
+// DebugRnglistsSection represents the debug_rnglists section of DWARFv5.
+// See DWARFv5 section 7.28 page 242 and following.
+type DebugRnglistsSection struct {
+	byteOrder binary.ByteOrder
+	ptrSz     int
+	dwarf64   bool
+	data      []byte
+}
+
+// range list entry kinds
+const (
+	_DW_RLE_end_of_list   uint8 = 0x0
+	_DW_RLE_base_addressx uint8 = 0x1
+	_DW_RLE_startx_endx   uint8 = 0x2
+	_DW_RLE_startx_length uint8 = 0x3
+	_DW_RLE_offset_pair   uint8 = 0x4
+	_DW_RLE_base_address  uint8 = 0x5
+	_DW_RLE_start_end     uint8 = 0x6
+	_DW_RLE_start_length  uint8 = 0x7
+)
+
+// rnglistsHeaderSize returns the size of the header of a debug_rnglists
+// table, the offsets table of DW_FORM_rnglistx follows it.
+func rnglistsHeaderSize(dwarf64 bool) int {
+	if dwarf64 {
+		return 20
+	}
+	return 12
+}
+
+// ParseRnglists parses the header of a debug_rnglists section.
+func ParseRnglists(data []byte) *DebugRnglistsSection {
+	if len(data) < rnglistsHeaderSize(false) {
+		return nil
+	}
+	r := &DebugRnglistsSection{data: data}
+	_, r.dwarf64, _, r.byteOrder = util.ReadDwarfLengthVersion(data)
+	data = data[6:]
+	if r.dwarf64 {
+		data = data[8:]
+	}
+
+	addrSz := data[0]
+	segSelSz := data[1]
+	r.ptrSz = int(addrSz + segSelSz)
+
+	return r
+}
+
+// Offset returns the offset of the range list idx of the offsets table at
+// rnglistsBase, the value of DW_AT_rnglists_base, for DW_FORM_rnglistx.
+func (r *DebugRnglistsSection) Offset(rnglistsBase, idx uint64) (uint64, error) {
+	if r == nil {
+		return 0, errors.New("debug_rnglists section not present")
+	}
+	sz := uint64(4)
+	if r.dwarf64 {
+		sz = 8
+	}
+	off := rnglistsBase + idx*sz
+	if off+sz > uint64(len(r.data)) {
+		return 0, fmt.Errorf("range list index %d out of range", idx)
+	}
+	rel, err := util.ReadUintRaw(bytes.NewReader(r.data[off:]), r.byteOrder, int(sz))
+	if err != nil {
+		return 0, err
+	}
+	return rnglistsBase + rel, nil
+}
+
+// Ranges returns the address ranges of the range list at off. Base is the
+// base address of the compile unit, offset pairs are relative to it until
+// a base address entry changes it. Indexed addresses are read from
+// debugAddr.
+func (r *DebugRnglistsSection) Ranges(off, base uint64, debugAddr *DebugAddr) ([][2]uint64, error) {
+	if r == nil {
+		return nil, errors.New("debug_rnglists section not present")
+	}
+	if off >= uint64(len(r.data)) {
+		return nil, fmt.Errorf("range list offset %#x out of range", off)
+	}
+	buf := bytes.NewBuffer(r.data[off:])
+	readAddr := func() (uint64, error) {
+		return util.ReadUintRaw(buf, r.byteOrder, r.ptrSz)
+	}
+
+	var ranges [][2]uint64
+	for {
+		kind, err := buf.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		var start, end uint64
+		switch kind {
+		case _DW_RLE_end_of_list:
+			return ranges, nil
+
+		case _DW_RLE_base_addressx:
+			idx, _ := util.DecodeULEB128(buf)
+			if base, err = debugAddr.Get(idx); err != nil {
+				return nil, err
+			}
+			continue
+
+		case _DW_RLE_startx_endx:
+			startIdx, _ := util.DecodeULEB128(buf)
+			endIdx, _ := util.DecodeULEB128(buf)
+			if start, err = debugAddr.Get(startIdx); err == nil {
+				end, err = debugAddr.Get(endIdx)
+			}
+
+		case _DW_RLE_startx_length:
+			startIdx, _ := util.DecodeULEB128(buf)
+			length, _ := util.DecodeULEB128(buf)
+			start, err = debugAddr.Get(startIdx)
+			end = start + length
+
+		case _DW_RLE_offset_pair:
+			off1, _ := util.DecodeULEB128(buf)
+			off2, _ := util.DecodeULEB128(buf)
+			start, end = base+off1, base+off2
+
+		case _DW_RLE_base_address:
+			if base, err = readAddr(); err != nil {
+				return nil, err
+			}
+			continue
+
+		case _DW_RLE_start_end:
+			if start, err = readAddr(); err == nil {
+				end, err = readAddr()
+			}
+
+		case _DW_RLE_start_length:
+			start, err = readAddr()
+			length, _ := util.DecodeULEB128(buf)
+			end = start + length
+
+		default:
+			return nil, fmt.Errorf("unknown range list entry kind %#x at %#x", kind, len(r.data)-buf.Len()-1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start < end {
+			ranges = append(ranges, [2]uint64{start, end})
+		}
+	}
+}
@@ -3,7 +3,6 @@ package godwarf
 import (
 	"bytes"
 	"compress/zlib"
-	"debug/elf"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -14,7 +13,10 @@ import (
 // For example GetDebugSection("line") will return the contents of
 // .debug_line, if .debug_line doesn't exist it will try to return the
 // decompressed contents of .zdebug_line.
-func GetDebugSection(f *elf.File, name string) ([]byte, error) {
+//
+// f is either an *elf.File or a *File, the latter also looks up sections in
+// the separate debug file of stripped binaries.
+func GetDebugSection(f sectionReader, name string) ([]byte, error) {
 	sec := f.Section(".debug_" + name)
 	if sec != nil {
 		return sec.Data()
@@ -0,0 +1,249 @@
+package godwarf
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hitzhangjie/codemaster/dwarf/util"
+)
+
+// SplitUnit is a DWARF 5 skeleton unit of a binary built with
+// -gsplit-dwarf, and the split unit with its debug info in a .dwo file.
+type SplitUnit struct {
+	// Skeleton is the skeleton unit in the binary.
+	Skeleton *dwarf.Entry
+	// ID is the DWO id shared by the skeleton and the split unit.
+	ID uint64
+	// Path is the path of the .dwo file.
+	Path string
+	// Data is the DWARF of the .dwo file. Strings, addresses and range
+	// lists are resolved against the bases of the split unit, addresses
+	// are read from the .debug_addr of the binary.
+	Data *dwarf.Data
+	// Base is the base address of the unit, the low pc of the skeleton.
+	Base uint64
+
+	ranges    [][2]uint64
+	rnglists  *DebugRnglistsSection
+	debugAddr *DebugAddr
+	loclists  []byte
+}
+
+// split DWARF unit types
+const (
+	_DW_UT_skeleton      = 0x4
+	_DW_UT_split_compile = 0x5
+)
+
+// LoadSplitUnits loads the .dwo files of the skeleton units of f. A .dwo
+// file is looked up in the DW_AT_comp_dir of its unit, then in the
+// directory of the binary. The skeleton units whose .dwo file can't be
+// found are skipped.
+func LoadSplitUnits(f *File) ([]*SplitUnit, error) {
+	dw, err := f.DWARF()
+	if err != nil {
+		return nil, err
+	}
+	info, err := GetDebugSection(f, "info")
+	if err != nil {
+		return nil, err
+	}
+	ids := unitIDs(info, _DW_UT_skeleton)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	addr, _ := GetDebugSection(f, "addr")
+
+	var units []*SplitUnit
+	r := dw.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		r.SkipChildren()
+		id, ok := ids[e.Offset]
+		if !ok {
+			continue
+		}
+
+		u := &SplitUnit{Skeleton: e, ID: id}
+		u.Base, _ = e.Val(dwarf.AttrLowpc).(uint64)
+		if u.ranges, err = dw.Ranges(e); err != nil {
+			return nil, err
+		}
+		dwoName, _ := e.Val(dwarf.AttrDwoName).(string)
+		compDir, _ := e.Val(dwarf.AttrCompDir).(string)
+		if u.Path = findDwo(dwoName, compDir, f.Path); u.Path == "" {
+			continue
+		}
+		addrBase, _ := e.Val(dwarf.AttrAddrBase).(int64)
+		if err := u.load(addr, uint64(addrBase)); err != nil {
+			return nil, fmt.Errorf("could not load %s: %v", u.Path, err)
+		}
+		units = append(units, u)
+	}
+	return units, nil
+}
+
+// findDwo returns the path of the .dwo file name, "" if it doesn't exist
+func findDwo(name, compDir, binary string) string {
+	if name == "" {
+		return ""
+	}
+	var candidates []string
+	if filepath.IsAbs(name) {
+		candidates = append(candidates, name)
+	} else {
+		if compDir != "" {
+			candidates = append(candidates, filepath.Join(compDir, name))
+		}
+		if binary != "" {
+			candidates = append(candidates, filepath.Join(filepath.Dir(binary), filepath.Base(name)))
+		}
+	}
+	for _, p := range candidates {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// load reads the .dwo file of u, addr is the .debug_addr section of the
+// binary and addrBase the DW_AT_addr_base of the skeleton.
+func (u *SplitUnit) load(addr []byte, addrBase uint64) error {
+	f, err := elf.Open(u.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := GetDebugSection(f, "info.dwo")
+	if err != nil {
+		return err
+	}
+	var found bool
+	for _, id := range unitIDs(info, _DW_UT_split_compile) {
+		found = found || id == u.ID
+	}
+	if !found {
+		return fmt.Errorf("no split unit with DWO id %#x", u.ID)
+	}
+	abbrev, err := GetDebugSection(f, "abbrev.dwo")
+	if err != nil {
+		return err
+	}
+	line, _ := GetDebugSection(f, "line.dwo")
+	str, _ := GetDebugSection(f, "str.dwo")
+	if u.Data, err = dwarf.New(abbrev, nil, nil, info, line, nil, nil, str); err != nil {
+		return err
+	}
+
+	// Split units have no DW_AT_str_offsets_base and DW_AT_rnglists_base,
+	// their tables start after the header of the section. debug/dwarf
+	// assumes they start at 0, so the sections are given without header.
+	if data, _ := GetDebugSection(f, "str_offsets.dwo"); data != nil {
+		_, dwarf64, _, _ := util.ReadDwarfLengthVersion(data)
+		hdr := 8
+		if dwarf64 {
+			hdr = 16
+		}
+		if len(data) < hdr {
+			return errors.New("truncated .debug_str_offsets.dwo")
+		}
+		u.Data.AddSection(".debug_str_offsets", data[hdr:])
+	}
+	if data, _ := GetDebugSection(f, "rnglists.dwo"); data != nil {
+		if u.rnglists = ParseRnglists(data); u.rnglists == nil {
+			return errors.New("truncated .debug_rnglists.dwo")
+		}
+		hdr := rnglistsHeaderSize(u.rnglists.dwarf64)
+		u.rnglists.data = data[hdr:]
+		u.Data.AddSection(".debug_rnglists", data[hdr:])
+	}
+	// the addresses of the split unit are those of the skeleton
+	if addr != nil {
+		if addrBase > uint64(len(addr)) {
+			return fmt.Errorf("DW_AT_addr_base %#x out of .debug_addr", addrBase)
+		}
+		u.Data.AddSection(".debug_addr", addr[addrBase:])
+		u.debugAddr = ParseAddr(addr).GetSubsection(addrBase)
+	}
+	u.loclists, _ = GetDebugSection(f, "loclists.dwo")
+	return nil
+}
+
+// Loclists returns the .debug_loclists.dwo section of the split unit.
+func (u *SplitUnit) Loclists() []byte {
+	return u.loclists
+}
+
+// DebugAddr returns the addresses of the skeleton unit in .debug_addr.
+func (u *SplitUnit) DebugAddr() *DebugAddr {
+	return u.debugAddr
+}
+
+// Ranges returns the address ranges of e, an entry of the split unit. The
+// compile unit has the ranges of the skeleton.
+func (u *SplitUnit) Ranges(e *dwarf.Entry) ([][2]uint64, error) {
+	if e.Tag == dwarf.TagCompileUnit {
+		return u.ranges, nil
+	}
+	switch off := e.Val(dwarf.AttrRanges).(type) {
+	case uint64: // DW_FORM_rnglistx, resolved by debug/dwarf
+		return u.rnglists.Ranges(off, u.Base, u.debugAddr)
+	case int64: // DW_FORM_sec_offset, from the start of the section
+		if u.rnglists == nil {
+			return nil, errors.New("debug_rnglists.dwo section not present")
+		}
+		hdr := int64(rnglistsHeaderSize(u.rnglists.dwarf64))
+		if off < hdr {
+			return nil, fmt.Errorf("range list offset %#x in header", off)
+		}
+		return u.rnglists.Ranges(uint64(off-hdr), u.Base, u.debugAddr)
+	}
+	return u.Data.Ranges(e)
+}
+
+// LoadTree is like LoadTree for an entry of the split unit.
+func (u *SplitUnit) LoadTree(off dwarf.Offset, staticBase uint64) (*Tree, error) {
+	return loadTree(off, u.Data, u.Ranges, staticBase)
+}
+
+// unitIDs returns the unit IDs in the headers of the DWARF 5 units of type
+// utype in the .debug_info section info, by offset of their first entry.
+func unitIDs(info []byte, utype uint8) map[dwarf.Offset]uint64 {
+	ids := map[dwarf.Offset]uint64{}
+	for off := 0; off+12 <= len(info); {
+		length, dwarf64, version, order := util.ReadDwarfLengthVersion(info[off:])
+		hdr := off + 4
+		if dwarf64 {
+			hdr += 8
+		}
+		next := hdr + int(length)
+		if length == 0 || next > len(info) {
+			break
+		}
+		// version, unit type, address size and abbrev offset
+		p := hdr + 2
+		if version >= 5 && info[p] == utype {
+			p += 2 + 4
+			if dwarf64 {
+				p += 4
+			}
+			if p+8 <= next {
+				ids[dwarf.Offset(p+8)] = order.Uint64(info[p:])
+			}
+		}
+		off = next
+	}
+	return ids
+}
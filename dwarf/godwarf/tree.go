@@ -68,6 +68,13 @@ type Tree struct {
 // range of addresses that is not covered by its parent LoadTree will fix
 // the parent entry.
 func LoadTree(off dwarf.Offset, dw *dwarf.Data, staticBase uint64) (*Tree, error) {
+	return loadTree(off, dw, dw.Ranges, staticBase)
+}
+
+// rangesFunc returns the address ranges of an entry.
+type rangesFunc func(*dwarf.Entry) ([][2]uint64, error)
+
+func loadTree(off dwarf.Offset, dw *dwarf.Data, ranges rangesFunc, staticBase uint64) (*Tree, error) {
 	rdr := dw.Reader()
 	rdr.Seek(off)
 
@@ -81,7 +88,7 @@ func LoadTree(off dwarf.Offset, dw *dwarf.Data, staticBase uint64) (*Tree, error
 		return nil, err
 	}
 
-	err = r.resolveRanges(dw, staticBase)
+	err = r.resolveRanges(ranges, staticBase)
 	if err != nil {
 		return nil, err
 	}
@@ -126,9 +133,9 @@ func loadTreeChildren(e *dwarf.Entry, rdr *dwarf.Reader) ([]*Tree, error) {
 	return children, nil
 }
 
-func (n *Tree) resolveRanges(dw *dwarf.Data, staticBase uint64) error {
+func (n *Tree) resolveRanges(ranges rangesFunc, staticBase uint64) error {
 	var err error
-	n.Ranges, err = dw.Ranges(n.Entry.(*dwarf.Entry))
+	n.Ranges, err = ranges(n.Entry.(*dwarf.Entry))
 	if err != nil {
 		return err
 	}
@@ -139,7 +146,7 @@ func (n *Tree) resolveRanges(dw *dwarf.Data, staticBase uint64) error {
 	n.Ranges = normalizeRanges(n.Ranges)
 
 	for _, child := range n.Children {
-		err := child.resolveRanges(dw, staticBase)
+		err := child.resolveRanges(ranges, staticBase)
 		if err != nil {
 			return err
 		}
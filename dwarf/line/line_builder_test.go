@@ -0,0 +1,164 @@
+package line
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"fmt"
+	"math/rand"
+	"path"
+	"testing"
+
+	"github.com/hitzhangjie/codemaster/dwarf/dwarfbuilder"
+)
+
+type fileLine struct {
+	file string
+	line int
+}
+
+// TestLineProgramRoundTrip parses random line number programs written by
+// dwarfbuilder and checks the parser gives back the rows of the program.
+func TestLineProgramRoundTrip(t *testing.T) {
+	for version := uint16(2); version <= 5; version++ {
+		for seed := int64(0); seed < 20; seed++ {
+			t.Run(fmt.Sprintf("v%d/seed%d", version, seed), func(t *testing.T) {
+				testLineProgramRoundTrip(t, version, rand.New(rand.NewSource(seed)))
+			})
+		}
+	}
+}
+
+func testLineProgramRoundTrip(t *testing.T, version uint16, rnd *rand.Rand) {
+	const compDir = "/comp"
+	p := dwarfbuilder.NewLineProgram(version, compDir)
+	p.MinInstLength = uint8(1 + rnd.Intn(2)*3)
+	p.LineBase = int8(-1 - rnd.Intn(5))
+	p.LineRange = uint8(4 + rnd.Intn(12))
+	p.DefaultIsStmt = rnd.Intn(2) == 0
+
+	// directory 0 is the compilation directory, before DWARF 5 it's the
+	// DW_AT_comp_dir given to Parse
+	dirs := []string{compDir}
+	for i := 0; i < 1+rnd.Intn(3); i++ {
+		dir := fmt.Sprintf("/src/dir%d", i)
+		p.AddDir(dir)
+		dirs = append(dirs, dir)
+	}
+	withMD5 := version >= 5 && rnd.Intn(2) == 0
+	var files []uint64
+	paths := map[uint64]string{}
+	var digests [][]byte
+	for i := 0; i < 1+rnd.Intn(4); i++ {
+		name := fmt.Sprintf("file%d.go", i)
+		dir := uint64(rnd.Intn(len(dirs)))
+		var md5 []byte
+		if withMD5 {
+			md5 = make([]byte, 16)
+			rnd.Read(md5)
+		}
+		idx := p.AddFile(name, dir, md5)
+		files = append(files, idx)
+		paths[idx] = path.Join(dirs[dir], name)
+		digests = append(digests, md5)
+	}
+
+	type seq struct {
+		rows []dwarfbuilder.LineRow
+		end  uint64
+	}
+	var seqs []seq
+	addr := uint64(0x401000)
+	for i := 0; i < 1+rnd.Intn(3); i++ {
+		var s seq
+		line := 1 + rnd.Intn(100)
+		for j := 0; j < 1+rnd.Intn(40); j++ {
+			row := dwarfbuilder.LineRow{
+				Address:     addr,
+				File:        files[rnd.Intn(len(files))],
+				Line:        line,
+				IsStmt:      rnd.Intn(4) != 0,
+				PrologueEnd: j == 1,
+			}
+			s.rows = append(s.rows, row)
+			p.AddRow(row)
+			// mostly small advances, sometimes out of the range of the
+			// special opcodes
+			delta := 1 + rnd.Intn(20)
+			if rnd.Intn(5) == 0 {
+				delta = 1 + rnd.Intn(2000)
+			}
+			addr += uint64(delta) * uint64(p.MinInstLength)
+			if line += rnd.Intn(41) - 20; line < 1 {
+				line = 1 + rnd.Intn(10)
+			}
+		}
+		s.end = addr
+		p.EndSequence(addr)
+		seqs = append(seqs, s)
+		addr += 0x100
+	}
+
+	b := dwarfbuilder.New()
+	b.Attr(dwarf.AttrStmtList, b.AddLineProgram(p))
+	_, _, _, _, data, _, _, _, _, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(data)
+	dbl := Parse(compDir, buf, nil, t.Logf, 0, false, 8)
+	if dbl == nil {
+		t.Fatal("could not parse line program")
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%d bytes left after the line program", buf.Len())
+	}
+	if dbl.Prologue.Version != version {
+		t.Fatalf("version %d, expected %d", dbl.Prologue.Version, version)
+	}
+	if len(dbl.FileNames) != len(files) {
+		t.Fatalf("%d files, expected %d", len(dbl.FileNames), len(files))
+	}
+	for i, idx := range files {
+		if got := dbl.FileNames[i].Path; got != paths[idx] {
+			t.Errorf("file %d: %q, expected %q", idx, got, paths[idx])
+		}
+		if !bytes.Equal(dbl.FileNames[i].MD5, digests[i]) {
+			t.Errorf("file %d: MD5 %x, expected %x", idx, dbl.FileNames[i].MD5, digests[i])
+		}
+	}
+
+	want := map[fileLine][]PCStmt{}
+	for _, s := range seqs {
+		for i, row := range s.rows {
+			fl := fileLine{paths[row.File], row.Line}
+			want[fl] = append(want[fl], PCStmt{row.Address, row.IsStmt})
+
+			// the end of a sequence isn't a valid row, the pcs after the
+			// last row of a sequence aren't found
+			pcs := []uint64{row.Address}
+			if i+1 < len(s.rows) {
+				next := s.rows[i+1].Address
+				pcs = append(pcs, (row.Address+next)/2, next-1)
+			}
+			for _, pc := range pcs {
+				file, line := dbl.PCToLine(s.rows[0].Address, pc)
+				if file != fl.file || line != fl.line {
+					t.Fatalf("PCToLine(%#x) = %s:%d, expected %s:%d", pc, file, line, fl.file, fl.line)
+				}
+			}
+		}
+		if version >= 3 && len(s.rows) > 1 {
+			pc, _, _, ok := dbl.PrologueEndPC(s.rows[0].Address, s.end)
+			if !ok || pc != s.rows[1].Address {
+				t.Errorf("PrologueEndPC = %#x %v, expected %#x", pc, ok, s.rows[1].Address)
+			}
+		}
+	}
+	for fl, pcs := range want {
+		got := dbl.LineToPCs(fl.file, fl.line)
+		if fmt.Sprint(got) != fmt.Sprint(pcs) {
+			t.Errorf("LineToPCs(%s:%d) = %v, expected %v", fl.file, fl.line, got, pcs)
+		}
+	}
+}
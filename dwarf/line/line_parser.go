@@ -57,6 +57,7 @@ type FileEntry struct {
 	DirIdx      uint64
 	LastModTime uint64
 	Length      uint64
+	MD5         []byte // DWARF 5 only, nil if the producer didn't emit it
 }
 
 type DebugLines []*DebugLineInfo
@@ -280,12 +281,10 @@ func parseFileEntries5(info *DebugLineInfo, buf *bytes.Buffer) bool {
 	info.FileNames = make([]*FileEntry, 0, fileCount)
 	for i := 0; i < int(fileCount); i++ {
 		fileEntryFormReader.reset()
+		entry := new(FileEntry)
+		var p string
+		diridx := -1
 		for fileEntryFormReader.next(buf) {
-			entry := new(FileEntry)
-			var p string
-			var diridx int
-			diridx = -1
-
 			switch fileEntryFormReader.contentType {
 			case _DW_LNCT_path:
 				switch fileEntryFormReader.formCode {
@@ -299,24 +298,16 @@ func parseFileEntries5(info *DebugLineInfo, buf *bytes.Buffer) bool {
 				}
 			case _DW_LNCT_directory_index:
 				diridx = int(fileEntryFormReader.u64)
+				entry.DirIdx = fileEntryFormReader.u64
 			case _DW_LNCT_timestamp:
 				entry.LastModTime = fileEntryFormReader.u64
 			case _DW_LNCT_size:
 				entry.Length = fileEntryFormReader.u64
 			case _DW_LNCT_MD5:
-				// not implemented
-			}
-
-			if info.normalizeBackslash {
-				p = strings.ReplaceAll(p, "\\", "/")
-			}
-
-			if diridx >= 0 && !pathIsAbs(p) && diridx < len(info.IncludeDirs) {
-				p = path.Join(info.IncludeDirs[diridx], p)
+				if fileEntryFormReader.formCode == _DW_FORM_data16 && len(fileEntryFormReader.block) == 16 {
+					entry.MD5 = append([]byte(nil), fileEntryFormReader.block...)
+				}
 			}
-			entry.Path = p
-			info.FileNames = append(info.FileNames, entry)
-			info.Lookup[entry.Path] = entry
 		}
 		if fileEntryFormReader.err != nil {
 			if info.Logf != nil {
@@ -324,6 +315,16 @@ func parseFileEntries5(info *DebugLineInfo, buf *bytes.Buffer) bool {
 			}
 			return false
 		}
+
+		if info.normalizeBackslash {
+			p = strings.ReplaceAll(p, "\\", "/")
+		}
+		if diridx >= 0 && !pathIsAbs(p) && diridx < len(info.IncludeDirs) {
+			p = path.Join(info.IncludeDirs[diridx], p)
+		}
+		entry.Path = p
+		info.FileNames = append(info.FileNames, entry)
+		info.Lookup[entry.Path] = entry
 	}
 	return true
 }
@@ -1,8 +1,10 @@
 package line
 
 import (
+	"bytes"
 	"compress/zlib"
 	"debug/elf"
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -363,3 +365,54 @@ func TestDebugLineDwarf4(t *testing.T) {
 	}
 
 }
+
+func TestDebugLineDwarf5MD5(t *testing.T) {
+	md5a := bytes.Repeat([]byte{0xaa}, 16)
+	md5b := bytes.Repeat([]byte{0xbb}, 16)
+
+	// everything after header_length
+	var hdr bytes.Buffer
+	hdr.Write([]byte{1, 1, 1, 0xfb, 14, 13})              // min_inst_length ... opcode_base
+	hdr.Write([]byte{0, 1, 1, 1, 1, 0, 0, 0, 1, 0, 0, 1}) // standard_opcode_lengths
+	hdr.Write([]byte{1, _DW_LNCT_path, _DW_FORM_string})
+	hdr.Write([]byte{2})
+	hdr.WriteString("/src\x00inc\x00")
+	hdr.Write([]byte{3, _DW_LNCT_path, _DW_FORM_string, _DW_LNCT_directory_index, _DW_FORM_udata, _DW_LNCT_MD5, _DW_FORM_data16})
+	hdr.Write([]byte{2})
+	hdr.WriteString("a.c\x00")
+	hdr.WriteByte(0)
+	hdr.Write(md5a)
+	hdr.WriteString("b.h\x00")
+	hdr.WriteByte(1)
+	hdr.Write(md5b)
+	program := []byte{0, 1, 1} // DW_LNE_end_sequence
+
+	var unit bytes.Buffer
+	unit.Write([]byte{5, 0, 8, 0}) // version, address_size, segment_selector_size
+	binary.Write(&unit, binary.LittleEndian, uint32(hdr.Len()))
+	unit.Write(hdr.Bytes())
+	unit.Write(program)
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, uint32(unit.Len()))
+	data.Write(unit.Bytes())
+
+	dbl := Parse("", &data, nil, nil, 0, false, 8)
+	if dbl == nil {
+		t.Fatal("could not parse line table")
+	}
+	if len(dbl.FileNames) != 2 {
+		t.Fatalf("got %d files, want 2", len(dbl.FileNames))
+	}
+	for i, want := range []struct {
+		path string
+		md5  []byte
+	}{{"/src/a.c", md5a}, {"inc/b.h", md5b}} {
+		f := dbl.FileNames[i]
+		if f.Path != want.path || !bytes.Equal(f.MD5, want.md5) {
+			t.Errorf("file %d: got %s %x, want %s %x", i, f.Path, f.MD5, want.path, want.md5)
+		}
+	}
+	if !bytes.Equal(dbl.Instructions, program) {
+		t.Errorf("got instructions %x, want %x", dbl.Instructions, program)
+	}
+}
@@ -0,0 +1,316 @@
+package op_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hitzhangjie/codemaster/dwarf/dwarfbuilder"
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+)
+
+// DIEs of the compile unit of the tests, by offset
+var testDIEs = map[uint64]*op.DIE{
+	0x20: {Location: dwarfbuilder.LocationBlock(op.DW_OP_lit2)},
+	0x24: {},
+	0x28: {Location: dwarfbuilder.LocationBlock(op.DW_OP_call2, uint16(0x28))},
+	0x30: {ByteSize: 4, Encoding: 0x05}, // int32
+	0x40: {ByteSize: 8, Encoding: 0x04}, // float64
+	0x48: {ByteSize: 4, Encoding: 0x04}, // float32
+	0x50: {ByteSize: 1, Encoding: 0x08}, // uint8
+	0x58: {ByteSize: 1, Encoding: 0x06}, // int8
+	0x60: {ByteSize: 2, Encoding: 0x07}, // uint16
+	0x68: {ByteSize: 4, Encoding: 0x07}, // uint32
+}
+
+const (
+	testUnit    = 0x1000 // offset of the compile unit in .debug_info
+	testTLSBase = 0x7f0000
+)
+
+var testDebugAddr = []uint64{0x400000, 0x401000, 0x18}
+
+var testHooks = op.Hooks{
+	DIE: func(off uint64, unit bool) (*op.DIE, error) {
+		if !unit {
+			off -= testUnit
+		}
+		die, ok := testDIEs[off]
+		if !ok {
+			return nil, fmt.Errorf("no DIE at %#x", off)
+		}
+		return die, nil
+	},
+	EntryValue: func(expr []byte) (int64, error) {
+		if reflect.DeepEqual(expr, dwarfbuilder.LocationBlock(op.DW_OP_reg5)) {
+			return 42, nil
+		}
+		return 0, errors.New("entry value not found")
+	},
+	TLSBase: func() (uint64, error) {
+		return testTLSBase, nil
+	},
+	DebugAddr: func(idx uint64) (uint64, error) {
+		if idx >= uint64(len(testDebugAddr)) {
+			return 0, fmt.Errorf("no address %d", idx)
+		}
+		return testDebugAddr[idx], nil
+	},
+}
+
+func testMemory(buf []byte, addr uint64) (int, error) {
+	mem := []byte{0xfe, 0xff, 0xff, 0xff}
+	if addr != 0x100 || len(buf) > len(mem) {
+		return 0, fmt.Errorf("can't read %d bytes at %#x", len(buf), addr)
+	}
+	return copy(buf, mem), nil
+}
+
+func TestExecuteStackProgramWithHooks(t *testing.T) {
+	var regs op.DwarfRegisters
+	regs.StaticBase = 0x10000
+	regs.ObjBase = 0x5000
+	regs.AddReg(1, op.DwarfRegisterFromUint64(0xc000))
+	regs.AddReg(17, op.DwarfRegisterFromUint64(math.Float64bits(3.75)))
+
+	tests := []struct {
+		name   string
+		expr   []byte
+		addr   int64
+		pieces []op.Piece
+		err    string
+	}{
+		{
+			name:   "regx",
+			expr:   dwarfbuilder.LocationBlock(op.DW_OP_regx, uint(1)),
+			addr:   0xc000,
+			pieces: []op.Piece{{Kind: op.RegPiece, Val: 1}},
+		},
+		{
+			name: "bregx",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_bregx, uint(1), int(-8)),
+			addr: 0xc000 - 8,
+		},
+		{
+			name: "bit_piece",
+			expr: dwarfbuilder.LocationBlock(
+				op.DW_OP_regx, uint(1), op.DW_OP_bit_piece, uint(3), uint(5),
+				op.DW_OP_breg1, int(0), op.DW_OP_bit_piece, uint(13), uint(0),
+				op.DW_OP_bit_piece, uint(16), uint(0)),
+			pieces: []op.Piece{
+				{Size: 1, Kind: op.RegPiece, Val: 1, BitSize: 3, BitOffset: 5},
+				{Size: 2, Kind: op.AddrPiece, Val: 0xc000, BitSize: 13},
+				{Size: 2, Kind: op.ImmPiece, BitSize: 16},
+			},
+		},
+		{
+			name: "call2",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_lit1, op.DW_OP_call2, uint16(0x20), op.DW_OP_plus),
+			addr: 3,
+		},
+		{
+			name: "call4 without location",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_lit1, op.DW_OP_call4, uint32(0x24)),
+			addr: 1,
+		},
+		{
+			name: "call_ref",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_lit1, op.DW_OP_call_ref, uint32(testUnit+0x20), op.DW_OP_minus),
+			addr: -1,
+		},
+		{
+			name: "recursive call",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_call2, uint16(0x28)),
+			err:  "DW_OP_call2 nested too deeply",
+		},
+		{
+			name: "entry_value",
+			expr: dwarfbuilder.LocationBlock(
+				op.DW_OP_entry_value, uint(1), op.DW_OP_reg5, op.DW_OP_lit1, op.DW_OP_plus, op.DW_OP_stack_value),
+			pieces: []op.Piece{{Kind: op.ImmPiece, Val: 43}},
+		},
+		{
+			name: "GNU_entry_value",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_GNU_entry_value, uint(1), op.DW_OP_reg5),
+			addr: 42,
+		},
+		{
+			name: "entry_value not found",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_entry_value, uint(1), op.DW_OP_reg4),
+			err:  "entry value not found",
+		},
+		{
+			name:   "implicit_pointer",
+			expr:   dwarfbuilder.LocationBlock(op.DW_OP_implicit_pointer, uint32(0x1234), int(-8)),
+			pieces: []op.Piece{{Kind: op.ImplicitPtrPiece, Val: 0x1234, Offset: -8}},
+		},
+		{
+			name: "implicit_pointer piece",
+			expr: dwarfbuilder.LocationBlock(
+				op.DW_OP_GNU_implicit_pointer, uint32(0x1234), int(0), op.DW_OP_piece, uint(8),
+				op.DW_OP_regx, uint(1), op.DW_OP_piece, uint(8)),
+			pieces: []op.Piece{
+				{Size: 8, Kind: op.ImplicitPtrPiece, Val: 0x1234},
+				{Size: 8, Kind: op.RegPiece, Val: 1},
+			},
+		},
+		{
+			name: "addrx",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_addrx, uint(1)),
+			addr: 0x401000 + 0x10000,
+		},
+		{
+			name: "constx",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_constx, uint(2)),
+			addr: 0x18,
+		},
+		{
+			name: "GNU_addr_index",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_GNU_addr_index, uint(0)),
+			addr: 0x400000 + 0x10000,
+		},
+		{
+			name: "addrx out of range",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_addrx, uint(3)),
+			err:  "no address 3",
+		},
+		{
+			name: "form_tls_address",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_constx, uint(2), op.DW_OP_form_tls_address),
+			addr: testTLSBase + 0x18,
+		},
+		{
+			name: "GNU_push_tls_address",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_const1u, uint8(0x10), op.DW_OP_GNU_push_tls_address),
+			addr: testTLSBase + 0x10,
+		},
+		{
+			name: "push_object_address",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_push_object_address, op.DW_OP_plus_uconst, uint(8)),
+			addr: 0x5008,
+		},
+		{
+			name: "const_type",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_const_type, uint(0x30), uint8(4), uint32(0xfffffffe)),
+			addr: -2,
+		},
+		{
+			name: "const_type of wrong size",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_const_type, uint(0x30), uint8(2), uint16(0xfffe)),
+			err:  "constant of 2 bytes for a type of 4 bytes",
+		},
+		{
+			name: "regval_type and convert",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_regval_type, uint(17), uint(0x40), op.DW_OP_convert, uint(0x30)),
+			addr: 3,
+		},
+		{
+			name: "deref_size",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_const2u, uint16(0x100), op.DW_OP_deref_size, uint8(1)),
+			addr: 0xfe,
+		},
+		{
+			name: "deref_type unsigned",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_const2u, uint16(0x100), op.DW_OP_deref_type, uint8(1), uint(0x50)),
+			addr: 0xfe,
+		},
+		{
+			name: "deref_type signed",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_const2u, uint16(0x100), op.DW_OP_GNU_deref_type, uint8(1), uint(0x58)),
+			addr: -2,
+		},
+		{
+			name: "xderef_type",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_lit0, op.DW_OP_const2u, uint16(0x100), op.DW_OP_xderef_type, uint8(4), uint(0x30)),
+			addr: -2,
+		},
+		{
+			name: "convert to narrower type",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_const_type, uint(0x30), uint8(4), uint32(0xfffffffe), op.DW_OP_convert, uint(0x60)),
+			addr: 0xfffe,
+		},
+		{
+			name: "convert to float and reinterpret",
+			expr: dwarfbuilder.LocationBlock(
+				op.DW_OP_const_type, uint(0x30), uint8(4), uint32(0xfffffffe),
+				op.DW_OP_convert, uint(0x48), op.DW_OP_reinterpret, uint(0x68)),
+			addr: int64(math.Float32bits(-2)),
+		},
+		{
+			name: "convert float to float",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_regval_type, uint(17), uint(0x40), op.DW_OP_GNU_convert, uint(0x48)),
+			addr: int64(math.Float32bits(3.75)),
+		},
+		{
+			name: "convert to generic type",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_const_type, uint(0x58), uint8(1), uint8(0xff), op.DW_OP_convert, uint(0)),
+			addr: -1,
+		},
+		{
+			name: "types follow the stack",
+			expr: dwarfbuilder.LocationBlock(
+				op.DW_OP_const_type, uint(0x58), uint8(1), uint8(0xff), op.DW_OP_lit1,
+				op.DW_OP_swap, op.DW_OP_convert, uint(0x50), op.DW_OP_plus),
+			addr: 0x100,
+		},
+		{
+			name: "unknown base type",
+			expr: dwarfbuilder.LocationBlock(op.DW_OP_lit1, op.DW_OP_convert, uint(0x70)),
+			err:  "no DIE at 0x70",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, pieces, err := op.ExecuteStackProgramWithHooks(regs, tt.expr, 8, testMemory, testHooks)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addr != tt.addr {
+				t.Errorf("got %#x, want %#x", addr, tt.addr)
+			}
+			if !reflect.DeepEqual(pieces, tt.pieces) {
+				t.Errorf("got pieces %+v, want %+v", pieces, tt.pieces)
+			}
+		})
+	}
+}
+
+func TestMissingHooks(t *testing.T) {
+	tests := []struct {
+		expr []byte
+		err  error
+	}{
+		{dwarfbuilder.LocationBlock(op.DW_OP_call2, uint16(0x20)), op.ErrDIEUnavailable},
+		{dwarfbuilder.LocationBlock(op.DW_OP_const_type, uint(0x30), uint8(4), uint32(1)), op.ErrDIEUnavailable},
+		{dwarfbuilder.LocationBlock(op.DW_OP_entry_value, uint(1), op.DW_OP_reg5), op.ErrEntryValueUnavailable},
+		{dwarfbuilder.LocationBlock(op.DW_OP_lit0, op.DW_OP_form_tls_address), op.ErrTLSUnavailable},
+		{dwarfbuilder.LocationBlock(op.DW_OP_addrx, uint(0)), op.ErrDebugAddrUnavailable},
+	}
+	for _, tt := range tests {
+		_, _, err := op.ExecuteStackProgram(op.DwarfRegisters{}, tt.expr, 8, nil)
+		if err != tt.err {
+			t.Errorf("%x: got error %v, want %v", tt.expr, err, tt.err)
+		}
+	}
+}
+
+func TestPrettyPrintTyped(t *testing.T) {
+	var out strings.Builder
+	op.PrettyPrint(&out, dwarfbuilder.LocationBlock(
+		op.DW_OP_const_type, uint(0x30), uint8(4), uint32(0xfffffffe),
+		op.DW_OP_regx, uint(17), op.DW_OP_implicit_pointer, uint32(0x1234), int(-8)))
+	want := "DW_OP_const_type 0x30 4 [feffffff] DW_OP_regx 0x11 DW_OP_implicit_pointer 0x1234 -0x8 "
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 
 	"github.com/hitzhangjie/codemaster/dwarf/util"
 )
@@ -20,15 +21,49 @@ type stackfn func(Opcode, *context) error
 
 type ReadMemoryFunc func([]byte, uint64) (int, error)
 
+// Hooks are the callbacks of the operations that need more than the
+// registers and the memory of the target. The operations whose hook is nil
+// fail.
+type Hooks struct {
+	// DIE returns the DIE at off, an offset in .debug_info or, if unit is
+	// true, in the compile unit of the expression. It's used for the
+	// procedures of DW_OP_call2, DW_OP_call4 and DW_OP_call_ref and the
+	// base types of the typed stack operations.
+	DIE func(off uint64, unit bool) (*DIE, error)
+
+	// EntryValue returns the value expr, a register or a DWARF
+	// expression, had at the entry of the current function, for
+	// DW_OP_entry_value.
+	EntryValue func(expr []byte) (int64, error)
+
+	// TLSBase returns the address of the thread local storage of the
+	// module for the current thread, for DW_OP_form_tls_address.
+	TLSBase func() (uint64, error)
+
+	// DebugAddr returns entry idx of the .debug_addr table of the compile
+	// unit, for DW_OP_addrx and DW_OP_constx.
+	DebugAddr func(idx uint64) (uint64, error)
+}
+
+// DIE is what the operations referring to a DIE need of it.
+type DIE struct {
+	Location []byte // DW_AT_location of a procedure, nil if none
+	ByteSize int    // DW_AT_byte_size of a base type
+	Encoding int64  // DW_AT_encoding of a base type
+}
+
 type context struct {
 	buf     *bytes.Buffer
 	prog    []byte
 	stack   []int64
+	types   []baseType // of the values of stack
 	pieces  []Piece
 	ptrSize int
+	depth   int // of the DW_OP_call* being executed
 
 	DwarfRegisters
 	readMemory ReadMemoryFunc
+	hooks      Hooks
 }
 
 // Piece is a piece of memory stored either at an address or in a register.
@@ -37,37 +72,89 @@ type Piece struct {
 	Kind  PieceKind
 	Val   uint64
 	Bytes []byte
+
+	// BitSize and BitOffset are those of a DW_OP_bit_piece, the piece is
+	// the BitSize bits after the first BitOffset bits of the location and
+	// Size is BitSize rounded up to bytes. BitSize is zero for the other
+	// pieces.
+	BitSize   int
+	BitOffset int
+
+	// Offset is the offset in the value of the variable an ImplicitPtrPiece
+	// points into.
+	Offset int64
 }
 
 // PieceKind describes the kind of a piece.
 type PieceKind uint8
 
 const (
-	AddrPiece PieceKind = iota // The piece is stored in memory, Val is the address
-	RegPiece                   // The piece is stored in a register, Val is the register number
-	ImmPiece                   // The piece is an immediate value, Val or Bytes is the value
+	AddrPiece        PieceKind = iota // The piece is stored in memory, Val is the address
+	RegPiece                          // The piece is stored in a register, Val is the register number
+	ImmPiece                          // The piece is an immediate value, Val or Bytes is the value
+	ImplicitPtrPiece                  // The piece is a pointer optimized away, Val is the offset in .debug_info of the variable it points to
 )
 
 var (
 	ErrStackUnderflow        = errors.New("DWARF stack underflow")
 	ErrStackIndexOutOfBounds = errors.New("DWARF stack index out of bounds")
 	ErrMemoryReadUnavailable = errors.New("memory read unavailable")
+	ErrDIEUnavailable        = errors.New("DIE lookup unavailable")
+	ErrEntryValueUnavailable = errors.New("entry value unavailable")
+	ErrTLSUnavailable        = errors.New("TLS base unavailable")
+	ErrDebugAddrUnavailable  = errors.New("debug_addr unavailable")
 )
 
 const arbitraryExecutionLimitFactor = 10
 
+// maxCallDepth limits the nesting of DW_OP_call*, a procedure calling
+// itself would never end.
+const maxCallDepth = 16
+
 // ExecuteStackProgram executes a DWARF location expression and returns
 // either an address (int64), or a slice of Pieces for location expressions
 // that don't evaluate to an address (such as register and composite expressions).
 func ExecuteStackProgram(regs DwarfRegisters, instructions []byte, ptrSize int, readMemory ReadMemoryFunc) (int64, []Piece, error) {
+	return ExecuteStackProgramWithHooks(regs, instructions, ptrSize, readMemory, Hooks{})
+}
+
+// ExecuteStackProgramWithHooks is ExecuteStackProgram with the hooks of the
+// operations that refer to DIEs, entry values, thread local storage and
+// .debug_addr.
+func ExecuteStackProgramWithHooks(regs DwarfRegisters, instructions []byte, ptrSize int, readMemory ReadMemoryFunc, hooks Hooks) (int64, []Piece, error) {
 	ctxt := &context{
-		buf:            bytes.NewBuffer(instructions),
-		prog:           instructions,
 		stack:          make([]int64, 0, 3),
+		types:          make([]baseType, 0, 3),
 		DwarfRegisters: regs,
 		ptrSize:        ptrSize,
+		readMemory:     readMemory,
+		hooks:          hooks,
+	}
+
+	if err := ctxt.run(instructions); err != nil {
+		return 0, nil, err
 	}
 
+	if ctxt.pieces != nil {
+		if len(ctxt.pieces) == 1 && ctxt.pieces[0].Kind == RegPiece {
+			return int64(regs.Uint64Val(ctxt.pieces[0].Val)), ctxt.pieces, nil
+		}
+		return 0, ctxt.pieces, nil
+	}
+
+	if len(ctxt.stack) == 0 {
+		return 0, nil, errors.New("empty OP stack")
+	}
+
+	return ctxt.stack[len(ctxt.stack)-1], nil, nil
+}
+
+// run executes instructions, it's called again for the procedures of
+// DW_OP_call*.
+func (ctxt *context) run(instructions []byte) error {
+	ctxt.buf = bytes.NewBuffer(instructions)
+	ctxt.prog = instructions
+
 	for tick := 0; tick < len(instructions)*arbitraryExecutionLimitFactor; tick++ {
 		opcodeByte, err := ctxt.buf.ReadByte()
 		if err != nil {
@@ -79,27 +166,24 @@ func ExecuteStackProgram(regs DwarfRegisters, instructions []byte, ptrSize int,
 		}
 		fn, ok := oplut[opcode]
 		if !ok {
-			return 0, nil, fmt.Errorf("invalid instruction %#v", opcode)
+			return fmt.Errorf("invalid instruction %#v", opcode)
 		}
 
 		err = fn(opcode, ctxt)
 		if err != nil {
-			return 0, nil, err
+			return err
 		}
-	}
 
-	if ctxt.pieces != nil {
-		if len(ctxt.pieces) == 1 && ctxt.pieces[0].Kind == RegPiece {
-			return int64(regs.Uint64Val(ctxt.pieces[0].Val)), ctxt.pieces, nil
+		// the operations unaware of types push values of the generic type
+		// and their results have the type of their operands
+		if len(ctxt.types) > len(ctxt.stack) {
+			ctxt.types = ctxt.types[:len(ctxt.stack)]
+		}
+		for len(ctxt.types) < len(ctxt.stack) {
+			ctxt.types = append(ctxt.types, baseType{})
 		}
-		return 0, ctxt.pieces, nil
-	}
-
-	if len(ctxt.stack) == 0 {
-		return 0, nil, errors.New("empty OP stack")
 	}
-
-	return ctxt.stack[len(ctxt.stack)-1], nil, nil
+	return nil
 }
 
 // PrettyPrint prints the DWARF stack program instructions to `out`.
@@ -147,6 +231,12 @@ func PrettyPrint(out io.Writer, instructions []byte) {
 				sz2, _ := in.Read(data)
 				data = data[:sz2]
 				fmt.Fprintf(out, "%d [%x] ", sz, data)
+			case 'b':
+				sz, _ := in.ReadByte()
+				data := make([]byte, sz)
+				sz2, _ := in.Read(data)
+				data = data[:sz2]
+				fmt.Fprintf(out, "%d [%x] ", sz, data)
 			}
 		}
 	}
@@ -180,8 +270,13 @@ func (ctxt *context) closeLoc(opcode0 Opcode, piece Piece) error {
 		return nil
 
 	case DW_OP_bit_piece:
-		// not supported
-		return fmt.Errorf("invalid instruction %#v", opcode)
+		sz, _ := util.DecodeULEB128(ctxt.buf)
+		off, _ := util.DecodeULEB128(ctxt.buf)
+		piece.Size = int(sz+7) / 8
+		piece.BitSize, piece.BitOffset = int(sz), int(off)
+		ctxt.pieces = append(ctxt.pieces, piece)
+		return nil
+
 	default:
 		return fmt.Errorf("invalid instruction %#v after %#v", opcode, opcode0)
 	}
@@ -251,16 +346,23 @@ func bregister(opcode Opcode, ctxt *context) error {
 
 func piece(opcode Opcode, ctxt *context) error {
 	sz, _ := util.DecodeULEB128(ctxt.buf)
+	p := Piece{Size: int(sz)}
+	if opcode == DW_OP_bit_piece {
+		off, _ := util.DecodeULEB128(ctxt.buf)
+		p = Piece{Size: int(sz+7) / 8, BitSize: int(sz), BitOffset: int(off)}
+	}
 
 	if len(ctxt.stack) == 0 {
 		// nothing on the stack means this piece is unavailable (padding,
 		// optimized away...), see DWARFv4 sec. 2.6.1.3 page 30.
-		ctxt.pieces = append(ctxt.pieces, Piece{Size: int(sz), Kind: ImmPiece, Val: 0})
+		p.Kind = ImmPiece
+		ctxt.pieces = append(ctxt.pieces, p)
 		return nil
 	}
 
-	addr := ctxt.stack[len(ctxt.stack)-1]
-	ctxt.pieces = append(ctxt.pieces, Piece{Size: int(sz), Kind: AddrPiece, Val: uint64(addr)})
+	p.Kind = AddrPiece
+	p.Val = uint64(ctxt.stack[len(ctxt.stack)-1])
+	ctxt.pieces = append(ctxt.pieces, p)
 	ctxt.stack = ctxt.stack[:0]
 	return nil
 }
@@ -335,6 +437,7 @@ func dup(_ Opcode, ctxt *context) error {
 		return ErrStackUnderflow
 	}
 	ctxt.stack = append(ctxt.stack, ctxt.stack[len(ctxt.stack)-1])
+	ctxt.types = append(ctxt.types, ctxt.types[len(ctxt.types)-1])
 	return nil
 }
 
@@ -361,6 +464,7 @@ func pick(opcode Opcode, ctxt *context) error {
 		return ErrStackIndexOutOfBounds
 	}
 	ctxt.stack = append(ctxt.stack, ctxt.stack[idx])
+	ctxt.types = append(ctxt.types, ctxt.types[idx])
 	return nil
 }
 
@@ -369,6 +473,7 @@ func swap(_ Opcode, ctxt *context) error {
 		return ErrStackUnderflow
 	}
 	ctxt.stack[len(ctxt.stack)-1], ctxt.stack[len(ctxt.stack)-2] = ctxt.stack[len(ctxt.stack)-2], ctxt.stack[len(ctxt.stack)-1]
+	ctxt.types[len(ctxt.types)-1], ctxt.types[len(ctxt.types)-2] = ctxt.types[len(ctxt.types)-2], ctxt.types[len(ctxt.types)-1]
 	return nil
 }
 
@@ -377,6 +482,7 @@ func rot(_ Opcode, ctxt *context) error {
 		return ErrStackUnderflow
 	}
 	ctxt.stack[len(ctxt.stack)-1], ctxt.stack[len(ctxt.stack)-2], ctxt.stack[len(ctxt.stack)-3] = ctxt.stack[len(ctxt.stack)-2], ctxt.stack[len(ctxt.stack)-3], ctxt.stack[len(ctxt.stack)-1]
+	ctxt.types[len(ctxt.types)-1], ctxt.types[len(ctxt.types)-2], ctxt.types[len(ctxt.types)-3] = ctxt.types[len(ctxt.types)-2], ctxt.types[len(ctxt.types)-3], ctxt.types[len(ctxt.types)-1]
 	return nil
 }
 
@@ -539,18 +645,326 @@ func deref(op Opcode, ctxt *context) error {
 		ctxt.stack = ctxt.stack[:len(ctxt.stack)-1]
 	}
 
+	if sz > 8 {
+		return fmt.Errorf("unsupported %s of %d bytes", opcodeName[op], sz)
+	}
 	buf := make([]byte, sz)
 	_, err := ctxt.readMemory(buf, uint64(addr))
 	if err != nil {
 		return err
 	}
 
-	x, err := util.ReadUintRaw(bytes.NewReader(buf), binary.LittleEndian, sz)
+	ctxt.stack = append(ctxt.stack, int64(uintLE(buf)))
+
+	return nil
+}
+
+func pushobjaddr(_ Opcode, ctxt *context) error {
+	if ctxt.ObjBase == 0 {
+		return errors.New("could not retrieve the address of the object being evaluated")
+	}
+	ctxt.stack = append(ctxt.stack, ctxt.ObjBase)
+	return nil
+}
+
+func init() {
+	// the procedures of DW_OP_call* are executed with oplut, they can't be
+	// in its initializer
+	oplut[DW_OP_call2] = call
+	oplut[DW_OP_call4] = call
+	oplut[DW_OP_call_ref] = call
+}
+
+func call(opcode Opcode, ctxt *context) error {
+	var (
+		off uint64
+		err error
+	)
+	switch opcode {
+	case DW_OP_call2:
+		off, err = util.ReadUintRaw(ctxt.buf, binary.LittleEndian, 2)
+	case DW_OP_call4, DW_OP_call_ref:
+		off, err = util.ReadUintRaw(ctxt.buf, binary.LittleEndian, 4)
+	default:
+		panic("internal error")
+	}
+	if err != nil {
+		return err
+	}
+	if ctxt.hooks.DIE == nil {
+		return ErrDIEUnavailable
+	}
+	die, err := ctxt.hooks.DIE(off, opcode != DW_OP_call_ref)
+	if err != nil {
+		return err
+	}
+	// a DIE without a location is a call without effect
+	if die.Location == nil {
+		return nil
+	}
+	if ctxt.depth >= maxCallDepth {
+		return fmt.Errorf("%s nested too deeply", opcodeName[opcode])
+	}
+
+	// the procedure shares the stack of the caller
+	buf, prog := ctxt.buf, ctxt.prog
+	ctxt.depth++
+	err = ctxt.run(die.Location)
+	ctxt.depth--
+	ctxt.buf, ctxt.prog = buf, prog
+	return err
+}
+
+func tlsaddr(_ Opcode, ctxt *context) error {
+	if len(ctxt.stack) < 1 {
+		return ErrStackUnderflow
+	}
+	if ctxt.hooks.TLSBase == nil {
+		return ErrTLSUnavailable
+	}
+	base, err := ctxt.hooks.TLSBase()
 	if err != nil {
 		return err
 	}
+	ctxt.stack[len(ctxt.stack)-1] += int64(base)
+	return nil
+}
 
-	ctxt.stack = append(ctxt.stack, int64(x))
+func implicitpointer(opcode Opcode, ctxt *context) error {
+	die, err := util.ReadUintRaw(ctxt.buf, binary.LittleEndian, 4)
+	if err != nil {
+		return err
+	}
+	off, _ := util.DecodeSLEB128(ctxt.buf)
+	return ctxt.closeLoc(opcode, Piece{Kind: ImplicitPtrPiece, Val: die, Offset: off})
+}
 
+func addrx(opcode Opcode, ctxt *context) error {
+	idx, _ := util.DecodeULEB128(ctxt.buf)
+	if ctxt.hooks.DebugAddr == nil {
+		return ErrDebugAddrUnavailable
+	}
+	n, err := ctxt.hooks.DebugAddr(idx)
+	if err != nil {
+		return err
+	}
+	// constants, such as offsets in the thread local storage, aren't
+	// relocated
+	if opcode == DW_OP_addrx || opcode == DW_OP_GNU_addr_index {
+		n += ctxt.StaticBase
+	}
+	ctxt.stack = append(ctxt.stack, int64(n))
 	return nil
 }
+
+func entryvalue(_ Opcode, ctxt *context) error {
+	sz, _ := util.DecodeULEB128(ctxt.buf)
+	block := make([]byte, sz)
+	n, _ := ctxt.buf.Read(block)
+	if uint64(n) != sz {
+		return fmt.Errorf("insufficient bytes read while reading DW_OP_entry_value's block %d (expected: %d)", n, sz)
+	}
+	if ctxt.hooks.EntryValue == nil {
+		return ErrEntryValueUnavailable
+	}
+	v, err := ctxt.hooks.EntryValue(block)
+	if err != nil {
+		return err
+	}
+	ctxt.stack = append(ctxt.stack, v)
+	return nil
+}
+
+// Encodings of base types, DW_ATE_*.
+const (
+	encBoolean      = 0x02
+	encFloat        = 0x04
+	encSigned       = 0x05
+	encSignedChar   = 0x06
+	encUnsigned     = 0x07
+	encUnsignedChar = 0x08
+)
+
+// baseType is the type of a value of the stack, the zero value is the
+// generic type, an integer of the size of an address.
+type baseType struct {
+	size     int
+	encoding int64
+}
+
+// baseType returns the base type at off in the compile unit, the generic
+// type for 0.
+func (ctxt *context) baseType(off uint64) (baseType, error) {
+	if off == 0 {
+		return baseType{}, nil
+	}
+	if ctxt.hooks.DIE == nil {
+		return baseType{}, ErrDIEUnavailable
+	}
+	die, err := ctxt.hooks.DIE(off, true)
+	if err != nil {
+		return baseType{}, err
+	}
+	if die.ByteSize <= 0 || die.ByteSize > 8 {
+		return baseType{}, fmt.Errorf("unsupported base type of size %d at %#x", die.ByteSize, off)
+	}
+	return baseType{size: die.ByteSize, encoding: die.Encoding}, nil
+}
+
+func (t baseType) float() bool {
+	return t.encoding == encFloat
+}
+
+func (t baseType) signed() bool {
+	return t.encoding == encSigned || t.encoding == encSignedChar
+}
+
+// value returns the value of type t whose bits are the low bits of v,
+// sign extended for signed types.
+func (t baseType) value(v uint64) int64 {
+	if t.size == 0 || t.size >= 8 {
+		return int64(v)
+	}
+	shift := 64 - 8*uint(t.size)
+	if t.signed() {
+		return int64(v<<shift) >> shift
+	}
+	return int64(v << shift >> shift)
+}
+
+// floatValue returns the float of type t v is the bits of.
+func (t baseType) floatValue(v int64) float64 {
+	if t.size == 4 {
+		return float64(math.Float32frombits(uint32(v)))
+	}
+	return math.Float64frombits(uint64(v))
+}
+
+// floatBits returns the bits of f as a float of type t.
+func (t baseType) floatBits(f float64) int64 {
+	if t.size == 4 {
+		return int64(math.Float32bits(float32(f)))
+	}
+	return int64(math.Float64bits(f))
+}
+
+// push pushes v of type t.
+func (ctxt *context) push(v int64, t baseType) {
+	ctxt.stack = append(ctxt.stack, v)
+	ctxt.types = append(ctxt.types, t)
+}
+
+func consttype(_ Opcode, ctxt *context) error {
+	off, _ := util.DecodeULEB128(ctxt.buf)
+	sz, err := ctxt.buf.ReadByte()
+	if err != nil {
+		return err
+	}
+	t, err := ctxt.baseType(off)
+	if err != nil {
+		return err
+	}
+	if int(sz) != t.size {
+		return fmt.Errorf("constant of %d bytes for a type of %d bytes", sz, t.size)
+	}
+	buf := ctxt.buf.Next(int(sz))
+	if len(buf) != int(sz) {
+		return fmt.Errorf("insufficient bytes read while reading DW_OP_const_type's constant %d (expected: %d)", len(buf), sz)
+	}
+	ctxt.push(t.value(uintLE(buf)), t)
+	return nil
+}
+
+func regvaltype(_ Opcode, ctxt *context) error {
+	regnum, _ := util.DecodeULEB128(ctxt.buf)
+	off, _ := util.DecodeULEB128(ctxt.buf)
+	t, err := ctxt.baseType(off)
+	if err != nil {
+		return err
+	}
+	if ctxt.Reg(regnum) == nil {
+		return fmt.Errorf("register %d not available", regnum)
+	}
+	ctxt.push(t.value(ctxt.Uint64Val(regnum)), t)
+	return nil
+}
+
+func dereftype(opcode Opcode, ctxt *context) error {
+	if ctxt.readMemory == nil {
+		return ErrMemoryReadUnavailable
+	}
+	sz, err := ctxt.buf.ReadByte()
+	if err != nil {
+		return err
+	}
+	off, _ := util.DecodeULEB128(ctxt.buf)
+	t, err := ctxt.baseType(off)
+	if err != nil {
+		return err
+	}
+	if int(sz) > 8 {
+		return fmt.Errorf("unsupported %s of %d bytes", opcodeName[opcode], sz)
+	}
+
+	n := 1
+	if opcode == DW_OP_xderef_type {
+		// the address space identifier, ignored like for DW_OP_xderef
+		n = 2
+	}
+	if len(ctxt.stack) < n {
+		return ErrStackUnderflow
+	}
+	addr := ctxt.stack[len(ctxt.stack)-1]
+	ctxt.stack = ctxt.stack[:len(ctxt.stack)-n]
+	ctxt.types = ctxt.types[:len(ctxt.types)-n]
+
+	buf := make([]byte, sz)
+	if _, err := ctxt.readMemory(buf, uint64(addr)); err != nil {
+		return err
+	}
+	ctxt.push(t.value(uintLE(buf)), t)
+	return nil
+}
+
+func convert(opcode Opcode, ctxt *context) error {
+	off, _ := util.DecodeULEB128(ctxt.buf)
+	to, err := ctxt.baseType(off)
+	if err != nil {
+		return err
+	}
+	if len(ctxt.stack) < 1 {
+		return ErrStackUnderflow
+	}
+	v := ctxt.stack[len(ctxt.stack)-1]
+	from := ctxt.types[len(ctxt.types)-1]
+
+	switch {
+	case opcode == DW_OP_reinterpret || opcode == DW_OP_GNU_reinterpret:
+		// same bits, another type
+		v = to.value(uint64(v))
+	case from.float() && to.float():
+		v = to.floatBits(from.floatValue(v))
+	case from.float() && to.signed():
+		v = to.value(uint64(int64(from.floatValue(v))))
+	case from.float():
+		v = to.value(uint64(from.floatValue(v)))
+	case to.float() && from.signed():
+		v = to.floatBits(float64(v))
+	case to.float():
+		v = to.floatBits(float64(uint64(from.value(uint64(v)))))
+	default:
+		v = to.value(uint64(v))
+	}
+	ctxt.stack[len(ctxt.stack)-1] = v
+	ctxt.types[len(ctxt.types)-1] = to
+	return nil
+}
+
+// uintLE returns the little endian unsigned integer of at most 8 bytes b.
+func uintLE(b []byte) uint64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
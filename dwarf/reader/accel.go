@@ -0,0 +1,323 @@
+package reader
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/hitzhangjie/codemaster/dwarf/util"
+)
+
+// Acceleration tables, see DWARF v4 section 6.1.1 (.debug_pubnames and
+// .debug_pubtypes) and DWARF v5 section 6.1.1 (.debug_names).
+
+var errShortAccel = errors.New("truncated acceleration table")
+
+// accelBuf reads the fixed size fields of an acceleration table.
+type accelBuf struct {
+	order binary.ByteOrder
+	data  []byte
+	off   int
+	err   error
+}
+
+func (b *accelBuf) bytes(n int) []byte {
+	if b.err != nil {
+		return nil
+	}
+	if n < 0 || b.off+n > len(b.data) {
+		b.err = errShortAccel
+		return nil
+	}
+	p := b.data[b.off : b.off+n]
+	b.off += n
+	return p
+}
+
+func (b *accelBuf) u16() uint16 {
+	if p := b.bytes(2); p != nil {
+		return b.order.Uint16(p)
+	}
+	return 0
+}
+
+func (b *accelBuf) u32() uint32 {
+	if p := b.bytes(4); p != nil {
+		return b.order.Uint32(p)
+	}
+	return 0
+}
+
+func (b *accelBuf) u64() uint64 {
+	if p := b.bytes(8); p != nil {
+		return b.order.Uint64(p)
+	}
+	return 0
+}
+
+// offset reads a 4 or 8 bytes offset, as for 32 and 64 bits DWARF
+func (b *accelBuf) offset(dwarf64 bool) uint64 {
+	if dwarf64 {
+		return b.u64()
+	}
+	return uint64(b.u32())
+}
+
+// unitLength reads the length of a unit, and whether it's 64 bits DWARF
+func (b *accelBuf) unitLength() (uint64, bool) {
+	n := uint64(b.u32())
+	if n == 0xffffffff {
+		return b.u64(), true
+	}
+	return n, false
+}
+
+func (b *accelBuf) cstring() string {
+	if b.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(b.data[b.off:], 0)
+	if i < 0 {
+		b.err = errShortAccel
+		return ""
+	}
+	s := string(b.data[b.off : b.off+i])
+	b.off += i + 1
+	return s
+}
+
+func (b *accelBuf) uleb() uint64 {
+	if b.err != nil {
+		return 0
+	}
+	if b.off >= len(b.data) {
+		b.err = errShortAccel
+		return 0
+	}
+	v, n := util.DecodeULEB128(bytes.NewBuffer(b.data[b.off:]))
+	b.off += int(n)
+	return v
+}
+
+// readPubnames reads the names of .debug_pubnames and .debug_pubtypes. The
+// tables don't tell functions from variables, their entries are read to
+// get their tags.
+func (u *unitIndex) readPubnames(data *dwarf.Data, sections IndexSections) error {
+	r := data.Reader()
+	read := func(table []byte, types bool) error {
+		b := &accelBuf{order: sections.ByteOrder, data: table}
+		for b.off < len(b.data) && b.err == nil {
+			length, dwarf64 := b.unitLength()
+			end := b.off + int(length)
+			if b.err != nil || end > len(b.data) {
+				return errShortAccel
+			}
+			b.u16() // version
+			unit := b.offset(dwarf64)
+			b.offset(dwarf64) // length of the unit in .debug_info
+			for b.off < end && b.err == nil {
+				off := b.offset(dwarf64)
+				if off == 0 {
+					break
+				}
+				name := b.cstring()
+				die := dwarf.Offset(unit + off)
+				if types {
+					u.types = append(u.types, namedOffset{name, die})
+					continue
+				}
+				r.Seek(die)
+				e, err := r.Next()
+				if err != nil {
+					return err
+				}
+				if e == nil {
+					return fmt.Errorf("no entry at %#x for %s", die, name)
+				}
+				u.add(e.Tag, name, die)
+			}
+			b.off = end
+		}
+		return b.err
+	}
+	if err := read(sections.Pubnames, false); err != nil {
+		return fmt.Errorf("could not read .debug_pubnames: %v", err)
+	}
+	if err := read(sections.Pubtypes, true); err != nil {
+		return fmt.Errorf("could not read .debug_pubtypes: %v", err)
+	}
+	return nil
+}
+
+// DWARF 5 name index attributes
+const (
+	idxCompileUnit = 1
+	idxTypeUnit    = 2
+	idxDieOffset   = 3
+)
+
+type namesAbbrev struct {
+	tag   dwarf.Tag
+	attrs [][2]uint64 // index attribute and form
+}
+
+// readNames reads the names of .debug_names, names of type units are
+// ignored as entries of type units can't be read by offset.
+func (u *unitIndex) readNames(sections IndexSections) error {
+	b := &accelBuf{order: sections.ByteOrder, data: sections.Names}
+	for b.off < len(b.data) && b.err == nil {
+		if err := u.readNameIndex(b, sections.Str); err != nil {
+			return fmt.Errorf("could not read .debug_names: %v", err)
+		}
+	}
+	if b.err != nil {
+		return fmt.Errorf("could not read .debug_names: %v", b.err)
+	}
+	return nil
+}
+
+// readNameIndex reads one name index, there's one per module.
+func (u *unitIndex) readNameIndex(b *accelBuf, str []byte) error {
+	length, dwarf64 := b.unitLength()
+	end := b.off + int(length)
+	if b.err != nil || end > len(b.data) {
+		return errShortAccel
+	}
+	if version := b.u16(); version != 5 {
+		return fmt.Errorf("unsupported version %d", version)
+	}
+	b.u16() // padding
+	cuCount := b.u32()
+	localTUCount := b.u32()
+	foreignTUCount := b.u32()
+	bucketCount := b.u32()
+	nameCount := b.u32()
+	abbrevSize := b.u32()
+	augmentationSize := b.u32()
+	b.bytes(int(augmentationSize))
+
+	offsetSize := 4
+	if dwarf64 {
+		offsetSize = 8
+	}
+	cus := make([]uint64, cuCount)
+	for i := range cus {
+		cus[i] = b.offset(dwarf64)
+	}
+	b.bytes(int(localTUCount)*offsetSize + int(foreignTUCount)*8)
+	b.bytes(int(bucketCount) * 4)
+	if bucketCount > 0 {
+		b.bytes(int(nameCount) * 4) // hashes
+	}
+	strOffsets := make([]uint64, nameCount)
+	for i := range strOffsets {
+		strOffsets[i] = b.offset(dwarf64)
+	}
+	entryOffsets := make([]uint64, nameCount)
+	for i := range entryOffsets {
+		entryOffsets[i] = b.offset(dwarf64)
+	}
+
+	abbrevs := map[uint64]*namesAbbrev{}
+	ab := &accelBuf{order: b.order, data: b.bytes(int(abbrevSize))}
+	for ab.off < len(ab.data) && ab.err == nil {
+		code := ab.uleb()
+		if code == 0 {
+			break
+		}
+		a := &namesAbbrev{tag: dwarf.Tag(ab.uleb())}
+		for ab.err == nil {
+			attr, form := ab.uleb(), ab.uleb()
+			if attr == 0 && form == 0 {
+				break
+			}
+			a.attrs = append(a.attrs, [2]uint64{attr, form})
+		}
+		abbrevs[code] = a
+	}
+	if b.err != nil {
+		return b.err
+	}
+	if ab.err != nil {
+		return ab.err
+	}
+
+	pool := b.data[b.off:end]
+	for i := range strOffsets {
+		if strOffsets[i] >= uint64(len(str)) {
+			return fmt.Errorf("name offset %#x out of .debug_str", strOffsets[i])
+		}
+		s := &accelBuf{data: str, off: int(strOffsets[i])}
+		name := s.cstring()
+
+		e := &accelBuf{order: b.order, data: pool, off: int(entryOffsets[i])}
+		if e.off > len(pool) {
+			return errShortAccel
+		}
+		for e.err == nil {
+			code := e.uleb()
+			if code == 0 {
+				break
+			}
+			a := abbrevs[code]
+			if a == nil {
+				return fmt.Errorf("unknown abbreviation code %d", code)
+			}
+			var cu, die uint64
+			typeUnit := false
+			for _, attr := range a.attrs {
+				v, err := e.form(attr[1], dwarf64)
+				if err != nil {
+					return err
+				}
+				switch attr[0] {
+				case idxCompileUnit:
+					cu = v
+				case idxTypeUnit:
+					typeUnit = true
+				case idxDieOffset:
+					die = v
+				}
+			}
+			if typeUnit || cu >= uint64(len(cus)) {
+				continue
+			}
+			u.add(a.tag, name, dwarf.Offset(cus[cu]+die))
+		}
+		if e.err != nil {
+			return e.err
+		}
+	}
+	b.off = end
+	return nil
+}
+
+// form reads a value of form f, only the forms allowed in name indexes are
+// supported.
+func (b *accelBuf) form(f uint64, dwarf64 bool) (uint64, error) {
+	switch f {
+	case 0x0b, 0x11: // data1, ref1
+		if p := b.bytes(1); p != nil {
+			return uint64(p[0]), nil
+		}
+	case 0x05, 0x12: // data2, ref2
+		return uint64(b.u16()), b.err
+	case 0x06, 0x13: // data4, ref4
+		return uint64(b.u32()), b.err
+	case 0x07, 0x14, 0x20: // data8, ref8, ref_sig8
+		return b.u64(), b.err
+	case 0x0f, 0x15: // udata, ref_udata
+		return b.uleb(), b.err
+	case 0x19: // flag_present
+		return 1, nil
+	case 0x0c: // flag
+		if p := b.bytes(1); p != nil {
+			return uint64(p[0]), nil
+		}
+	default:
+		return 0, fmt.Errorf("unsupported form %#x in name index", f)
+	}
+	return 0, b.err
+}
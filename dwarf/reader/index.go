@@ -0,0 +1,394 @@
+package reader
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+)
+
+// Index maps the names of functions, types and package variables to their
+// entries, and PCs to their compile unit and function, so lookups don't
+// scan .debug_info like Reader does.
+//
+// Only the top level entries of compile units are indexed, that's where
+// Go declares everything. Nested declarations, e.g. C++ namespaces, are
+// found only if the binary has acceleration tables. Function names always
+// come from the compile units, as their entries are read anyway for the PC
+// index, and Go's .debug_pubnames has only variables.
+type Index struct {
+	data *dwarf.Data
+
+	// BuildID is the GNU build ID of the binary, the key of the cache.
+	BuildID string
+
+	Functions map[string]dwarf.Offset
+	Types     map[string]dwarf.Offset
+	Variables map[string]dwarf.Offset
+
+	// Units and Funcs are sorted by Low and don't overlap.
+	Units []AddrRange
+	Funcs []AddrRange
+}
+
+// AddrRange is the range [Low, High) of a compile unit or a function.
+type AddrRange struct {
+	Low, High uint64
+	Unit      dwarf.Offset // the compile unit
+	Func      dwarf.Offset // the function, 0 for compile units
+}
+
+// IndexSections are the acceleration tables used to build an Index, any of
+// them may be nil. .debug_names is used if present, then .debug_pubnames
+// and .debug_pubtypes.
+type IndexSections struct {
+	ByteOrder binary.ByteOrder
+
+	Names    []byte // .debug_names
+	Str      []byte // .debug_str, for the names of .debug_names
+	Pubnames []byte // .debug_pubnames
+	Pubtypes []byte // .debug_pubtypes
+}
+
+// NewIndex indexes data. Compile units are indexed concurrently.
+func NewIndex(data *dwarf.Data, sections IndexSections) (*Index, error) {
+	if sections.ByteOrder == nil {
+		sections.ByteOrder = binary.LittleEndian
+	}
+
+	// find the compile units, skipping the children of a unit moves to
+	// the next one without reading them
+	var units []dwarf.Offset
+	r := data.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag == dwarf.TagCompileUnit || e.Tag == dwarf.TagPartialUnit {
+			units = append(units, e.Offset)
+		}
+		r.SkipChildren()
+	}
+
+	names := &unitIndex{}
+	var (
+		indexed tagKinds
+		err     error
+	)
+	switch {
+	case sections.Names != nil:
+		indexed = tagKinds{variables: true, types: true}
+		err = names.readNames(sections)
+	case sections.Pubnames != nil || sections.Pubtypes != nil:
+		indexed = tagKinds{variables: sections.Pubnames != nil, types: sections.Pubtypes != nil}
+		err = names.readPubnames(data, sections)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*unitIndex, len(units))
+	errs := make([]error, len(units))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j], errs[j] = indexUnit(data, units[j], indexed)
+			}
+		}()
+	}
+	for j := range units {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+
+	idx := &Index{
+		data:      data,
+		Functions: map[string]dwarf.Offset{},
+		Types:     map[string]dwarf.Offset{},
+		Variables: map[string]dwarf.Offset{},
+	}
+	results = append([]*unitIndex{names}, results...)
+	for j, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("could not index compile unit at %#x: %v", units[j], err)
+		}
+	}
+	// the first definition wins, as it would when scanning
+	for _, u := range results {
+		u.mergeInto(idx)
+	}
+	idx.Units = sortRanges(idx.Units)
+	idx.Funcs = sortRanges(idx.Funcs)
+	return idx, nil
+}
+
+// NewIndexFromFile indexes the DWARF of f. If cacheDir isn't empty the
+// index is loaded from, or saved to, cacheDir/<build id>.idx. Binaries
+// without a GNU build ID aren't cached.
+func NewIndexFromFile(f *godwarf.File, cacheDir string) (*Index, error) {
+	data, err := f.DWARF()
+	if err != nil {
+		return nil, err
+	}
+	buildID, err := godwarf.BuildID(f.File)
+	if err != nil {
+		return nil, err
+	}
+	if cacheDir != "" && buildID != "" {
+		if idx, err := LoadIndex(cacheDir, buildID, data); err == nil {
+			return idx, nil
+		}
+	}
+
+	sections := IndexSections{ByteOrder: f.ByteOrder}
+	sections.Names, _ = godwarf.GetDebugSection(f, "names")
+	sections.Str, _ = godwarf.GetDebugSection(f, "str")
+	sections.Pubnames, _ = godwarf.GetDebugSection(f, "pubnames")
+	sections.Pubtypes, _ = godwarf.GetDebugSection(f, "pubtypes")
+	idx, err := NewIndex(data, sections)
+	if err != nil {
+		return nil, err
+	}
+	idx.BuildID = buildID
+	if cacheDir != "" && buildID != "" {
+		if err := idx.Save(cacheDir); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// unitIndex is the part of the index built from one compile unit, or from
+// the acceleration tables.
+type unitIndex struct {
+	functions, types, variables []namedOffset
+	units, funcs                []AddrRange
+}
+
+type namedOffset struct {
+	name string
+	off  dwarf.Offset
+}
+
+func (u *unitIndex) mergeInto(idx *Index) {
+	add := func(m map[string]dwarf.Offset, names []namedOffset) {
+		for _, n := range names {
+			if _, ok := m[n.name]; !ok {
+				m[n.name] = n.off
+			}
+		}
+	}
+	add(idx.Functions, u.functions)
+	add(idx.Types, u.types)
+	add(idx.Variables, u.variables)
+	idx.Units = append(idx.Units, u.units...)
+	idx.Funcs = append(idx.Funcs, u.funcs...)
+}
+
+// add adds the name of entry e with tag to the index
+func (u *unitIndex) add(tag dwarf.Tag, name string, off dwarf.Offset) {
+	switch {
+	case tag == dwarf.TagSubprogram:
+		u.functions = append(u.functions, namedOffset{name, off})
+	case tag == dwarf.TagVariable:
+		u.variables = append(u.variables, namedOffset{name, off})
+	case isType(tag):
+		u.types = append(u.types, namedOffset{name, off})
+	}
+}
+
+func isType(tag dwarf.Tag) bool {
+	switch tag {
+	case dwarf.TagArrayType, dwarf.TagBaseType, dwarf.TagClassType, dwarf.TagStructType, dwarf.TagUnionType, dwarf.TagConstType, dwarf.TagVolatileType, dwarf.TagRestrictType, dwarf.TagEnumerationType, dwarf.TagPointerType, dwarf.TagSubroutineType, dwarf.TagTypedef, dwarf.TagUnspecifiedType:
+		return true
+	}
+	return false
+}
+
+// tagKinds are the kinds of names found in the acceleration tables.
+type tagKinds struct {
+	variables, types bool
+}
+
+// indexUnit indexes the top level entries of the compile unit at off,
+// except the names already indexed by the acceleration tables.
+func indexUnit(data *dwarf.Data, off dwarf.Offset, indexed tagKinds) (*unitIndex, error) {
+	u := &unitIndex{}
+	r := data.Reader()
+	r.Seek(off)
+	cu, err := r.Next()
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := data.Ranges(cu)
+	if err != nil {
+		return nil, err
+	}
+	for _, rng := range ranges {
+		u.units = append(u.units, AddrRange{Low: rng[0], High: rng[1], Unit: off})
+	}
+	if !cu.Children {
+		return u, nil
+	}
+
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil || e.Tag == 0 {
+			break
+		}
+		r.SkipChildren()
+
+		name, _ := e.Val(dwarf.AttrName).(string)
+		switch {
+		case name == "", e.Val(dwarf.AttrDeclaration) != nil:
+		case e.Tag == dwarf.TagVariable && indexed.variables:
+		case isType(e.Tag) && indexed.types:
+		default:
+			u.add(e.Tag, name, e.Offset)
+		}
+		if e.Tag != dwarf.TagSubprogram {
+			continue
+		}
+		ranges, err := data.Ranges(e)
+		if err != nil {
+			return nil, err
+		}
+		for _, rng := range ranges {
+			u.funcs = append(u.funcs, AddrRange{Low: rng[0], High: rng[1], Unit: off, Func: e.Offset})
+		}
+	}
+	return u, nil
+}
+
+// sortRanges sorts rs by address and drops empty ranges and the ranges
+// overlapping a previous one, e.g. functions folded by the linker.
+func sortRanges(rs []AddrRange) []AddrRange {
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].Low < rs[j].Low })
+	out := rs[:0]
+	for _, r := range rs {
+		if r.Low >= r.High {
+			continue
+		}
+		if n := len(out); n > 0 && r.Low < out[n-1].High {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// findRange returns the range of rs containing pc
+func findRange(rs []AddrRange, pc uint64) (AddrRange, bool) {
+	i := sort.Search(len(rs), func(i int) bool { return rs[i].High > pc })
+	if i < len(rs) && rs[i].Low <= pc {
+		return rs[i], true
+	}
+	return AddrRange{}, false
+}
+
+// Entry returns the entry at off.
+func (idx *Index) Entry(off dwarf.Offset) (*dwarf.Entry, error) {
+	r := idx.data.Reader()
+	r.Seek(off)
+	e, err := r.Next()
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, fmt.Errorf("no entry at %#x", off)
+	}
+	return e, nil
+}
+
+func (idx *Index) lookup(m map[string]dwarf.Offset, kind, name string) (*dwarf.Entry, error) {
+	off, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("could not find %s %s", kind, name)
+	}
+	return idx.Entry(off)
+}
+
+// Function returns the entry of the function name.
+func (idx *Index) Function(name string) (*dwarf.Entry, error) {
+	return idx.lookup(idx.Functions, "function", name)
+}
+
+// Type returns the entry of the type name.
+func (idx *Index) Type(name string) (*dwarf.Entry, error) {
+	e, err := idx.lookup(idx.Types, "type", name)
+	if err != nil {
+		return nil, ErrTypeNotFound
+	}
+	return e, nil
+}
+
+// Variable returns the entry of the package variable name.
+func (idx *Index) Variable(name string) (*dwarf.Entry, error) {
+	return idx.lookup(idx.Variables, "variable", name)
+}
+
+// SeekToTypeNamed is like Reader.SeekToTypeNamed, it moves reader to the
+// type name found in the index.
+func (idx *Index) SeekToTypeNamed(reader *Reader, name string) (*dwarf.Entry, error) {
+	off, ok := idx.Types[name]
+	if !ok {
+		return nil, ErrTypeNotFound
+	}
+	reader.Seek(off)
+	return reader.Next()
+}
+
+// AddrFor is like Reader.AddrFor for the package variable name.
+func (idx *Index) AddrFor(name string, staticBase uint64, ptrSize int) (uint64, error) {
+	entry, err := idx.Variable(name)
+	if err != nil {
+		return 0, err
+	}
+	instructions, ok := entry.Val(dwarf.AttrLocation).([]byte)
+	if !ok {
+		return 0, fmt.Errorf("type assertion failed")
+	}
+	addr, _, err := op.ExecuteStackProgram(op.DwarfRegisters{StaticBase: staticBase}, instructions, ptrSize, nil)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(addr), nil
+}
+
+// PCToUnit returns the entry of the compile unit containing pc.
+func (idx *Index) PCToUnit(pc uint64) (*dwarf.Entry, error) {
+	r, ok := findRange(idx.Units, pc)
+	if !ok {
+		// units without ranges still have functions
+		if r, ok = findRange(idx.Funcs, pc); !ok {
+			return nil, fmt.Errorf("no compile unit contains %#x", pc)
+		}
+	}
+	return idx.Entry(r.Unit)
+}
+
+// PCToFunc returns the entry of the function containing pc.
+func (idx *Index) PCToFunc(pc uint64) (*dwarf.Entry, error) {
+	r, ok := findRange(idx.Funcs, pc)
+	if !ok {
+		return nil, fmt.Errorf("no function contains %#x", pc)
+	}
+	return idx.Entry(r.Func)
+}
@@ -0,0 +1,82 @@
+package reader
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// indexVersion is incremented when the format of the cached index changes,
+// older cache files are then rebuilt.
+const indexVersion = 1
+
+type indexFile struct {
+	Version int
+	Index   *Index
+}
+
+// indexPath returns the path of the cached index of buildID in dir
+func indexPath(dir, buildID string) string {
+	return filepath.Join(dir, buildID+".idx")
+}
+
+// Save writes idx to dir, it's found again by LoadIndex with its BuildID.
+// The file is written to a temporary file first, so concurrent readers
+// never see a partial index.
+func (idx *Index) Save(dir string) error {
+	if idx.BuildID == "" {
+		return errors.New("can't save an index without build ID")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, idx.BuildID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	err = gob.NewEncoder(w).Encode(indexFile{Version: indexVersion, Index: idx})
+	if err == nil {
+		err = w.Flush()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), indexPath(dir, idx.BuildID))
+}
+
+// LoadIndex reads the index of the binary with buildID saved in dir, data
+// is the DWARF of the binary.
+func LoadIndex(dir, buildID string, data *dwarf.Data) (*Index, error) {
+	f, err := os.Open(indexPath(dir, buildID))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file indexFile
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&file); err != nil {
+		return nil, fmt.Errorf("could not read index %s: %v", f.Name(), err)
+	}
+	if file.Version != indexVersion || file.Index == nil || file.Index.BuildID != buildID {
+		return nil, fmt.Errorf("stale index %s", f.Name())
+	}
+	idx := file.Index
+	idx.data = data
+	for _, m := range []*map[string]dwarf.Offset{&idx.Functions, &idx.Types, &idx.Variables} {
+		if *m == nil {
+			*m = map[string]dwarf.Offset{}
+		}
+	}
+	return idx, nil
+}
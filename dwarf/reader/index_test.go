@@ -0,0 +1,167 @@
+package reader
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+)
+
+const fixture = "../test/fixtures/elf_read_dwarf"
+
+func openFixture(t *testing.T) *godwarf.File {
+	f, err := godwarf.Open(fixture)
+	require.Nil(t, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestIndex(t *testing.T) {
+	f := openFixture(t)
+	data, err := f.DWARF()
+	require.Nil(t, err)
+
+	// the fixture has .debug_pubnames and .debug_pubtypes
+	withTables, err := NewIndexFromFile(f, "")
+	require.Nil(t, err)
+	scanned, err := NewIndex(data, IndexSections{})
+	require.Nil(t, err)
+
+	for _, idx := range []*Index{withTables, scanned} {
+		fn, err := idx.Function("main.main")
+		require.Nil(t, err)
+		assert.Equal(t, dwarf.TagSubprogram, fn.Tag)
+		lowpc := fn.Val(dwarf.AttrLowpc).(uint64)
+
+		got, err := idx.PCToFunc(lowpc + 1)
+		require.Nil(t, err)
+		assert.Equal(t, fn.Offset, got.Offset)
+		cu, err := idx.PCToUnit(lowpc)
+		require.Nil(t, err)
+		assert.Equal(t, "main", cu.Val(dwarf.AttrName))
+		_, err = idx.PCToFunc(0)
+		assert.NotNil(t, err)
+
+		typ, err := idx.Type("main.Student")
+		require.Nil(t, err)
+		assert.Equal(t, dwarf.TagStructType, typ.Tag)
+		_, err = idx.Type("main.NoSuchType")
+		assert.Equal(t, ErrTypeNotFound, err)
+
+		r := New(data)
+		_, err = idx.SeekToTypeNamed(r, "main.Student")
+		require.Nil(t, err)
+		var members []string
+		for m, _ := r.NextMemberVariable(); m != nil; m, _ = r.NextMemberVariable() {
+			members = append(members, m.Val(dwarf.AttrName).(string))
+		}
+		assert.Equal(t, []string{"Name", "Age"}, members)
+
+		want, err := New(data).AddrFor("runtime.buildVersion", 0, 8)
+		require.Nil(t, err)
+		addr, err := idx.AddrFor("runtime.buildVersion", 0, 8)
+		require.Nil(t, err)
+		assert.Equal(t, want, addr)
+	}
+
+	// both ways find the same package level names
+	for name, off := range scanned.Functions {
+		if got, ok := withTables.Functions[name]; ok {
+			assert.Equal(t, off, got, name)
+		}
+	}
+	assert.Equal(t, scanned.Funcs, withTables.Funcs)
+	assert.Equal(t, scanned.Units, withTables.Units)
+}
+
+func TestIndexNames(t *testing.T) {
+	f := openFixture(t)
+	data, err := f.DWARF()
+	require.Nil(t, err)
+	scanned, err := NewIndex(data, IndexSections{})
+	require.Nil(t, err)
+
+	fn, err := scanned.Function("main.main")
+	require.Nil(t, err)
+	cu, err := scanned.PCToUnit(fn.Val(dwarf.AttrLowpc).(uint64))
+	require.Nil(t, err)
+	typ := scanned.Types["main.Student"]
+
+	// a name index of one compile unit, with no hash table
+	str := []byte("\x00main.main\x00main.Student\x00")
+	var abbrevs, pool, body bytes.Buffer
+	abbrevs.Write([]byte{
+		1, byte(dwarf.TagSubprogram), idxDieOffset, 0x06, 0, 0, // ref4
+		2, byte(dwarf.TagStructType), idxCompileUnit, 0x0b, idxDieOffset, 0x06, 0, 0,
+		0,
+	})
+	u32 := func(b *bytes.Buffer, v uint32) { binary.Write(b, binary.LittleEndian, v) }
+	pool.WriteByte(1)
+	u32(&pool, uint32(fn.Offset-cu.Offset))
+	pool.WriteByte(0)
+	structEntry := pool.Len()
+	pool.Write([]byte{2, 0})
+	u32(&pool, uint32(typ-cu.Offset))
+	pool.WriteByte(0)
+
+	body.Write([]byte{5, 0, 0, 0}) // version, padding
+	// units, local and foreign type units, buckets, names, abbrevs size
+	// and augmentation size
+	for _, v := range []uint32{1, 0, 0, 0, 2, uint32(abbrevs.Len()), 0} {
+		u32(&body, v)
+	}
+	u32(&body, uint32(cu.Offset))
+	// offsets of the names in str and of their entries in pool
+	u32(&body, 1)
+	u32(&body, 11)
+	u32(&body, 0)
+	u32(&body, uint32(structEntry))
+	body.Write(abbrevs.Bytes())
+	body.Write(pool.Bytes())
+
+	var names bytes.Buffer
+	u32(&names, uint32(body.Len()))
+	names.Write(body.Bytes())
+
+	idx, err := NewIndex(data, IndexSections{Names: names.Bytes(), Str: str})
+	require.Nil(t, err)
+	// types and variables come only from the table
+	assert.Equal(t, map[string]dwarf.Offset{"main.Student": typ}, idx.Types)
+	assert.Empty(t, idx.Variables)
+	assert.Equal(t, scanned.Functions, idx.Functions)
+	assert.Equal(t, scanned.Funcs, idx.Funcs)
+
+	_, err = NewIndex(data, IndexSections{Names: names.Bytes()[:20], Str: str})
+	assert.NotNil(t, err)
+}
+
+func TestIndexCache(t *testing.T) {
+	f := openFixture(t)
+	data, err := f.DWARF()
+	require.Nil(t, err)
+	idx, err := NewIndex(data, IndexSections{})
+	require.Nil(t, err)
+
+	dir := t.TempDir()
+	assert.NotNil(t, idx.Save(dir), "index without build ID saved")
+	idx.BuildID = "0123456789abcdef"
+	require.Nil(t, idx.Save(dir))
+
+	loaded, err := LoadIndex(dir, idx.BuildID, data)
+	require.Nil(t, err)
+	assert.Equal(t, idx.Functions, loaded.Functions)
+	assert.Equal(t, idx.Types, loaded.Types)
+	assert.Equal(t, idx.Variables, loaded.Variables)
+	assert.Equal(t, idx.Funcs, loaded.Funcs)
+	fn, err := loaded.Function("main.main")
+	require.Nil(t, err)
+	assert.Equal(t, "main.main", fn.Val(dwarf.AttrName))
+
+	_, err = LoadIndex(dir, "fedcba9876543210", data)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,56 @@
+package stack
+
+import (
+	"encoding/binary"
+
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+	"github.com/hitzhangjie/codemaster/dwarf/regnum"
+)
+
+// Arch describes the registers of an architecture used when unwinding.
+type Arch struct {
+	Name      string
+	PtrSize   int
+	ByteOrder binary.ByteOrder
+
+	// DWARF register numbers
+	PCRegNum uint64
+	SPRegNum uint64
+	BPRegNum uint64
+	LRRegNum uint64
+}
+
+// AMD64 is the x86-64 architecture.
+var AMD64 = &Arch{
+	Name:      "amd64",
+	PtrSize:   8,
+	ByteOrder: binary.LittleEndian,
+	PCRegNum:  regnum.AMD64_Rip,
+	SPRegNum:  regnum.AMD64_Rsp,
+	BPRegNum:  regnum.AMD64_Rbp,
+}
+
+// ARM64 is the 64-bit ARM architecture.
+var ARM64 = &Arch{
+	Name:      "arm64",
+	PtrSize:   8,
+	ByteOrder: binary.LittleEndian,
+	PCRegNum:  regnum.ARM64_PC,
+	SPRegNum:  regnum.ARM64_SP,
+	BPRegNum:  regnum.ARM64_BP,
+	LRRegNum:  regnum.ARM64_LR,
+}
+
+// Registers returns a register set holding pc, sp and bp, more registers
+// can be added with AddReg.
+func (a *Arch) Registers(staticBase, pc, sp, bp uint64) *op.DwarfRegisters {
+	regs := a.newRegisters(staticBase)
+	regs.AddReg(a.PCRegNum, op.DwarfRegisterFromUint64(pc))
+	regs.AddReg(a.SPRegNum, op.DwarfRegisterFromUint64(sp))
+	regs.AddReg(a.BPRegNum, op.DwarfRegisterFromUint64(bp))
+	return regs
+}
+
+func (a *Arch) newRegisters(staticBase uint64) *op.DwarfRegisters {
+	return op.NewDwarfRegisters(staticBase, nil, a.ByteOrder, a.PCRegNum, a.SPRegNum, a.BPRegNum, a.LRRegNum)
+}
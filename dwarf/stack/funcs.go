@@ -0,0 +1,165 @@
+package stack
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"sort"
+
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+	"github.com/hitzhangjie/codemaster/dwarf/line"
+	"github.com/hitzhangjie/codemaster/dwarf/reader"
+)
+
+// Function is a function of the binary.
+type Function struct {
+	Name   string
+	Entry  uint64 // first PC
+	End    uint64 // PC after the last instruction
+	Offset dwarf.Offset
+
+	cu *compileUnit
+}
+
+// LineInfo returns the line table of the compile unit of fn.
+func (fn *Function) LineInfo() *line.DebugLineInfo {
+	return fn.cu.lines
+}
+
+type compileUnit struct {
+	lines *line.DebugLineInfo
+}
+
+// fileName returns the name of the file at idx in the line table, as used
+// by DW_AT_call_file.
+func (cu *compileUnit) fileName(idx int64) string {
+	if cu.lines == nil {
+		return ""
+	}
+	if cu.lines.Prologue.Version < 5 {
+		// file indexes start at 1 before DWARF 5
+		idx--
+	}
+	if idx < 0 || idx >= int64(len(cu.lines.FileNames)) {
+		return ""
+	}
+	return cu.lines.FileNames[idx].Path
+}
+
+// loadFunctions indexes the subprograms of every compile unit, sorted by
+// entry point.
+func (u *Unwinder) loadFunctions(debugLine, debugLineStr []byte) error {
+	rdr := u.dwarf.Reader()
+	var cu *compileUnit
+	for {
+		e, err := rdr.Next()
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			break
+		}
+
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			cu = &compileUnit{}
+			if off, ok := e.Val(dwarf.AttrStmtList).(int64); ok && off < int64(len(debugLine)) {
+				compdir, _ := e.Val(dwarf.AttrCompDir).(string)
+				buf := bytes.NewBuffer(debugLine[off:])
+				cu.lines = line.Parse(compdir, buf, debugLineStr, nil, u.StaticBase, false, u.Arch.PtrSize)
+			}
+
+		case dwarf.TagSubprogram:
+			rdr.SkipChildren()
+			rngs, err := u.dwarf.Ranges(e)
+			if err != nil || len(rngs) == 0 {
+				// declarations and abstract origins of inlined functions
+				continue
+			}
+			name, _ := e.Val(dwarf.AttrName).(string)
+			for _, rng := range rngs {
+				u.funcs = append(u.funcs, &Function{
+					Name:   name,
+					Entry:  rng[0] + u.StaticBase,
+					End:    rng[1] + u.StaticBase,
+					Offset: e.Offset,
+					cu:     cu,
+				})
+			}
+
+		default:
+			rdr.SkipChildren()
+		}
+	}
+
+	sort.Slice(u.funcs, func(i, j int) bool { return u.funcs[i].Entry < u.funcs[j].Entry })
+	return nil
+}
+
+// Functions returns all the functions of the binary sorted by entry point.
+func (u *Unwinder) Functions() []*Function {
+	return u.funcs
+}
+
+// PCToFunc returns the function containing pc, nil if none does.
+func (u *Unwinder) PCToFunc(pc uint64) *Function {
+	i := sort.Search(len(u.funcs), func(i int) bool { return u.funcs[i].End > pc })
+	if i == len(u.funcs) || u.funcs[i].Entry > pc {
+		return nil
+	}
+	return u.funcs[i]
+}
+
+// PCToLine returns the file and line of pc.
+func (u *Unwinder) PCToLine(pc uint64) (string, int, *Function) {
+	fn := u.PCToFunc(pc)
+	if fn == nil {
+		return "", 0, nil
+	}
+	file, ln := fn.cu.lines.PCToLine(fn.Entry, pc)
+	return file, ln, fn
+}
+
+// inlineStack returns the calls inlined at pc in fn, innermost first
+func (u *Unwinder) inlineStack(fn *Function, pc uint64) []*godwarf.Tree {
+	tree, ok := u.trees[fn.Offset]
+	if !ok {
+		var err error
+		if tree, err = godwarf.LoadTree(fn.Offset, u.dwarf, u.StaticBase); err != nil {
+			tree = nil
+		}
+		u.trees[fn.Offset] = tree
+	}
+	if tree == nil {
+		return nil
+	}
+	return reader.InlineStack(tree, pc)
+}
+
+// expand returns the frames of the physical frame f: one per call inlined
+// at pc, innermost first, followed by f itself.
+func (u *Unwinder) expand(f Frame, pc uint64) []Frame {
+	file, ln, fn := u.PCToLine(pc)
+	if fn == nil {
+		return []Frame{f}
+	}
+	f.Function, f.Entry = fn.Name, fn.Entry
+	f.File, f.Line = file, ln
+
+	var frames []Frame
+	for _, call := range u.inlineStack(fn, pc) {
+		inl := f
+		inl.Inlined = true
+		inl.Function, _ = call.Val(dwarf.AttrName).(string)
+		inl.Entry = 0
+		if len(call.Ranges) > 0 {
+			inl.Entry = call.Ranges[0][0]
+		}
+		frames = append(frames, inl)
+
+		// the caller is at the call site of the inlined call
+		callFile, _ := call.Val(dwarf.AttrCallFile).(int64)
+		callLine, _ := call.Val(dwarf.AttrCallLine).(int64)
+		f.File, f.Line = fn.cu.fileName(callFile), int(callLine)
+	}
+	return append(frames, f)
+}
@@ -0,0 +1,332 @@
+// Package stack unwinds call stacks using the CFA rules of .debug_frame
+// (or .eh_frame), falling back to frame pointers for code without them.
+//
+// Frames are resolved to function, file and line with .debug_line, calls
+// inlined by the compiler are expanded into frames of their own.
+package stack
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+
+	"github.com/hitzhangjie/codemaster/dwarf/frame"
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+)
+
+// Sections holds the raw contents of the sections used by the Unwinder,
+// either Frame or EHFrame is needed.
+type Sections struct {
+	Frame       []byte // .debug_frame
+	EHFrame     []byte // .eh_frame
+	EHFrameAddr uint64 // address .eh_frame is mapped at
+	Line        []byte // .debug_line
+	LineStr     []byte // .debug_line_str, DWARF 5 only
+}
+
+// Unwinder unwinds the stacks of a binary, it's not safe for concurrent use.
+type Unwinder struct {
+	Arch       *Arch
+	StaticBase uint64
+
+	dwarf *dwarf.Data
+	fdes  frame.FrameDescriptionEntries
+	funcs []*Function
+	trees map[dwarf.Offset]*godwarf.Tree
+}
+
+// Frame is a frame of a call stack.
+type Frame struct {
+	// PC is the current pc for the topmost frame, the return address
+	// for the others.
+	PC uint64
+
+	// Regs are the registers of the frame, Regs.CFA is set if the frame
+	// could be unwound. Inlined frames share the registers of the frame
+	// they're inlined into.
+	Regs op.DwarfRegisters
+
+	Function string
+	Entry    uint64 // entry point of Function, 0 if unknown
+	File     string
+	Line     int
+
+	// Inlined is set for calls inlined into the next frame
+	Inlined bool
+
+	// FramePointer is set if no FDE covers PC and the frame was unwound
+	// by following frame pointers.
+	FramePointer bool
+}
+
+// New returns an Unwinder for a binary loaded at staticBase.
+func New(dw *dwarf.Data, sections Sections, arch *Arch, staticBase uint64) (*Unwinder, error) {
+	u := &Unwinder{
+		Arch:       arch,
+		StaticBase: staticBase,
+		dwarf:      dw,
+		trees:      map[dwarf.Offset]*godwarf.Tree{},
+	}
+
+	var err error
+	switch {
+	case sections.Frame != nil:
+		u.fdes, err = frame.Parse(sections.Frame, arch.ByteOrder, staticBase, arch.PtrSize, 0)
+	case sections.EHFrame != nil:
+		u.fdes, err = frame.Parse(sections.EHFrame, arch.ByteOrder, staticBase, arch.PtrSize, sections.EHFrameAddr+staticBase)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.loadFunctions(sections.Line, sections.LineStr); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// NewFromELF returns an Unwinder for the ELF binary f loaded at staticBase.
+func NewFromELF(f *elf.File, staticBase uint64) (*Unwinder, error) {
+	return NewFromFile(&godwarf.File{File: f}, staticBase)
+}
+
+// NewFromFile is like NewFromELF, the debug sections of f may be in its
+// separate debug file.
+func NewFromFile(f *godwarf.File, staticBase uint64) (*Unwinder, error) {
+	var arch *Arch
+	switch f.Machine {
+	case elf.EM_X86_64:
+		arch = AMD64
+	case elf.EM_AARCH64:
+		arch = ARM64
+	default:
+		return nil, fmt.Errorf("unsupported architecture %s", f.Machine)
+	}
+
+	dw, err := f.DWARF()
+	if err != nil {
+		return nil, err
+	}
+
+	var sections Sections
+	sections.Frame, _ = godwarf.GetDebugSection(f, "frame")
+	if sections.Frame == nil {
+		if sec := f.Section(".eh_frame"); sec != nil {
+			if sections.EHFrame, err = sec.Data(); err != nil {
+				return nil, err
+			}
+			sections.EHFrameAddr = sec.Addr
+		}
+	}
+	if sections.Line, err = godwarf.GetDebugSection(f, "line"); err != nil {
+		return nil, err
+	}
+	sections.LineStr, _ = godwarf.GetDebugSection(f, "line_str")
+
+	return New(dw, sections, arch, staticBase)
+}
+
+// Functions at which unwinding stops, there's nothing meaningful past them.
+var stackTops = map[string]bool{
+	"runtime.goexit": true,
+	"runtime.rt0_go": true,
+	"runtime.mstart": true,
+	"runtime.mcall":  true,
+}
+
+// Stack unwinds the stack starting at regs, up to depth frames. The frames
+// unwound so far are returned if an error stops the unwinding.
+func (u *Unwinder) Stack(regs *op.DwarfRegisters, readMemory op.ReadMemoryFunc, depth int) ([]Frame, error) {
+	var frames []Frame
+	for top := true; len(frames) < depth; top = false {
+		pc := regs.PC()
+		if pc == 0 {
+			break
+		}
+
+		// the return address is after the call instruction, which may be
+		// the start of the next line or even of the next function
+		lookup := pc
+		if !top {
+			lookup--
+		}
+		fn := u.PCToFunc(lookup)
+		if fn != nil && stackTops[fn.Name] {
+			frames = append(frames, u.expand(Frame{PC: pc, Regs: *regs}, lookup)...)
+			break
+		}
+
+		caller, fp, err := u.unwind(regs, lookup, readMemory)
+		f := Frame{PC: pc, Regs: *regs, FramePointer: fp}
+		frames = append(frames, u.expand(f, lookup)...)
+		if err != nil {
+			return trim(frames, depth), err
+		}
+		if caller == nil {
+			break
+		}
+		regs = caller
+	}
+	return trim(frames, depth), nil
+}
+
+func trim(frames []Frame, depth int) []Frame {
+	if len(frames) > depth {
+		return frames[:depth]
+	}
+	return frames
+}
+
+// unwind computes the CFA of the frame of regs and returns the registers of
+// its caller, nil at the outermost frame. The rules are looked up at pc.
+func (u *Unwinder) unwind(regs *op.DwarfRegisters, pc uint64, readMemory op.ReadMemoryFunc) (*op.DwarfRegisters, bool, error) {
+	fctxt, fp := u.frameContext(pc)
+	if fp && regs.BP() == 0 {
+		// the outermost frame has no frame pointer
+		return nil, fp, nil
+	}
+
+	cfa, err := u.cfa(fctxt.CFA, regs, readMemory)
+	if err != nil {
+		return nil, fp, err
+	}
+	if cfa == 0 {
+		return nil, fp, nil
+	}
+	if cfa <= regs.SP() {
+		return nil, fp, fmt.Errorf("stack not growing at pc %#x: cfa %#x, sp %#x", regs.PC(), cfa, regs.SP())
+	}
+	regs.CFA = int64(cfa)
+
+	caller := u.Arch.newRegisters(u.StaticBase)
+	for num, rule := range fctxt.Regs {
+		reg, err := u.register(num, rule, regs, cfa, readMemory)
+		if err != nil {
+			return nil, fp, err
+		}
+		if reg != nil {
+			caller.AddReg(num, reg)
+		}
+	}
+	caller.AddReg(u.Arch.SPRegNum, op.DwarfRegisterFromUint64(cfa))
+
+	ret := caller.Reg(fctxt.RetAddrReg)
+	if ret == nil {
+		// the return address is undefined in the outermost frame
+		return nil, fp, nil
+	}
+	caller.AddReg(u.Arch.PCRegNum, op.DwarfRegisterFromUint64(ret.Uint64Val))
+	return caller, fp, nil
+}
+
+// frameContext returns the unwinding rules at pc, the returned bool is
+// true if they are derived from frame pointers because no FDE covers pc.
+func (u *Unwinder) frameContext(pc uint64) (*frame.FrameContext, bool) {
+	ptrSize := int64(u.Arch.PtrSize)
+
+	fde, err := u.fdes.FDEForPC(pc)
+	if err != nil {
+		// the frame pointer points to the saved frame pointer of the
+		// caller followed by the return address, on both amd64 and arm64
+		return &frame.FrameContext{
+			CFA: frame.DWRule{Rule: frame.RuleCFA, Reg: u.Arch.BPRegNum, Offset: 2 * ptrSize},
+			Regs: map[uint64]frame.DWRule{
+				u.Arch.BPRegNum: {Rule: frame.RuleOffset, Offset: -2 * ptrSize},
+				u.Arch.PCRegNum: {Rule: frame.RuleOffset, Offset: -ptrSize},
+			},
+			RetAddrReg: u.Arch.PCRegNum,
+		}, true
+	}
+
+	fctxt := fde.EstablishFrame(pc)
+	if _, ok := fctxt.Regs[u.Arch.BPRegNum]; !ok && u.Arch == AMD64 {
+		// Go doesn't describe where the frame pointer is saved: it's at
+		// the address the frame pointer points to once the function
+		// pushed it, which is below the CFA.
+		fctxt.Regs[u.Arch.BPRegNum] = frame.DWRule{Rule: frame.RuleFramePointer, Reg: u.Arch.BPRegNum}
+	}
+	return fctxt, false
+}
+
+// cfa evaluates the CFA rule
+func (u *Unwinder) cfa(rule frame.DWRule, regs *op.DwarfRegisters, readMemory op.ReadMemoryFunc) (uint64, error) {
+	switch rule.Rule {
+	case frame.RuleCFA:
+		reg := regs.Reg(rule.Reg)
+		if reg == nil {
+			return 0, fmt.Errorf("register %d not available to compute the cfa", rule.Reg)
+		}
+		return uint64(int64(reg.Uint64Val) + rule.Offset), nil
+	case frame.RuleExpression:
+		v, _, err := op.ExecuteStackProgram(*regs, rule.Expression, u.Arch.PtrSize, readMemory)
+		return uint64(v), err
+	default:
+		return 0, fmt.Errorf("unsupported cfa rule %d", rule.Rule)
+	}
+}
+
+// register returns the value of register num in the caller, nil if it's
+// undefined.
+func (u *Unwinder) register(num uint64, rule frame.DWRule, regs *op.DwarfRegisters, cfa uint64, readMemory op.ReadMemoryFunc) (*op.DwarfRegister, error) {
+	switch rule.Rule {
+	case frame.RuleUndefined:
+		return nil, nil
+	case frame.RuleSameVal:
+		return copyReg(regs.Reg(num)), nil
+	case frame.RuleOffset:
+		return u.readRegister(uint64(int64(cfa)+rule.Offset), readMemory)
+	case frame.RuleValOffset:
+		return op.DwarfRegisterFromUint64(uint64(int64(cfa) + rule.Offset)), nil
+	case frame.RuleRegister:
+		return copyReg(regs.Reg(rule.Reg)), nil
+	case frame.RuleExpression, frame.RuleValExpression:
+		r := *regs
+		r.CFA = int64(cfa)
+		v, _, err := op.ExecuteStackProgram(r, rule.Expression, u.Arch.PtrSize, readMemory)
+		if err != nil {
+			return nil, err
+		}
+		if rule.Rule == frame.RuleValExpression {
+			return op.DwarfRegisterFromUint64(uint64(v)), nil
+		}
+		return u.readRegister(uint64(v), readMemory)
+	case frame.RuleCFA:
+		reg := regs.Reg(rule.Reg)
+		if reg == nil {
+			return nil, nil
+		}
+		return op.DwarfRegisterFromUint64(uint64(int64(reg.Uint64Val) + rule.Offset)), nil
+	case frame.RuleFramePointer:
+		reg := regs.Reg(rule.Reg)
+		if reg == nil {
+			return nil, nil
+		}
+		if reg.Uint64Val != 0 && reg.Uint64Val+uint64(rule.Offset) < cfa {
+			return u.readRegister(reg.Uint64Val+uint64(rule.Offset), readMemory)
+		}
+		return copyReg(reg), nil
+	default:
+		return nil, errors.New("unsupported register rule")
+	}
+}
+
+func (u *Unwinder) readRegister(addr uint64, readMemory op.ReadMemoryFunc) (*op.DwarfRegister, error) {
+	buf := make([]byte, u.Arch.PtrSize)
+	if _, err := readMemory(buf, addr); err != nil {
+		return nil, fmt.Errorf("could not read register at %#x: %v", addr, err)
+	}
+	if u.Arch.PtrSize == 4 {
+		return op.DwarfRegisterFromUint64(uint64(u.Arch.ByteOrder.Uint32(buf))), nil
+	}
+	return op.DwarfRegisterFromUint64(u.Arch.ByteOrder.Uint64(buf)), nil
+}
+
+func copyReg(reg *op.DwarfRegister) *op.DwarfRegister {
+	if reg == nil {
+		return nil
+	}
+	r := *reg
+	return &r
+}
@@ -0,0 +1,150 @@
+package stack
+
+import (
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hitzhangjie/codemaster/dwarf/op"
+)
+
+// traceCallstack builds and starts testdata/callstack under ptrace, and
+// returns its registers and memory once it hits runtime.Breakpoint.
+func traceCallstack(t *testing.T) (string, *op.DwarfRegisters, op.ReadMemoryFunc) {
+	// ptrace requests must come from the thread that started the tracee,
+	// the thread is discarded when the test ends
+	runtime.LockOSThread()
+
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not found")
+	}
+
+	exe := filepath.Join(t.TempDir(), "callstack")
+	build := exec.Command(gobin, "build", "-gcflags=-l=4", "-o", exe, "./testdata/callstack")
+	out, err := build.CombinedOutput()
+	require.Nil(t, err, string(out))
+
+	cmd := exec.Command(exe)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("can't trace process: %v", err)
+	}
+	pid := cmd.Process.Pid
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	// stopped at exec, then continue until the breakpoint
+	var ws syscall.WaitStatus
+	_, err = syscall.Wait4(pid, &ws, 0, nil)
+	require.Nil(t, err)
+	for {
+		require.Nil(t, syscall.PtraceCont(pid, 0))
+		_, err = syscall.Wait4(pid, &ws, 0, nil)
+		require.Nil(t, err)
+		require.True(t, ws.Stopped(), "process exited: %v", ws)
+		if ws.StopSignal() == syscall.SIGTRAP {
+			break
+		}
+	}
+
+	var pregs syscall.PtraceRegs
+	require.Nil(t, syscall.PtraceGetRegs(pid, &pregs))
+
+	mem, err := os.Open("/proc/" + strconv.Itoa(pid) + "/mem")
+	require.Nil(t, err)
+	t.Cleanup(func() { mem.Close() })
+	readMemory := func(buf []byte, addr uint64) (int, error) {
+		return mem.ReadAt(buf, int64(addr))
+	}
+	return exe, AMD64.Registers(0, pregs.Rip, pregs.Rsp, pregs.Rbp), readMemory
+}
+
+func newUnwinder(t *testing.T, exe string) *Unwinder {
+	f, err := elf.Open(exe)
+	require.Nil(t, err)
+	defer f.Close()
+	if f.Type != elf.ET_EXEC {
+		t.Skip("position independent executable")
+	}
+	u, err := NewFromELF(f, 0)
+	require.Nil(t, err)
+	return u
+}
+
+func TestStack(t *testing.T) {
+	exe, regs, readMemory := traceCallstack(t)
+	u := newUnwinder(t, exe)
+
+	frames, err := u.Stack(regs, readMemory, 50)
+	require.Nil(t, err)
+	require.True(t, len(frames) >= 7, "%v", frames)
+
+	want := []struct {
+		fn      string
+		inlined bool
+	}{
+		{"runtime.breakpoint", false},
+		{"runtime.Breakpoint", true},
+		{"main.c", false},
+		{"main.inlined", true},
+		{"main.b", false},
+		{"main.a", false},
+		{"main.main", false},
+	}
+	for i, w := range want {
+		assert.Equal(t, w.fn, frames[i].Function, "frame %d", i)
+		assert.Equal(t, w.inlined, frames[i].Inlined, "frame %d", i)
+		assert.False(t, frames[i].FramePointer, "frame %d", i)
+	}
+
+	// inlined frames are at the call site in their caller
+	assert.Equal(t, "main.go", filepath.Base(frames[2].File))
+	assert.Equal(t, 12, frames[2].Line)
+	assert.Equal(t, 16, frames[3].Line)
+	assert.Equal(t, 21, frames[4].Line)
+	assert.Equal(t, 26, frames[5].Line)
+	assert.Equal(t, frames[3].PC, frames[4].PC)
+	assert.NotZero(t, frames[2].Regs.CFA)
+	assert.Equal(t, "runtime.goexit", frames[len(frames)-1].Function)
+}
+
+func TestStackFramePointer(t *testing.T) {
+	exe, regs, readMemory := traceCallstack(t)
+	u := newUnwinder(t, exe)
+	u.fdes = nil
+
+	frames, err := u.Stack(regs, readMemory, 50)
+	require.Nil(t, err)
+
+	// runtime.breakpoint doesn't set up a frame, so its caller is missed:
+	// check that the rest of the stack is found in order.
+	var fns []string
+	for i, f := range frames {
+		if i < len(frames)-1 {
+			// the outermost frame isn't unwound
+			assert.True(t, f.FramePointer, "frame %d", i)
+		}
+		fns = append(fns, f.Function)
+	}
+	assert.Subset(t, fns, []string{"main.a", "main.b", "main.main"})
+	idx := func(fn string) int {
+		for i, f := range fns {
+			if f == fn {
+				return i
+			}
+		}
+		return -1
+	}
+	assert.True(t, idx("main.b") < idx("main.a") && idx("main.a") < idx("main.main"), "%v", fns)
+}
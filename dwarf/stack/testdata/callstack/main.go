@@ -0,0 +1,31 @@
+package main
+
+import "runtime"
+
+func init() {
+	// keep the traced thread running main
+	runtime.LockOSThread()
+}
+
+//go:noinline
+func c() {
+	runtime.Breakpoint()
+}
+
+func inlined() {
+	c()
+}
+
+//go:noinline
+func b() {
+	inlined()
+}
+
+//go:noinline
+func a() {
+	b()
+}
+
+func main() {
+	a()
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hitzhangjie/codemaster/dwarf/godwarf"
+)
+
+// symbols returns the address ranges of the function symbols of f
+func symbols(t *testing.T, f *elf.File) map[string][2]uint64 {
+	syms, err := f.Symbols()
+	require.Nil(t, err)
+	m := map[string][2]uint64{}
+	for _, s := range syms {
+		if elf.ST_TYPE(s.Info) == elf.STT_FUNC {
+			m[s.Name] = [2]uint64{s.Value, s.Value + s.Size}
+		}
+	}
+	return m
+}
+
+// findEntry returns the first entry named name with tag in dw
+func findEntry(t *testing.T, dw *dwarf.Data, tag dwarf.Tag, name string) *dwarf.Entry {
+	r := dw.Reader()
+	for {
+		e, err := r.Next()
+		require.Nil(t, err)
+		require.NotNil(t, e, "%s not found", name)
+		if e.Tag == tag && e.Val(dwarf.AttrName) == name {
+			return e
+		}
+	}
+}
+
+func Test_DWARF5Rnglists(t *testing.T) {
+	f, err := godwarf.Open("fixtures/cgo_dwarf5")
+	require.Nil(t, err)
+	defer f.Close()
+	dw, err := f.DWARF()
+	require.Nil(t, err)
+
+	data, err := godwarf.GetDebugSection(f, "rnglists")
+	require.Nil(t, err)
+	rnglists := godwarf.ParseRnglists(data)
+	require.NotNil(t, rnglists)
+	addr, err := godwarf.GetDebugSection(f, "addr")
+	require.Nil(t, err)
+	debugAddr := godwarf.ParseAddr(addr)
+
+	// the range lists of Go and of gcc, with base address entries and
+	// offset pairs, read the same as debug/dwarf reads them
+	var cu *dwarf.Entry
+	n := 0
+	r := dw.Reader()
+	for {
+		e, err := r.Next()
+		require.Nil(t, err)
+		if e == nil {
+			break
+		}
+		if e.Tag == dwarf.TagCompileUnit {
+			cu = e
+		}
+		off, ok := e.Val(dwarf.AttrRanges).(int64)
+		if !ok {
+			continue
+		}
+		base, _ := cu.Val(dwarf.AttrLowpc).(uint64)
+		addrBase, _ := cu.Val(dwarf.AttrAddrBase).(int64)
+		got, err := rnglists.Ranges(uint64(off), base, debugAddr.GetSubsection(uint64(addrBase)))
+		require.Nil(t, err)
+		want, err := dw.Ranges(e)
+		require.Nil(t, err)
+		assert.Equal(t, want, got, "ranges of entry at %#x", e.Offset)
+		n++
+	}
+	assert.NotZero(t, n)
+
+	// scale has a hot and a cold part
+	syms := symbols(t, f.File)
+	scale := findEntry(t, dw, dwarf.TagSubprogram, "scale")
+	tree, err := godwarf.LoadTree(scale.Offset, dw, 0)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, [][2]uint64{syms["scale"], syms["scale.cold"]}, tree.Ranges)
+}
+
+func Test_SplitDWARF(t *testing.T) {
+	f, err := godwarf.Open("fixtures/split_dwarf5")
+	require.Nil(t, err)
+	defer f.Close()
+
+	units, err := godwarf.LoadSplitUnits(f)
+	require.Nil(t, err)
+	require.Len(t, units, 1)
+	u := units[0]
+	assert.Equal(t, "fixtures/split_dwarf5.dwo", u.Path)
+	assert.NotZero(t, u.ID)
+	assert.NotEmpty(t, u.Loclists())
+
+	// strings are read through .debug_str_offsets.dwo
+	cu, err := u.Data.Reader().Next()
+	require.Nil(t, err)
+	assert.Equal(t, "split_dwarf5.c", cu.Val(dwarf.AttrName))
+	assert.Contains(t, cu.Val(dwarf.AttrProducer), "GNU C")
+
+	// addresses are read from the .debug_addr of the binary
+	syms := symbols(t, f.File)
+	sum := findEntry(t, u.Data, dwarf.TagSubprogram, "sum")
+	assert.Equal(t, syms["sum"][0], sum.Val(dwarf.AttrLowpc))
+	ranges, err := u.Ranges(sum)
+	require.Nil(t, err)
+	assert.Equal(t, [][2]uint64{syms["sum"]}, ranges)
+
+	// range lists are read from .debug_rnglists.dwo
+	scale := findEntry(t, u.Data, dwarf.TagSubprogram, "scale")
+	tree, err := u.LoadTree(scale.Offset, 0)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, [][2]uint64{syms["scale"], syms["scale.cold"]}, tree.Ranges)
+	assert.True(t, tree.ContainsPC(syms["scale.cold"][0]))
+
+	cuRanges, err := u.Ranges(cu)
+	require.Nil(t, err)
+	for _, name := range []string{"main", "sum", "scale", "scale.cold"} {
+		assert.True(t, inRanges(cuRanges, syms[name][0]), name)
+	}
+}
+
+func inRanges(ranges [][2]uint64, pc uint64) bool {
+	for _, r := range ranges {
+		if r[0] <= pc && pc < r[1] {
+			return true
+		}
+	}
+	return false
+}
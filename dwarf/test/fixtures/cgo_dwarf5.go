@@ -0,0 +1,44 @@
+//go:build ignore
+// +build ignore
+
+// cgo_dwarf5 is a cgo program whose C code has DWARF 5 debug info, with
+// range lists for the cold part of scale.
+//
+//	CGO_CFLAGS="-gdwarf-5 -O2" go build -trimpath -o cgo_dwarf5 cgo_dwarf5.go
+package main
+
+/*
+#include <stdio.h>
+#include <stdlib.h>
+
+struct point {
+	int x, y;
+};
+
+static int counter;
+
+__attribute__((cold, noinline)) static void fail(const char *msg, int n) {
+	fprintf(stderr, "%s %d\n", msg, n);
+}
+
+__attribute__((noinline)) static int scale(int n) {
+	if (n < 0) {
+		fail("negative", n);
+		abort();
+	}
+	return n * 2;
+}
+
+__attribute__((noinline)) int sum(struct point *p) {
+	counter++;
+	return scale(p->x) + scale(p->y);
+}
+*/
+import "C"
+
+import "fmt"
+
+func main() {
+	p := C.struct_point{x: 1, y: 2}
+	fmt.Println(C.sum(&p))
+}
@@ -0,0 +1,35 @@
+// split_dwarf5 has its DWARF 5 debug info in split_dwarf5.dwo, with range
+// lists for the cold part of scale.
+//
+//	gcc -gdwarf-5 -gsplit-dwarf -O2 -fdebug-prefix-map=$PWD=. -o ../split_dwarf5 split_dwarf5.c
+#include <stdio.h>
+#include <stdlib.h>
+
+struct point {
+	int x, y;
+};
+
+static int counter;
+
+__attribute__((cold, noinline)) static void fail(const char *msg, int n) {
+	fprintf(stderr, "%s %d\n", msg, n);
+}
+
+__attribute__((noinline)) static int scale(int n) {
+	if (n < 0) {
+		fail("negative", n);
+		abort();
+	}
+	return n * 2;
+}
+
+__attribute__((noinline)) int sum(struct point *p) {
+	counter++;
+	return scale(p->x) + scale(p->y);
+}
+
+int main(int argc, char **argv) {
+	struct point p = {argc, 2};
+	printf("%d\n", sum(&p));
+	return 0;
+}
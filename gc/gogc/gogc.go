@@ -2,9 +2,12 @@ package main
 
 import (
 	"flag"
+	"log"
 	"net/http"
 	"runtime"
 	"time"
+
+	"github.com/hitzhangjie/codemaster/gc/tuner"
 )
 
 // 对下面几种Go GC设置进行分别测试，验证那种方案最合适
@@ -47,6 +50,7 @@ import (
 var (
 	enableBallast = flag.Bool("ballast", false, "enable ballast")
 	enableSchedGC = flag.Bool("schedgc", false, "enable GC every 10s")
+	enableTuner   = flag.Bool("tuner", false, "enable gc/tuner, GOMEMLIMIT and GOGC tuned by the cgroup limit, heap growth and GC CPU")
 )
 
 func init() {
@@ -60,6 +64,16 @@ func main() {
 		defer runtime.KeepAlive(&ballast)
 	}
 
+	if *enableTuner {
+		t := tuner.Start(tuner.Config{
+			OnChange: func(c tuner.Change) {
+				log.Printf("tuner: %s: gogc %d->%d memlimit %d->%d heap %d projected %d gccpu %.3f",
+					c.Reason, c.OldGOGC, c.GOGC, c.OldMemoryLimit, c.MemoryLimit, c.HeapLive, c.Projected, c.GCCPUFraction)
+			},
+		})
+		defer t.Stop()
+	}
+
 	if *enableSchedGC {
 		go func() {
 			for {
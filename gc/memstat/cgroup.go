@@ -0,0 +1,318 @@
+package memstat
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// unlimited is the smallest limit of cgroup v1 meaning no limit, the
+// largest multiple of the page size of an int64.
+const unlimited = 1<<63 - 1<<16
+
+// Cgroup is the memory cgroup of the process, the sizes are in bytes.
+type Cgroup struct {
+	// Version is 1 or 2.
+	Version int
+	// Dir is the directory of the cgroup.
+	Dir string
+
+	// Limit is the limit of the cgroup or of its ancestors, the process is
+	// killed if the usage reaches it, 0 means no limit.
+	Limit uint64
+	// High is the throttling limit of v2, memory.high, or the soft limit of
+	// v1, 0 means no limit.
+	High uint64
+
+	// Usage is the memory charged to the cgroup, including the page cache.
+	Usage uint64
+	// WorkingSet is Usage less the inactive page cache, which is reclaimed
+	// before an OOM, what kubelet compares to the limit.
+	WorkingSet uint64
+	// Peak is the maximum of Usage, 0 if unknown.
+	Peak uint64
+	// Anon is the anonymous memory and File the page cache.
+	Anon uint64
+	File uint64
+	// Swap is the swap used, 0 if unknown.
+	Swap uint64
+	// OOMKills is the number of processes killed by the OOM killer in the
+	// cgroup.
+	OOMKills uint64
+}
+
+// ReadCgroup reads the memory cgroup of the current process, nil if it
+// isn't in one.
+func ReadCgroup() (*Cgroup, error) {
+	return readCgroup("/proc/self", "")
+}
+
+// readCgroup reads the memory cgroup of the process with the proc directory
+// dir, with the mount points under root.
+func readCgroup(dir, root string) (*Cgroup, error) {
+	v1, v2, err := cgroupPaths(dir + "/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := cgroupMounts(dir + "/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	// in the hybrid hierarchy of systemd, memory is a v1 controller and
+	// the v2 hierarchy has none
+	if m, ok := mounts["memory"]; ok && v1 != "" {
+		if d := m.dir(root, v1); exists(filepath.Join(d, "memory.usage_in_bytes")) {
+			return readCgroupV1(d)
+		}
+	}
+	if m, ok := mounts[""]; ok && v2 != "" {
+		if d := m.dir(root, v2); exists(filepath.Join(d, "memory.current")) {
+			return readCgroupV2(d, filepath.Join(root, m.point))
+		}
+	}
+	return nil, nil
+}
+
+// cgroupPaths returns the paths of the v1 memory cgroup and of the v2
+// cgroup in /proc/self/cgroup.
+func cgroupPaths(path string) (v1, v2 string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(sc.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			v2 = fields[2]
+			continue
+		}
+		for _, c := range strings.Split(fields[1], ",") {
+			if c == "memory" {
+				v1 = fields[2]
+			}
+		}
+	}
+	return v1, v2, sc.Err()
+}
+
+// mount is the mount of a cgroup hierarchy.
+type mount struct {
+	// root is the cgroup mounted, such as the cgroup of a container, and
+	// point where.
+	root, point string
+}
+
+// dir returns the directory of the cgroup at path, the mount point if
+// path is out of the cgroup mounted, as in a cgroup namespace.
+func (m mount) dir(root, path string) string {
+	rel, err := filepath.Rel(m.root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = ""
+	}
+	d := filepath.Join(root, m.point, rel)
+	if !exists(d) {
+		return filepath.Join(root, m.point)
+	}
+	return d
+}
+
+// cgroupMounts returns the mounts of the v1 memory hierarchy, "memory",
+// and of the v2 hierarchy, "", in /proc/self/mountinfo.
+func cgroupMounts(path string) (map[string]mount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mounts := map[string]mount{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		// id parent major:minor root point options [optional...] - type source superoptions
+		pre, post, ok := strings.Cut(sc.Text(), " - ")
+		if !ok {
+			continue
+		}
+		fields, super := strings.Fields(pre), strings.Fields(post)
+		if len(fields) < 5 || len(super) < 3 {
+			continue
+		}
+		m := mount{root: unescape(fields[3]), point: unescape(fields[4])}
+		switch super[0] {
+		case "cgroup2":
+			if _, ok := mounts[""]; !ok {
+				mounts[""] = m
+			}
+		case "cgroup":
+			for _, opt := range strings.Split(super[2], ",") {
+				if _, ok := mounts["memory"]; opt == "memory" && !ok {
+					mounts["memory"] = m
+				}
+			}
+		}
+	}
+	return mounts, sc.Err()
+}
+
+// unescape unescapes the octal escapes of the spaces, tabs, newlines and
+// backslashes of mountinfo.
+func unescape(s string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(s)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func readCgroupV1(dir string) (*Cgroup, error) {
+	c := &Cgroup{Version: 1, Dir: dir}
+	var err error
+	if c.Usage, err = readUint(dir, "memory.usage_in_bytes"); err != nil {
+		return nil, err
+	}
+	if c.Limit, err = readLimit(dir, "memory.limit_in_bytes"); err != nil {
+		return nil, err
+	}
+	if c.High, err = readLimit(dir, "memory.soft_limit_in_bytes"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if c.Peak, err = readUint(dir, "memory.max_usage_in_bytes"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	// memsw is the memory and the swap, if swap accounting is on
+	if memsw, err := readUint(dir, "memory.memsw.usage_in_bytes"); err == nil && memsw > c.Usage {
+		c.Swap = memsw - c.Usage
+	}
+	stat, err := readStat(dir, "memory.stat")
+	if err != nil {
+		return nil, err
+	}
+	// the limit of the ancestors if lower
+	if l := stat["hierarchical_memory_limit"]; l < unlimited && (c.Limit == 0 || l < c.Limit) {
+		c.Limit = l
+	}
+	c.Anon = stat["total_rss"]
+	c.File = stat["total_cache"]
+	c.WorkingSet = sub(c.Usage, stat["total_inactive_file"])
+	if oom, err := readStat(dir, "memory.oom_control"); err == nil {
+		c.OOMKills = oom["oom_kill"]
+	}
+	return c, nil
+}
+
+// readCgroupV2 reads the cgroup at dir, in the hierarchy mounted at top.
+func readCgroupV2(dir, top string) (*Cgroup, error) {
+	c := &Cgroup{Version: 2, Dir: dir}
+	var err error
+	if c.Usage, err = readUint(dir, "memory.current"); err != nil {
+		return nil, err
+	}
+	// the lowest limits of the cgroup and its ancestors, up to the root
+	// which has none
+	for d := dir; ; d = filepath.Dir(d) {
+		limit, err := readLimit(d, "memory.max")
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if limit != 0 && (c.Limit == 0 || limit < c.Limit) {
+			c.Limit = limit
+		}
+		if high, err := readLimit(d, "memory.high"); err == nil && high != 0 && (c.High == 0 || high < c.High) {
+			c.High = high
+		}
+		if d == top || d == filepath.Dir(d) {
+			break
+		}
+	}
+	if c.Peak, err = readUint(dir, "memory.peak"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if c.Swap, err = readUint(dir, "memory.swap.current"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	stat, err := readStat(dir, "memory.stat")
+	if err != nil {
+		return nil, err
+	}
+	c.Anon = stat["anon"]
+	c.File = stat["file"]
+	c.WorkingSet = sub(c.Usage, stat["inactive_file"])
+	if events, err := readStat(dir, "memory.events"); err == nil {
+		c.OOMKills = events["oom_kill"]
+	}
+	return c, nil
+}
+
+func sub(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
+
+func readUint(dir, name string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", filepath.Join(dir, name), err)
+	}
+	return v, nil
+}
+
+// readLimit reads a limit, 0 for "max" of v2 and the huge values of v1.
+func readLimit(dir, name string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", filepath.Join(dir, name), err)
+	}
+	if v >= unlimited {
+		v = 0
+	}
+	return v, nil
+}
+
+// readStat reads the "name value" lines of a file such as memory.stat.
+func readStat(dir, name string) (map[string]uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]uint64{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s: %v", filepath.Join(dir, name), fields[0], err)
+		}
+		m[fields[0]] = v
+	}
+	return m, sc.Err()
+}
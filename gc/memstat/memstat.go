@@ -0,0 +1,43 @@
+// Package memstat samples the memory of the current process: its resident
+// set from /proc/self, the limit and usage of its memory cgroup, v1 or v2,
+// and the heap and GC metrics of the Go runtime.
+//
+// Unlike rss.GetRSS, the errors are returned, and Sampler reads the stats
+// periodically for dashboards and GC tuning, such as the ballast and GOGC
+// experiments of the gc directory.
+package memstat
+
+import (
+	"time"
+)
+
+// Stats is a sample of the memory of the process.
+type Stats struct {
+	Time    time.Time
+	Process Process
+	// Cgroup is nil if the process isn't in a memory cgroup, such as
+	// outside of a container.
+	Cgroup  *Cgroup
+	Runtime Runtime
+}
+
+// Read reads the stats of the current process.
+func Read() (*Stats, error) {
+	return read("/proc", "")
+}
+
+// read reads the stats with the proc filesystem at proc and the mount
+// points of the cgroups under root.
+func read(proc, root string) (*Stats, error) {
+	s := &Stats{Time: time.Now()}
+	p, err := readProcess(proc + "/self")
+	if err != nil {
+		return nil, err
+	}
+	s.Process = *p
+	if s.Cgroup, err = readCgroup(proc+"/self", root); err != nil {
+		return nil, err
+	}
+	s.Runtime = ReadRuntime()
+	return s, nil
+}
@@ -0,0 +1,236 @@
+package memstat
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.Nil(t, os.WriteFile(path, []byte(data), 0644))
+	}
+}
+
+const smapsRollup = `5638177f1000-7ffc7dba1000 ---p 00000000 00:00 0                          [rollup]
+Rss:                1364 kB
+Pss:                 495 kB
+Pss_Anon:            104 kB
+Shared_Clean:       1184 kB
+Private_Clean:        76 kB
+Private_Dirty:       104 kB
+Anonymous:           104 kB
+Swap:                  8 kB
+`
+
+func TestReadProcess(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"statm": "1000 300 200 1 0 100 0\n"})
+	p, err := readProcess(dir)
+	require.Nil(t, err)
+	assert.Equal(t, Process{VSize: 1000 * pageSize, RSS: 300 * pageSize}, *p)
+
+	writeFiles(t, dir, map[string]string{"smaps_rollup": smapsRollup})
+	p, err = readProcess(dir)
+	require.Nil(t, err)
+	assert.Equal(t, Process{VSize: 1000 * pageSize, RSS: 1364 << 10, PSS: 495 << 10, USS: 180 << 10, Anon: 104 << 10, Swap: 8 << 10, Rollup: true}, *p)
+
+	writeFiles(t, dir, map[string]string{"statm": "1000\n"})
+	_, err = readProcess(dir)
+	assert.NotNil(t, err)
+
+	// the current process
+	p, err = ReadProcess()
+	require.Nil(t, err)
+	assert.NotZero(t, p.RSS)
+	assert.True(t, p.VSize >= p.RSS)
+	if p.Rollup {
+		assert.True(t, p.USS <= p.PSS && p.PSS <= p.RSS, "%+v", p)
+	}
+}
+
+func TestReadCgroupV1(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"proc/cgroup": "5:devices:/docker/abc\n4:memory:/docker/abc\n1:name=systemd:/docker/abc\n0::/docker/abc\n",
+		"proc/mountinfo": "36 32 0:32 / /sys/fs/cgroup/memory rw,relatime - cgroup cgroup rw,memory\n" +
+			"42 32 0:38 / /sys/fs/cgroup/unified rw,relatime - cgroup2 cgroup2 rw\n",
+		"sys/fs/cgroup/memory/docker/abc/memory.usage_in_bytes":       "1000000\n",
+		"sys/fs/cgroup/memory/docker/abc/memory.limit_in_bytes":       "9223372036854771712\n",
+		"sys/fs/cgroup/memory/docker/abc/memory.soft_limit_in_bytes":  "9223372036854771712\n",
+		"sys/fs/cgroup/memory/docker/abc/memory.max_usage_in_bytes":   "3000000\n",
+		"sys/fs/cgroup/memory/docker/abc/memory.memsw.usage_in_bytes": "1200000\n",
+		"sys/fs/cgroup/memory/docker/abc/memory.oom_control":          "oom_kill_disable 0\nunder_oom 0\noom_kill 2\n",
+		"sys/fs/cgroup/memory/docker/abc/memory.stat": "cache 100\nrss 200\n" +
+			"hierarchical_memory_limit 2000000\ntotal_cache 400000\ntotal_rss 500000\ntotal_inactive_file 300000\n",
+	})
+	c, err := readCgroup(filepath.Join(root, "proc"), root)
+	require.Nil(t, err)
+	assert.Equal(t, &Cgroup{
+		Version:    1,
+		Dir:        filepath.Join(root, "sys/fs/cgroup/memory/docker/abc"),
+		Limit:      2000000,
+		Usage:      1000000,
+		WorkingSet: 700000,
+		Peak:       3000000,
+		Anon:       500000,
+		File:       400000,
+		Swap:       200000,
+		OOMKills:   2,
+	}, c)
+
+	// in a cgroup namespace, the cgroup is mounted at the mount point
+	require.Nil(t, os.Rename(filepath.Join(root, "sys/fs/cgroup/memory/docker/abc"), filepath.Join(root, "ns")))
+	require.Nil(t, os.RemoveAll(filepath.Join(root, "sys/fs/cgroup/memory")))
+	require.Nil(t, os.Rename(filepath.Join(root, "ns"), filepath.Join(root, "sys/fs/cgroup/memory")))
+	c, err = readCgroup(filepath.Join(root, "proc"), root)
+	require.Nil(t, err)
+	assert.Equal(t, filepath.Join(root, "sys/fs/cgroup/memory"), c.Dir)
+	assert.Equal(t, uint64(1000000), c.Usage)
+
+	writeFiles(t, root, map[string]string{"sys/fs/cgroup/memory/memory.stat": "total_rss x\n"})
+	_, err = readCgroup(filepath.Join(root, "proc"), root)
+	assert.NotNil(t, err)
+}
+
+func TestReadCgroupV2(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"proc/cgroup":    "0::/kubepods/pod1/ctr\n",
+		"proc/mountinfo": "30 24 0:26 / /sys/fs/cgroup rw,nosuid - cgroup2 cgroup2 rw,nsdelegate\n",
+		// the root has no memory files
+		"sys/fs/cgroup/cgroup.controllers":                    "cpu memory\n",
+		"sys/fs/cgroup/kubepods/memory.max":                   "3000000\n",
+		"sys/fs/cgroup/kubepods/memory.high":                  "max\n",
+		"sys/fs/cgroup/kubepods/pod1/memory.max":              "2000000\n",
+		"sys/fs/cgroup/kubepods/pod1/memory.high":             "1500000\n",
+		"sys/fs/cgroup/kubepods/pod1/ctr/memory.max":          "max\n",
+		"sys/fs/cgroup/kubepods/pod1/ctr/memory.high":         "max\n",
+		"sys/fs/cgroup/kubepods/pod1/ctr/memory.current":      "1000000\n",
+		"sys/fs/cgroup/kubepods/pod1/ctr/memory.peak":         "1800000\n",
+		"sys/fs/cgroup/kubepods/pod1/ctr/memory.swap.current": "0\n",
+		"sys/fs/cgroup/kubepods/pod1/ctr/memory.events":       "low 0\nhigh 3\nmax 1\noom 1\noom_kill 1\n",
+		"sys/fs/cgroup/kubepods/pod1/ctr/memory.stat":         "anon 600000\nfile 350000\nkernel 50000\ninactive_file 250000\n",
+	})
+	c, err := readCgroup(filepath.Join(root, "proc"), root)
+	require.Nil(t, err)
+	assert.Equal(t, &Cgroup{
+		Version:    2,
+		Dir:        filepath.Join(root, "sys/fs/cgroup/kubepods/pod1/ctr"),
+		Limit:      2000000,
+		High:       1500000,
+		Usage:      1000000,
+		WorkingSet: 750000,
+		Peak:       1800000,
+		Anon:       600000,
+		File:       350000,
+		OOMKills:   1,
+	}, c)
+
+	// the root cgroup isn't a memory cgroup
+	writeFiles(t, root, map[string]string{"proc/cgroup": "0::/\n"})
+	c, err = readCgroup(filepath.Join(root, "proc"), root)
+	require.Nil(t, err)
+	assert.Nil(t, c)
+
+	// nor the v2 cgroup of the hybrid hierarchy without memory
+	writeFiles(t, root, map[string]string{
+		"proc/cgroup":    "4:memory:/a\n0::/kubepods/pod1/ctr\n",
+		"proc/mountinfo": "30 24 0:26 / /sys/fs/cgroup/unified rw,nosuid - cgroup2 cgroup2 rw\n",
+	})
+	c, err = readCgroup(filepath.Join(root, "proc"), root)
+	require.Nil(t, err)
+	assert.Nil(t, c)
+}
+
+func TestReadRuntime(t *testing.T) {
+	old := debug.SetGCPercent(-1)
+	defer debug.SetGCPercent(old)
+	runtime.GC()
+	r := ReadRuntime()
+	assert.Equal(t, -1, r.GOGC)
+	assert.NotZero(t, r.Total)
+	assert.NotZero(t, r.HeapObjects)
+	assert.NotZero(t, r.GCCycles)
+	assert.NotZero(t, r.GCForced)
+	assert.NotZero(t, r.Goroutines)
+	assert.NotZero(t, r.MemoryLimit)
+
+	debug.SetGCPercent(50)
+	assert.Equal(t, 50, ReadRuntime().GOGC)
+}
+
+func TestRead(t *testing.T) {
+	s, err := Read()
+	require.Nil(t, err)
+	assert.NotZero(t, s.Process.RSS)
+	assert.NotZero(t, s.Runtime.Total)
+	assert.WithinDuration(t, time.Now(), s.Time, time.Minute)
+	if s.Cgroup != nil {
+		assert.NotZero(t, s.Cgroup.Usage)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	var mu sync.Mutex
+	var samples []*Stats
+	s := NewSampler(10*time.Millisecond, func(st *Stats, err error) {
+		assert.Nil(t, err)
+		mu.Lock()
+		samples = append(samples, st)
+		mu.Unlock()
+	})
+	st := <-s.C()
+	assert.NotZero(t, st.Process.RSS)
+	time.Sleep(50 * time.Millisecond)
+	s.Stop()
+	s.Stop()
+
+	mu.Lock()
+	n := len(samples)
+	mu.Unlock()
+	assert.True(t, n >= 2, "%d samples", n)
+	last, err := s.Last()
+	assert.Nil(t, err)
+	assert.Equal(t, samples[n-1], last)
+	// the channel has the latest sample not received
+	select {
+	case st := <-s.C():
+		assert.Equal(t, last, st)
+	default:
+	}
+}
+
+func TestSamplerErrors(t *testing.T) {
+	errRead := errors.New("read")
+	var calls int
+	reads := make(chan struct{}, 1)
+	s := newSampler(time.Hour, func(st *Stats, err error) {
+		calls++
+		assert.Nil(t, st)
+		assert.Equal(t, errRead, err)
+		reads <- struct{}{}
+	}, func() (*Stats, error) { return nil, errRead })
+	<-reads
+	s.Stop()
+	assert.Equal(t, 1, calls)
+	last, err := s.Last()
+	assert.Nil(t, last)
+	assert.Equal(t, errRead, err)
+	select {
+	case <-s.C():
+		t.Fatal("sample of a failed read")
+	default:
+	}
+}
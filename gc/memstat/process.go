@@ -0,0 +1,102 @@
+package memstat
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var pageSize = uint64(syscall.Getpagesize())
+
+// Process is the memory of the process, in bytes.
+type Process struct {
+	// VSize is the size of the virtual address space.
+	VSize uint64
+	// RSS is the resident set size, the memory mapped in RAM, including
+	// the pages shared with other processes.
+	RSS uint64
+	// PSS is the proportional set size, RSS with the shared pages divided
+	// by the number of processes sharing them.
+	PSS uint64
+	// USS is the unique set size, the private pages, freed if the process
+	// exits.
+	USS uint64
+	// Anon is the anonymous memory, such as the heap and the stacks.
+	Anon uint64
+	// Swap is the memory swapped out.
+	Swap uint64
+	// Rollup reports whether PSS, USS, Anon and Swap are read, from
+	// smaps_rollup of Linux 4.14 and later.
+	Rollup bool
+}
+
+// ReadProcess reads the memory of the current process.
+func ReadProcess() (*Process, error) {
+	return readProcess("/proc/self")
+}
+
+func readProcess(dir string) (*Process, error) {
+	data, err := os.ReadFile(dir + "/statm")
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid %s/statm: %q", dir, data)
+	}
+	var pages [2]uint64
+	for i := range pages {
+		if pages[i], err = strconv.ParseUint(fields[i], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s/statm: %v", dir, err)
+		}
+	}
+	p := &Process{VSize: pages[0] * pageSize, RSS: pages[1] * pageSize}
+
+	data, err = os.ReadFile(dir + "/smaps_rollup")
+	if errors.Is(err, fs.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	kb, err := parseKB(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s/smaps_rollup: %v", dir, err)
+	}
+	p.RSS = kb["Rss"]
+	p.PSS = kb["Pss"]
+	p.USS = kb["Private_Clean"] + kb["Private_Dirty"]
+	p.Anon = kb["Anonymous"]
+	p.Swap = kb["Swap"]
+	p.Rollup = true
+	return p, nil
+}
+
+// parseKB parses the "Name: value kB" lines of smaps_rollup to bytes,
+// skipping the header.
+func parseKB(data []byte) (map[string]uint64, error) {
+	m := map[string]uint64{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		name, value, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) != 2 || fields[1] != "kB" {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		m[name] = v << 10
+	}
+	return m, sc.Err()
+}
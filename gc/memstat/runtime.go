@@ -0,0 +1,84 @@
+package memstat
+
+import (
+	"math"
+	"runtime/metrics"
+	"time"
+)
+
+// Runtime is the memory of the Go runtime and its GC, from runtime/metrics,
+// the sizes are in bytes.
+type Runtime struct {
+	// Total is the memory mapped by the runtime, the heap, the stacks and
+	// the metadata, close to the RSS of a process without cgo.
+	Total uint64
+	// HeapObjects is the memory of the heap objects, live or not yet
+	// swept, and HeapLive the live heap marked by the last GC.
+	HeapObjects uint64
+	HeapLive    uint64
+	// HeapGoal is the heap size of the next GC.
+	HeapGoal uint64
+	// HeapFree is the memory of the heap free and mapped, HeapReleased the
+	// memory free and returned to the OS, and HeapUnused the fragmentation
+	// of the spans in use.
+	HeapFree     uint64
+	HeapReleased uint64
+	HeapUnused   uint64
+	Stacks       uint64
+
+	// GCCycles is the number of GCs and GCForced those forced, such as by
+	// runtime.GC.
+	GCCycles uint64
+	GCForced uint64
+	// GCCPU is the CPU time of the GC, estimated by the runtime.
+	GCCPU time.Duration
+	// GOGC is the GOGC percent, -1 if off, and MemoryLimit the GOMEMLIMIT,
+	// math.MaxInt64 if none.
+	GOGC        int
+	MemoryLimit uint64
+
+	Goroutines uint64
+}
+
+// ReadRuntime reads the metrics of the runtime, those not supported by the
+// Go version are zero.
+func ReadRuntime() Runtime {
+	var r Runtime
+	var gogc uint64
+	uints := map[string]*uint64{
+		"/memory/classes/total:bytes":         &r.Total,
+		"/memory/classes/heap/objects:bytes":  &r.HeapObjects,
+		"/gc/heap/live:bytes":                 &r.HeapLive,
+		"/gc/heap/goal:bytes":                 &r.HeapGoal,
+		"/memory/classes/heap/free:bytes":     &r.HeapFree,
+		"/memory/classes/heap/released:bytes": &r.HeapReleased,
+		"/memory/classes/heap/unused:bytes":   &r.HeapUnused,
+		"/memory/classes/heap/stacks:bytes":   &r.Stacks,
+		"/gc/cycles/total:gc-cycles":          &r.GCCycles,
+		"/gc/cycles/forced:gc-cycles":         &r.GCForced,
+		"/gc/gogc:percent":                    &gogc,
+		"/gc/gomemlimit:bytes":                &r.MemoryLimit,
+		"/sched/goroutines:goroutines":        &r.Goroutines,
+	}
+	samples := []metrics.Sample{{Name: "/cpu/classes/gc/total:cpu-seconds"}}
+	for name := range uints {
+		samples = append(samples, metrics.Sample{Name: name})
+	}
+	metrics.Read(samples)
+
+	for _, s := range samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			*uints[s.Name] = s.Value.Uint64()
+		case metrics.KindFloat64:
+			r.GCCPU = time.Duration(s.Value.Float64() * float64(time.Second))
+		}
+	}
+	// the percent is the uint64 of -1 if off
+	if gogc > math.MaxInt32 {
+		r.GOGC = -1
+	} else {
+		r.GOGC = int(gogc)
+	}
+	return r
+}
@@ -0,0 +1,107 @@
+package memstat
+
+import (
+	"sync"
+	"time"
+)
+
+// Sampler reads the stats of the process periodically.
+//
+// The samples are passed to the callback of NewSampler, and the latest is
+// kept in C and by Last, for the dashboards polling it.
+type Sampler struct {
+	interval time.Duration
+	f        func(*Stats, error)
+	read     func() (*Stats, error)
+
+	c    chan *Stats
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+
+	mu   sync.Mutex
+	last *Stats
+	err  error
+}
+
+// NewSampler starts a sampler reading the stats now and every interval,
+// calling f, if not nil, with each sample or the error of the read. f is
+// called by the goroutine of the sampler, which waits for it to return.
+func NewSampler(interval time.Duration, f func(*Stats, error)) *Sampler {
+	return newSampler(interval, f, Read)
+}
+
+func newSampler(interval time.Duration, f func(*Stats, error), read func() (*Stats, error)) *Sampler {
+	s := &Sampler{
+		interval: interval,
+		f:        f,
+		read:     read,
+		c:        make(chan *Stats, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *Sampler) run() {
+	defer close(s.done)
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		s.sample()
+		select {
+		case <-t.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Sampler) sample() {
+	st, err := s.read()
+	s.mu.Lock()
+	if err == nil {
+		s.last = st
+	}
+	s.err = err
+	s.mu.Unlock()
+	if s.f != nil {
+		s.f(st, err)
+	}
+	if err != nil {
+		return
+	}
+	// replace the sample not yet received, the channel has the latest
+	for {
+		select {
+		case s.c <- st:
+			return
+		default:
+		}
+		select {
+		case <-s.c:
+		default:
+		}
+	}
+}
+
+// C returns the channel of the samples. A sample not received is replaced
+// by the next one, so a slow receiver gets the latest.
+func (s *Sampler) C() <-chan *Stats {
+	return s.c
+}
+
+// Last returns the latest sample read, nil if none yet, and the error of
+// the last read, nil if it succeeded.
+func (s *Sampler) Last() (*Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, s.err
+}
+
+// Stop stops the sampler and waits for the callback running to return.
+func (s *Sampler) Stop() {
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+}
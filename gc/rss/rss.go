@@ -1,25 +1,19 @@
 package rss
 
 import (
-	"io/ioutil"
 	"log"
-	"strconv"
-	"strings"
-	"syscall"
-)
 
-var pageSize = syscall.Getpagesize()
+	"github.com/hitzhangjie/codemaster/gc/memstat"
+)
 
 // GetRSS returns the resident set size of the current process
+//
+// Deprecated: use memstat.ReadProcess, which returns the error instead of
+// exiting, or memstat.Read for the cgroup and GC metrics too.
 func GetRSS() uint64 {
-	data, err := ioutil.ReadFile("/proc/self/stat")
-	if err != nil {
-		log.Fatal(err)
-	}
-	fs := strings.Fields(string(data))
-	rss, err := strconv.ParseUint(fs[23], 10, 64)
+	p, err := memstat.ReadProcess()
 	if err != nil {
 		log.Fatal(err)
 	}
-	return uint64(rss) * uint64(pageSize)
+	return p.RSS
 }
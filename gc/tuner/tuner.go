@@ -0,0 +1,391 @@
+// Package tuner tunes the GC of the process at runtime, with
+// debug.SetMemoryLimit and debug.SetGCPercent, instead of a ballast.
+//
+// A ballast, see gc/ballast, raises the heap goal to make the GC less
+// frequent while the heap is small, but its pages may become resident, and
+// GOMEMLIMIT alone with GOGC=off, see gc/gogc, only collects near the
+// limit. The tuner sets the memory limit to a ratio of the limit of the
+// container, read from its cgroup, and adjusts GOGC periodically:
+//
+//   - the heap goal is kept below the memory limit, for the live heap
+//     projected with its growth, GOGC getting lower as the heap grows;
+//   - while the memory allows it, GOGC is raised above its default if the
+//     GC uses more than a target fraction of the CPU, and brought back to
+//     its default otherwise.
+//
+// GOGC stays between a minimum and a maximum and changes by a bounded step
+// per period, except to lower it for the memory. Stop restores the
+// settings of the process.
+package tuner
+
+import (
+	"math"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/hitzhangjie/codemaster/gc/memstat"
+)
+
+const (
+	// DefaultLimitRatio is the default ratio of the memory limit of the
+	// container set as GOMEMLIMIT, leaving room for the memory outside of
+	// the Go heap, such as cgo, and the page cache.
+	DefaultLimitRatio = 0.7
+
+	// DefaultGOGC is the default GOGC the tuner starts from and goes back
+	// to while the GC is cheap.
+	DefaultGOGC = 100
+
+	// DefaultMinGOGC and DefaultMaxGOGC bound the GOGC set by default.
+	DefaultMinGOGC = 25
+	DefaultMaxGOGC = 500
+
+	// DefaultGCCPUTarget is the default fraction of the CPU for the GC
+	// above which GOGC is raised.
+	DefaultGCCPUTarget = 0.05
+
+	// DefaultMaxStep is the default factor by which GOGC changes at most
+	// per period.
+	DefaultMaxStep = 2
+
+	// DefaultInterval is the default period of the tuner.
+	DefaultInterval = time.Second
+
+	// DefaultHorizon is the default number of periods over which the live
+	// heap is projected.
+	DefaultHorizon = 5
+)
+
+// Reasons of a Change.
+const (
+	// ReasonStart is the memory limit set when the tuner starts.
+	ReasonStart = "start"
+	// ReasonLimit is a change of the memory limit of the container.
+	ReasonLimit = "limit"
+	// ReasonHeap is GOGC lowered to keep the heap goal below the limit.
+	ReasonHeap = "heap"
+	// ReasonGCCPU is GOGC changed for the GC CPU fraction.
+	ReasonGCCPU = "gc cpu"
+	// ReasonStop is the settings restored by Stop.
+	ReasonStop = "stop"
+)
+
+// Config configures the tuner, the zero values mean the defaults.
+type Config struct {
+	// Limit is the memory limit of the process in bytes, 0 means the
+	// limit of its memory cgroup, read periodically. Without a limit only
+	// the GC CPU fraction tunes GOGC.
+	Limit uint64
+	// LimitRatio is the ratio of Limit set as GOMEMLIMIT, DefaultLimitRatio
+	// if 0.
+	LimitRatio float64
+
+	// GOGC is the GOGC the tuner starts from, DefaultGOGC if 0.
+	GOGC int
+	// MinGOGC and MaxGOGC bound GOGC, DefaultMinGOGC and DefaultMaxGOGC if
+	// 0.
+	MinGOGC int
+	MaxGOGC int
+	// GCCPUTarget is the fraction of the CPU of GOMAXPROCS for the GC above
+	// which GOGC is raised, DefaultGCCPUTarget if 0.
+	GCCPUTarget float64
+	// MaxStep is the factor by which GOGC changes at most per period,
+	// DefaultMaxStep if 0. GOGC is lowered for the memory at once.
+	MaxStep float64
+
+	// Interval is the period of the tuner, DefaultInterval if 0.
+	Interval time.Duration
+	// Horizon is the number of periods over which the growth of the live
+	// heap is projected, DefaultHorizon if 0.
+	Horizon int
+
+	// OnChange, if not nil, is called with each change of the settings, by
+	// the goroutine of the tuner.
+	OnChange func(Change)
+}
+
+// Change is a change of the GC settings by the tuner.
+type Change struct {
+	Time   time.Time
+	Reason string
+
+	OldGOGC        int
+	GOGC           int
+	OldMemoryLimit int64
+	MemoryLimit    int64
+
+	// HeapLive is the live heap, Projected the live heap projected at the
+	// horizon and Threshold the memory limit set, 0 if none.
+	HeapLive  uint64
+	Projected uint64
+	Threshold uint64
+	// GCCPUFraction is the fraction of the CPU used by the GC in the last
+	// period.
+	GCCPUFraction float64
+
+	// Stats is the sample the change is made for, nil for ReasonStop.
+	Stats *memstat.Stats
+}
+
+// Tuner tunes the GC periodically.
+type Tuner struct {
+	cfg Config
+
+	// the GC settings, swapped by tests
+	setGCPercent   func(int) int
+	setMemoryLimit func(int64) int64
+	procs          func() int
+
+	sampler *memstat.Sampler
+
+	mu        sync.Mutex
+	gogc      int
+	limit     int64
+	origGOGC  int
+	origLimit int64
+	prev      *memstat.Stats
+	stopped   bool
+}
+
+// Start starts a tuner with cfg.
+func Start(cfg Config) *Tuner {
+	t := newTuner(cfg, debug.SetGCPercent, debug.SetMemoryLimit, func() int { return runtime.GOMAXPROCS(0) })
+	t.sampler = memstat.NewSampler(t.cfg.Interval, t.tune)
+	return t
+}
+
+func newTuner(cfg Config, setGCPercent func(int) int, setMemoryLimit func(int64) int64, procs func() int) *Tuner {
+	if cfg.LimitRatio <= 0 || cfg.LimitRatio > 1 {
+		cfg.LimitRatio = DefaultLimitRatio
+	}
+	if cfg.GOGC <= 0 {
+		cfg.GOGC = DefaultGOGC
+	}
+	if cfg.MinGOGC <= 0 {
+		cfg.MinGOGC = DefaultMinGOGC
+	}
+	if cfg.MaxGOGC <= 0 {
+		cfg.MaxGOGC = DefaultMaxGOGC
+	}
+	if cfg.MaxGOGC < cfg.MinGOGC {
+		cfg.MaxGOGC = cfg.MinGOGC
+	}
+	if cfg.GCCPUTarget <= 0 {
+		cfg.GCCPUTarget = DefaultGCCPUTarget
+	}
+	if cfg.MaxStep <= 1 {
+		cfg.MaxStep = DefaultMaxStep
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.Horizon <= 0 {
+		cfg.Horizon = DefaultHorizon
+	}
+	t := &Tuner{
+		cfg:            cfg,
+		setGCPercent:   setGCPercent,
+		setMemoryLimit: setMemoryLimit,
+		procs:          procs,
+	}
+	t.origGOGC = setGCPercent(cfg.GOGC)
+	t.gogc = clamp(cfg.GOGC, cfg.MinGOGC, cfg.MaxGOGC)
+	if t.gogc != cfg.GOGC {
+		setGCPercent(t.gogc)
+	}
+	t.origLimit = setMemoryLimit(-1)
+	t.limit = t.origLimit
+	return t
+}
+
+// GOGC returns the GOGC set by the tuner.
+func (t *Tuner) GOGC() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.gogc
+}
+
+// MemoryLimit returns the memory limit set by the tuner, math.MaxInt64 if
+// none.
+func (t *Tuner) MemoryLimit() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit
+}
+
+// Stop stops the tuner and restores the GOGC and the memory limit of the
+// process before Start.
+func (t *Tuner) Stop() {
+	if t.sampler != nil {
+		t.sampler.Stop()
+	}
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return
+	}
+	t.stopped = true
+	c := Change{
+		Time:           time.Now(),
+		Reason:         ReasonStop,
+		OldGOGC:        t.gogc,
+		GOGC:           t.origGOGC,
+		OldMemoryLimit: t.limit,
+		MemoryLimit:    t.origLimit,
+	}
+	t.gogc, t.limit = t.origGOGC, t.origLimit
+	t.setGCPercent(t.origGOGC)
+	t.setMemoryLimit(t.origLimit)
+	t.mu.Unlock()
+	if t.cfg.OnChange != nil {
+		t.cfg.OnChange(c)
+	}
+}
+
+// tune adjusts the settings for the sample st, skipping the failed reads.
+func (t *Tuner) tune(st *memstat.Stats, err error) {
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return
+	}
+	var changes []Change
+	if c, ok := t.tuneLimit(st); ok {
+		changes = append(changes, c)
+	}
+	if c, ok := t.tuneGOGC(st); ok {
+		changes = append(changes, c)
+	}
+	t.prev = st
+	t.mu.Unlock()
+
+	if t.cfg.OnChange != nil {
+		for _, c := range changes {
+			t.cfg.OnChange(c)
+		}
+	}
+}
+
+// threshold returns the memory limit to set for st, 0 if none.
+func (t *Tuner) threshold(st *memstat.Stats) uint64 {
+	limit := t.cfg.Limit
+	if limit == 0 && st.Cgroup != nil {
+		limit = st.Cgroup.Limit
+	}
+	return uint64(float64(limit) * t.cfg.LimitRatio)
+}
+
+// tuneLimit sets the memory limit to the threshold, or to the limit set
+// before Start if it's lower.
+func (t *Tuner) tuneLimit(st *memstat.Stats) (Change, bool) {
+	limit := t.origLimit
+	if th := t.threshold(st); th > 0 && th < math.MaxInt64 && int64(th) < limit {
+		limit = int64(th)
+	}
+	if limit == t.limit {
+		return Change{}, false
+	}
+	reason := ReasonLimit
+	if t.prev == nil {
+		reason = ReasonStart
+	}
+	c := t.change(st, reason)
+	c.MemoryLimit = limit
+	t.setMemoryLimit(limit)
+	t.limit = limit
+	return c, true
+}
+
+// tuneGOGC sets GOGC for the GC CPU fraction, lowered to keep the heap
+// goal of the projected live heap below the threshold.
+func (t *Tuner) tuneGOGC(st *memstat.Stats) (Change, bool) {
+	if t.prev == nil {
+		return Change{}, false
+	}
+	c := t.change(st, ReasonGCCPU)
+	cur := float64(t.gogc)
+
+	// raise GOGC in proportion to the excess of GC CPU, bring it back to
+	// its default otherwise, lowering it only while the GC is cheap
+	want, def := cur, float64(t.cfg.GOGC)
+	switch target := t.cfg.GCCPUTarget; {
+	case c.GCCPUFraction > target:
+		want = cur * math.Min(c.GCCPUFraction/target, t.cfg.MaxStep)
+	case cur < def:
+		want = math.Min(cur*t.cfg.MaxStep, def)
+	case c.GCCPUFraction < target/2 && cur > def:
+		want = math.Max(cur/t.cfg.MaxStep, def)
+	}
+	want = math.Min(want, cur*t.cfg.MaxStep)
+
+	// the heap goal, live*(1+GOGC/100), of the projected heap below the
+	// threshold, at once
+	if c.Threshold > 0 && c.Projected > 0 {
+		if heap := (float64(c.Threshold)/float64(c.Projected) - 1) * 100; heap < want {
+			want = heap
+			c.Reason = ReasonHeap
+		}
+	}
+
+	gogc := clamp(int(math.Round(want)), t.cfg.MinGOGC, t.cfg.MaxGOGC)
+	if gogc == t.gogc {
+		return Change{}, false
+	}
+	c.GOGC = gogc
+	t.setGCPercent(gogc)
+	t.gogc = gogc
+	return c, true
+}
+
+// change returns a change of the current settings for st.
+func (t *Tuner) change(st *memstat.Stats, reason string) Change {
+	c := Change{
+		Time:           st.Time,
+		Reason:         reason,
+		OldGOGC:        t.gogc,
+		GOGC:           t.gogc,
+		OldMemoryLimit: t.limit,
+		MemoryLimit:    t.limit,
+		HeapLive:       heapLive(st),
+		Threshold:      t.threshold(st),
+		Stats:          st,
+	}
+	c.Projected = c.HeapLive
+	if t.prev == nil {
+		return c
+	}
+	// the growth of the live heap over the last period, projected over
+	// the horizon, ignoring the shrinking
+	if prev := heapLive(t.prev); c.HeapLive > prev {
+		c.Projected += (c.HeapLive - prev) * uint64(t.cfg.Horizon)
+	}
+	wall := st.Time.Sub(t.prev.Time)
+	if cpu := st.Runtime.GCCPU - t.prev.Runtime.GCCPU; wall > 0 && cpu > 0 {
+		c.GCCPUFraction = float64(cpu) / (float64(wall) * float64(t.procs()))
+	}
+	return c
+}
+
+// heapLive returns the live heap of the last GC, the heap objects before
+// the first one.
+func heapLive(st *memstat.Stats) uint64 {
+	if st.Runtime.HeapLive != 0 {
+		return st.Runtime.HeapLive
+	}
+	return st.Runtime.HeapObjects
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
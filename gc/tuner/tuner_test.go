@@ -0,0 +1,226 @@
+package tuner
+
+import (
+	"math"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hitzhangjie/codemaster/gc/memstat"
+)
+
+// fakeGC records the settings of a tuner instead of changing those of the
+// test.
+type fakeGC struct {
+	gogc    int
+	limit   int64
+	changes []Change
+}
+
+func (f *fakeGC) setGCPercent(p int) int {
+	old := f.gogc
+	f.gogc = p
+	return old
+}
+
+func (f *fakeGC) setMemoryLimit(l int64) int64 {
+	old := f.limit
+	if l >= 0 {
+		f.limit = l
+	}
+	return old
+}
+
+func newFake(cfg Config) (*Tuner, *fakeGC) {
+	f := &fakeGC{gogc: 100, limit: math.MaxInt64}
+	cfg.OnChange = func(c Change) { f.changes = append(f.changes, c) }
+	return newTuner(cfg, f.setGCPercent, f.setMemoryLimit, func() int { return 4 }), f
+}
+
+// sample returns a sample at sec seconds with the live heap, the GC CPU time
+// and the limit of the cgroup.
+func sample(sec float64, live uint64, gcCPU time.Duration, cgroupLimit uint64) *memstat.Stats {
+	st := &memstat.Stats{
+		Time:    time.Unix(1000, 0).Add(time.Duration(sec * float64(time.Second))),
+		Runtime: memstat.Runtime{HeapLive: live, GCCPU: gcCPU},
+	}
+	if cgroupLimit != 0 {
+		st.Cgroup = &memstat.Cgroup{Version: 2, Limit: cgroupLimit}
+	}
+	return st
+}
+
+func TestTuneHeap(t *testing.T) {
+	const mb = 1 << 20
+	tn, f := newFake(Config{})
+
+	// the limit of the cgroup, 70% of 1000MB
+	tn.tune(sample(0, 100*mb, 0, 1000*mb), nil)
+	require.Len(t, f.changes, 1)
+	assert.Equal(t, ReasonStart, f.changes[0].Reason)
+	assert.Equal(t, int64(700*mb), f.limit)
+	assert.Equal(t, int64(math.MaxInt64), f.changes[0].OldMemoryLimit)
+	assert.Equal(t, 100, f.gogc)
+
+	// a steady heap with a cheap GC keeps GOGC
+	tn.tune(sample(1, 100*mb, 10*time.Millisecond, 1000*mb), nil)
+	assert.Len(t, f.changes, 1)
+
+	// a growing heap lowers GOGC at once, its goal below the limit: 200MB
+	// growing of 50MB per period is projected to 450MB
+	tn.tune(sample(2, 200*mb, 20*time.Millisecond, 1000*mb), nil)
+	require.Len(t, f.changes, 2)
+	c := f.changes[1]
+	assert.Equal(t, ReasonHeap, c.Reason)
+	assert.Equal(t, uint64(700*mb), c.Threshold)
+	assert.Equal(t, uint64(700*mb), c.Projected)
+	assert.Equal(t, 25, c.GOGC) // 0 clamped to the minimum
+	assert.Equal(t, 25, f.gogc)
+	assert.Equal(t, 25, tn.GOGC())
+
+	// a steady heap raises GOGC back by a bounded step
+	tn.tune(sample(3, 200*mb, 30*time.Millisecond, 1000*mb), nil)
+	require.Len(t, f.changes, 3)
+	assert.Equal(t, 50, f.gogc)
+	tn.tune(sample(4, 200*mb, 40*time.Millisecond, 1000*mb), nil)
+	assert.Equal(t, 100, f.gogc)
+	tn.tune(sample(5, 200*mb, 50*time.Millisecond, 1000*mb), nil)
+	assert.Len(t, f.changes, 4)
+
+	// a larger limit of the cgroup
+	tn.tune(sample(6, 200*mb, 60*time.Millisecond, 2000*mb), nil)
+	require.Len(t, f.changes, 5)
+	assert.Equal(t, ReasonLimit, f.changes[4].Reason)
+	assert.Equal(t, int64(1400*mb), f.limit)
+	assert.Equal(t, int64(1400*mb), tn.MemoryLimit())
+
+	// the failed reads are skipped
+	tn.tune(nil, assert.AnError)
+	assert.Len(t, f.changes, 5)
+
+	tn.Stop()
+	tn.Stop()
+	require.Len(t, f.changes, 6)
+	assert.Equal(t, ReasonStop, f.changes[5].Reason)
+	assert.Equal(t, 100, f.gogc)
+	assert.Equal(t, int64(math.MaxInt64), f.limit)
+	tn.tune(sample(7, 900*mb, 0, 1000*mb), nil)
+	assert.Len(t, f.changes, 6)
+}
+
+func TestTuneGCCPU(t *testing.T) {
+	tn, f := newFake(Config{GCCPUTarget: 0.1, MaxGOGC: 300})
+	tn.tune(sample(0, 10<<20, 0, 0), nil)
+	assert.Empty(t, f.changes)
+
+	// 0.8s of GC of 4s of CPU is twice the target
+	tn.tune(sample(1, 10<<20, 800*time.Millisecond, 0), nil)
+	require.Len(t, f.changes, 1)
+	c := f.changes[0]
+	assert.Equal(t, ReasonGCCPU, c.Reason)
+	assert.InDelta(t, 0.2, c.GCCPUFraction, 1e-9)
+	assert.Equal(t, 100, c.OldGOGC)
+	assert.Equal(t, 200, c.GOGC)
+	assert.Zero(t, c.Threshold)
+
+	// bounded by the step and the maximum
+	tn.tune(sample(2, 10<<20, 3*time.Second, 0), nil)
+	assert.Equal(t, 300, f.gogc)
+
+	// a cheap GC lowers GOGC back to its default
+	tn.tune(sample(3, 10<<20, 3*time.Second, 0), nil)
+	assert.Equal(t, 150, f.gogc)
+	tn.tune(sample(4, 10<<20, 3*time.Second, 0), nil)
+	assert.Equal(t, 100, f.gogc)
+	tn.tune(sample(5, 10<<20, 3*time.Second, 0), nil)
+	assert.Equal(t, 100, f.gogc)
+	assert.Len(t, f.changes, 4)
+
+	// the memory wins over the GC CPU, with the limit of the config
+	tn, f = newFake(Config{Limit: 100 << 20, LimitRatio: 0.5, GCCPUTarget: 0.1, Horizon: 1})
+	tn.tune(sample(0, 20<<20, 0, 1000<<20), nil)
+	assert.Equal(t, int64(50<<20), f.limit)
+	tn.tune(sample(1, 20<<20, 4*time.Second, 1000<<20), nil)
+	assert.Equal(t, 150, f.gogc) // 50MB/20MB-1
+	assert.Equal(t, ReasonHeap, f.changes[1].Reason)
+}
+
+func TestTuneOriginalLimit(t *testing.T) {
+	// GOMEMLIMIT of the process is kept if lower
+	f := &fakeGC{gogc: 100, limit: 300 << 20}
+	tn := newTuner(Config{MinGOGC: 200, MaxGOGC: 100}, f.setGCPercent, f.setMemoryLimit, func() int { return 1 })
+	assert.Equal(t, 200, f.gogc)
+	tn.tune(sample(0, 1<<20, 0, 1000<<20), nil)
+	assert.Equal(t, int64(300<<20), f.limit)
+	tn.Stop()
+	assert.Equal(t, 100, f.gogc)
+}
+
+// TestTunerWorkload runs the tuner on the process with a workload keeping
+// a growing heap, up to near the limit.
+func TestTunerWorkload(t *testing.T) {
+	const limit = 512 << 20
+	oldGOGC := debug.SetGCPercent(100)
+	defer debug.SetGCPercent(oldGOGC)
+	oldLimit := debug.SetMemoryLimit(-1)
+
+	var mu sync.Mutex
+	var changes []Change
+	tn := Start(Config{
+		Limit:      limit,
+		LimitRatio: 0.5,
+		Interval:   20 * time.Millisecond,
+		OnChange: func(c Change) {
+			mu.Lock()
+			changes = append(changes, c)
+			mu.Unlock()
+		},
+	})
+
+	// keep 1MB more every ms, with 1MB of garbage, up to 192MB of the
+	// 256MB of the memory limit
+	var kept [][]byte
+	var sink []byte
+	lowest := DefaultGOGC
+	for i := 0; i < 192; i++ {
+		kept = append(kept, make([]byte, 1<<20))
+		sink = make([]byte, 1<<20)
+		time.Sleep(time.Millisecond)
+		if g := tn.GOGC(); g < lowest {
+			lowest = g
+		}
+	}
+	runtime.KeepAlive(sink)
+	assert.Equal(t, int64(limit/2), tn.MemoryLimit())
+	assert.Equal(t, int64(limit/2), debug.SetMemoryLimit(-1))
+	// the heap goal of GOGC stays below the limit: 256/192 - 1 is 33%
+	for start := time.Now(); tn.GOGC() > 40 && time.Since(start) < 5*time.Second; {
+		runtime.GC()
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.True(t, tn.GOGC() <= 40, "GOGC %d", tn.GOGC())
+	assert.True(t, lowest < DefaultGOGC, "GOGC %d", lowest)
+	assert.Equal(t, tn.GOGC(), debug.SetGCPercent(-1))
+	debug.SetGCPercent(tn.GOGC())
+	runtime.KeepAlive(kept)
+
+	tn.Stop()
+	assert.Equal(t, oldLimit, debug.SetMemoryLimit(-1))
+	assert.Equal(t, 100, debug.SetGCPercent(oldGOGC))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, changes)
+	assert.Equal(t, ReasonStart, changes[0].Reason)
+	assert.Equal(t, ReasonStop, changes[len(changes)-1].Reason)
+	var heap bool
+	for _, c := range changes {
+		heap = heap || c.Reason == ReasonHeap
+	}
+	assert.True(t, heap)
+}
@@ -20,6 +20,8 @@ require (
 	github.com/iancoleman/strcase v0.2.0
 	github.com/influxdata/tdigest v0.0.1
 	github.com/json-iterator/go v1.1.12
+	github.com/klauspost/compress v1.18.0
+	github.com/klauspost/pgzip v1.2.6
 	github.com/lafikl/consistent v0.0.0-20210222184039-5e8acd7e59f2
 	github.com/libp2p/go-reuseport v0.1.0
 	github.com/lithammer/go-jump-consistent-hash v1.0.2
@@ -46,6 +48,7 @@ require (
 	go.uber.org/atomic v1.9.0
 	go.uber.org/zap v1.24.0
 	golang.org/x/arch v0.0.0-20210923205945-b76863e36670
+	golang.org/x/exp v0.0.0-20250819193227-8b4c13bb791b
 	golang.org/x/net v0.43.0
 	google.golang.org/grpc v1.59.0
 	google.golang.org/grpc/examples v0.0.0-20211119181224-d542bfcee46d
@@ -78,7 +81,6 @@ require (
 	go.etcd.io/etcd/client/pkg/v3 v3.5.15 // indirect
 	go.opentelemetry.io/otel/trace v1.8.0 // indirect
 	go.uber.org/multierr v1.8.0 // indirect
-	golang.org/x/exp v0.0.0-20250819193227-8b4c13bb791b // indirect
 	golang.org/x/sys v0.39.0 // indirect
 	golang.org/x/text v0.28.0 // indirect
 	gonum.org/v1/gonum v0.16.0 // indirect
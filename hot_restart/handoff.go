@@ -0,0 +1,308 @@
+package hotrestart
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"syscall"
+)
+
+// The handoff protocol passes the connections over a unix stream socket,
+// as passfd and passfd2 do, a frame per connection with its fd as the
+// SCM_RIGHTS of the first byte:
+//
+//	magic "HOFF", type, 3 bytes of padding,
+//	length of the kind, the state and the buffered bytes, uint32 LE
+//	kind, state, buffered bytes
+//
+// The frame of type frameEnd, without fd nor data, ends the handoff.
+const (
+	handoffMagic = "HOFF"
+	headerSize   = 20
+
+	frameConn = 1
+	frameEnd  = 2
+)
+
+// MaxHandoffSize is the maximum size of the kind, state and buffered bytes
+// of a connection handed off.
+const MaxHandoffSize = 64 << 20
+
+var (
+	// ErrNoHandoff is returned by Upgrader.Handoff out of the drain after an
+	// upgrade.
+	ErrNoHandoff = errors.New("hot_restart: no handoff in progress")
+
+	// errHandoffEnd is returned by RecvConn at the end of the handoff.
+	errHandoffEnd = errors.New("hot_restart: end of handoff")
+)
+
+// HandoffConn is a connection handed off by the old process, with the
+// state of the application and the bytes it read but didn't consume, such
+// as those of a bufio.Reader.
+type HandoffConn struct {
+	// Conn is the connection, without the buffered bytes.
+	Conn net.Conn
+	// Kind names the protocol of the connection, such as websocket, for
+	// the new process to resume it.
+	Kind     string
+	State    []byte
+	Buffered []byte
+}
+
+// NetConn returns the connection reading the buffered bytes first.
+func (h *HandoffConn) NetConn() net.Conn {
+	if len(h.Buffered) == 0 {
+		return h.Conn
+	}
+	return &bufferedConn{Conn: h.Conn, buf: h.Buffered}
+}
+
+// bufferedConn is a connection reading buf first.
+type bufferedConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(b, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// SendConn sends the connection c with its kind, state and buffered bytes
+// over the unix socket uc, and closes c in this process. The connection
+// itself isn't shut down, the process receiving it goes on with it.
+//
+// The application stops using c first: the goroutines reading it stopped,
+// such as by a read deadline, and the bytes they read but didn't consume
+// gathered as buffered.
+func SendConn(uc *net.UnixConn, c net.Conn, kind string, state, buffered []byte) error {
+	if len(kind)+len(state)+len(buffered) > MaxHandoffSize {
+		return fmt.Errorf("hot_restart: handoff of %d bytes larger than %d", len(kind)+len(state)+len(buffered), MaxHandoffSize)
+	}
+	fc, ok := c.(filer)
+	if !ok {
+		return fmt.Errorf("hot_restart: conn %T has no file", c)
+	}
+	f, err := fc.File()
+	if err != nil {
+		return fmt.Errorf("hot_restart: file of conn: %v", err)
+	}
+	defer f.Close()
+
+	frame := header(frameConn, kind, state, buffered)
+	frame = append(frame, kind...)
+	frame = append(frame, state...)
+	frame = append(frame, buffered...)
+	if err := writeFrame(uc, frame, syscall.UnixRights(int(f.Fd()))); err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+// SendEnd ends the handoff over uc.
+func SendEnd(uc *net.UnixConn) error {
+	return writeFrame(uc, header(frameEnd, "", nil, nil), nil)
+}
+
+func header(typ byte, kind string, state, buffered []byte) []byte {
+	h := make([]byte, headerSize)
+	copy(h, handoffMagic)
+	h[4] = typ
+	binary.LittleEndian.PutUint32(h[8:], uint32(len(kind)))
+	binary.LittleEndian.PutUint32(h[12:], uint32(len(state)))
+	binary.LittleEndian.PutUint32(h[16:], uint32(len(buffered)))
+	return h
+}
+
+// writeFrame writes frame with the oob on its first byte.
+func writeFrame(uc *net.UnixConn, frame, oob []byte) error {
+	n, _, err := uc.WriteMsgUnix(frame, oob, nil)
+	if err == nil && n < len(frame) {
+		_, err = uc.Write(frame[n:])
+	}
+	if err != nil {
+		return fmt.Errorf("hot_restart: send handoff: %v", err)
+	}
+	return nil
+}
+
+// RecvConn receives a connection sent by SendConn over uc. It returns
+// io.EOF at the end of the handoff.
+func RecvConn(uc *net.UnixConn) (*HandoffConn, error) {
+	h := make([]byte, headerSize)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := uc.ReadMsgUnix(h, oob)
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	if oobn > 0 {
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return nil, fmt.Errorf("hot_restart: handoff control message: %v", err)
+		}
+		for _, m := range msgs {
+			rights, err := syscall.ParseUnixRights(&m)
+			if err != nil {
+				return nil, fmt.Errorf("hot_restart: handoff control message: %v", err)
+			}
+			fds = append(fds, rights...)
+		}
+	}
+	frame, err := recvConn(uc, h, n, fds)
+	if err != nil {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		if err == errHandoffEnd {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	ho := &HandoffConn{}
+	f := os.NewFile(uintptr(fds[0]), "handoff")
+	ho.Conn, err = net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("hot_restart: handoff conn: %v", err)
+	}
+	sizes := [3]int{
+		int(binary.LittleEndian.Uint32(frame[8:])),
+		int(binary.LittleEndian.Uint32(frame[12:])),
+		int(binary.LittleEndian.Uint32(frame[16:])),
+	}
+	data := frame[headerSize:]
+	ho.Kind = string(data[:sizes[0]])
+	if sizes[1] > 0 {
+		ho.State = data[sizes[0] : sizes[0]+sizes[1]]
+	}
+	if sizes[2] > 0 {
+		ho.Buffered = data[sizes[0]+sizes[1]:]
+	}
+	return ho, nil
+}
+
+// recvConn reads the rest of the frame with the first n bytes of its
+// header in h, and checks it has the fd.
+func recvConn(uc *net.UnixConn, h []byte, n int, fds []int) ([]byte, error) {
+	if _, err := io.ReadFull(uc, h[n:]); err != nil {
+		return nil, fmt.Errorf("hot_restart: handoff header: %v", io.ErrUnexpectedEOF)
+	}
+	if string(h[:4]) != handoffMagic {
+		return nil, fmt.Errorf("hot_restart: invalid handoff magic %q", h[:4])
+	}
+	if h[4] == frameEnd {
+		return nil, errHandoffEnd
+	}
+	if h[4] != frameConn {
+		return nil, fmt.Errorf("hot_restart: invalid handoff frame %d", h[4])
+	}
+	var size uint64
+	for i := 8; i < headerSize; i += 4 {
+		size += uint64(binary.LittleEndian.Uint32(h[i:]))
+	}
+	if size > MaxHandoffSize {
+		return nil, fmt.Errorf("hot_restart: handoff of %d bytes larger than %d", size, MaxHandoffSize)
+	}
+	if len(fds) != 1 {
+		return nil, fmt.Errorf("hot_restart: handoff with %d fds", len(fds))
+	}
+	frame := make([]byte, headerSize+int(size))
+	copy(frame, h)
+	if _, err := io.ReadFull(uc, frame[headerSize:]); err != nil {
+		return nil, fmt.Errorf("hot_restart: handoff data: %v", io.ErrUnexpectedEOF)
+	}
+	return frame, nil
+}
+
+// socketpair returns a connected pair of unix stream sockets, the first as
+// a conn of this process and the second as the file passed to the new one.
+func socketpair() (*net.UnixConn, *os.File, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("hot_restart: socketpair: %v", err)
+	}
+	f := os.NewFile(uintptr(fds[0]), "handoff")
+	c, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, fmt.Errorf("hot_restart: socketpair: %v", err)
+	}
+	return c.(*net.UnixConn), os.NewFile(uintptr(fds[1]), "handoff"), nil
+}
+
+// Handoff hands off the connection c with Default.
+func Handoff(c net.Conn, kind string, state, buffered []byte) error {
+	return Default.Handoff(c, kind, state, buffered)
+}
+
+// Handoffs returns the connections handed off to the process with Default.
+func Handoffs() <-chan *HandoffConn {
+	return Default.Handoffs()
+}
+
+// Handoff hands off the connection c, with its kind, state and buffered
+// bytes, to the new process and closes it in this process. It's called by
+// the OnShutdown funcs after an upgrade, for long-lived connections such as
+// websockets to go on in the new process, see SendConn.
+func (u *Upgrader) Handoff(c net.Conn, kind string, state, buffered []byte) error {
+	u.handoffMu.Lock()
+	defer u.handoffMu.Unlock()
+	if u.handoff == nil {
+		return ErrNoHandoff
+	}
+	return SendConn(u.handoff, c, kind, state, buffered)
+}
+
+// endHandoff ends the handoff to the new process, if any.
+func (u *Upgrader) endHandoff() {
+	u.handoffMu.Lock()
+	defer u.handoffMu.Unlock()
+	if u.handoff == nil {
+		return
+	}
+	SendEnd(u.handoff)
+	u.handoff.Close()
+	u.handoff = nil
+}
+
+// Handoffs returns the channel of the connections handed off by the parent
+// to the process, closed at the end of the handoff, once the parent has
+// drained. It's closed at once if the process wasn't started by Upgrade.
+func (u *Upgrader) Handoffs() <-chan *HandoffConn {
+	u.init()
+	u.handoffsOnce.Do(func() {
+		u.handoffs = make(chan *HandoffConn)
+		go u.recvHandoffs()
+	})
+	return u.handoffs
+}
+
+func (u *Upgrader) recvHandoffs() {
+	defer close(u.handoffs)
+	if u.parent == nil {
+		return
+	}
+	defer u.parent.Close()
+	for {
+		h, err := RecvConn(u.parent)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Printf("hot_restart: handoff: %v", err)
+			return
+		}
+		u.handoffs <- h
+	}
+}
@@ -0,0 +1,252 @@
+package hotrestart
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendRecvConn(t *testing.T) {
+	uc, f, err := socketpair()
+	require.Nil(t, err)
+	defer uc.Close()
+	c, err := net.FileConn(f)
+	f.Close()
+	require.Nil(t, err)
+	peer := c.(*net.UnixConn)
+	defer peer.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer client.Close()
+	server, err := ln.Accept()
+	require.Nil(t, err)
+
+	// the server read a line and a half
+	_, err = client.Write([]byte("one\ntw"))
+	require.Nil(t, err)
+	br := bufio.NewReader(server)
+	line, err := br.ReadString('\n')
+	require.Nil(t, err)
+	assert.Equal(t, "one\n", line)
+	buffered, err := br.Peek(br.Buffered())
+	require.Nil(t, err)
+	assert.Equal(t, "tw", string(buffered))
+
+	require.Nil(t, SendConn(uc, server, "line", []byte("count=1"), buffered))
+	_, err = server.Write([]byte("x"))
+	assert.NotNil(t, err, "closed once sent")
+	require.Nil(t, SendConn(uc, client, "", nil, nil))
+	_, err = client.Write([]byte("x"))
+	assert.NotNil(t, err)
+	require.Nil(t, SendEnd(uc))
+
+	h, err := RecvConn(peer)
+	require.Nil(t, err)
+	defer h.Conn.Close()
+	assert.Equal(t, "line", h.Kind)
+	assert.Equal(t, "count=1", string(h.State))
+	assert.Equal(t, "tw", string(h.Buffered))
+	assert.Equal(t, client.LocalAddr().String(), h.Conn.RemoteAddr().String())
+
+	// the client, sent too
+	h2, err := RecvConn(peer)
+	require.Nil(t, err)
+	defer h2.Conn.Close()
+	assert.Empty(t, h2.Kind)
+	assert.Nil(t, h2.State)
+	assert.Nil(t, h2.Buffered)
+	assert.Equal(t, h2.Conn, h2.NetConn())
+
+	// the connection goes on with the buffered bytes first
+	_, err = h2.Conn.Write([]byte("o\n"))
+	require.Nil(t, err)
+	line, err = bufio.NewReader(h.NetConn()).ReadString('\n')
+	require.Nil(t, err)
+	assert.Equal(t, "two\n", line)
+	_, err = h.Conn.Write([]byte("ok\n"))
+	require.Nil(t, err)
+	line, err = bufio.NewReader(h2.Conn).ReadString('\n')
+	require.Nil(t, err)
+	assert.Equal(t, "ok\n", line)
+
+	_, err = RecvConn(peer)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRecvConnErrors(t *testing.T) {
+	pair := func() (*net.UnixConn, *net.UnixConn) {
+		uc, f, err := socketpair()
+		require.Nil(t, err)
+		c, err := net.FileConn(f)
+		f.Close()
+		require.Nil(t, err)
+		t.Cleanup(func() {
+			uc.Close()
+			c.Close()
+		})
+		return uc, c.(*net.UnixConn)
+	}
+
+	uc, peer := pair()
+	_, err := uc.Write([]byte("HELLO, WORLD, HELLO!"))
+	require.Nil(t, err)
+	_, err = RecvConn(peer)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "magic")
+
+	// a frame of a conn without its fd
+	uc, peer = pair()
+	_, err = uc.Write(header(frameConn, "k", nil, nil))
+	require.Nil(t, err)
+	_, err = RecvConn(peer)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "0 fds")
+
+	uc, peer = pair()
+	h := header(frameConn, "", nil, nil)
+	h[16] = 0xff
+	h[19] = 0xff
+	_, err = uc.Write(h)
+	require.Nil(t, err)
+	_, err = RecvConn(peer)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "larger than")
+
+	// a truncated frame
+	uc, peer = pair()
+	_, err = uc.Write(header(frameConn, "", nil, nil)[:10])
+	require.Nil(t, err)
+	uc.CloseWrite()
+	_, err = RecvConn(peer)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), io.ErrUnexpectedEOF.Error())
+
+	// a conn without file
+	uc, _ = pair()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	assert.NotNil(t, SendConn(uc, c1, "", nil, nil))
+
+	// out of an upgrade
+	u := &Upgrader{}
+	assert.Equal(t, ErrNoHandoff, u.Handoff(c1, "", nil, nil))
+	_, ok := <-u.Handoffs()
+	assert.False(t, ok)
+}
+
+// TestUpgradeHandoff upgrades a line server twice with clients connected,
+// the counts of their sessions going on in the new processes.
+func TestUpgradeHandoff(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs a server")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "session")
+	out, err := exec.Command("go", "build", "-o", bin, "./testdata/session").CombinedOutput()
+	require.Nil(t, err, "%s", out)
+
+	logs, err := os.Create(filepath.Join(dir, "log"))
+	require.Nil(t, err)
+	defer logs.Close()
+	addrFile := filepath.Join(dir, "addr")
+	cmd := exec.Command(bin, "-addr", addrFile)
+	cmd.Stdout, cmd.Stderr = logs, logs
+	require.Nil(t, cmd.Start())
+	go cmd.Wait()
+
+	pid := cmd.Process.Pid
+	pids := map[int]bool{pid: true}
+	defer func() {
+		for pid := range pids {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+		if t.Failed() {
+			data, _ := os.ReadFile(logs.Name())
+			t.Logf("server logs:\n%s", data)
+		}
+	}()
+
+	var addr []byte
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if addr, err = os.ReadFile(addrFile); err == nil {
+			break
+		}
+		require.Less(t, time.Since(start), 10*time.Second)
+	}
+
+	type client struct {
+		c  net.Conn
+		br *bufio.Reader
+		n  int
+	}
+	// recv reads the answer of a line, the count of lines of the session
+	// and the pid
+	recv := func(c *client) int {
+		c.c.SetReadDeadline(time.Now().Add(10 * time.Second))
+		line, err := c.br.ReadString('\n')
+		require.Nil(t, err)
+		var count, pid int
+		_, err = fmt.Sscanf(line, "%d %d\n", &count, &pid)
+		require.Nil(t, err, line)
+		assert.Equal(t, c.n, count)
+		return pid
+	}
+	send := func(c *client) int {
+		c.n++
+		_, err := fmt.Fprintf(c.c, "ping %d\n", c.n)
+		require.Nil(t, err)
+		return recv(c)
+	}
+	var clients []*client
+	for i := 0; i < 5; i++ {
+		c, err := net.Dial("tcp", string(addr))
+		require.Nil(t, err)
+		defer c.Close()
+		cl := &client{c: c, br: bufio.NewReader(c)}
+		clients = append(clients, cl)
+		assert.Equal(t, pid, send(cl))
+	}
+
+	for i := 0; i < 2; i++ {
+		// a line in flight, half written, during the upgrade
+		_, err := clients[0].c.Write([]byte("pi"))
+		require.Nil(t, err)
+		clients[0].n++
+		require.Nil(t, syscall.Kill(pid, syscall.SIGUSR2))
+		waitExit(t, pid)
+		_, err = clients[0].c.Write([]byte("ng " + strconv.Itoa(clients[0].n) + "\n"))
+		require.Nil(t, err)
+		assert.NotEqual(t, pid, recv(clients[0]))
+
+		// the sessions go on in the new process
+		next := 0
+		for _, c := range clients {
+			p := send(c)
+			assert.NotEqual(t, pid, p)
+			if next == 0 {
+				next = p
+			}
+			assert.Equal(t, next, p)
+		}
+		pid = next
+		pids[pid] = true
+	}
+	require.Nil(t, syscall.Kill(pid, syscall.SIGTERM))
+	waitExit(t, pid)
+}
@@ -0,0 +1,544 @@
+// Package hotrestart restarts a server with a new binary without dropping
+// connections, the listeners passed from the old process to the new one.
+//
+// It brings together the experiments of hot_restart1..7: the listeners are
+// inherited as the extra files of the new process, found by the env vars
+// set by the parent, instead of the fd requests over a unixgram socket of
+// hot_restart3 or SO_REUSEPORT of hot_restart7, which would let the kernel
+// queue the connections to the process exiting.
+//
+// A server listens with Listen, which returns the listener of the parent if
+// it was inherited, registers how it drains with OnShutdown, calls Ready
+// when it serves, and Wait:
+//
+//	ln, err := hotrestart.Listen("tcp", ":8080")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	srv := &http.Server{Handler: h}
+//	go srv.Serve(ln)
+//	hotrestart.OnShutdown(srv.Shutdown)
+//	if err := hotrestart.Ready(); err != nil {
+//		log.Fatal(err)
+//	}
+//	if err := hotrestart.Wait(); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// On SIGUSR2, Wait calls Upgrade, which starts the binary again with the
+// listeners and waits for it to be ready. The old process then stops
+// accepting, the new one accepting the connections of the shared sockets,
+// drains with the OnShutdown funcs after DrainDelay and within DrainTimeout,
+// and Wait returns for main to exit. If the new process fails or isn't
+// ready within ReadyTimeout, the old one keeps serving. On SIGINT and
+// SIGTERM Wait drains and returns.
+//
+// Long-lived connections, such as websockets, survive an upgrade handed off
+// to the new process: an OnShutdown func stops reading them and passes each
+// with Handoff, its fd sent with SCM_RIGHTS over a unix socket, as passfd
+// and passfd2 do, together with the state of the connection and the bytes
+// read but not consumed yet. The new process resumes them from Handoffs.
+package hotrestart
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// envListeners is the JSON of the listeners passed by the parent, the
+	// extra files from fd 3 in order.
+	envListeners = "HOT_RESTART_LISTENERS"
+	// envReady is the fd of the pipe Ready writes to.
+	envReady = "HOT_RESTART_READY"
+	// envHandoff is the fd of the unix socket of the connections handed
+	// off by the parent.
+	envHandoff = "HOT_RESTART_HANDOFF"
+)
+
+const (
+	// DefaultReadyTimeout is the default time Upgrade waits for the new
+	// process to be ready.
+	DefaultReadyTimeout = time.Minute
+
+	// DefaultDrainDelay is the default time between closing the listeners
+	// and calling the OnShutdown funcs.
+	DefaultDrainDelay = time.Second
+
+	// DefaultDrainTimeout is the default time the OnShutdown funcs have to
+	// drain the process.
+	DefaultDrainTimeout = 30 * time.Second
+)
+
+var (
+	// ErrUpgrading is returned by Upgrade while an upgrade is in progress.
+	ErrUpgrading = errors.New("hot_restart: upgrade in progress")
+
+	// ErrShutdown is returned by Upgrade and Listen once the process has
+	// upgraded or is shutting down.
+	ErrShutdown = errors.New("hot_restart: shut down")
+)
+
+// Default is the Upgrader of the package functions.
+var Default = &Upgrader{}
+
+// Listen listens on the network address with Default.
+func Listen(network, addr string) (net.Listener, error) {
+	return Default.Listen(network, addr)
+}
+
+// OnShutdown registers f to drain the process with Default.
+func OnShutdown(f func(context.Context) error) {
+	Default.OnShutdown(f)
+}
+
+// Ready notifies the parent of Default that the process serves.
+func Ready() error {
+	return Default.Ready()
+}
+
+// Upgrade upgrades the process with Default.
+func Upgrade() error {
+	return Default.Upgrade()
+}
+
+// Wait handles the signals with Default.
+func Wait() error {
+	return Default.Wait()
+}
+
+// Upgrader passes the listeners of the process to the new binary.
+//
+// The zero value is ready to use.
+type Upgrader struct {
+	// ReadyTimeout is the time Upgrade waits for the new process to be
+	// ready, DefaultReadyTimeout if 0.
+	ReadyTimeout time.Duration
+	// DrainDelay is the time between closing the listeners and calling the
+	// OnShutdown funcs, DefaultDrainDelay if 0 and none if negative. It
+	// lets the connections just accepted send their requests, which an
+	// http.Server shutting down closes unanswered.
+	DrainDelay time.Duration
+	// DrainTimeout is the time the OnShutdown funcs have to drain the
+	// process, DefaultDrainTimeout if 0.
+	DrainTimeout time.Duration
+
+	once sync.Once
+	// inherited are the files of the listeners of the parent not listened
+	// yet, by key, ready the pipe to the parent and parent the socket of
+	// its handoff, nil if none.
+	inherited map[string]*os.File
+	ready     *os.File
+	parent    *net.UnixConn
+	initErr   error
+
+	mu        sync.Mutex
+	listeners []*listener
+	hooks     []func(context.Context) error
+	upgrading bool
+	shutdown  bool
+
+	drainOnce sync.Once
+	drainErr  error
+	done      chan struct{}
+
+	// handoff is the socket of the handoff to the new process during the
+	// drain after an upgrade.
+	handoffMu    sync.Mutex
+	handoff      *net.UnixConn
+	handoffsOnce sync.Once
+	handoffs     chan *HandoffConn
+}
+
+// listener is a listener of the process passed to the new one.
+type listener struct {
+	net.Listener
+	network, addr string
+}
+
+// filer is implemented by the listeners of package net.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// inheritedListener is a listener in envListeners.
+type inheritedListener struct {
+	Network string `json:"network"`
+	Addr    string `json:"addr"`
+}
+
+func key(network, addr string) string {
+	return network + " " + addr
+}
+
+// init reads the listeners and the pipe passed by the parent, once.
+func (u *Upgrader) init() error {
+	u.once.Do(func() {
+		u.inherited = map[string]*os.File{}
+		u.done = make(chan struct{})
+		if v, ok := os.LookupEnv(envListeners); ok {
+			var ls []inheritedListener
+			if err := json.Unmarshal([]byte(v), &ls); err != nil {
+				u.initErr = fmt.Errorf("hot_restart: invalid %s: %v", envListeners, err)
+				return
+			}
+			for i, l := range ls {
+				u.inherited[key(l.Network, l.Addr)] = os.NewFile(uintptr(3+i), l.Network+":"+l.Addr)
+			}
+		}
+		if v, ok := os.LookupEnv(envReady); ok {
+			fd, err := strconv.Atoi(v)
+			if err != nil {
+				u.initErr = fmt.Errorf("hot_restart: invalid %s: %v", envReady, err)
+				return
+			}
+			u.ready = os.NewFile(uintptr(fd), "hot_restart_ready")
+		}
+		if v, ok := os.LookupEnv(envHandoff); ok {
+			fd, err := strconv.Atoi(v)
+			if err != nil {
+				u.initErr = fmt.Errorf("hot_restart: invalid %s: %v", envHandoff, err)
+				return
+			}
+			f := os.NewFile(uintptr(fd), "hot_restart_handoff")
+			c, err := net.FileConn(f)
+			f.Close()
+			uc, ok := c.(*net.UnixConn)
+			if err != nil || !ok {
+				u.initErr = fmt.Errorf("hot_restart: invalid handoff socket %d: %v", fd, err)
+				return
+			}
+			u.parent = uc
+		}
+		// not passed to the processes started by this one
+		os.Unsetenv(envListeners)
+		os.Unsetenv(envReady)
+		os.Unsetenv(envHandoff)
+	})
+	return u.initErr
+}
+
+// Listen listens on the network address, or returns the listener of the
+// parent on the same network and address if it passed one. The network is
+// that of net.Listen, such as tcp or unix.
+func (u *Upgrader) Listen(network, addr string) (net.Listener, error) {
+	if err := u.init(); err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.shutdown {
+		return nil, ErrShutdown
+	}
+
+	var ln net.Listener
+	k := key(network, addr)
+	if f, ok := u.inherited[k]; ok {
+		delete(u.inherited, k)
+		var err error
+		ln, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("hot_restart: inherit %s: %v", k, err)
+		}
+	} else {
+		var err error
+		if ln, err = net.Listen(network, addr); err != nil {
+			return nil, err
+		}
+	}
+	// the socket file is shared with the new process
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	if _, ok := ln.(filer); !ok {
+		ln.Close()
+		return nil, fmt.Errorf("hot_restart: listener %T of %s has no file", ln, k)
+	}
+	u.listeners = append(u.listeners, &listener{Listener: ln, network: network, addr: addr})
+	return ln, nil
+}
+
+// OnShutdown registers f to drain the process when it has upgraded or is
+// shut down, such as the Shutdown of an http.Server or the GracefulStop of
+// a grpc.Server. The funcs are called concurrently with a context done
+// after DrainTimeout.
+func (u *Upgrader) OnShutdown(f func(context.Context) error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.hooks = append(u.hooks, f)
+}
+
+// Ready notifies the parent that the process serves, for it to drain and
+// exit, and closes the listeners of the parent not listened. It does
+// nothing if the process wasn't started by Upgrade.
+func (u *Upgrader) Ready() error {
+	if err := u.init(); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for k, f := range u.inherited {
+		f.Close()
+		delete(u.inherited, k)
+	}
+	if u.ready == nil {
+		return nil
+	}
+	_, err := u.ready.Write([]byte{1})
+	u.ready.Close()
+	u.ready = nil
+	if err != nil {
+		return fmt.Errorf("hot_restart: notify parent: %v", err)
+	}
+	return nil
+}
+
+// Upgrade starts the executable of the process again, with the same
+// arguments and the listeners, and waits for it to call Ready. The process
+// then stops accepting and drains, and Upgrade returns the error of the
+// OnShutdown funcs, or of the drain timing out.
+//
+// If the new process can't start, exits or isn't ready within ReadyTimeout,
+// it's killed and the process keeps serving.
+func (u *Upgrader) Upgrade() error {
+	if err := u.init(); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	switch {
+	case u.shutdown:
+		u.mu.Unlock()
+		return ErrShutdown
+	case u.upgrading:
+		u.mu.Unlock()
+		return ErrUpgrading
+	}
+	u.upgrading = true
+	listeners := append([]*listener(nil), u.listeners...)
+	u.mu.Unlock()
+
+	err := u.start(listeners)
+
+	u.mu.Lock()
+	u.upgrading = false
+	if err != nil {
+		u.mu.Unlock()
+		return err
+	}
+	u.shutdown = true
+	u.mu.Unlock()
+	return u.drain()
+}
+
+// start starts the new process with the listeners and waits for it to be
+// ready.
+func (u *Upgrader) start(listeners []*listener) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("hot_restart: %v", err)
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	var passed []inheritedListener
+	for _, l := range listeners {
+		f, err := l.Listener.(filer).File()
+		if err != nil {
+			return fmt.Errorf("hot_restart: file of %s: %v", key(l.network, l.addr), err)
+		}
+		files = append(files, f)
+		passed = append(passed, inheritedListener{Network: l.network, Addr: l.addr})
+	}
+	env, err := json.Marshal(passed)
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("hot_restart: %v", err)
+	}
+	defer r.Close()
+	files = append(files, w)
+	handoff, child, err := socketpair()
+	if err != nil {
+		return err
+	}
+	files = append(files, child)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(environ(),
+		envListeners+"="+string(env),
+		envReady+"="+strconv.Itoa(3+len(files)-2),
+		envHandoff+"="+strconv.Itoa(3+len(files)-1))
+	if err := cmd.Start(); err != nil {
+		handoff.Close()
+		return fmt.Errorf("hot_restart: start %s: %v", exe, err)
+	}
+	// the write end is the child's, a read of EOF means it exited
+	w.Close()
+	child.Close()
+	files = files[:len(files)-2]
+
+	ready := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		if _, err := r.Read(b); err != nil {
+			ready <- fmt.Errorf("hot_restart: process %d exited before ready: %v", cmd.Process.Pid, err)
+			return
+		}
+		ready <- nil
+	}()
+	// reap the child if it exits while the process serves
+	go cmd.Wait()
+
+	timeout := u.ReadyTimeout
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case err = <-ready:
+	case <-t.C:
+		err = fmt.Errorf("hot_restart: process %d not ready after %v", cmd.Process.Pid, timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		handoff.Close()
+		return err
+	}
+	u.handoffMu.Lock()
+	u.handoff = handoff
+	u.handoffMu.Unlock()
+	return nil
+}
+
+// environ returns the environment without the vars of the package.
+func environ() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envListeners+"=") && !strings.HasPrefix(kv, envReady+"=") && !strings.HasPrefix(kv, envHandoff+"=") {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// Shutdown stops accepting and drains the process with the OnShutdown
+// funcs, returning their error or that of the drain timing out.
+func (u *Upgrader) Shutdown() error {
+	if err := u.init(); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	u.shutdown = true
+	u.mu.Unlock()
+	return u.drain()
+}
+
+// Done returns a channel closed when the process has drained, after an
+// upgrade or Shutdown.
+func (u *Upgrader) Done() <-chan struct{} {
+	u.init()
+	return u.done
+}
+
+// drain closes the listeners and runs the OnShutdown funcs, once.
+func (u *Upgrader) drain() error {
+	u.drainOnce.Do(func() {
+		defer close(u.done)
+		defer u.endHandoff()
+		u.mu.Lock()
+		listeners, hooks := u.listeners, u.hooks
+		u.mu.Unlock()
+		// the sockets stay open in the new process, which accepts the
+		// connections queued
+		for _, l := range listeners {
+			l.Close()
+		}
+		delay := u.DrainDelay
+		if delay == 0 {
+			delay = DefaultDrainDelay
+		}
+		if delay > 0 && len(hooks) > 0 {
+			time.Sleep(delay)
+		}
+
+		timeout := u.DrainTimeout
+		if timeout <= 0 {
+			timeout = DefaultDrainTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		errs := make(chan error, len(hooks))
+		for _, f := range hooks {
+			go func(f func(context.Context) error) { errs <- f(ctx) }(f)
+		}
+		for range hooks {
+			select {
+			case err := <-errs:
+				if err != nil && u.drainErr == nil {
+					u.drainErr = err
+				}
+			case <-ctx.Done():
+				u.drainErr = fmt.Errorf("hot_restart: drain: %v", ctx.Err())
+				return
+			}
+		}
+	})
+	return u.drainErr
+}
+
+// Wait upgrades the process on SIGUSR2, and shuts it down on SIGINT and
+// SIGTERM. It returns once the process has drained, with the error of the
+// drain, for main to exit. A failed upgrade is logged and the process keeps
+// serving.
+func (u *Upgrader) Wait() error {
+	if err := u.init(); err != nil {
+		return err
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-u.done:
+			return u.drainErr
+		case sig := <-ch:
+			if sig != syscall.SIGUSR2 {
+				return u.Shutdown()
+			}
+			if err := u.Upgrade(); err != nil && !u.drained() {
+				log.Printf("hot_restart: upgrade of %d: %v", os.Getpid(), err)
+			}
+		}
+	}
+}
+
+func (u *Upgrader) drained() bool {
+	select {
+	case <-u.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,266 @@
+package hotrestart
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenAndShutdown(t *testing.T) {
+	u := &Upgrader{DrainDelay: -1, DrainTimeout: 100 * time.Millisecond}
+	ln, err := u.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	sock := filepath.Join(t.TempDir(), "s.sock")
+	uln, err := u.Listen("unix", sock)
+	require.Nil(t, err)
+	// no parent
+	assert.Nil(t, u.Ready())
+
+	var calls int
+	var mu sync.Mutex
+	u.OnShutdown(func(ctx context.Context) error {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return nil
+	})
+	errHook := errors.New("hook")
+	u.OnShutdown(func(ctx context.Context) error { return errHook })
+	assert.Equal(t, errHook, u.Shutdown())
+	assert.Equal(t, errHook, u.Shutdown())
+	assert.Equal(t, 1, calls)
+	<-u.Done()
+
+	// the listeners are closed, the socket file is kept for a new process
+	_, err = ln.Accept()
+	assert.NotNil(t, err)
+	_, err = uln.Accept()
+	assert.NotNil(t, err)
+	_, err = os.Stat(sock)
+	assert.Nil(t, err)
+
+	_, err = u.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, ErrShutdown, err)
+	assert.Equal(t, ErrShutdown, u.Upgrade())
+}
+
+func TestDrainTimeout(t *testing.T) {
+	u := &Upgrader{DrainDelay: 50 * time.Millisecond, DrainTimeout: 10 * time.Millisecond}
+	u.OnShutdown(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	start := time.Now()
+	err := u.Shutdown()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "deadline exceeded")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestInherit(t *testing.T) {
+	// a listener of a parent at fd 3 is inherited by the process started
+	// with it, and the others listen
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	require.Nil(t, err)
+	defer f.Close()
+	r, w, err := os.Pipe()
+	require.Nil(t, err)
+	defer r.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInheritChild$")
+	cmd.ExtraFiles = []*os.File{f, w}
+	cmd.Env = append(os.Environ(),
+		"HOT_RESTART_TEST_CHILD=1",
+		envListeners+`=[{"network":"tcp","addr":"127.0.0.1:0"}]`,
+		envReady+"=4")
+	out, err := cmd.CombinedOutput()
+	w.Close()
+	require.Nil(t, err, "%s", out)
+	b, err := io.ReadAll(r)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+	assert.Contains(t, string(out), "inherited "+ln.Addr().String())
+}
+
+func TestInheritChild(t *testing.T) {
+	if os.Getenv("HOT_RESTART_TEST_CHILD") != "1" {
+		t.Skip("run by TestInherit")
+	}
+	u := &Upgrader{}
+	ln, err := u.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	fmt.Println("inherited", ln.Addr())
+	other, err := u.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	assert.NotEqual(t, ln.Addr().String(), other.Addr().String())
+	assert.Empty(t, os.Getenv(envListeners))
+	require.Nil(t, u.Ready())
+	require.Nil(t, u.Ready())
+}
+
+// alive reports whether the process pid runs, not exited nor a zombie.
+func alive(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// pid (comm) state ...
+	s := string(data)
+	fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func waitExit(t *testing.T, pid int) {
+	t.Helper()
+	for start := time.Now(); alive(pid); time.Sleep(10 * time.Millisecond) {
+		require.Less(t, time.Since(start), 10*time.Second, "process %d still runs", pid)
+	}
+}
+
+// TestUpgrade upgrades a server twice under continuous load, the requests
+// served by the three processes without any error.
+func TestUpgrade(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs a server")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "server")
+	out, err := exec.Command("go", "build", "-o", bin, "./testdata/server").CombinedOutput()
+	require.Nil(t, err, "%s", out)
+
+	logs, err := os.Create(filepath.Join(dir, "log"))
+	require.Nil(t, err)
+	defer logs.Close()
+	addrFile := filepath.Join(dir, "addr")
+	cmd := exec.Command(bin, "-addr", addrFile, "-delay", "2ms")
+	cmd.Stdout, cmd.Stderr = logs, logs
+	require.Nil(t, cmd.Start())
+	go cmd.Wait()
+
+	var mu sync.Mutex
+	pids := map[int]int{}
+	var errs []error
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for pid := range pids {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+		if t.Failed() {
+			data, _ := os.ReadFile(logs.Name())
+			t.Logf("server logs:\n%s", data)
+		}
+	}()
+
+	var addr []byte
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if addr, err = os.ReadFile(addrFile); err == nil {
+			break
+		}
+		require.Less(t, time.Since(start), 10*time.Second)
+	}
+	url := "http://" + string(addr) + "/"
+
+	// new connections for most requests, and kept alive for some
+	get := func(c *http.Client) (int, error) {
+		resp, err := c.Get(url)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("status %s", resp.Status)
+		}
+		return strconv.Atoi(string(body))
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		c := &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{DisableKeepAlives: i < 8}}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				pid, err := get(c)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					pids[pid]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	pid := cmd.Process.Pid
+	served := func(pid int) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return pids[pid]
+	}
+	for i := 0; i < 2; i++ {
+		for start := time.Now(); served(pid) < 100; time.Sleep(10 * time.Millisecond) {
+			require.Less(t, time.Since(start), 10*time.Second, "no load on %d", pid)
+		}
+		require.Nil(t, syscall.Kill(pid, syscall.SIGUSR2))
+		waitExit(t, pid)
+
+		// the new process serves
+		next := 0
+		for start := time.Now(); next == 0; time.Sleep(10 * time.Millisecond) {
+			mu.Lock()
+			for p := range pids {
+				if p != pid && alive(p) {
+					next = p
+				}
+			}
+			mu.Unlock()
+			require.Less(t, time.Since(start), 10*time.Second, "no process after %d", pid)
+		}
+		pid = next
+	}
+	for start := time.Now(); served(pid) < 100; time.Sleep(10 * time.Millisecond) {
+		require.Less(t, time.Since(start), 10*time.Second, "no load on %d", pid)
+	}
+	close(stop)
+	wg.Wait()
+
+	require.Nil(t, syscall.Kill(pid, syscall.SIGTERM))
+	waitExit(t, pid)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Empty(t, errs)
+	assert.Len(t, pids, 3)
+	for p, n := range pids {
+		assert.True(t, n >= 100, "%d requests served by %d", n, p)
+	}
+}
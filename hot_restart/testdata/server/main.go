@@ -0,0 +1,47 @@
+// Command server is the http server upgraded by TestUpgrade: it writes its
+// address to the file of -addr and answers its pid.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	hotrestart "github.com/hitzhangjie/codemaster/hot_restart"
+)
+
+func main() {
+	addrFile := flag.String("addr", "", "write the address listened to `file`")
+	delay := flag.Duration("delay", 0, "delay of the responses")
+	flag.Parse()
+
+	ln, err := hotrestart.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(*delay)
+		fmt.Fprintf(w, "%d", os.Getpid())
+	})}
+	go srv.Serve(ln)
+	hotrestart.OnShutdown(srv.Shutdown)
+
+	// the address is written by the first process only
+	if _, err := os.Stat(*addrFile); os.IsNotExist(err) {
+		if err := os.WriteFile(*addrFile+".tmp", []byte(ln.Addr().String()), 0644); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.Rename(*addrFile+".tmp", *addrFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := hotrestart.Ready(); err != nil {
+		log.Fatal(err)
+	}
+	if err := hotrestart.Wait(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,140 @@
+// Command session is the line server upgraded by TestUpgradeHandoff: it
+// writes its address to the file of -addr and answers each line of a
+// connection with the count of lines of the session and its pid, the
+// sessions handed off to the new process on upgrade.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	hotrestart "github.com/hitzhangjie/codemaster/hot_restart"
+)
+
+var (
+	mu       sync.Mutex
+	sessions = map[net.Conn]bool{}
+	stopping bool
+	wg       sync.WaitGroup
+)
+
+func main() {
+	addrFile := flag.String("addr", "", "write the address listened to `file`")
+	flag.Parse()
+
+	ln, err := hotrestart.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(c, 0, bufio.NewReader(c))
+		}
+	}()
+	go func() {
+		for h := range hotrestart.Handoffs() {
+			count, err := strconv.Atoi(string(h.State))
+			if err != nil {
+				log.Printf("session of %s: %v", h.Conn.RemoteAddr(), err)
+				h.Conn.Close()
+				continue
+			}
+			go serve(h.Conn, count, bufio.NewReader(h.NetConn()))
+		}
+	}()
+	hotrestart.OnShutdown(shutdown)
+
+	// the address is written by the first process only
+	if _, err := os.Stat(*addrFile); os.IsNotExist(err) {
+		if err := os.WriteFile(*addrFile+".tmp", []byte(ln.Addr().String()), 0644); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.Rename(*addrFile+".tmp", *addrFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := hotrestart.Ready(); err != nil {
+		log.Fatal(err)
+	}
+	if err := hotrestart.Wait(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// serve answers the lines of c after count lines, until the process stops
+// and hands it off.
+func serve(c net.Conn, count int, br *bufio.Reader) {
+	mu.Lock()
+	if stopping {
+		c.SetReadDeadline(time.Now())
+	}
+	sessions[c] = true
+	wg.Add(1)
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		delete(sessions, c)
+		mu.Unlock()
+		wg.Done()
+	}()
+
+	for {
+		line, err := br.ReadString('\n')
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// the partial line and the bytes read after it go on in the new
+			// process
+			buffered, _ := br.Peek(br.Buffered())
+			c.SetReadDeadline(time.Time{})
+			err := hotrestart.Handoff(c, "line", []byte(strconv.Itoa(count)), append([]byte(line), buffered...))
+			if err != nil {
+				if err != hotrestart.ErrNoHandoff {
+					log.Printf("handoff of %s: %v", c.RemoteAddr(), err)
+				}
+				c.Close()
+			}
+			return
+		}
+		if err != nil {
+			c.Close()
+			return
+		}
+		count++
+		fmt.Fprintf(c, "%d %d\n", count, os.Getpid())
+	}
+}
+
+// shutdown stops the reads of the sessions, for them to be handed off, and
+// waits for them.
+func shutdown(ctx context.Context) error {
+	mu.Lock()
+	stopping = true
+	for c := range sessions {
+		c.SetReadDeadline(time.Now())
+	}
+	mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}